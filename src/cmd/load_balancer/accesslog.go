@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------- Access log ---------------- //
+
+// accessLog writes a line per finished connection or request, see -access-log;
+// nil if off
+var accessLog *accessLogger
+
+// default of -access-log-format
+const defaultAccessFormat = `$time $client $mode $backend "$request" $status $bytes_in $bytes_out $duration`
+
+// accessEntry is what is known of a finished connection or request.
+type accessEntry struct {
+	start, end time.Time
+	id         string        // of the connection or request, see connLog
+	client     string        // address
+	mode       string        // tcp or http
+	backend    string        // "" if none took it
+	sni, ja3   string        // of TLS clients, if known
+	in, out    int64         // bytes from and to the client
+	status     int           // HTTP status, 0 in tcp mode
+	err        error         // why it failed, if it did
+	r          *http.Request // nil in tcp mode
+}
+
+// accessVars are the variables of -access-log-format. A value that is empty
+// is written as "-".
+var accessVars = map[string]func(e *accessEntry) string{
+	"time":       func(e *accessEntry) string { return e.end.Format(time.RFC3339) },
+	"time_local": func(e *accessEntry) string { return e.end.Format("02/Jan/2006:15:04:05 -0700") },
+	"client":     func(e *accessEntry) string { return e.client },
+	"client_ip": func(e *accessEntry) string {
+		host, _, err := net.SplitHostPort(e.client)
+		if err != nil {
+			return e.client
+		}
+		return host
+	},
+	"geo":       func(e *accessEntry) string { return locate(e.client).String() },
+	"id":        func(e *accessEntry) string { return e.id },
+	"mode":      func(e *accessEntry) string { return e.mode },
+	"backend":   func(e *accessEntry) string { return e.backend },
+	"sni":       func(e *accessEntry) string { return e.sni },
+	"ja3":       func(e *accessEntry) string { return e.ja3 },
+	"bytes_in":  func(e *accessEntry) string { return strconv.FormatInt(e.in, 10) },
+	"bytes_out": func(e *accessEntry) string { return strconv.FormatInt(e.out, 10) },
+	"duration":  func(e *accessEntry) string { return strconv.FormatFloat(e.end.Sub(e.start).Seconds(), 'f', 3, 64) },
+	"duration_ms": func(e *accessEntry) string {
+		return strconv.FormatInt(e.end.Sub(e.start).Milliseconds(), 10)
+	},
+	"status": func(e *accessEntry) string {
+		if e.status == 0 {
+			return ""
+		}
+		return strconv.Itoa(e.status)
+	},
+	"error": func(e *accessEntry) string {
+		if e.err == nil {
+			return ""
+		}
+		return e.err.Error()
+	},
+	"request": func(e *accessEntry) string {
+		if e.r == nil {
+			return ""
+		}
+		return e.r.Method + " " + e.r.RequestURI + " " + e.r.Proto
+	},
+	"method":     requestVar(func(r *http.Request) string { return r.Method }),
+	"uri":        requestVar(func(r *http.Request) string { return r.RequestURI }),
+	"proto":      requestVar(func(r *http.Request) string { return r.Proto }),
+	"host":       requestVar(func(r *http.Request) string { return r.Host }),
+	"user_agent": requestVar(func(r *http.Request) string { return r.UserAgent() }),
+	"referer":    requestVar(func(r *http.Request) string { return r.Referer() }),
+}
+
+// requestVar is a variable of HTTP requests, empty in tcp mode.
+func requestVar(f func(r *http.Request) string) func(e *accessEntry) string {
+	return func(e *accessEntry) string {
+		if e.r == nil {
+			return ""
+		}
+		return f(e.r)
+	}
+}
+
+// accessLogger writes access log lines in its format.
+type accessLogger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format []accessField
+}
+
+// accessField is a literal or, if value is set, a variable.
+type accessField struct {
+	literal string
+	value   func(e *accessEntry) string
+}
+
+// parseAccessFormat parses a format of $name or ${name} variables in
+// literal text; $$ is a dollar sign.
+func parseAccessFormat(format string) ([]accessField, error) {
+	var fields []accessField
+	var lit strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '$' {
+			lit.WriteByte(format[i])
+			continue
+		}
+		rest := format[i+1:]
+		var name string
+		switch {
+		case strings.HasPrefix(rest, "$"):
+			lit.WriteByte('$')
+			i++
+			continue
+		case strings.HasPrefix(rest, "{"):
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed ${ in %q", format)
+			}
+			name = rest[1:end]
+			i += end + 1
+		default:
+			end := strings.IndexFunc(rest, func(r rune) bool { return !(r == '_' || 'a' <= r && r <= 'z' || '0' <= r && r <= '9') })
+			if end < 0 {
+				end = len(rest)
+			}
+			name = rest[:end]
+			i += end
+		}
+		value, ok := accessVars[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown variable $%s in %q", name, format)
+		}
+		if lit.Len() > 0 {
+			fields = append(fields, accessField{literal: lit.String()})
+			lit.Reset()
+		}
+		fields = append(fields, accessField{value: value})
+	}
+	if lit.Len() > 0 {
+		fields = append(fields, accessField{literal: lit.String()})
+	}
+	return fields, nil
+}
+
+// checkAccessLog validates an access log to path in format without opening it.
+func checkAccessLog(path, format string) error {
+	if _, err := parseAccessFormat(format); err != nil {
+		return err
+	}
+	switch {
+	case isLogSink(path):
+		_, err := openLogSink(path, "access", accessSeverity)
+		return err
+	case path != "-":
+		return checkLogFile(path)
+	}
+	return nil
+}
+
+// newAccessLogger writes to path, - for stdout, or a sink (see openLogSink),
+// in format.
+func newAccessLogger(path, format string) (*accessLogger, error) {
+	fields, err := parseAccessFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	var w io.Writer = os.Stdout
+	switch {
+	case isLogSink(path):
+		if w, err = openLogSink(path, "access", accessSeverity); err != nil {
+			return nil, err
+		}
+	case path != "-":
+		if w, err = openLogFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return &accessLogger{w: w, format: fields}, nil
+}
+
+// log writes the line of e, which ended now.
+func (l *accessLogger) log(e *accessEntry) {
+	e.end = time.Now()
+	b := l.line(e)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(b); err != nil {
+		logger.Printf("ERROR writing access log: %v", err)
+	}
+}
+
+// line returns the line of e in l's format.
+func (l *accessLogger) line(e *accessEntry) []byte {
+	var b []byte
+	for _, f := range l.format {
+		if f.value == nil {
+			b = append(b, f.literal...)
+		} else if v := f.value(e); v == "" {
+			b = append(b, '-')
+		} else {
+			b = appendEscaped(b, v)
+		}
+	}
+	return append(b, '\n')
+}
+
+// appendEscaped appends v with quotes, backslashes and control characters
+// escaped as \xHH, so clients can't forge lines or break quoted fields.
+func appendEscaped(b []byte, v string) []byte {
+	const hex = "0123456789ABCDEF"
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; c < 0x20 || c == 0x7f || c == '"' || c == '\\' {
+			b = append(b, '\\', 'x', hex[c>>4], hex[c&0xf])
+		} else {
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
+// countingBody counts the bytes of a request body read.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckAccessLogCreatesNothing(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	if err := checkAccessLog(path, defaultAccessFormat); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("checking the access log created it: %v", err)
+	}
+	if err := checkAccessLog(filepath.Join(dir, "missing", "access.log"), defaultAccessFormat); err == nil {
+		t.Error("access log in a missing directory passed")
+	}
+	if err := checkAccessLog("syslog://localhost", defaultAccessFormat); err == nil {
+		t.Error("sink without a port passed")
+	}
+}
+
+func TestAccessFormat(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	r := httptest.NewRequest("POST", "/api/users?page=2", nil)
+	r.Host = "example.com"
+	r.Header.Set("User-Agent", `curl/8.5 "quoted"`)
+	r.Header.Set("Referer", "https://example.com/\nforged line")
+	http := &accessEntry{
+		start: start, end: start.Add(1500 * time.Millisecond), id: "c42", client: "203.0.113.7:51234",
+		mode: "http", backend: "10.0.0.1:8000", in: 120, out: 3400, status: 201, r: r,
+	}
+	tcp := &accessEntry{
+		start: start, end: start.Add(2 * time.Millisecond), id: "c43", client: "[2001:db8::1]:4000", mode: "tcp",
+		sni: "api.example.com", ja3: "771,4865", err: errors.New("connection refused"),
+	}
+	tests := []struct {
+		format    string
+		http, tcp string
+	}{
+		{"$time", "2024-03-01T12:00:01Z", "2024-03-01T12:00:00Z"},
+		{"$time_local", "01/Mar/2024:12:00:01 +0000", "01/Mar/2024:12:00:00 +0000"},
+		{"$client", "203.0.113.7:51234", "[2001:db8::1]:4000"},
+		{"$client_ip", "203.0.113.7", "2001:db8::1"},
+		{"$geo", "-", "-"}, // without -geoip
+		{"$id", "c42", "c43"},
+		{"$mode", "http", "tcp"},
+		{"$backend", "10.0.0.1:8000", "-"},
+		{"$sni $ja3", "- -", "api.example.com 771,4865"},
+		{"$bytes_in $bytes_out", "120 3400", "0 0"},
+		{"$duration", "1.500", "0.002"},
+		{"$duration_ms", "1500", "2"},
+		{"$status", "201", "-"},
+		{"$error", "-", "connection refused"},
+		{`"$request"`, `"POST /api/users?page=2 HTTP/1.1"`, `"-"`},
+		{"$method $uri $proto", "POST /api/users?page=2 HTTP/1.1", "- - -"},
+		{"$host", "example.com", "-"},
+		{`"$user_agent"`, `"curl/8.5 \x22quoted\x22"`, `"-"`},    // quotes escaped
+		{"$referer", `https://example.com/\x0Aforged line`, "-"}, // and line breaks
+		{"${status}ok $$5", "201ok $5", "-ok $5"},
+		{"[$mode]", "[http]", "[tcp]"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		fields, err := parseAccessFormat(tt.format)
+		if err != nil {
+			t.Errorf("%q: %v", tt.format, err)
+			continue
+		}
+		l := &accessLogger{format: fields}
+		for _, c := range []struct {
+			e    *accessEntry
+			want string
+		}{{http, tt.http}, {tcp, tt.tcp}} {
+			if got := string(l.line(c.e)); got != c.want+"\n" {
+				t.Errorf("%q of a %s entry: %q, want %q", tt.format, c.e.mode, got, c.want+"\n")
+			}
+		}
+	}
+
+	fields, err := parseAccessFormat(defaultAccessFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &accessLogger{format: fields}
+	want := `2024-03-01T12:00:01Z 203.0.113.7:51234 http 10.0.0.1:8000 "POST /api/users?page=2 HTTP/1.1" 201 120 3400 1.500` + "\n"
+	if got := string(l.line(http)); got != want {
+		t.Errorf("default format: %q, want %q", got, want)
+	}
+}
+
+func TestAccessFormatErrors(t *testing.T) {
+	for _, tt := range []struct{ format, want string }{
+		{"$nope", "unknown variable $nope"},
+		{"$Status", "unknown variable $"}, // names are lower case
+		{"${nope}", "unknown variable $nope"},
+		{"${status", "unclosed ${"},
+		{"$time $statuses", "unknown variable $statuses"},
+		{"100$", "unknown variable $ "}, // a $ alone names none
+	} {
+		_, err := parseAccessFormat(tt.format)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: got error %v, want one with %q", tt.format, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// ---------------- Admin API ---------------- //
+
+var (
+	adminDebug bool           // serve expvar and pprof on the admin API, see -admin-debug
+	adminToken string         // bearer token the admin API wants, see -admin-token
+	adminAllow []netip.Prefix // networks the admin API answers, see -admin-allow
+)
+
+// serveAdmin exposes runtime controls over HTTP. Endpoints act on the main
+// frontend's backends, or on another frontend's with ?frontend=name. It serves
+// ls, opened on addr, and only returns once they fail or are closed.
+func serveAdmin(addr string, ls []net.Listener, main *load_balancer.Switchable, frontends map[string]*frontend) {
+	mux := http.NewServeMux()
+	handle := func(pattern string, h func(http.ResponseWriter, *http.Request, *load_balancer.Switchable)) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			policy := main
+			if name := r.URL.Query().Get("frontend"); name != "" {
+				f, ok := frontends[name]
+				if !ok {
+					http.Error(w, "unknown frontend "+name, http.StatusNotFound)
+					return
+				}
+				policy = f.policy
+			}
+			h(w, r, policy)
+		})
+	}
+
+	// GET /policy: name of the active policy
+	handle("GET /policy", func(w http.ResponseWriter, r *http.Request, policy *load_balancer.Switchable) {
+		fmt.Fprintln(w, policy.Name())
+	})
+
+	// POST /policy?name=LeastConnections: switch policy, open connections are kept
+	handle("POST /policy", func(w http.ResponseWriter, r *http.Request, policy *load_balancer.Switchable) {
+		name := r.URL.Query().Get("name")
+		old := policy.Name()
+		if err := policy.Switch(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Printf("Policy switched from %s to %s", old, name)
+		fmt.Fprintln(w, name)
+	})
+
+	// GET /stats: per-backend counters of the active policy, as JSON
+	handle("GET /stats", func(w http.ResponseWriter, r *http.Request, policy *load_balancer.Switchable) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(policy.Stats())
+	})
+
+	// GET /stats/stream?interval=1s: per-second rates and backend health as
+	// Server-Sent Events, every interval (default 1s)
+	handle("GET /stats/stream", func(w http.ResponseWriter, r *http.Request, policy *load_balancer.Switchable) {
+		interval := time.Second
+		if v := r.URL.Query().Get("interval"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 100*time.Millisecond {
+				http.Error(w, "invalid interval, want a duration of at least 100ms", http.StatusBadRequest)
+				return
+			}
+			interval = d
+		}
+		streamStats(w, r, policy, interval)
+	})
+
+	// GET /split: share of the requests each pool gets from -http-split, in percent, as JSON
+	mux.HandleFunc("GET /split", func(w http.ResponseWriter, r *http.Request) {
+		if httpSplit == nil {
+			http.Error(w, "no traffic split, see -http-split", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(httpSplit.percentages())
+	})
+
+	// POST /split?stable=90&canary=10: change the weights of pools in the split,
+	// the others keep theirs
+	mux.HandleFunc("POST /split", func(w http.ResponseWriter, r *http.Request) {
+		if httpSplit == nil {
+			http.Error(w, "no traffic split, see -http-split", http.StatusNotFound)
+			return
+		}
+		weights := make(map[string]int)
+		for name, v := range r.URL.Query() {
+			weight, err := strconv.Atoi(v[0])
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid weight %q for pool %s", v[0], name), http.StatusBadRequest)
+				return
+			}
+			weights[name] = weight
+		}
+		if err := httpSplit.set(weights); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Printf("Traffic split set to %s", httpSplit)
+		fmt.Fprintln(w, httpSplit)
+	})
+
+	// GET /cutover: active and standby pool of -blue-green, as JSON
+	mux.HandleFunc("GET /cutover", func(w http.ResponseWriter, r *http.Request) {
+		if blueGreen == nil {
+			http.Error(w, "no blue/green pools, see -blue-green", http.StatusNotFound)
+			return
+		}
+		active, standby := blueGreen.state()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"active": active, "standby": standby})
+	})
+
+	// POST /cutover?pool=green&timeout=30s: send new traffic to a pool of -blue-green,
+	// the standby one if not given; connections open to the other finish, those
+	// left after timeout (optional) are closed
+	mux.HandleFunc("POST /cutover", func(w http.ResponseWriter, r *http.Request) {
+		if blueGreen == nil {
+			http.Error(w, "no blue/green pools, see -blue-green", http.StatusNotFound)
+			return
+		}
+		var timeout time.Duration
+		var err error
+		if t := r.URL.Query().Get("timeout"); t != "" {
+			timeout, err = time.ParseDuration(t)
+		}
+		if err == nil {
+			err = blueGreen.cut(r.URL.Query().Get("pool"), timeout)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		active, _ := blueGreen.state()
+		fmt.Fprintln(w, active)
+	})
+
+	// GET /buffers: copy buffer pool size, use and hit rate, as JSON
+	mux.HandleFunc("GET /buffers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(copyBuffers.stats())
+	})
+
+	// GET /explain: which connections have their backend selection explained
+	// in the log, as JSON, null if only those of -explain
+	mux.HandleFunc("GET /explain", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(currentExplainRule())
+	})
+
+	// POST /explain?client=10.0.0.5&count=20: explain in the log how the
+	// backends of the next 20 connections or requests from 10.0.0.5 are
+	// selected; client and count are optional, for any client and no end
+	mux.HandleFunc("POST /explain", func(w http.ResponseWriter, r *http.Request) {
+		rule := &explainRule{Client: r.URL.Query().Get("client")}
+		if rule.Client != "" && net.ParseIP(rule.Client) == nil {
+			http.Error(w, "invalid client, want an IP address", http.StatusBadRequest)
+			return
+		}
+		if v := r.URL.Query().Get("count"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid count", http.StatusBadRequest)
+				return
+			}
+			rule.Left = n
+		}
+		setExplainRule(rule)
+		logger.Printf("Explaining backend selection for %s", describeExplainRule(rule))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// DELETE /explain: stop explaining, but for -explain
+	mux.HandleFunc("DELETE /explain", func(w http.ResponseWriter, r *http.Request) {
+		setExplainRule(nil)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// GET /connections: open proxied connections, of one backend with
+	// ?server=localhost:8000, as JSON
+	mux.HandleFunc("GET /connections", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(openConns.list(serverParam(r)))
+	})
+
+	// DELETE /connections?id=3f2a9c1e07b4d568: close a connection
+	mux.HandleFunc("DELETE /connections", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		if !openConns.close(id) {
+			http.Error(w, fmt.Sprintf("no open connection %q", id), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// GET /transitions: the last backends changing state, e.g. healthy to
+	// unhealthy or ejected, of one backend with ?server=localhost:8000, as JSON
+	mux.HandleFunc("GET /transitions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(recentTransitions(serverParam(r)))
+	})
+
+	// GET /unavailable: clients and requests turned away for want of a backend, as JSON
+	mux.HandleFunc("GET /unavailable", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(turnedAwayStats())
+	})
+
+	// GET /breakers: circuit breaker state and transition counts per backend, as JSON
+	mux.HandleFunc("GET /breakers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(breaker.Stats())
+	})
+
+	// POST /weight?server=localhost:8000&weight=5: change a backend's weight
+	handle("POST /weight", func(w http.ResponseWriter, r *http.Request, policy *load_balancer.Switchable) {
+		server := serverParam(r)
+		weight, err := strconv.Atoi(r.URL.Query().Get("weight"))
+		if err == nil {
+			err = policy.SetWeight(server, weight)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Printf("Backend %s weight set to %d", server, weight)
+		fmt.Fprintln(w, weight)
+	})
+
+	// POST /servers?server=localhost:8003&weight=2: add a backend, weight is optional
+	handle("POST /servers", func(w http.ResponseWriter, r *http.Request, policy *load_balancer.Switchable) {
+		b := load_balancer.Backend{Address: serverParam(r)}
+		var err error
+		if weight := r.URL.Query().Get("weight"); weight != "" {
+			b.Weight, err = strconv.Atoi(weight)
+		}
+		if err == nil {
+			err = policy.AddServer(b)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Printf("Backend %s added", b.Address)
+		fmt.Fprintln(w, b.Address)
+	})
+
+	// DELETE /servers?server=localhost:8003: remove a backend, open connections finish
+	handle("DELETE /servers", func(w http.ResponseWriter, r *http.Request, policy *load_balancer.Switchable) {
+		server := serverParam(r)
+		if err := policy.RemoveServer(server); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		logger.Printf("Backend %s removed", server)
+		fmt.Fprintln(w, server)
+	})
+
+	// POST /drain?server=localhost:8000&timeout=30s: stop new connections to a backend
+	// and let open ones finish, closing those left after timeout (optional)
+	handle("POST /drain", func(w http.ResponseWriter, r *http.Request, policy *load_balancer.Switchable) {
+		server := serverParam(r)
+		var timeout time.Duration
+		var err error
+		if t := r.URL.Query().Get("timeout"); t != "" {
+			timeout, err = time.ParseDuration(t)
+		}
+		if err == nil {
+			err = policy.SetDraining(server, true)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Printf("Draining backend %s", server)
+		go drain(policy, server, timeout)
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	// DELETE /drain?server=localhost:8000: send new connections to a drained backend again
+	handle("DELETE /drain", func(w http.ResponseWriter, r *http.Request, policy *load_balancer.Switchable) {
+		server := serverParam(r)
+		if err := policy.SetDraining(server, false); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		logger.Printf("Backend %s back in rotation", server)
+		fmt.Fprintln(w, server)
+	})
+
+	// POST /maintenance?server=localhost:8000: take a backend out for maintenance, it
+	// gets no new connections and isn't health checked
+	handle("POST /maintenance", func(w http.ResponseWriter, r *http.Request, policy *load_balancer.Switchable) {
+		server := serverParam(r)
+		if err := policy.SetMaintenance(server, true); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		logger.Printf("Backend %s in maintenance", server)
+		fmt.Fprintln(w, server)
+	})
+
+	// DELETE /maintenance?server=localhost:8000: end a backend's maintenance
+	handle("DELETE /maintenance", func(w http.ResponseWriter, r *http.Request, policy *load_balancer.Switchable) {
+		server := serverParam(r)
+		if err := policy.SetMaintenance(server, false); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		logger.Printf("Backend %s back from maintenance", server)
+		fmt.Fprintln(w, server)
+	})
+
+	// POST /report {"server":"localhost:8000","queue_depth":3,"cpu":0.5}: load report
+	// from a backend agent, used by the ReportedLoad policy
+	handle("POST /report", func(w http.ResponseWriter, r *http.Request, policy *load_balancer.Switchable) {
+		var report load_balancer.LoadReport
+		err := json.NewDecoder(r.Body).Decode(&report)
+		if err == nil {
+			report.Server = load_balancer.CanonicalAddress(report.Server)
+			err = policy.Report(report)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	if adminDebug {
+		// GET /debug/vars: expvar, with the stats of the main pool next to the
+		// runtime's memstats
+		expvar.Publish("stats", expvar.Func(func() any { return main.Stats() }))
+		mux.Handle("GET /debug/vars", expvar.Handler())
+		// /debug/pprof/: runtime profiles for go tool pprof, e.g.
+		// /debug/pprof/profile?seconds=30 for CPU, /debug/pprof/heap
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	logger.Printf("Admin API listening on %s", addr)
+	srv := &http.Server{Handler: adminGuard(mux)}
+	for _, l := range ls[1:] {
+		go srv.Serve(l)
+	}
+	if err := srv.Serve(ls[0]); err != nil && !errors.Is(err, net.ErrClosed) {
+		logger.Printf("ERROR admin API on %s: %v", addr, err)
+	}
+}
+
+// adminGuard lets through to h only the requests from -admin-allow networks
+// and with -admin-token, whichever are set.
+func adminGuard(h http.Handler) http.Handler {
+	if adminToken == "" && len(adminAllow) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(adminAllow) > 0 && !peerIn(r.RemoteAddr, adminAllow) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if adminToken != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serverParam returns the backend named by the server query parameter, in the
+// canonical form the pools know it by.
+func serverParam(r *http.Request) string {
+	return load_balancer.CanonicalAddress(r.URL.Query().Get("server"))
+}
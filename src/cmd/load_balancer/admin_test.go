@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+func TestAdminGuard(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name   string
+		token  string
+		allow  []netip.Prefix
+		remote string
+		auth   string
+		want   int
+	}{
+		{"open", "", nil, "192.0.2.1:1234", "", http.StatusOK},
+		{"allowed network", "", []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, "10.1.2.3:1234", "", http.StatusOK},
+		{"other network", "", []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, "192.0.2.1:1234", "", http.StatusForbidden},
+		{"token", "s3cret", nil, "192.0.2.1:1234", "Bearer s3cret", http.StatusOK},
+		{"no token", "s3cret", nil, "192.0.2.1:1234", "", http.StatusUnauthorized},
+		{"wrong token", "s3cret", nil, "192.0.2.1:1234", "Bearer guess", http.StatusUnauthorized},
+		{"token from other network", "s3cret", []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, "192.0.2.1:1234", "Bearer s3cret", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			savedToken, savedAllow := adminToken, adminAllow
+			t.Cleanup(func() { adminToken, adminAllow = savedToken, savedAllow })
+			adminToken, adminAllow = tt.token, tt.allow
+
+			r := httptest.NewRequest("GET", "/debug/pprof/heap", nil)
+			r.RemoteAddr = tt.remote
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			adminGuard(ok).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("got %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+// startAdmin serves the admin API for the test, with main as the main
+// frontend's policy, and returns its URL.
+func startAdmin(t *testing.T, main *load_balancer.Switchable) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serveAdmin(l.Addr().String(), []net.Listener{l}, main, nil)
+	t.Cleanup(func() { l.Close() })
+	return "http://" + l.Addr().String()
+}
+
+// adminCall makes a request to the admin API and returns the status and body.
+func adminCall(t *testing.T, method, url string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	return res.StatusCode, strings.TrimSpace(string(body))
+}
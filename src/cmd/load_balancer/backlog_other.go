@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// ---------------- Socket options ---------------- //
+
+// setBacklog fails: the listen queue can only be resized on Unix.
+func setBacklog(l net.Listener, n int) error {
+	return errors.New("-backlog is only supported on Unix")
+}
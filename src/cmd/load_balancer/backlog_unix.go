@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// ---------------- Socket options ---------------- //
+
+// setBacklog changes the listen queue length of l by listening again, which
+// Unix allows on a listening socket. The kernel caps it, at net.core.somaxconn
+// on Linux.
+func setBacklog(l net.Listener, n int) error {
+	sc, ok := l.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if cerr := rc.Control(func(fd uintptr) { err = syscall.Listen(int(fd), n) }); cerr != nil {
+		return cerr
+	}
+	return err
+}
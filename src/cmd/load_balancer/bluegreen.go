@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// ---------------- Blue/green ---------------- //
+
+// cutover holds two named pools of which one, the active one, takes the main
+// frontend's traffic; the other stands by with the next release. A cutover swaps
+// them at once: new connections and requests go to the new pool while those
+// still open to the old one finish. The pools shouldn't share backends. A nil
+// *cutover leaves traffic to -s.
+type cutover struct {
+	mu       sync.Mutex // held while cutting over
+	names    [2]string
+	policies [2]load_balancer.Policy
+	active   atomic.Int32
+}
+
+// blueGreen is the main frontend's pair of pools, see -blue-green
+var blueGreen *cutover
+
+// parseBlueGreen parses active,standby, two pools given by name; the first
+// takes traffic from the start.
+func parseBlueGreen(v string) (*cutover, error) {
+	active, standby, ok := strings.Cut(v, ",")
+	if !ok || active == "" || standby == "" || active == standby {
+		return nil, fmt.Errorf("invalid pools %q, want two: active,standby", v)
+	}
+	c := &cutover{names: [2]string{active, standby}}
+	for i, name := range c.names {
+		if c.policies[i], ok = pools[name]; !ok {
+			return nil, fmt.Errorf("no pool named %q, see -pool", name)
+		}
+	}
+	return c, nil
+}
+
+// policy returns the policy of the active pool, or def if c is nil. A
+// connection or request keeps the policy it got for its whole life, so the
+// outcome is reported to the pool it was balanced over.
+func (c *cutover) policy(def load_balancer.Policy) load_balancer.Policy {
+	if c == nil {
+		return def
+	}
+	return c.policies[c.active.Load()]
+}
+
+// state returns the names of the active and the standby pool.
+func (c *cutover) state() (active, standby string) {
+	i := c.active.Load()
+	return c.names[i], c.names[1-i]
+}
+
+// cut makes the named pool active, the standby one if name is empty, and
+// drains the other in the background: its open connections finish, those left
+// after maxWait, if not zero, are closed. Cutting over to the active pool
+// changes nothing.
+func (c *cutover) cut(name string, maxWait time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	from := c.active.Load()
+	to := 1 - from
+	switch name {
+	case "", c.names[to]:
+	case c.names[from]:
+		return nil
+	default:
+		return fmt.Errorf("no pool %s, want %s or %s", name, c.names[0], c.names[1])
+	}
+	c.active.Store(to)
+	logger.Printf("Cut over from pool %s to %s", c.names[from], c.names[to])
+	go c.drain(from, maxWait)
+	return nil
+}
+
+// drain waits for the connections open to the backends of pool i to finish,
+// closing those left after maxWait if not zero. It gives up if the pool is made
+// active again first.
+func (c *cutover) drain(i int32, maxWait time.Duration) {
+	var backends []string
+	for _, b := range c.policies[i].Stats().Backends {
+		backends = append(backends, b.Address)
+	}
+	deadline := time.Now().Add(maxWait)
+	for c.active.Load() != i {
+		open := 0
+		for _, b := range backends {
+			open += openConns.count(b)
+		}
+		if open == 0 {
+			logger.Printf("Pool %s drained", c.names[i])
+			return
+		}
+		if maxWait > 0 && time.Now().After(deadline) {
+			closed := 0
+			for _, b := range backends {
+				closed += openConns.closeAll(b)
+			}
+			logger.Printf("Drain of pool %s timed out, closed %d connections", c.names[i], closed)
+			return
+		}
+		time.Sleep(drainPoll)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseBlueGreen(t *testing.T) {
+	withPools(t, "blue", "green")
+	for _, v := range []string{"blue", "blue,", ",green", "blue,blue", "blue,nope", "nope,green"} {
+		if _, err := parseBlueGreen(v); err == nil {
+			t.Errorf("parseBlueGreen(%q) succeeded", v)
+		}
+	}
+	c, err := parseBlueGreen("green,blue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active, standby := c.state(); active != "green" || standby != "blue" {
+		t.Errorf("active %s, standby %s; want green, the first, then blue", active, standby)
+	}
+	var none *cutover
+	def := pools["blue"]
+	if none.policy(def) != def {
+		t.Error("no blue/green pools, want the default pool")
+	}
+}
+
+func TestCutover(t *testing.T) {
+	p := withPools(t, "blue", "green")
+	c, err := parseBlueGreen("blue,green")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		pool, active string
+		ok           bool
+	}{
+		{"", "green", true}, // the standby pool
+		{"green", "green", true},
+		{"blue", "blue", true},
+		{"nope", "blue", false},
+		{"", "green", true},
+	}
+	for _, tt := range tests {
+		if err := c.cut(tt.pool, 0); (err == nil) != tt.ok {
+			t.Errorf("cut(%q): %v", tt.pool, err)
+		}
+		if active, _ := c.state(); active != tt.active || c.policy(nil) != p[tt.active] {
+			t.Errorf("after cut(%q), %s is active, want %s", tt.pool, active, tt.active)
+		}
+	}
+
+	// connections picking a pool during cutovers get one or the other
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				if policy := c.policy(nil); policy != p["blue"] && policy != p["green"] {
+					t.Error("picked neither pool")
+					return
+				}
+			}
+		}()
+	}
+	for range 100 {
+		c.cut("", 0)
+	}
+	wg.Wait()
+}
+
+// openConn adds a connection to backend to the open connections for the test
+// and returns its client side.
+func openConn(t *testing.T, backend string) net.Conn {
+	t.Helper()
+	client, clientPeer := net.Pipe()
+	backendConn, backendPeer := net.Pipe()
+	t.Cleanup(func() {
+		for _, c := range []net.Conn{client, clientPeer, backendConn, backendPeer} {
+			c.Close()
+		}
+	})
+	c := proxied{client, backendConn}
+	openConns.add(c, newConnInfo("test", "client", backend, "tcp", time.Now()))
+	t.Cleanup(func() { openConns.remove(backend, c) })
+	return clientPeer
+}
+
+func TestCutoverDrain(t *testing.T) {
+	withPools(t, "blue", "green") // backends blue:80 and green:80
+	c, err := parseBlueGreen("blue,green")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// connections open to the old pool are left to finish, then closed once
+	// the timeout is up
+	conn := openConn(t, "blue:80")
+	if err := c.cut("green", 3*drainPoll); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(drainPoll)
+	if closedWithin(conn, 10*time.Millisecond) {
+		t.Fatal("connection to the old pool closed at once")
+	}
+	time.Sleep(4 * drainPoll)
+	if !closedWithin(conn, 10*time.Millisecond) {
+		t.Fatal("connection to the old pool still open after the drain timeout")
+	}
+
+	// a drain gives up when its pool is made active again
+	conn = openConn(t, "green:80")
+	if err := c.cut("blue", 3*drainPoll); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.cut("green", 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * drainPoll)
+	if closedWithin(conn, 10*time.Millisecond) {
+		t.Error("connection to the pool made active again closed by its drain")
+	}
+}
+
+func TestCutoverAdmin(t *testing.T) {
+	withPools(t, "blue", "green")
+	saved := blueGreen
+	t.Cleanup(func() { blueGreen = saved })
+	url := startAdmin(t, nil)
+
+	blueGreen = nil
+	if code, _ := adminCall(t, "POST", url+"/cutover"); code != http.StatusNotFound {
+		t.Errorf("POST /cutover without pools: %d, want 404", code)
+	}
+	var err error
+	if blueGreen, err = parseBlueGreen("blue,green"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method, query string
+		code          int
+		body          string
+	}{
+		{"GET", "", http.StatusOK, `{"active":"blue","standby":"green"}`},
+		{"POST", "", http.StatusOK, "green"},
+		{"GET", "", http.StatusOK, `{"active":"green","standby":"blue"}`},
+		{"POST", "?pool=green", http.StatusOK, "green"},
+		{"POST", "?pool=nope", http.StatusBadRequest, "no pool nope, want blue or green"},
+		{"POST", "?pool=blue&timeout=soon", http.StatusBadRequest, ""},
+		{"POST", "?pool=blue&timeout=30s", http.StatusOK, "blue"},
+	}
+	for _, tt := range tests {
+		code, body := adminCall(t, tt.method, url+"/cutover"+tt.query)
+		if code != tt.code || tt.body != "" && body != tt.body {
+			t.Errorf("%s /cutover%s: %d %s, want %d %s", tt.method, tt.query, code, body, tt.code, tt.body)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// ---------------- Copy buffers ---------------- //
+
+// copyBuffers holds the buffers connections and HTTP bodies are proxied
+// through, see -copy-buffer. Reusing them spares the GC a buffer per
+// connection when connections come and go quickly.
+var copyBuffers = &bufferPool{size: 32 << 10}
+
+// bufferPool is a pool of equally sized buffers that counts how often a buffer
+// had to be allocated. It implements httputil.BufferPool.
+type bufferPool struct {
+	size int
+	pool sync.Pool
+
+	gets, allocs atomic.Int64
+}
+
+// bufferStats is a bufferPool's counters, as served on GET /buffers.
+type bufferStats struct {
+	Size    int     `json:"size"`
+	Gets    int64   `json:"gets"`
+	Allocs  int64   `json:"allocs"`
+	HitRate float64 `json:"hit_rate"` // share of gets served from the pool
+}
+
+func (p *bufferPool) Get() []byte {
+	p.gets.Add(1)
+	if b, ok := p.pool.Get().(*[]byte); ok {
+		return *b
+	}
+	p.allocs.Add(1)
+	return make([]byte, p.size)
+}
+
+func (p *bufferPool) Put(b []byte) {
+	if len(b) == p.size {
+		p.pool.Put(&b)
+	}
+}
+
+func (p *bufferPool) stats() bufferStats {
+	s := bufferStats{Size: p.size, Gets: p.gets.Load(), Allocs: p.allocs.Load()}
+	if s.Gets > 0 {
+		s.HitRate = float64(s.Gets-s.Allocs) / float64(s.Gets)
+	}
+	return s
+}
+
+// writerOnly and readerOnly hide the ReadFrom and WriteTo of connections such
+// as *net.TCPConn, which would copy through a buffer of their own.
+type writerOnly struct {
+	io.Writer
+}
+
+type readerOnly struct {
+	io.Reader
+}
+
+// copyPooled is io.Copy through a buffer from copyBuffers.
+func copyPooled(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get()
+	defer copyBuffers.Put(buf)
+	return io.CopyBuffer(writerOnly{dst}, readerOnly{src}, buf)
+}
+
+// splice TCP connections to each other instead of copying through a buffer,
+// see -splice
+var spliceConns bool
+
+// relay copies src to dst for one direction of a proxied connection, counting
+// reads as activity for idle, pacing them to the bandwidth limits and adding
+// the bytes written to count, if not nil. If those are off, with -splice two
+// TCP connections are spliced on Linux: the kernel moves the bytes from one
+// socket to the other and they never reach user space, and count only gets
+// them once it is done.
+func relay(dst, src net.Conn, idle *idleWatch, count *atomic.Int64) (int64, error) {
+	if spliceConns && idle == nil && connBandwidth == 0 && totalBandwidth == nil {
+		if n, ok, err := splice(dst, src); ok {
+			if count != nil {
+				count.Add(n)
+			}
+			return n, err
+		}
+	}
+	var w io.Writer = dst
+	if count != nil {
+		w = countingWriter{dst, count}
+	}
+	return copyPooled(w, throttled(idle.reader(src), newThrottle(connBandwidth), totalBandwidth))
+}
+
+// countingWriter adds the bytes written to n.
+type countingWriter struct {
+	io.Writer
+	n *atomic.Int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n.Add(int64(n))
+	return n, err
+}
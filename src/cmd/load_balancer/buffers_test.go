@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(b *testing.B) (net.Conn, net.Conn) {
+	b.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+	dialed, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	accepted, err := l.Accept()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		dialed.Close()
+		accepted.Close()
+	})
+	return dialed, accepted
+}
+
+// BenchmarkRelay measures the throughput of one proxied direction between
+// loopback TCP connections, spliced (Linux only) and through a pooled buffer.
+func BenchmarkRelay(b *testing.B) {
+	for _, bc := range []struct {
+		name   string
+		splice bool
+	}{
+		{"splice", true},
+		{"buffered", false},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			if bc.splice && !canSplice {
+				b.Skip("no splice(2) on this platform")
+			}
+			spliceConns = bc.splice
+			defer func() { spliceConns = false }()
+			client, relayIn := tcpPair(b)
+			relayOut, backend := tcpPair(b)
+			chunk := make([]byte, 1<<20)
+			b.SetBytes(int64(len(chunk)))
+			go func() {
+				relay(relayOut, relayIn, nil, nil)
+				relayOut.Close()
+			}()
+			go func() {
+				for range b.N {
+					client.Write(chunk)
+				}
+				client.Close()
+			}()
+			b.ResetTimer()
+			n, err := io.Copy(io.Discard, backend)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if n != int64(b.N)*int64(len(chunk)) {
+				b.Fatalf("relayed %d bytes, want %d", n, int64(b.N)*int64(len(chunk)))
+			}
+		})
+	}
+}
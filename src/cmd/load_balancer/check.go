@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// ---------------- Pre-flight check ---------------- //
+
+// how long a discovery provider gets for its first lookup in -check
+const checkLookupTimeout = 10 * time.Second
+
+// preflight checks the TLS certificates and resolves every backend, static or
+// discovered; with dial it also connects to each and runs its health check. It writes one line per
+// backend to w and reports whether all of them passed.
+func preflight(w io.Writer, backends []load_balancer.Backend, providers []source, dial bool) bool {
+	ok := true
+	fail := func(format string, args ...any) {
+		ok = false
+		fmt.Fprintf(w, "FAIL "+format+"\n", args...)
+	}
+
+	for _, store := range certStores {
+		cert := store.current().Leaf
+		if time.Now().After(cert.NotAfter) {
+			fail("certificate %s (%s) expired on %s", store.certFile, cert.Subject, cert.NotAfter.Format(time.DateOnly))
+		} else {
+			fmt.Fprintf(w, "ok   certificate %s (%s), valid until %s\n", store.certFile, cert.Subject, cert.NotAfter.Format(time.DateOnly))
+		}
+	}
+
+	all := append([]load_balancer.Backend(nil), backends...)
+	for _, p := range providers {
+		ctx, cancel := context.WithTimeout(context.Background(), checkLookupTimeout)
+		found, err := p.Backends(ctx)
+		cancel()
+		if err != nil {
+			fail("%s: %v", p.Name(), err)
+			continue
+		}
+		if len(found) == 0 {
+			fail("%s: no backends", p.Name())
+			continue
+		}
+		fmt.Fprintf(w, "ok   %s: %d backends\n", p.Name(), len(found))
+		all = append(all, found...)
+	}
+	if len(all) == 0 {
+		fail("no backends")
+	}
+
+	for _, b := range all {
+		ctx, cancel := context.WithTimeout(context.Background(), dialer.Timeout)
+		err := checkBackend(ctx, b.Address, dial)
+		cancel()
+		if err != nil {
+			fail("%s: %v", b.Address, err)
+		} else {
+			fmt.Fprintf(w, "ok   %s\n", b.Address)
+		}
+	}
+	return ok
+}
+
+// checkBackend resolves server, or finds its unix socket, and with dial connects
+// to it and runs its health check.
+func checkBackend(ctx context.Context, server string, dial bool) error {
+	if network, path := load_balancer.SplitNetwork(server); network == "unix" {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s is not a socket", path)
+		}
+	} else {
+		host, _, err := net.SplitHostPort(server)
+		if err != nil {
+			return err
+		}
+		if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+			return err
+		}
+	}
+	if !dial {
+		return nil
+	}
+	conn, err := dialBackend(ctx, server, nil, "")
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	if checker != nil {
+		if err := checker.Check(ctx, server); err != nil {
+			return fmt.Errorf("health check: %w", err)
+		}
+	}
+	return nil
+}
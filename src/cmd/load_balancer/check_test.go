@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// fixedProvider is a discovery provider that always finds the same backends,
+// or fails with err.
+type fixedProvider struct {
+	backends []load_balancer.Backend
+	err      error
+}
+
+func (p fixedProvider) Name() string { return "fixed" }
+
+func (p fixedProvider) Backends(context.Context) ([]load_balancer.Backend, error) {
+	return p.backends, p.err
+}
+
+func TestPreflight(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	up, down := l.Addr().String(), closedAddr(t)
+
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "up.sock")
+	ul, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ul.Close()
+	notSocket := filepath.Join(dir, "file")
+	if err := os.WriteFile(notSocket, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	backends := func(addrs ...string) []load_balancer.Backend {
+		var out []load_balancer.Backend
+		for _, a := range addrs {
+			out = append(out, load_balancer.Backend{Address: a})
+		}
+		return out
+	}
+	tests := []struct {
+		name      string
+		backends  []string
+		providers []source
+		dial      bool
+		ok        bool
+		want      []string // lines of the report
+	}{
+		{"all up", []string{up, "unix://" + socket}, nil, true, true,
+			[]string{"ok   " + up, "ok   unix://" + socket}},
+		{"one unreachable", []string{up, down}, nil, true, false,
+			[]string{"ok   " + up, "FAIL " + down + ": dial tcp " + down + ": connect: connection refused"}},
+		{"unreachable, not dialed", []string{down}, nil, false, true,
+			[]string{"ok   " + down}},
+		{"no port", []string{"127.0.0.1"}, nil, false, false,
+			[]string{"FAIL 127.0.0.1: address 127.0.0.1: missing port in address"}},
+		{"no socket", []string{"unix://" + filepath.Join(dir, "none.sock")}, nil, false, false,
+			[]string{"FAIL unix://" + filepath.Join(dir, "none.sock") + ": stat " + filepath.Join(dir, "none.sock") + ": no such file or directory"}},
+		{"not a socket", []string{"unix://" + notSocket}, nil, false, false,
+			[]string{"FAIL unix://" + notSocket + ": " + notSocket + " is not a socket"}},
+		{"no backends", nil, nil, false, false,
+			[]string{"FAIL no backends"}},
+		{"discovered", nil, []source{{Provider: fixedProvider{backends: backends(up, down)}}}, true, false,
+			[]string{"ok   fixed: 2 backends", "ok   " + up, "FAIL " + down + ": dial tcp " + down + ": connect: connection refused"}},
+		{"discovery failing", []string{up}, []source{{Provider: fixedProvider{err: errors.New("registry down")}}}, true, false,
+			[]string{"FAIL fixed: registry down", "ok   " + up}},
+		{"discovering nothing", []string{up}, []source{{Provider: fixedProvider{}}}, true, false,
+			[]string{"FAIL fixed: no backends", "ok   " + up}},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		if ok := preflight(&out, backends(tt.backends...), tt.providers, tt.dial); ok != tt.ok {
+			t.Errorf("%s: preflight = %v, want %v", tt.name, ok, tt.ok)
+		}
+		if got, want := out.String(), strings.Join(tt.want, "\n")+"\n"; got != want {
+			t.Errorf("%s: reported\n%s\nwant\n%s", tt.name, got, want)
+		}
+	}
+}
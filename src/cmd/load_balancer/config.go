@@ -0,0 +1,405 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+	"Load-Balancer/pkg/discovery"
+	"Load-Balancer/pkg/load_balancer"
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// ---------------- Config file ---------------- //
+
+// config is the settings read from -config, a JSON, YAML or TOML file, as
+// keys and values of the same names whatever the format. Its reloadable
+// part is the policy, servers, slow start, panic threshold and the frontends'
+// policies and servers; fields left out keep their current value, from the
+// flags or an earlier load.
+//
+//	{
+//	  "policy": "LeastConnections",
+//	  "servers": [{"address": "localhost:8000", "weight": 2}, {"address": "localhost:8001", "maintenance": true}],
+//	  "slow_start": "30s",
+//	  "panic_threshold": 50,
+//	  "frontends": [{"name": "api", "listen": ":9000", "mode": "http", "servers": [{"address": "localhost:9001"}]}]
+//	}
+//
+// The rest is read at startup, as the flags it stands for: pools, routes and
+// health checks in sections of their own and any flag by name. Flags given on
+// the command line or in LB_* variables win over them, -a over the policy and
+// -s over the servers included, at startup and on reload.
+type config struct {
+	Policy         string           `yaml:"policy"`
+	Servers        []serverConfig   `yaml:"servers"`
+	SlowStart      duration         `yaml:"slow_start"`
+	PanicThreshold float64          `yaml:"panic_threshold"`
+	Frontends      []frontendConfig `yaml:"frontends"`
+	// LogSink is -log-sink, read at startup
+	LogSink string `yaml:"log_sink"`
+
+	Pools  map[string]poolConfig `yaml:"pools"`
+	Routes []routeConfig         `yaml:"routes"`
+	Health *healthConfig         `yaml:"health"`
+	// Flags are the other keys of the file: flag names, with - or _, and their
+	// value, or a list of values for repeatable flags
+	Flags map[string]yaml.Node `yaml:",inline"`
+
+	// Schema is the JSON Schema editors check the file against, see
+	// load_balancer config schema
+	Schema string `yaml:"$schema"`
+
+	// lines is the tree the config was decoded from, to locate mistakes
+	lines *yaml.Node
+}
+
+type serverConfig struct {
+	Address  string            `yaml:"address"`
+	Weight   int               `yaml:"weight"`
+	Zone     string            `yaml:"zone"`
+	Metadata map[string]string `yaml:"metadata"`
+	// Maintenance keeps the server in the pool without traffic or health checks
+	Maintenance bool `yaml:"maintenance"`
+}
+
+// frontendConfig is a listener of its own with its own backends, so one process
+// can balance several services. Its listener and mode are read at startup; a
+// reload applies its policy and servers.
+type frontendConfig struct {
+	Name    string         `yaml:"name"`
+	Listen  string         `yaml:"listen"` // host:port or unix:///path
+	Mode    string         `yaml:"mode"`   // tcp (default), http or grpc
+	Policy  string         `yaml:"policy"` // default: -a
+	Servers []serverConfig `yaml:"servers"`
+}
+
+// duration is a time.Duration written as a string, e.g. "30s".
+type duration time.Duration
+
+func (d *duration) UnmarshalYAML(node *yaml.Node) error {
+	v, err := time.ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*d = duration(v)
+	return nil
+}
+
+// configError is a mistake at path in the config file, its keys and indices
+// from the top, e.g. servers, 2, for the error to say on which line.
+type configError struct {
+	path []any
+	msg  string
+}
+
+func (e *configError) Error() string { return e.msg }
+
+func badConfig(path []any, format string, args ...any) *configError {
+	return &configError{path, fmt.Sprintf(format, args...)}
+}
+
+// at returns path followed by more.
+func at(path []any, more ...any) []any {
+	return append(slices.Clip(path), more...)
+}
+
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseConfig(path, data)
+	if err != nil {
+		return nil, err
+	}
+	var cfg config
+	if err := decodeConfig(path, root, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.check(); err != nil {
+		return nil, cfg.errorIn(path, err)
+	}
+	return &cfg, nil
+}
+
+// check validates cfg, making server addresses canonical and defaulting the
+// mode of frontends to tcp.
+func (cfg *config) check() error {
+	if err := checkServers(cfg.Servers, []any{"servers"}); err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for i, fc := range cfg.Frontends {
+		where := []any{"frontends", i}
+		if fc.Mode == "" {
+			fc.Mode = "tcp"
+			cfg.Frontends[i].Mode = fc.Mode
+		}
+		switch {
+		case fc.Name == "":
+			return badConfig(where, "frontend %d has no name", i)
+		case names[fc.Name]:
+			return badConfig(where, "frontend %s listed twice", fc.Name)
+		case fc.Listen == "":
+			return badConfig(where, "frontend %s has no listen address", fc.Name)
+		case fc.Mode != "tcp" && fc.Mode != "http" && fc.Mode != "grpc":
+			return badConfig(at(where, "mode"), "frontend %s: invalid mode %q, want tcp, http or grpc", fc.Name, fc.Mode)
+		case len(fc.Servers) == 0:
+			return badConfig(where, "frontend %s has no servers", fc.Name)
+		}
+		if err := checkServers(fc.Servers, at(where, "servers")); err != nil {
+			err.msg = "frontend " + fc.Name + ": " + err.msg
+			return err
+		}
+		names[fc.Name] = true
+	}
+	_, err := cfg.flagSettings()
+	return err
+}
+
+// errorIn prefixes err, found in the config file at path, with the file and
+// the line of the mistake.
+func (cfg *config) errorIn(path string, err error) error {
+	var ce *configError
+	if errors.As(err, &ce) && cfg.lines != nil {
+		return fmt.Errorf("%s:%d: %w", path, lineOf(cfg.lines, ce.path), err)
+	}
+	return fmt.Errorf("%s: %w", path, err)
+}
+
+// checkServers validates servers, at path in the config file, making their
+// addresses canonical.
+func checkServers(servers []serverConfig, path []any) *configError {
+	seen := make(map[string]bool)
+	for i, s := range servers {
+		s.Address = load_balancer.CanonicalAddress(s.Address)
+		servers[i].Address = s.Address
+		switch {
+		case s.Address == "":
+			return badConfig(at(path, i), "server %d has no address", i)
+		case seen[s.Address]:
+			return badConfig(at(path, i), "server %s listed twice", s.Address)
+		case s.Weight < 0:
+			return badConfig(at(path, i, "weight"), "invalid weight %d for %s", s.Weight, s.Address)
+		}
+		seen[s.Address] = true
+	}
+	return nil
+}
+
+// without returns cfg less the policy and servers that flags in given, those
+// set on the command line or in the environment, override.
+func (cfg *config) without(given map[string]bool) *config {
+	out := *cfg
+	if given["a"] {
+		out.Policy = ""
+	}
+	if given["s"] {
+		out.Servers = nil
+	}
+	return &out
+}
+
+// backends returns the servers of cfg as backends.
+func (cfg *config) backends() []load_balancer.Backend { return backendsOf(cfg.Servers) }
+
+func backendsOf(servers []serverConfig) []load_balancer.Backend {
+	out := make([]load_balancer.Backend, 0, len(servers))
+	for _, s := range servers {
+		out = append(out, load_balancer.Backend{Address: s.Address, Weight: s.Weight, Zone: s.Zone, Metadata: s.Metadata, Maintenance: s.Maintenance})
+	}
+	return out
+}
+
+// applyConfig brings the running balancer in line with cfg without dropping
+// connections: new backends are added, removed ones drained then dropped, and
+// changed weights and settings applied.
+func applyConfig(policy *load_balancer.Switchable, cfg *config, drainTimeout time.Duration) error {
+	if cfg.Policy != "" && cfg.Policy != policy.Name() {
+		if err := policy.Switch(cfg.Policy); err != nil {
+			return err
+		}
+		logger.Printf("Policy switched to %s", cfg.Policy)
+	}
+	if cfg.SlowStart != 0 {
+		policy.SetSlowStart(time.Duration(cfg.SlowStart))
+	}
+	if cfg.PanicThreshold != 0 {
+		policy.SetPanicThreshold(cfg.PanicThreshold)
+	}
+	if len(cfg.Servers) == 0 {
+		return nil
+	}
+	return syncBackends(policy, "", cfg.backends(), drainTimeout)
+}
+
+// syncBackends makes the backends from source, "" for the ones configured
+// directly, match backends: new ones are added, missing ones drained then
+// removed, and changed weights and maintenance applied. Backends from other
+// sources are left alone.
+func syncBackends(policy *load_balancer.Switchable, source string, backends []load_balancer.Backend, drainTimeout time.Duration) error {
+	current := make(map[string]load_balancer.Backend)
+	for _, b := range policy.Backends() {
+		if b.Metadata[discovery.SourceKey] == source {
+			current[b.Address] = b
+		}
+	}
+	wanted := make(map[string]bool)
+	for _, b := range backends {
+		wanted[b.Address] = true
+		if b.Weight <= 0 {
+			b.Weight = load_balancer.DefaultWeight
+		}
+		old, ok := current[b.Address]
+		switch {
+		case !ok:
+			if err := policy.AddServer(b); err != nil {
+				return err
+			}
+			logger.Printf("Backend %s added", b.Address)
+			continue
+		case old.Draining:
+			policy.SetDraining(b.Address, false) // back before its drain finished
+			logger.Printf("Backend %s back in rotation", b.Address)
+		}
+		if old.Weight != b.Weight {
+			policy.SetWeight(b.Address, b.Weight)
+			logger.Printf("Backend %s weight set to %d", b.Address, b.Weight)
+		}
+		// discovery has no notion of maintenance, only the config file sets it
+		if source == "" && old.Maintenance != b.Maintenance {
+			policy.SetMaintenance(b.Address, b.Maintenance)
+			logger.Printf("Backend %s maintenance set to %t", b.Address, b.Maintenance)
+		}
+	}
+	for server, b := range current {
+		if wanted[server] || b.Draining {
+			continue
+		}
+		policy.SetDraining(server, true)
+		logger.Printf("Draining removed backend %s", server)
+		go func() {
+			drain(policy, server, drainTimeout)
+			if stillDraining(policy, server) {
+				policy.RemoveServer(server)
+				logger.Printf("Backend %s removed", server)
+			}
+		}()
+	}
+	return nil
+}
+
+// reloader applies changes to the config file. It keeps the last config that
+// applied cleanly, to roll back to when a new one fails halfway.
+type reloader struct {
+	path         string
+	policy       *load_balancer.Switchable
+	frontends    map[string]*frontend // from the config file, by name
+	drainTimeout time.Duration
+	given        map[string]bool // flags set on the command line or in the environment
+
+	mu   sync.Mutex
+	good *config
+}
+
+// reload re-reads the config file and applies it. A file that doesn't parse
+// leaves the running settings alone.
+func (r *reloader) reload() {
+	if r.path == "" {
+		logger.Printf("Reload requested but no -config file given")
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	logger.Printf("Reloading %s", r.path)
+	cfg, err := loadConfig(r.path)
+	if err != nil {
+		logger.Printf("ERROR reloading %s, keeping the running config: %v", r.path, err)
+		return
+	}
+	cfg = cfg.without(r.given)
+	if err := r.apply(cfg); err != nil {
+		logger.Printf("ERROR applying %s, rolling back: %v", r.path, err)
+		if r.good != nil {
+			r.apply(r.good)
+		}
+		return
+	}
+	r.good = cfg
+}
+
+// apply applies cfg to the main frontend and to the frontends it lists. A
+// frontend's listener can't change while it runs; new ones, and changes to a
+// listener or mode, wait for a restart.
+func (r *reloader) apply(cfg *config) error {
+	if err := applyConfig(r.policy, cfg, r.drainTimeout); err != nil {
+		return err
+	}
+	for _, fc := range cfg.Frontends {
+		f, ok := r.frontends[fc.Name]
+		if !ok {
+			logger.Printf("Frontend %s is new, it starts with the next restart", fc.Name)
+			continue
+		}
+		if fc.Listen != f.addr || fc.Mode != f.mode {
+			logger.Printf("Frontend %s listens on %s in %s mode until the next restart", fc.Name, f.addr, f.mode)
+		}
+		if err := applyConfig(f.policy, &config{Policy: fc.Policy, Servers: fc.Servers}, r.drainTimeout); err != nil {
+			return fmt.Errorf("frontend %s: %w", fc.Name, err)
+		}
+	}
+	return nil
+}
+
+// how long a watched file has to stay quiet before a change is applied, so a
+// write in several steps is read once, complete
+const watchSettle = 200 * time.Millisecond
+
+// watch reloads the config file whenever it changes.
+func (r *reloader) watch() error {
+	logger.Printf("Watching %s for changes", r.path)
+	return watchFiles([]string{r.path}, r.reload)
+}
+
+// watchFiles calls changed whenever one of paths changes, once per burst of
+// changes. Their directories are watched rather than the files, so replacing a
+// file by a rename is seen too.
+func watchFiles(paths []string, changed func()) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	names := make(map[string]bool)
+	for _, path := range paths {
+		names[filepath.Clean(path)] = true
+		if err := w.Add(filepath.Dir(path)); err != nil {
+			return err
+		}
+	}
+	var settle <-chan time.Time
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if names[filepath.Clean(ev.Name)] && ev.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+				settle = time.After(watchSettle)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			logger.Printf("ERROR watching %v: %v", paths, err)
+		case <-settle:
+			settle = nil
+			changed()
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"Load-Balancer/pkg/load_balancer"
+	"gopkg.in/yaml.v3"
+)
+
+// the same config in each format
+var (
+	yamlConfig = `policy: LeastConnections
+slow_start: 30s
+panic_threshold: 50
+max_clients: 100
+servers:
+  - address: localhost:8000
+    weight: 2
+  - address: localhost:8001
+    maintenance: true
+pools:
+  api:
+    policy: RoundRobin
+    servers: [localhost:9000, localhost:9001]
+routes:
+  - path: /api
+    pool: api
+    strip: true
+health:
+  type: http
+  path: /healthz
+  interval: 5s
+frontends:
+  - name: admin
+    listen: ":9100"
+    servers:
+      - address: localhost:9101
+`
+	jsonConfig = `{
+  "policy": "LeastConnections",
+  "slow_start": "30s",
+  "panic_threshold": 50,
+  "max_clients": 100,
+  "servers": [{"address": "localhost:8000", "weight": 2}, {"address": "localhost:8001", "maintenance": true}],
+  "pools": {"api": {"policy": "RoundRobin", "servers": ["localhost:9000", "localhost:9001"]}},
+  "routes": [{"path": "/api", "pool": "api", "strip": true}],
+  "health": {"type": "http", "path": "/healthz", "interval": "5s"},
+  "frontends": [{"name": "admin", "listen": ":9100", "servers": [{"address": "localhost:9101"}]}]
+}
+`
+	tomlConfig = `policy = "LeastConnections"
+slow_start = "30s"
+panic_threshold = 50
+max_clients = 100
+
+[[servers]]
+address = "localhost:8000"
+weight = 2
+
+[[servers]]
+address = "localhost:8001"
+maintenance = true
+
+[pools.api]
+policy = "RoundRobin"
+servers = ["localhost:9000", "localhost:9001"]
+
+[[routes]]
+path = "/api"
+pool = "api"
+strip = true
+
+[health]
+type = "http"
+path = "/healthz"
+interval = "5s"
+
+[[frontends]]
+name = "admin"
+listen = ":9100"
+
+[[frontends.servers]]
+address = "localhost:9101"
+`
+)
+
+// withConfigFlags is withFlags with the flags the test configs name.
+func withConfigFlags(t *testing.T) {
+	t.Helper()
+	withFlags(t)
+	flag.Int("max-clients", 0, "")
+	flag.Duration("health-interval", 0, "")
+	flag.String("config", "", "")
+}
+
+// writeConfig writes a config file named name and returns its path.
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigFormats(t *testing.T) {
+	withConfigFlags(t)
+	type loaded struct {
+		cfg      config
+		settings []flagSetting
+	}
+	var want *loaded
+	for _, f := range []struct{ name, content string }{
+		{"lb.yaml", yamlConfig}, {"lb.json", jsonConfig}, {"lb.toml", tomlConfig},
+	} {
+		cfg, err := loadConfig(writeConfig(t, f.name, f.content))
+		if err != nil {
+			t.Fatalf("%s: %v", f.name, err)
+		}
+		settings, err := cfg.flagSettings()
+		if err != nil {
+			t.Fatalf("%s: %v", f.name, err)
+		}
+		// where the values were differs, not what they are
+		got := &loaded{*cfg, settings}
+		got.cfg.lines, got.cfg.Flags = nil, nil
+		if want == nil {
+			want = got
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s gives\n%+v\nwant, as lb.yaml gives,\n%+v", f.name, got, want)
+		}
+	}
+	if want.cfg.Frontends[0].Mode != "tcp" || len(want.cfg.Servers) != 2 || want.cfg.Pools["api"].Policy != "RoundRobin" {
+		t.Errorf("config decoded as %+v", want.cfg)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	withConfigFlags(t)
+	tests := []struct {
+		name, content, want string
+	}{
+		{"lb.yaml", "servers:\n  - address: localhost:8000\n    wieght: 2\n", "lb.yaml:3: unknown key wieght"},
+		{"lb.json", "{\n  \"servers\": [{\"address\": \"localhost:8000\", \"wieght\": 2}]\n}\n", "lb.json:2: unknown key wieght"},
+		{"lb.toml", "[[servers]]\naddress = \"localhost:8000\"\nwieght = 2\n", "lb.toml:3: unknown key wieght"},
+		{"lb.yaml", "max_clinets: 100\n", "lb.yaml:1: unknown setting \"max_clinets\", did you mean max-clients?"},
+		{"lb.toml", "max_clinets = 100\n", "did you mean max-clients?"},
+		{"lb.yaml", "health:\n  intervals: 5s\n", "lb.yaml:2: unknown key intervals"},
+		{"lb.yaml", "slow_start: soon\n", "lb.yaml:1: "},
+		{"lb.json", "{\"slow_start\": \"soon\"}", "invalid duration"},
+		{"lb.yaml", "servers:\n  - address: localhost:8000\n  - address: localhost:8000\n", "lb.yaml:3: server localhost:8000 listed twice"},
+		{"lb.yaml", "frontends:\n  - name: api\n    listen: \":9000\"\n    mode: udp\n    servers: [{address: localhost:9001}]\n", "lb.yaml:4: frontend api: invalid mode \"udp\""},
+		{"lb.json", "{\"policy\": \"RoundRobin\", \"policy\": \"N2One\"}", "policy"},
+		{"lb.yaml", "config: other.yaml\n", "can't name another"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+strings.TrimSpace(tt.want), func(t *testing.T) {
+			_, err := loadConfig(writeConfig(t, tt.name, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one with %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigSchema(t *testing.T) {
+	withConfigFlags(t)
+	schema := configSchema()
+	if schema["additionalProperties"] != false {
+		t.Error("schema allows keys no setting has")
+	}
+	props := schema["properties"].(map[string]any)
+	for _, key := range []string{"max_clients", "max-clients", "health_interval", "a", "s"} {
+		if props[key] == nil {
+			t.Errorf("schema has no flag %s", key)
+		}
+	}
+	if props["config"] != nil {
+		t.Error("schema allows config, a file can't name another")
+	}
+	if !reflect.DeepEqual(props["slow_start"], durationSchema) {
+		t.Errorf("slow_start is %v, want a duration", props["slow_start"])
+	}
+
+	// every key of a full config is in the schema, with a value of its type
+	root, err := parseYAML("lb.yaml", []byte(yamlConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSchema(t, schema, root.Content[0], "")
+
+	// and every key of the config struct is in the schema
+	var walk func(reflect.Type, map[string]any, string)
+	walk = func(typ reflect.Type, s map[string]any, where string) {
+		for typ.Kind() == reflect.Pointer || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Map {
+			switch typ.Kind() {
+			case reflect.Slice:
+				s = s["items"].(map[string]any)
+			case reflect.Map:
+				s = s["additionalProperties"].(map[string]any)
+			}
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct || typ == reflect.TypeFor[yaml.Node]() {
+			return
+		}
+		props := s["properties"].(map[string]any)
+		fields, _ := configFields(typ)
+		for key, f := range fields {
+			sub, ok := props[key].(map[string]any)
+			if !ok {
+				t.Errorf("schema has no %s%s", where, key)
+				continue
+			}
+			walk(f.Type, sub, where+key+".")
+		}
+	}
+	walk(reflect.TypeFor[config](), schema, "")
+}
+
+// checkSchema reports the keys and values of n, at where in a config, that
+// schema doesn't allow.
+func checkSchema(t *testing.T, schema map[string]any, n *yaml.Node, where string) {
+	t.Helper()
+	types, _ := schema["type"].([]string)
+	if typ, ok := schema["type"].(string); ok {
+		types = []string{typ}
+	}
+	kind := map[yaml.Kind]string{yaml.MappingNode: "object", yaml.SequenceNode: "array"}[n.Kind]
+	if kind == "" {
+		kind = "scalar"
+	}
+	ok := false
+	for _, typ := range types {
+		switch typ {
+		case "object", "array":
+			ok = ok || typ == kind
+		default:
+			ok = ok || kind == "scalar"
+		}
+	}
+	if !ok {
+		t.Errorf("%s: a %s, schema wants %v", where, kind, types)
+		return
+	}
+	switch n.Kind {
+	case yaml.MappingNode:
+		props, _ := schema["properties"].(map[string]any)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			sub, ok := props[key].(map[string]any)
+			if !ok {
+				sub, ok = schema["additionalProperties"].(map[string]any)
+			}
+			if !ok {
+				t.Errorf("%s%s: not in the schema", where, key)
+				continue
+			}
+			checkSchema(t, sub, n.Content[i+1], where+key+".")
+		}
+	case yaml.SequenceNode:
+		for _, item := range n.Content {
+			checkSchema(t, schema["items"].(map[string]any), item, where)
+		}
+	}
+}
+
+// newReloader returns a reloader of the config file at path, for a main
+// frontend over servers.
+func newReloader(t *testing.T, path string, servers ...string) *reloader {
+	t.Helper()
+	policy, err := load_balancer.NewSwitchable("RoundRobin", load_balancer.NewPool(load_balancer.NewBackends(servers)), load_balancer.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &reloader{path: path, policy: policy, frontends: make(map[string]*frontend), drainTimeout: time.Minute}
+}
+
+// backendState returns the backends of policy as address, weight and
+// whether draining, e.g. "a:80 1" and "b:80 1 draining", in pool order.
+func backendState(policy *load_balancer.Switchable) []string {
+	var out []string
+	for _, b := range policy.Backends() {
+		s := b.Address + " " + strconv.Itoa(b.Weight)
+		if b.Draining {
+			s += " draining"
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// waitFor fails the test unless cond holds within a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: not within a second", what)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	withConfigFlags(t)
+	path := writeConfig(t, "lb.yaml", "")
+	r := newReloader(t, path, "a:80")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		r.reload()
+	}
+	want := func(state ...string) {
+		t.Helper()
+		if got := backendState(r.policy); !slices.Equal(got, state) {
+			t.Fatalf("backends %q, want %q", got, state)
+		}
+	}
+
+	// added, and weights changed
+	write("servers: [{address: a:80, weight: 3}, {address: b:80}, {address: c:80}]\n")
+	want("a:80 3", "b:80 1", "c:80 1")
+
+	// removed: drained while its connections finish, then dropped
+	conn := proxied{}
+	conn.client, conn.backend = net.Pipe()
+	defer conn.client.Close()
+	openConns.add(conn, newConnInfo("test", "client", "b:80", "tcp", time.Now()))
+	write("servers: [{address: a:80, weight: 3}, {address: c:80}]\n")
+	want("a:80 3", "b:80 1 draining", "c:80 1")
+	time.Sleep(2 * drainPoll)
+	want("a:80 3", "b:80 1 draining", "c:80 1")
+	openConns.remove("b:80", conn)
+	waitFor(t, "drained backend removed", func() bool { return len(r.policy.Backends()) == 2 })
+	want("a:80 3", "c:80 1")
+
+	// re-added while draining: back in rotation, its connections kept past
+	// the drain timeout
+	r.drainTimeout = drainPoll
+	conn.client, conn.backend = net.Pipe()
+	defer conn.client.Close()
+	openConns.add(conn, newConnInfo("test", "client", "c:80", "tcp", time.Now()))
+	defer openConns.remove("c:80", conn)
+	write("servers: [{address: a:80, weight: 3}]\n")
+	want("a:80 3", "c:80 1 draining")
+	write("servers: [{address: a:80, weight: 3}, {address: c:80, weight: 2}]\n")
+	want("a:80 3", "c:80 2")
+	time.Sleep(3 * drainPoll)
+	want("a:80 3", "c:80 2")
+	if closedWithin(conn.backend, 10*time.Millisecond) {
+		t.Error("connection to a backend put back closed by its old drain")
+	}
+
+	// a file that doesn't load leaves the running config alone
+	for _, bad := range []string{"servers: [{address: a:80\n", "servers: [{address: a:80, wieght: 2}]\n"} {
+		write(bad)
+		want("a:80 3", "c:80 2")
+	}
+}
+
+func TestReloadRollsBack(t *testing.T) {
+	withConfigFlags(t)
+	path := writeConfig(t, "lb.yaml", "")
+	r := newReloader(t, path)
+	admin, err := load_balancer.NewSwitchable("RoundRobin", load_balancer.NewPool(load_balancer.NewBackends([]string{"x:80"})), load_balancer.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.frontends["admin"] = &frontend{name: "admin", addr: ":9100", mode: "tcp", policy: admin}
+	good := "servers: [{address: a:80}]\nfrontends: [{name: admin, listen: \":9100\", servers: [{address: x:80}]}]\n"
+	if err := os.WriteFile(path, []byte(good), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r.reload()
+	if got := backendState(r.policy); !slices.Equal(got, []string{"a:80 1"}) {
+		t.Fatalf("backends %q after a good reload", got)
+	}
+
+	// the main frontend's servers apply, then the admin frontend's policy
+	// fails: the main frontend goes back to a:80
+	bad := "servers: [{address: a:80}, {address: b:80}]\nfrontends: [{name: admin, listen: \":9100\", policy: Nope, servers: [{address: x:80}]}]\n"
+	if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r.reload()
+	if got := backendState(r.policy); !slices.Equal(got, []string{"a:80 1", "b:80 1 draining"}) {
+		t.Errorf("backends %q after a failed reload, want b:80 on its way out", got)
+	}
+	waitFor(t, "rolled back", func() bool { return len(r.policy.Backends()) == 1 })
+	if admin.Name() != "RoundRobin" {
+		t.Errorf("admin frontend switched to %s", admin.Name())
+	}
+}
+
+func TestWatchFilesDebounces(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "lb.yaml")
+	changes := make(chan struct{}, 10)
+	go watchFiles([]string{path}, func() { changes <- struct{}{} })
+	time.Sleep(50 * time.Millisecond) // for the watch to start
+
+	// writes in quick succession, and other files changing, are one change
+	for i := range 5 {
+		if err := os.WriteFile(path, []byte(strings.Repeat("#\n", i)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(filepath.Join(dir, "other.yaml"), nil, 0o644)
+		time.Sleep(watchSettle / 10)
+	}
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no change seen")
+	}
+	select {
+	case <-changes:
+		t.Fatal("a burst of writes seen as more than one change")
+	case <-time.After(2 * watchSettle):
+	}
+
+	// so is a file replaced by a rename, as editors save
+	tmp := filepath.Join(dir, ".lb.yaml.tmp")
+	if err := os.WriteFile(tmp, []byte("servers: []\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatal("file replaced by a rename not seen")
+	}
+	select {
+	case <-changes:
+		t.Fatal("a rename seen as more than one change")
+	case <-time.After(2 * watchSettle):
+	}
+}
+
+func TestWatchKeepsRunningConfig(t *testing.T) {
+	withConfigFlags(t)
+	path := writeConfig(t, "lb.yaml", "servers: [{address: a:80}]\n")
+	r := newReloader(t, path, "a:80")
+	go r.watch()
+	time.Sleep(50 * time.Millisecond) // for the watch to start
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	has := func(n int) func() bool { return func() bool { return len(r.policy.Backends()) == n } }
+
+	write("servers: [{address: a:80}, {address: b:80}]\n")
+	waitFor(t, "change applied", has(2))
+
+	// half a file, as an editor might leave it, doesn't apply
+	write("servers: [{address: a:80}, {address: c:80\n")
+	time.Sleep(3 * watchSettle)
+	if got := backendState(r.policy); !slices.Equal(got, []string{"a:80 1", "b:80 1"}) {
+		t.Fatalf("backends %q after an invalid file, want the running ones", got)
+	}
+
+	// and the watch goes on
+	write("servers: [{address: a:80}, {address: b:80}, {address: c:80}]\n")
+	waitFor(t, "change after an invalid file applied", has(3))
+}
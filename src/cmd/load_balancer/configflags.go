@@ -0,0 +1,299 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"gopkg.in/yaml.v3"
+)
+
+// ---------------- Flags from the config file ---------------- //
+
+// poolConfig is a named pool for routes, as -pool and -pool-policy set one.
+type poolConfig struct {
+	Policy  string   `json:"policy" yaml:"policy"` // default: -a
+	Servers []string `json:"servers" yaml:"servers"`
+}
+
+// routeConfig sends the traffic it matches to a named pool, as the
+// -http-*-route, -geo-route and -ja3-route flags do, or to servers of its own
+// for an SNI route, as -sni-route does. It matches on one of host, path,
+// header, cookie, country, continent, ja3 or sni.
+type routeConfig struct {
+	Host      string   `json:"host" yaml:"host"`
+	Path      string   `json:"path" yaml:"path"`
+	Strip     bool     `json:"strip" yaml:"strip"` // of path routes: remove the prefix
+	Header    string   `json:"header" yaml:"header"`
+	Cookie    string   `json:"cookie" yaml:"cookie"`
+	Value     string   `json:"value" yaml:"value"`   // of the header or cookie
+	Regexp    string   `json:"regexp" yaml:"regexp"` // or matched by it
+	Country   []string `json:"country" yaml:"country"`
+	Continent []string `json:"continent" yaml:"continent"`
+	JA3       string   `json:"ja3" yaml:"ja3"`
+	SNI       string   `json:"sni" yaml:"sni"`
+	Pool      string   `json:"pool" yaml:"pool"`
+	Servers   []string `json:"servers" yaml:"servers"` // of SNI routes
+}
+
+// healthConfig is the active health checks, as the -health-* flags set them.
+type healthConfig struct {
+	Type        string                    `json:"type" yaml:"type"`
+	Path        string                    `json:"path" yaml:"path"`
+	GRPCService string                    `json:"grpc_service" yaml:"grpc_service"`
+	Command     string                    `json:"command" yaml:"command"`
+	Interval    duration                  `json:"interval" yaml:"interval"`
+	Timeout     duration                  `json:"timeout" yaml:"timeout"`
+	Jitter      *float64                  `json:"jitter" yaml:"jitter"`
+	Overrides   map[string]healthOverride `json:"overrides" yaml:"overrides"` // by backend
+}
+
+// healthOverride is the health check of one backend, see -health-override.
+type healthOverride struct {
+	Type        string   `json:"type" yaml:"type"`
+	Path        string   `json:"path" yaml:"path"`
+	Port        int      `json:"port" yaml:"port"`
+	GRPCService string   `json:"grpc_service" yaml:"grpc_service"`
+	Command     string   `json:"command" yaml:"command"`
+	Interval    duration `json:"interval" yaml:"interval"`
+	Timeout     duration `json:"timeout" yaml:"timeout"`
+}
+
+// flagSetting is the value the config file gives a flag, and where.
+type flagSetting struct {
+	name, value string
+	path        []any
+}
+
+// flagSettings returns the flags cfg sets: its policy and log sink, those
+// its file names, in file order, then the ones its pools, routes and health
+// checks stand for.
+func (cfg *config) flagSettings() ([]flagSetting, error) {
+	var out []flagSetting
+	if cfg.Policy != "" {
+		out = append(out, flagSetting{"a", cfg.Policy, []any{"policy"}})
+	}
+	if cfg.LogSink != "" {
+		out = append(out, flagSetting{"log-sink", cfg.LogSink, []any{"log_sink"}})
+	}
+	keys := slices.SortedFunc(maps.Keys(cfg.Flags), func(a, b string) int {
+		return cmp.Compare(cfg.Flags[a].Line, cfg.Flags[b].Line)
+	})
+	for _, key := range keys {
+		node := cfg.Flags[key]
+		name := strings.ReplaceAll(key, "_", "-")
+		switch {
+		case flag.Lookup(name) == nil:
+			if near := nearestFlag(name); near != "" {
+				return nil, badConfig([]any{key}, "unknown setting %q, did you mean %s?", key, near)
+			}
+			return nil, badConfig([]any{key}, "unknown setting %q, see load_balancer -h for the flags", key)
+		case name == "config":
+			return nil, badConfig([]any{key}, "a config file can't name another")
+		case node.Kind == yaml.ScalarNode:
+			out = append(out, flagSetting{name, node.Value, []any{key}})
+		case node.Kind == yaml.SequenceNode:
+			for i, item := range node.Content {
+				if item.Kind != yaml.ScalarNode {
+					return nil, badConfig([]any{key, i}, "%s: want a value, not a list or mapping", key)
+				}
+				out = append(out, flagSetting{name, item.Value, []any{key, i}})
+			}
+		default:
+			return nil, badConfig([]any{key}, "%s: want a value or a list of values", key)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Pools)) {
+		p, where := cfg.Pools[name], []any{"pools", name}
+		if len(p.Servers) == 0 {
+			return nil, badConfig(where, "pool %s has no servers", name)
+		}
+		out = append(out, flagSetting{"pool", name + "=" + strings.Join(p.Servers, ","), where})
+		if p.Policy != "" {
+			out = append(out, flagSetting{"pool-policy", name + "=" + p.Policy, at(where, "policy")})
+		}
+	}
+	for i, r := range cfg.Routes {
+		name, value, err := r.flag()
+		if err != nil {
+			return nil, badConfig([]any{"routes", i}, "route %d: %v", i, err)
+		}
+		out = append(out, flagSetting{name, value, []any{"routes", i}})
+	}
+	if h := cfg.Health; h != nil {
+		where := []any{"health"}
+		for _, s := range []struct{ name, value, key string }{
+			{"health-check", h.Type, "type"},
+			{"health-path", h.Path, "path"},
+			{"health-grpc-service", h.GRPCService, "grpc_service"},
+			{"health-command", h.Command, "command"},
+			{"health-interval", h.Interval.flag(), "interval"},
+			{"health-timeout", h.Timeout.flag(), "timeout"},
+		} {
+			if s.value != "" {
+				out = append(out, flagSetting{s.name, s.value, at(where, s.key)})
+			}
+		}
+		if h.Jitter != nil {
+			out = append(out, flagSetting{"health-jitter", strconv.FormatFloat(*h.Jitter, 'g', -1, 64), at(where, "jitter")})
+		}
+		for _, server := range slices.Sorted(maps.Keys(h.Overrides)) {
+			out = append(out, flagSetting{"health-override", h.Overrides[server].flag(server), at(where, "overrides", server)})
+		}
+	}
+	return out, nil
+}
+
+// nearestFlag returns the flag whose name is at most two edits from name, ""
+// if there is none, for a setting misspelled in the config file.
+func nearestFlag(name string) string {
+	best, bestDist := "", 3
+	flag.VisitAll(func(f *flag.Flag) {
+		if d := editDistance(name, f.Name); d < bestDist {
+			best, bestDist = f.Name, d
+		}
+	})
+	return best
+}
+
+// editDistance returns the Levenshtein distance of a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// flag returns d as a flag value, "" if unset.
+func (d duration) flag() string {
+	if d == 0 {
+		return ""
+	}
+	return time.Duration(d).String()
+}
+
+// flag returns the flag r stands for and its value.
+func (r routeConfig) flag() (string, string, error) {
+	var matches []string
+	for _, m := range []struct {
+		key string
+		set bool
+	}{
+		{"host", r.Host != ""}, {"path", r.Path != ""}, {"header", r.Header != ""}, {"cookie", r.Cookie != ""},
+		{"country", len(r.Country) > 0}, {"continent", len(r.Continent) > 0}, {"ja3", r.JA3 != ""}, {"sni", r.SNI != ""},
+	} {
+		if m.set {
+			matches = append(matches, m.key)
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		return "", "", fmt.Errorf("matches nothing, give it one of host, path, header, cookie, country, continent, ja3 or sni")
+	case len(matches) > 1:
+		return "", "", fmt.Errorf("matches on both %s and %s, split it in two", matches[0], matches[1])
+	case r.SNI != "" && r.Pool != "":
+		return "", "", fmt.Errorf("sni routes go to servers of their own, not a pool")
+	case r.SNI != "" && len(r.Servers) == 0:
+		return "", "", fmt.Errorf("has no servers to send sni %s to", r.SNI)
+	case r.SNI != "":
+		return "sni-route", r.SNI + "=" + strings.Join(r.Servers, ","), nil
+	case r.Pool == "":
+		return "", "", fmt.Errorf("has no pool to send %s matches to", matches[0])
+	case len(r.Servers) > 0:
+		return "", "", fmt.Errorf("only sni routes have servers, others a pool")
+	case r.Strip && r.Path == "":
+		return "", "", fmt.Errorf("strip removes the prefix of path routes")
+	case (r.Value != "" || r.Regexp != "") && r.Header == "" && r.Cookie == "":
+		return "", "", fmt.Errorf("value and regexp are for header and cookie routes")
+	}
+	match := func(name string) (string, error) {
+		switch {
+		case r.Value != "" && r.Regexp != "":
+			return "", fmt.Errorf("has both a value and a regexp for %s", name)
+		case r.Regexp != "":
+			return name + "~" + r.Regexp + "=" + r.Pool, nil
+		}
+		return name + ":" + r.Value + "=" + r.Pool, nil
+	}
+	switch {
+	case r.Host != "":
+		return "http-route", r.Host + "=" + r.Pool, nil
+	case r.Path != "":
+		v := r.Path + "=" + r.Pool
+		if r.Strip {
+			v += ",strip"
+		}
+		return "http-path-route", v, nil
+	case r.Header != "":
+		v, err := match(r.Header)
+		return "http-header-route", v, err
+	case r.Cookie != "":
+		v, err := match(r.Cookie)
+		return "http-cookie-route", v, err
+	case len(r.Country) > 0:
+		return "geo-route", "country:" + strings.Join(r.Country, ",") + "=" + r.Pool, nil
+	case len(r.Continent) > 0:
+		return "geo-route", "continent:" + strings.Join(r.Continent, ",") + "=" + r.Pool, nil
+	}
+	return "ja3-route", r.JA3 + "=" + r.Pool, nil
+}
+
+// flag returns o, the health check of server, as a -health-override value.
+func (o healthOverride) flag(server string) string {
+	v := server
+	for _, kv := range []struct{ key, value string }{
+		{"type", o.Type}, {"path", o.Path}, {"service", o.GRPCService}, {"command", o.Command},
+		{"interval", o.Interval.flag()}, {"timeout", o.Timeout.flag()},
+	} {
+		if kv.value != "" {
+			v += "," + kv.key + "=" + kv.value
+		}
+	}
+	if o.Port != 0 {
+		v += ",port=" + strconv.Itoa(o.Port)
+	}
+	return v
+}
+
+// givenFlags returns the names of the flags set so far, on the command line
+// or in the environment once setEnvFlags has run.
+func givenFlags() map[string]bool {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	return given
+}
+
+// setFlags gives the flags not in given, those set on the command line or in
+// the environment, the values the config file at path has for them.
+func (cfg *config) setFlags(path string, given map[string]bool) error {
+	settings, err := cfg.flagSettings()
+	if err != nil {
+		return cfg.errorIn(path, err)
+	}
+	for _, s := range settings {
+		if given[s.name] {
+			continue
+		}
+		if err := flag.Set(s.name, s.value); err != nil {
+			return cfg.errorIn(path, badConfig(s.path, "invalid -%s %q: %v", s.name, s.value, err))
+		}
+	}
+	return nil
+}
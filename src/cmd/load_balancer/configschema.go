@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ---------------- Config schema ---------------- //
+
+// durationSchema is a duration such as 30s or 1m30s
+var durationSchema = map[string]any{
+	"type":    "string",
+	"pattern": `^(0|([0-9]*\.?[0-9]+(ns|us|µs|ms|s|m|h))+)$`,
+}
+
+// valueSchema is a flag value written as it would be on the command line
+var valueSchema = map[string]any{"type": []string{"string", "number", "boolean"}}
+
+// configSchema returns the JSON Schema of config files, whatever their format:
+// the keys and sections of config, and every flag but -config by name.
+func configSchema() map[string]any {
+	schema := typeSchema(reflect.TypeFor[config]())
+	props := schema["properties"].(map[string]any)
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		for _, name := range []string{f.Name, strings.ReplaceAll(f.Name, "-", "_")} {
+			if props[name] == nil {
+				props[name] = flagSchema(f)
+			}
+		}
+	})
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "Load balancer config"
+	schema["additionalProperties"] = false
+	return schema
+}
+
+// typeSchema returns the schema of the values of t, a type of the config.
+func typeSchema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		fields, open := configFields(t)
+		props := make(map[string]any, len(fields))
+		for name, f := range fields {
+			props[name] = typeSchema(f.Type)
+		}
+		return map[string]any{"type": "object", "properties": props, "additionalProperties": open}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Int, reflect.Int64:
+		if t == reflect.TypeFor[duration]() {
+			return durationSchema
+		}
+		return map[string]any{"type": "integer"}
+	}
+	return map[string]any{"type": "string"}
+}
+
+// flagSchema returns the schema of the value of f, described by its usage:
+// flags of the flag package's types take a value of that type, the others a
+// value or, for repeatable ones, a list of values.
+func flagSchema(f *flag.Flag) map[string]any {
+	var schema map[string]any
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		schema = map[string]any{"anyOf": []any{valueSchema, map[string]any{"type": "array", "items": valueSchema}}}
+	} else {
+		switch getter.Get().(type) {
+		case bool:
+			schema = map[string]any{"type": "boolean"}
+		case int, int64, uint, uint64:
+			schema = map[string]any{"type": "integer"}
+		case float64:
+			schema = map[string]any{"type": "number"}
+		case time.Duration:
+			schema = maps.Clone(durationSchema)
+		default:
+			schema = map[string]any{"type": "string"}
+		}
+	}
+	schema["description"] = f.Usage
+	return schema
+}
+
+// runConfig is the config subcommand; config schema writes the JSON Schema of
+// config files to stdout, for editors to check them against.
+func runConfig(args []string) {
+	if len(args) != 1 || args[0] != "schema" {
+		fmt.Fprint(os.Stderr, "Usage: load_balancer config schema > lb.schema.json\n\nWrites the JSON Schema of -config files, JSON, YAML or TOML.\n")
+		os.Exit(2)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(configSchema()); err != nil {
+		logger.Fatalf("Writing the schema: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"gopkg.in/yaml.v3"
+)
+
+// ---------------- Config tree ---------------- //
+
+// Config files are parsed, whatever their format, into a tree of YAML nodes
+// that remember their line, and the config is decoded from that tree: every
+// format gets the same settings, checks and line numbers in errors.
+
+// yamlLine finds the line of a YAML error, e.g. "line 3: ..."
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+
+// parseConfig parses data, the config file at path: YAML for .yaml and .yml,
+// TOML for .toml, JSON otherwise.
+func parseConfig(path string, data []byte) (*yaml.Node, error) {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return parseYAML(path, data)
+	case ".toml":
+		return parseTOML(path, data)
+	}
+	return parseJSON(path, data)
+}
+
+// parseYAML parses data, the YAML config file at path.
+func parseYAML(path string, data []byte) (*yaml.Node, error) {
+	root := new(yaml.Node)
+	if err := yaml.Unmarshal(data, root); err != nil {
+		return nil, errors.New(yamlMessage(path, err.Error()))
+	}
+	return root, nil
+}
+
+// decodeConfig decodes root, the tree of the config file at path, into cfg.
+// Decoding is strict: within the sections, keys no setting has are errors, as
+// are the other top-level keys unless they name a flag (see flagSettings).
+func decodeConfig(path string, root *yaml.Node, cfg *config) error {
+	if err := checkKeys(root, reflect.TypeFor[config](), nil); err != nil {
+		return fmt.Errorf("%s:%d: %w", path, lineOf(root, err.path), err)
+	}
+	if err := root.Decode(cfg); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return errors.New(yamlMessage(path, err.Error()))
+		}
+		msgs := make([]string, len(typeErr.Errors))
+		for i, msg := range typeErr.Errors {
+			msgs[i] = yamlMessage(path, msg)
+		}
+		return errors.New(strings.Join(msgs, "\n"))
+	}
+	cfg.lines = root
+	return nil
+}
+
+// checkKeys returns the first key in n, the value of a t at path, that no
+// setting has; the decoder would drop it without a word.
+func checkKeys(n *yaml.Node, t reflect.Type, path []any) *configError {
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == reflect.TypeFor[yaml.Node]():
+		return nil // checked by flagSettings
+	case t.Kind() == reflect.Struct && n.Kind == yaml.MappingNode:
+		fields, open := configFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			f, ok := fields[key]
+			if !ok && !open {
+				return badConfig(at(path, key), "unknown key %s", key)
+			}
+			if ok {
+				if err := checkKeys(n.Content[i+1], f.Type, at(path, key)); err != nil {
+					return err
+				}
+			}
+		}
+	case t.Kind() == reflect.Slice && n.Kind == yaml.SequenceNode:
+		for i, item := range n.Content {
+			if err := checkKeys(item, t.Elem(), at(path, i)); err != nil {
+				return err
+			}
+		}
+	case t.Kind() == reflect.Map && n.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if err := checkKeys(n.Content[i+1], t.Elem(), at(path, n.Content[i].Value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// configFields returns the fields of t, a struct of the config, by key, and
+// whether it takes other keys too, in an inline map.
+func configFields(t reflect.Type) (fields map[string]reflect.StructField, open bool) {
+	fields = make(map[string]reflect.StructField)
+	for _, f := range reflect.VisibleFields(t) {
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		switch {
+		case !f.IsExported() || name == "-":
+		case opts == "inline":
+			open = true
+		default:
+			fields[name] = f
+		}
+	}
+	return fields, open
+}
+
+// yamlMessage rewrites msg, an error of the YAML parser or decoder, as
+// path:line: what went wrong, without the names of Go types.
+func yamlMessage(path, msg string) string {
+	msg = strings.ReplaceAll(msg, "main.", "")
+	if m := yamlLine.FindStringSubmatch(msg); m != nil {
+		return path + ":" + m[1] + ": " + msg[len(m[0]):]
+	}
+	return path + ": " + strings.TrimPrefix(msg, "yaml: ")
+}
+
+// lineOf returns the line of the key or item at path in the tree root, or of
+// as much of path as it has.
+func lineOf(root *yaml.Node, path []any) int {
+	n := root
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	line := n.Line
+	for _, p := range path {
+		var next *yaml.Node
+		switch p := p.(type) {
+		case string:
+			for i := 0; n.Kind == yaml.MappingNode && i+1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == p {
+					next, line = n.Content[i+1], n.Content[i].Line
+				}
+			}
+		case int:
+			if n.Kind == yaml.SequenceNode && p < len(n.Content) {
+				next = n.Content[p]
+				line = next.Line
+			}
+		}
+		if next == nil {
+			break
+		}
+		n = next
+	}
+	return line
+}
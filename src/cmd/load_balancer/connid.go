@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// ---------------- Connection IDs ---------------- //
+
+// requestIDHeader carries the ID of a request to its backend and back to the
+// client in HTTP mode, see -request-id-header; empty sends none
+var requestIDHeader = "X-Request-Id"
+
+// maxRequestID is the longest ID taken from a trusted proxy's request header
+const maxRequestID = 128
+
+// newConnID returns a random ID for a connection, or a request in HTTP mode:
+// 16 hex digits.
+func newConnID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// requestID returns the ID of r: the one a trusted proxy in front gave it in
+// requestIDHeader, so one ID follows the request through both, or a new one.
+func requestID(r *http.Request) string {
+	if requestIDHeader != "" && trustedPeer(r.RemoteAddr) {
+		if id := r.Header.Get(requestIDHeader); id != "" && len(id) <= maxRequestID && printable(id) {
+			return id
+		}
+	}
+	return newConnID()
+}
+
+// printable reports whether s is printable ASCII without spaces, safe to log.
+func printable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] <= ' ' || s[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// connLog logs the lines of one connection or request, ending them with its
+// ID so they can be found along with the backend's lines about it.
+type connLog string
+
+func (id connLog) Printf(format string, args ...any) {
+	logger.Printf(format+" id=%s", append(args, string(id))...)
+}
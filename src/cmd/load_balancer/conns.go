@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// ---------------- Open connections ---------------- //
+
+// proxied is one client connection and the backend connection it is proxied to.
+type proxied struct {
+	client, backend net.Conn
+}
+
+// connInfo is what is known of an open proxied connection, as listed on
+// GET /connections.
+type connInfo struct {
+	id             string // also in its log lines, see connLog
+	client         string // address
+	backend        string
+	mode           string // tcp, or upgraded for HTTP connections switched to another protocol
+	sni            string
+	start          time.Time
+	sent, received atomic.Int64 // bytes so far, from the client and to it
+	halfClosed     atomic.Bool  // one direction finished
+	killed         atomic.Bool  // closed through DELETE /connections
+}
+
+func newConnInfo(id, client, backend, mode string, start time.Time) *connInfo {
+	return &connInfo{id: id, client: client, backend: backend, mode: mode, start: start}
+}
+
+// connStatus is a connection as listed on GET /connections.
+type connStatus struct {
+	ID            string    `json:"id"`
+	Client        string    `json:"client"`
+	Backend       string    `json:"backend"`
+	Mode          string    `json:"mode"`
+	SNI           string    `json:"sni,omitempty"`
+	Start         time.Time `json:"start"`
+	BytesSent     int64     `json:"bytes_sent"`
+	BytesReceived int64     `json:"bytes_received"`
+	State         string    `json:"state"` // open, half-closed or closing
+}
+
+func (c *connInfo) status() connStatus {
+	state := "open"
+	switch {
+	case c.killed.Load():
+		state = "closing"
+	case c.halfClosed.Load():
+		state = "half-closed"
+	}
+	return connStatus{
+		ID:            c.id,
+		Client:        c.client,
+		Backend:       c.backend,
+		Mode:          c.mode,
+		SNI:           c.sni,
+		Start:         c.start,
+		BytesSent:     c.sent.Load(),
+		BytesReceived: c.received.Load(),
+		State:         state,
+	}
+}
+
+// connTable keeps the open proxied connections per backend, so they can be cut
+// when a drain runs out of time, and listed and closed through the admin API.
+type connTable struct {
+	mu    sync.Mutex
+	conns map[string]map[proxied]*connInfo
+}
+
+var openConns = &connTable{conns: make(map[string]map[proxied]*connInfo)}
+
+func (t *connTable) add(c proxied, info *connInfo) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conns[info.backend] == nil {
+		t.conns[info.backend] = make(map[proxied]*connInfo)
+	}
+	t.conns[info.backend][c] = info
+}
+
+func (t *connTable) remove(backend string, c proxied) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns[backend], c)
+	if len(t.conns[backend]) == 0 {
+		delete(t.conns, backend)
+	}
+}
+
+func (t *connTable) count(backend string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns[backend])
+}
+
+// closeAll closes every connection to backend and returns how many there were.
+func (t *connTable) closeAll(backend string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for c := range t.conns[backend] {
+		c.client.Close()
+		c.backend.Close()
+	}
+	return len(t.conns[backend])
+}
+
+// list returns the open connections, of backend if not empty, oldest first.
+func (t *connTable) list(backend string) []connStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := []connStatus{}
+	for b, conns := range t.conns {
+		if backend != "" && b != backend {
+			continue
+		}
+		for _, info := range conns {
+			list = append(list, info.status())
+		}
+	}
+	slices.SortFunc(list, func(a, b connStatus) int { return a.Start.Compare(b.Start) })
+	return list
+}
+
+// close closes the connection with the given ID and reports whether it was
+// open.
+func (t *connTable) close(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, conns := range t.conns {
+		for c, info := range conns {
+			if info.id == id {
+				info.killed.Store(true)
+				c.client.Close()
+				c.backend.Close()
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// closeEverything closes every connection, logging each, and returns how many
+// there were.
+func (t *connTable) closeEverything() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for backend, conns := range t.conns {
+		for c := range conns {
+			logger.Printf("Closing connection of client %s via backend %s", c.client.RemoteAddr(), backend)
+			c.client.Close()
+			c.backend.Close()
+			n++
+		}
+	}
+	return n
+}
+
+// how often a drain checks whether the backend's connections are gone
+const drainPoll = 100 * time.Millisecond
+
+// drain stops new connections to backend and waits for the open ones to finish.
+// After maxWait, if not zero, the remaining ones are closed. The backend stays
+// drained until it is resumed with SetDraining(false), which also ends the
+// drain, leaving its connections open.
+func drain(policy load_balancer.Policy, backend string, maxWait time.Duration) {
+	deadline := time.Now().Add(maxWait)
+	for openConns.count(backend) > 0 {
+		if !stillDraining(policy, backend) {
+			return
+		}
+		if maxWait > 0 && time.Now().After(deadline) {
+			logger.Printf("Drain of backend %s timed out, closed %d connections", backend, openConns.closeAll(backend))
+			return
+		}
+		time.Sleep(drainPoll)
+	}
+	logger.Printf("Backend %s drained", backend)
+}
+
+// stillDraining reports whether server is in the pool and draining, i.e. it
+// wasn't put back, by a reload or DELETE /drain, while its drain ran.
+func stillDraining(policy load_balancer.Policy, server string) bool {
+	for _, b := range policy.Stats().Backends {
+		if b.Address == server {
+			return b.Draining
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// ---------------- Grafana dashboard ---------------- //
+
+// Grafana's dashboard JSON model, as much of it as the generated dashboard
+// uses.
+type (
+	grafanaDashboard struct {
+		UID           string            `json:"uid"`
+		Title         string            `json:"title"`
+		Tags          []string          `json:"tags"`
+		SchemaVersion int               `json:"schemaVersion"`
+		Refresh       string            `json:"refresh"`
+		Time          grafanaTimeRange  `json:"time"`
+		Templating    grafanaTemplating `json:"templating"`
+		Panels        []grafanaPanel    `json:"panels"`
+	}
+	grafanaTimeRange struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	grafanaTemplating struct {
+		List []grafanaVariable `json:"list"`
+	}
+	grafanaVariable struct {
+		Name  string `json:"name"`
+		Label string `json:"label"`
+		Type  string `json:"type"`
+		Query string `json:"query"`
+	}
+	grafanaPanel struct {
+		ID          int                `json:"id"`
+		Type        string             `json:"type"`
+		Title       string             `json:"title"`
+		Description string             `json:"description,omitempty"`
+		GridPos     grafanaGridPos     `json:"gridPos"`
+		Datasource  grafanaDatasource  `json:"datasource"`
+		FieldConfig grafanaFieldConfig `json:"fieldConfig"`
+		Targets     []grafanaTarget    `json:"targets"`
+	}
+	grafanaGridPos struct {
+		H int `json:"h"`
+		W int `json:"w"`
+		X int `json:"x"`
+		Y int `json:"y"`
+	}
+	grafanaDatasource struct {
+		Type string `json:"type"`
+		UID  string `json:"uid"`
+	}
+	grafanaFieldConfig struct {
+		Defaults  grafanaFieldDefaults `json:"defaults"`
+		Overrides []any                `json:"overrides"`
+	}
+	grafanaFieldDefaults struct {
+		Unit string `json:"unit,omitempty"`
+	}
+	grafanaTarget struct {
+		RefID        string            `json:"refId"`
+		Datasource   grafanaDatasource `json:"datasource"`
+		Expr         string            `json:"expr"`
+		LegendFormat string            `json:"legendFormat"`
+		Exemplar     bool              `json:"exemplar"`
+	}
+)
+
+// prometheusSource is the data source panels query: the one picked in the
+// dashboard's datasource variable
+var prometheusSource = grafanaDatasource{Type: "prometheus", UID: "${datasource}"}
+
+// dashboardPanel is a graph of the generated dashboard.
+type dashboardPanel struct {
+	title, description, unit string
+	queries                  [][2]string // PromQL expression and legend
+	exemplars                bool        // show the traces linked to latency samples
+}
+
+// dashboardPanels are the graphs of the generated dashboard, two per row.
+var dashboardPanels = []dashboardPanel{
+	{title: "Client connections", description: "Client connections accepted per second, requests' connections in HTTP mode.", unit: "cps",
+		queries: [][2]string{{`sum by (protocol) (rate(lb_client_connections_total[$__rate_interval]))`, "{{protocol}}"}}},
+	{title: "Open client connections", unit: "short",
+		queries: [][2]string{{`sum by (protocol) (lb_client_connections_active)`, "{{protocol}}"}}},
+	{title: "Latency added", description: "Latency the balancer adds: until the backend is connected for TCP, the whole request for HTTP. Exemplars link to traces with -otlp-endpoint.", unit: "s", exemplars: true,
+		queries: [][2]string{
+			{`histogram_quantile(0.5, sum by (le, protocol) (rate(lb_proxy_latency_seconds_bucket[$__rate_interval])))`, "p50 {{protocol}}"},
+			{`histogram_quantile(0.99, sum by (le, protocol) (rate(lb_proxy_latency_seconds_bucket[$__rate_interval])))`, "p99 {{protocol}}"},
+		}},
+	{title: "Errors", description: "Failures by kind, see lb_errors_total.", unit: "ops",
+		queries: [][2]string{{`sum by (kind) (rate(lb_errors_total[$__rate_interval]))`, "{{kind}}"}}},
+	{title: "Backend share", description: "Times each backend was picked, per second.", unit: "ops",
+		queries: [][2]string{{`sum by (pool, backend) (rate(lb_backend_selections_total[$__rate_interval]))`, "{{pool}} {{backend}}"}}},
+	{title: "Open backend connections", unit: "short",
+		queries: [][2]string{{`sum by (pool, backend) (lb_backend_connections_active)`, "{{pool}} {{backend}}"}}},
+	{title: "Backend connection duration p99", description: "How long connections to each backend last, requests in HTTP mode.", unit: "s", exemplars: true,
+		queries: [][2]string{{`histogram_quantile(0.99, sum by (le, backend) (rate(lb_backend_connection_duration_seconds_bucket[$__rate_interval])))`, "{{backend}}"}}},
+	{title: "Backend dial latency p99", unit: "s",
+		queries: [][2]string{{`histogram_quantile(0.99, sum by (le, backend) (rate(lb_backend_dial_seconds_bucket[$__rate_interval])))`, "{{backend}}"}}},
+	{title: "Healthy backends", description: "Backends the policies may pick, per pool.", unit: "short",
+		queries: [][2]string{{`sum by (pool) (lb_backend_healthy)`, "{{pool}}"}}},
+	{title: "Backend state changes", unit: "short",
+		queries: [][2]string{{`sum by (backend, to, reason) (increase(lb_backend_transitions_total[$__rate_interval]))`, "{{backend}} {{to}} ({{reason}})"}}},
+	{title: "Throughput", unit: "Bps",
+		queries: [][2]string{{`sum by (direction) (rate(lb_backend_bytes_total[$__rate_interval]))`, "{{direction}}"}}},
+	{title: "Backend failures", unit: "ops",
+		queries: [][2]string{{`sum by (pool, backend) (rate(lb_backend_failures_total[$__rate_interval]))`, "{{pool}} {{backend}}"}}},
+}
+
+// dashboard returns a Grafana dashboard of the balancer's metrics, titled
+// title, querying the Prometheus data source picked when it is opened.
+func dashboard(title string) grafanaDashboard {
+	d := grafanaDashboard{
+		UID:           "load-balancer",
+		Title:         title,
+		Tags:          []string{"load-balancer"},
+		SchemaVersion: 39,
+		Refresh:       "30s",
+		Time:          grafanaTimeRange{From: "now-1h", To: "now"},
+		Templating: grafanaTemplating{List: []grafanaVariable{
+			{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+		}},
+	}
+	for i, p := range dashboardPanels {
+		panel := grafanaPanel{
+			ID:          i + 1,
+			Type:        "timeseries",
+			Title:       p.title,
+			Description: p.description,
+			GridPos:     grafanaGridPos{H: 8, W: 12, X: i % 2 * 12, Y: i / 2 * 8},
+			Datasource:  prometheusSource,
+			FieldConfig: grafanaFieldConfig{Defaults: grafanaFieldDefaults{Unit: p.unit}, Overrides: []any{}},
+		}
+		for j, q := range p.queries {
+			panel.Targets = append(panel.Targets, grafanaTarget{
+				RefID:        string(rune('A' + j)),
+				Datasource:   prometheusSource,
+				Expr:         q[0],
+				LegendFormat: q[1],
+				Exemplar:     p.exemplars,
+			})
+		}
+		d.Panels = append(d.Panels, panel)
+	}
+	return d
+}
+
+// runDashboards is the dashboards subcommand: it writes the Grafana dashboard
+// JSON to stdout, to import or provision.
+func runDashboards(args []string) {
+	fs := flag.NewFlagSet("dashboards", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), "Usage: load_balancer dashboards [-title name] > dashboard.json\n\nWrites a Grafana dashboard of the -metrics the balancer exports.\n\n")
+		fs.PrintDefaults()
+	}
+	title := fs.String("title", "Load balancer", "Title of the dashboard")
+	fs.Parse(args)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(dashboard(*title)); err != nil {
+		logger.Fatalf("Writing the dashboard: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ---------------- Backend dialer ---------------- //
+
+// source address, interface and DSCP of backend connections, see -source-ip,
+// -bind-interface and -dscp
+var (
+	sourceIP      net.IP
+	bindInterface string
+	dscp          = -1 // none
+)
+
+// backendDialer connects to backends with a dialer configured by the flags
+// above, and is the load_balancer.Dialer health checks use too. Unix sockets
+// have no source address or IP options, so they're dialed without them.
+type backendDialer struct {
+	*net.Dialer
+}
+
+func (d backendDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if network == "unix" {
+		plain := *d.Dialer
+		plain.LocalAddr, plain.Control = nil, nil
+		return plain.DialContext(ctx, network, address)
+	}
+	return d.Dialer.DialContext(ctx, network, address)
+}
+
+// configureDialer applies -source-ip, -bind-interface and -dscp to dialer.
+func configureDialer() error {
+	if sourceIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: sourceIP}
+	}
+	if bindInterface != "" {
+		if _, err := net.InterfaceByName(bindInterface); err != nil {
+			return fmt.Errorf("-bind-interface %s: %w", bindInterface, err)
+		}
+	}
+	if bindInterface != "" || dscp >= 0 {
+		control, err := dialControl(bindInterface, dscp)
+		if err != nil {
+			return err
+		}
+		dialer.Control = control
+	}
+	return nil
+}
+
+// dscpClasses are the names of common DSCP values.
+var dscpClasses = map[string]int{"EF": 46, "VA": 44, "LE": 1}
+
+// parseDSCP reads a DSCP value, 0-63, or the name of a class: EF, VA, LE,
+// CS0-CS7 or AF11-AF43.
+func parseDSCP(v string) (int, error) {
+	name := strings.ToUpper(v)
+	if n, ok := dscpClasses[name]; ok {
+		return n, nil
+	}
+	if x, ok := strings.CutPrefix(name, "CS"); ok && len(x) == 1 && x[0] >= '0' && x[0] <= '7' {
+		return int(x[0]-'0') << 3, nil
+	}
+	if xy, ok := strings.CutPrefix(name, "AF"); ok && len(xy) == 2 && xy[0] >= '1' && xy[0] <= '4' && xy[1] >= '1' && xy[1] <= '3' {
+		return int(xy[0]-'0')<<3 | int(xy[1]-'0')<<1, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > 63 {
+		return 0, fmt.Errorf("invalid DSCP %q, want 0-63, EF, CS0-CS7 or AF11-AF43", v)
+	}
+	return n, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"syscall"
+	"golang.org/x/sys/unix"
+)
+
+// ---------------- Backend dialer ---------------- //
+
+// dialControl returns the Control of backend sockets: bound to iface
+// (SO_BINDTODEVICE) unless it's "", and marked with dscp unless it's negative.
+func dialControl(iface string, dscp int) (func(network, address string, c syscall.RawConn) error, error) {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			if iface != "" {
+				if err = unix.BindToDevice(int(fd), iface); err != nil {
+					return
+				}
+			}
+			if dscp >= 0 {
+				if network == "tcp6" {
+					err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, dscp<<2)
+				} else {
+					err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, dscp<<2)
+				}
+			}
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}, nil
+}
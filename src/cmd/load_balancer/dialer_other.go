@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// ---------------- Backend dialer ---------------- //
+
+// dialControl fails: binding to an interface and marking packets are Linux
+// only here.
+func dialControl(iface string, dscp int) (func(network, address string, c syscall.RawConn) error, error) {
+	return nil, errors.New("-bind-interface and -dscp are only supported on Linux")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ---------------- Flags from the environment ---------------- //
+
+// envPrefix starts the names of the environment variables that set flags
+const envPrefix = "LB_"
+
+// envName returns the environment variable of the flag name, e.g.
+// LB_HEALTH_INTERVAL for -health-interval.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// setEnvFlags gives the flags not given on the command line the values of
+// their environment variables, so containers can be configured without a
+// file. Repeatable flags take one value per line. LB_* variables no flag has
+// are logged and ignored: the platform may set some, such as the service
+// links of Kubernetes.
+func setEnvFlags() error {
+	given := givenFlags()
+	known := map[string]bool{upgradeEnv: true}
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		env := envName(f.Name)
+		known[env] = true
+		value, ok := os.LookupEnv(env)
+		if !ok || given[f.Name] || err != nil {
+			return
+		}
+		values := []string{value}
+		if _, ok := f.Value.(flag.Getter); !ok {
+			values = strings.FieldsFunc(value, func(r rune) bool { return r == '\n' })
+		}
+		for _, v := range values {
+			if e := flag.Set(f.Name, strings.TrimSuffix(v, "\r")); e != nil {
+				err = fmt.Errorf("%s %q: %v", env, v, e)
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, kv := range os.Environ() {
+		env, _, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(env, envPrefix) || known[env] {
+			continue
+		}
+		name := strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(env, envPrefix), "_", "-"))
+		if near := nearestFlag(name); near != "" {
+			logger.Printf("Ignoring %s, no flag -%s; did you mean %s?", env, name, envName(near))
+		} else {
+			logger.Printf("Ignoring %s, no flag -%s", env, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withFlags replaces the command line flags for the test by a set with -a,
+// -s and -log-sink, parsed from args.
+func withFlags(t *testing.T, args ...string) (policy, servers, sink *string) {
+	t.Helper()
+	saved := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = saved })
+	flag.CommandLine = flag.NewFlagSet("load_balancer", flag.ContinueOnError)
+	policy = flag.String("a", "RoundRobin", "")
+	servers = flag.String("s", "", "")
+	sink = flag.String("log-sink", "", "")
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return policy, servers, sink
+}
+
+func TestFlagPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lb.yaml")
+	file := "policy: LeastConnections\nlog_sink: journald\nservers:\n  - address: localhost:7000\n"
+	if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		args         []string
+		env          map[string]string
+		policy, sink string
+		servers      string // -s
+		fileServers  bool   // whether the file's servers are kept
+	}{
+		{"file", nil, nil, "LeastConnections", "journald", "", true},
+		{"env over file", nil, map[string]string{"LB_A": "N2One", "LB_S": "localhost:6000", "LB_LOG_SINK": "syslog"},
+			"N2One", "syslog", "localhost:6000", false},
+		{"flags over env", []string{"-a", "RoundRobin", "-s", "localhost:5000"}, map[string]string{"LB_A": "N2One", "LB_S": "localhost:6000"},
+			"RoundRobin", "journald", "localhost:5000", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			policy, servers, sink := withFlags(t, tt.args...)
+			if err := setEnvFlags(); err != nil {
+				t.Fatal(err)
+			}
+			given := givenFlags()
+			cfg, err := loadConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cfg.setFlags(path, given); err != nil {
+				t.Fatal(err)
+			}
+			cfg = cfg.without(given)
+			if *policy != tt.policy || *sink != tt.sink || *servers != tt.servers {
+				t.Errorf("-a %q, -log-sink %q, -s %q; want %q, %q, %q", *policy, *sink, *servers, tt.policy, tt.sink, tt.servers)
+			}
+			if kept := len(cfg.Servers) > 0; kept != tt.fileServers {
+				t.Errorf("file's servers kept: %v, want %v", kept, tt.fileServers)
+			}
+			if tt.policy != "LeastConnections" && cfg.Policy != "" {
+				t.Errorf("file's policy %q kept over -a %s, a reload would switch to it", cfg.Policy, tt.policy)
+			}
+		})
+	}
+}
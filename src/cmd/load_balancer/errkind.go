@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// ---------------- Error kinds ---------------- //
+
+var proxyErrors = registry.Counter("lb_errors_total",
+	"Failures proxying, by kind (dial_timeout, dial_refused, dial_error, tls_handshake, client_reset, backend_reset, backend_timeout, copy_error, no_backend) and backend, empty if none was involved.",
+	"kind", "backend")
+
+// kinds of failure, logged as error=<kind> and counted in lb_errors_total
+const (
+	kindDialTimeout    = "dial_timeout"    // a backend didn't answer the connection attempt in time
+	kindDialRefused    = "dial_refused"    // a backend refused the connection
+	kindDialError      = "dial_error"      // connecting failed otherwise, e.g. no route or the PROXY header
+	kindTLSHandshake   = "tls_handshake"   // the TLS handshake with a client or backend failed
+	kindClientReset    = "client_reset"    // the client reset the connection or went away
+	kindBackendReset   = "backend_reset"   // the backend reset the connection or hung up mid-response
+	kindBackendTimeout = "backend_timeout" // HTTP mode: the backend didn't answer in time
+	kindCopyError      = "copy_error"      // relaying bytes failed otherwise
+	kindNoBackend      = "no_backend"      // the policy had no backend to offer, or none was reachable
+)
+
+// countError counts a failure of kind involving backend, "" if none, and
+// returns kind, for the log line.
+func countError(kind, backend string) string {
+	proxyErrors.With(kind, backend).Inc()
+	return kind
+}
+
+// dialKind returns the kind of err, the failure to connect to a backend.
+func dialKind(err error) string {
+	switch {
+	case handshakeFailed(err):
+		return kindTLSHandshake
+	case timedOut(err):
+		return kindDialTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return kindDialRefused
+	}
+	return kindDialError
+}
+
+// copyKind returns the kind of err, the failure to relay one direction of a
+// TCP connection: reading says the sending side reset it, readReset, writing
+// the receiving one, writeReset.
+func copyKind(err error, readReset, writeReset string) string {
+	var opErr *net.OpError
+	if connReset(err) && errors.As(err, &opErr) {
+		switch opErr.Op {
+		case "read":
+			return readReset
+		case "write":
+			return writeReset
+		}
+	}
+	return kindCopyError // spliced connections don't tell which side failed
+}
+
+// proxyKind returns the kind of err, the failure to proxy a request to a
+// backend in HTTP mode.
+func proxyKind(err error) string {
+	switch {
+	case dialFailed(err) || handshakeFailed(err):
+		return dialKind(err)
+	case errors.Is(err, context.Canceled):
+		return kindClientReset // the request's context ends when its client goes away
+	case timedOut(err):
+		return kindBackendTimeout
+	case connReset(err) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return kindBackendReset
+	}
+	return kindCopyError
+}
+
+// handshakeFailed reports whether err is from a failed TLS handshake with a
+// backend.
+func handshakeFailed(err error) bool {
+	var (
+		alert   tls.AlertError
+		record  tls.RecordHeaderError
+		verify  *tls.CertificateVerificationError
+		unknown x509.UnknownAuthorityError
+		host    x509.HostnameError
+	)
+	return errors.Is(err, errBackendHandshake) ||
+		errors.As(err, &alert) || errors.As(err, &record) || errors.As(err, &verify) ||
+		errors.As(err, &unknown) || errors.As(err, &host) ||
+		strings.Contains(err.Error(), "TLS handshake timeout") // net/http's, unexported
+}
+
+// timedOut reports whether err is from a deadline passing.
+func timedOut(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// connReset reports whether err is from the peer resetting the connection,
+// or closing it while it was written to.
+func connReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE)
+}
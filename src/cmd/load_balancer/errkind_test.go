@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// timeoutError is a net.Error that timed out, as a deadline passing gives.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// opError returns err as the net package reports it for op.
+func opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "tcp", Err: os.NewSyscallError(op, err)}
+}
+
+func TestDialKind(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"refused", opError("dial", syscall.ECONNREFUSED), kindDialRefused},
+		{"timeout", &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}, kindDialTimeout},
+		{"context deadline", fmt.Errorf("dial: %w", context.DeadlineExceeded), kindDialTimeout},
+		{"no route", opError("dial", syscall.EHOSTUNREACH), kindDialError},
+		{"PROXY header", fmt.Errorf("writing PROXY header: %w", opError("write", syscall.EPIPE)), kindDialError},
+		{"TLS alert", fmt.Errorf("%w: %w", errBackendHandshake, tls.AlertError(40)), kindTLSHandshake},
+		{"unknown authority", &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}, kindTLSHandshake},
+		{"TLS timeout", fmt.Errorf("%w: %w", errBackendHandshake, timeoutError{}), kindTLSHandshake}, // the handshake, not the dial
+	}
+	for _, tt := range tests {
+		if got := dialKind(tt.err); got != tt.want {
+			t.Errorf("%s: %v is %s, want %s", tt.name, tt.err, got, tt.want)
+		}
+	}
+
+	// and as a real dial fails
+	_, err := net.Dial("tcp", closedAddr(t))
+	if got := dialKind(err); got != kindDialRefused {
+		t.Errorf("%v is %s, want %s", err, got, kindDialRefused)
+	}
+}
+
+func TestCopyKind(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string // copying client->backend: reading resets are the client's
+	}{
+		{"read reset", opError("read", syscall.ECONNRESET), kindClientReset},
+		{"write reset", opError("write", syscall.ECONNRESET), kindBackendReset},
+		{"write broken pipe", opError("write", syscall.EPIPE), kindBackendReset},
+		{"read aborted", opError("read", syscall.ECONNABORTED), kindClientReset},
+		{"spliced, no side", os.NewSyscallError("splice", syscall.ECONNRESET), kindCopyError},
+		{"read timeout", &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, kindCopyError},
+		{"other", io.ErrShortWrite, kindCopyError},
+	}
+	for _, tt := range tests {
+		if got := copyKind(tt.err, kindClientReset, kindBackendReset); got != tt.want {
+			t.Errorf("%s: %v is %s, want %s", tt.name, tt.err, got, tt.want)
+		}
+	}
+	// backend->client, the other way round
+	if got := copyKind(opError("read", syscall.ECONNRESET), kindBackendReset, kindClientReset); got != kindBackendReset {
+		t.Errorf("reading from the backend reset is %s, want %s", got, kindBackendReset)
+	}
+}
+
+func TestProxyKind(t *testing.T) {
+	// as net/http's transport reports them, in a url.Error
+	wrap := func(err error) error { return &url.Error{Op: "Get", URL: "http://10.0.0.1:8000/", Err: err} }
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"refused", wrap(opError("dial", syscall.ECONNREFUSED)), kindDialRefused},
+		{"dial timeout", wrap(&net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}), kindDialTimeout},
+		{"TLS handshake timeout", wrap(errors.New("net/http: TLS handshake timeout")), kindTLSHandshake},
+		{"bad certificate", wrap(x509.HostnameError{Host: "backend"}), kindTLSHandshake},
+		{"client gone", wrap(context.Canceled), kindClientReset},
+		{"response timeout", wrap(context.DeadlineExceeded), kindBackendTimeout},
+		{"read timeout", wrap(&net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}), kindBackendTimeout},
+		{"reset", wrap(opError("read", syscall.ECONNRESET)), kindBackendReset},
+		{"hung up", wrap(io.EOF), kindBackendReset},
+		{"hung up mid-response", wrap(io.ErrUnexpectedEOF), kindBackendReset},
+		{"other", wrap(errors.New("malformed HTTP response")), kindCopyError},
+	}
+	for _, tt := range tests {
+		if got := proxyKind(tt.err); got != tt.want {
+			t.Errorf("%s: %v is %s, want %s", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
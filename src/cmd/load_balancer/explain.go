@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// ---------------- Explain ---------------- //
+
+// explainAll logs how the backend of every connection or request is selected,
+// see -explain
+var explainAll bool
+
+// explainRule is what POST /explain asks to explain: connections from client,
+// any if empty, the next left of them, with no end if 0.
+type explainRule struct {
+	Client string `json:"client,omitempty"`
+	Left   int    `json:"left,omitempty"`
+}
+
+// explainRules holds the rule set through the admin API, nil if none
+var explainRules struct {
+	mu   sync.Mutex
+	rule *explainRule
+}
+
+// setExplainRule sets the rule of the admin API, nil to stop explaining.
+func setExplainRule(r *explainRule) {
+	explainRules.mu.Lock()
+	defer explainRules.mu.Unlock()
+	explainRules.rule = r
+}
+
+// currentExplainRule returns the rule of the admin API, nil if none.
+func currentExplainRule() *explainRule {
+	explainRules.mu.Lock()
+	defer explainRules.mu.Unlock()
+	if r := explainRules.rule; r != nil {
+		copy := *r
+		return &copy
+	}
+	return nil
+}
+
+// describeExplainRule describes r for the log.
+func describeExplainRule(r *explainRule) string {
+	who := "every client"
+	if r.Client != "" {
+		who = r.Client
+	}
+	if r.Left > 0 {
+		return fmt.Sprintf("the next %d connections of %s", r.Left, who)
+	}
+	return who
+}
+
+// explainer logs why the backends of a connection or request were selected, or
+// skipped. A nil *explainer logs nothing.
+type explainer struct {
+	client string
+	log    connLog
+}
+
+// explainFor returns the explainer of a connection or request from client, nil
+// unless -explain or a rule of the admin API asks for it.
+func explainFor(client string, log connLog) *explainer {
+	if explainAll {
+		return &explainer{client, log}
+	}
+	explainRules.mu.Lock()
+	defer explainRules.mu.Unlock()
+	r := explainRules.rule
+	if r == nil {
+		return nil
+	}
+	if ip, _, err := net.SplitHostPort(client); r.Client != "" && (err != nil || ip != r.Client) {
+		return nil
+	}
+	if r.Left > 0 {
+		if r.Left--; r.Left == 0 {
+			explainRules.rule = nil
+		}
+	}
+	return &explainer{client, log}
+}
+
+// decisions returns the ConnInfo.Explain of the connection, nil for none.
+func (e *explainer) decisions() func(load_balancer.Decision) {
+	if e == nil {
+		return nil
+	}
+	return func(d load_balancer.Decision) {
+		e.log.Printf("Explain %s: %s", e.client, d)
+	}
+}
+
+// skipped logs that backend was selected but not tried, and why.
+func (e *explainer) skipped(backend, reason string) {
+	if e != nil {
+		e.log.Printf("Explain %s: skipped %s, %s", e.client, backend, reason)
+	}
+}
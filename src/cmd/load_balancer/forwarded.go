@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http/httputil"
+	"net/netip"
+	"strings"
+)
+
+// ---------------- Forwarded headers ---------------- //
+
+// trustedProxies are the networks of proxies in front of the balancer, see
+// -trusted-proxies. Forwarding headers from them are kept and added to; from
+// anyone else they are replaced, so clients can't forge their address.
+var trustedProxies []netip.Prefix
+
+// trustedPeer reports whether the peer at addr, host:port, is a trusted proxy.
+func trustedPeer(addr string) bool { return peerIn(addr, trustedProxies) }
+
+// peerIn reports whether the peer at addr, host:port, is in one of prefixes.
+func peerIn(addr string, prefixes []netip.Prefix) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// setForwarded sets X-Forwarded-For, X-Forwarded-Host, X-Forwarded-Proto and
+// Forwarded (RFC 7239) on a request to a backend. Rewrite has already removed
+// them from pr.Out.
+func setForwarded(pr *httputil.ProxyRequest) {
+	trusted := trustedPeer(pr.In.RemoteAddr)
+	if trusted {
+		pr.Out.Header["X-Forwarded-For"] = pr.In.Header["X-Forwarded-For"]
+	}
+	pr.SetXForwarded() // appends the peer to X-Forwarded-For
+	if trusted {
+		for _, name := range []string{"X-Forwarded-Host", "X-Forwarded-Proto"} {
+			if v := pr.In.Header.Get(name); v != "" {
+				pr.Out.Header.Set(name, v)
+			}
+		}
+	}
+
+	proto := "http"
+	if pr.In.TLS != nil {
+		proto = "https"
+	}
+	element := fmt.Sprintf("for=%s;host=%s;proto=%s", forwardedNode(pr.In.RemoteAddr), forwardedValue(pr.In.Host), proto)
+	if prior := pr.In.Header.Values("Forwarded"); trusted && len(prior) > 0 {
+		element = strings.Join(prior, ", ") + ", " + element
+	}
+	pr.Out.Header.Set("Forwarded", element)
+}
+
+// forwardedNode formats the address of a peer for a Forwarded header: the IP,
+// quoted and in brackets if it's IPv6.
+func forwardedNode(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "unknown"
+	}
+	if strings.Contains(host, ":") {
+		return `"[` + host + `]"`
+	}
+	return host
+}
+
+// forwardedValue quotes v if it isn't a plain token, e.g. for a host with a port.
+func forwardedValue(v string) string {
+	if strings.ContainsAny(v, ":[]\",;= ") {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+	}
+	return v
+}
+
+// parsePrefixes parses a comma-separated list of CIDRs or plain IPs.
+func parsePrefixes(v string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, netip.PrefixFrom(ip, ip.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
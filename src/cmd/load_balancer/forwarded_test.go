@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/netip"
+	"net/url"
+	"testing"
+)
+
+func TestForwardedHeaders(t *testing.T) {
+	saved := trustedProxies
+	t.Cleanup(func() { trustedProxies = saved })
+	trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	got := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Clone()
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+	proxy := &httputil.ReverseProxy{Rewrite: func(pr *httputil.ProxyRequest) {
+		pr.SetURL(target)
+		pr.Out.Host = pr.In.Host
+		setForwarded(pr)
+	}}
+
+	spoofed := http.Header{
+		"X-Forwarded-For":   {"198.51.100.7"},
+		"X-Forwarded-Host":  {"evil.example"},
+		"X-Forwarded-Proto": {"https"},
+		"Forwarded":         {"for=198.51.100.7;proto=https"},
+	}
+	tests := []struct {
+		name   string
+		remote string
+		host   string
+		in     http.Header
+		want   map[string]string
+	}{
+		{"untrusted peer, no headers", "203.0.113.5:4000", "example.com", nil, map[string]string{
+			"X-Forwarded-For":   "203.0.113.5",
+			"X-Forwarded-Host":  "example.com",
+			"X-Forwarded-Proto": "http",
+			"Forwarded":         "for=203.0.113.5;host=example.com;proto=http",
+		}},
+		{"untrusted peer spoofing", "203.0.113.5:4000", "example.com", spoofed, map[string]string{
+			"X-Forwarded-For":   "203.0.113.5",
+			"X-Forwarded-Host":  "example.com",
+			"X-Forwarded-Proto": "http",
+			"Forwarded":         "for=203.0.113.5;host=example.com;proto=http",
+		}},
+		{"trusted proxy", "10.1.2.3:4000", "example.com", spoofed, map[string]string{
+			"X-Forwarded-For":   "198.51.100.7, 10.1.2.3",
+			"X-Forwarded-Host":  "evil.example",
+			"X-Forwarded-Proto": "https",
+			"Forwarded":         "for=198.51.100.7;proto=https, for=10.1.2.3;host=example.com;proto=http",
+		}},
+		{"trusted proxy, IPv4-mapped", "[::ffff:10.1.2.3]:4000", "example.com", spoofed, map[string]string{
+			"X-Forwarded-For": "198.51.100.7, ::ffff:10.1.2.3",
+		}},
+		{"untrusted IPv6 peer, host with port", "[2001:db8::1]:4000", "example.com:8080", spoofed, map[string]string{
+			"X-Forwarded-For": "2001:db8::1",
+			"Forwarded":       `for="[2001:db8::1]";host="example.com:8080";proto=http`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://"+tt.host+"/", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.in {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("proxy answered %d", w.Code)
+			}
+			h := <-got
+			for name, want := range tt.want {
+				if v := h.Get(name); v != want {
+					t.Errorf("%s: %q, want %q", name, v, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// ---------------- Frontends ---------------- //
+
+// frontend is a listener and the policy its clients are balanced with. The one
+// from the flags is the main frontend; the config file can add more, each with
+// its own port, mode and backends, see frontendConfig.
+type frontend struct {
+	name   string // "" for the main frontend
+	addr   string
+	mode   string
+	bySNI  bool // route connections by TLS server name, see -sni-passthrough
+	pool   *load_balancer.Pool
+	policy *load_balancer.Switchable
+	pools  *cutover       // blue/green pools taking traffic in place of policy, main frontend only
+	ls     []net.Listener // one per address, or per socket with -reuseport
+	srv    *http.Server   // nil in tcp mode
+	loops  sync.WaitGroup // accept loops, one per listener
+
+	sniffed *handoffListener // in sniff mode, the HTTP connections srv serves
+}
+
+// newFrontend builds a frontend from the config file over pool, which holds its
+// backends; it doesn't listen until listen is called. HTTP and gRPC frontends
+// get the options from the -http-* flags, without the routes, which belong to
+// the main frontend.
+func newFrontend(fc frontendConfig, pool *load_balancer.Pool, opts load_balancer.Options, httpOpts httpOptions) (*frontend, error) {
+	policy, err := load_balancer.NewSwitchable(fc.Policy, pool, opts)
+	if err != nil {
+		return nil, err
+	}
+	f := &frontend{name: fc.Name, addr: fc.Listen, mode: fc.Mode, pool: pool, policy: policy}
+	if f.mode != "tcp" {
+		httpOpts.routed = false
+		httpOpts.retry.budget = httpOpts.retry.budget.fresh()
+		httpOpts.grpc = f.mode == "grpc"
+		httpOpts.backendHTTP2 = httpOpts.backendHTTP2 || httpOpts.grpc
+		// only the main frontend terminates TLS, so there's no HTTP/2 to offer but h2c
+		f.srv = newHTTPServer(newHTTPProxy(policy, httpOpts), false, httpOpts.grpc)
+	}
+	return f, nil
+}
+
+// newHTTPServer returns the server of an HTTP or gRPC frontend. HTTP/2 is
+// offered over TLS if http2 is set; gRPC clients without TLS use h2c.
+func newHTTPServer(handler http.Handler, http2, grpc bool) *http.Server {
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: headerTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+		IdleTimeout:       idleTimeout,
+		ErrorLog:          logger,
+		Protocols:         new(http.Protocols),
+		ConnState:         countHTTPClients,
+	}
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetHTTP2(http2)
+	srv.Protocols.SetUnencryptedHTTP2(grpc)
+	return srv
+}
+
+// listen opens the frontend's listeners, which tune the sockets they accept.
+// The HTTP server has its own accept loop, so the limits are applied by the
+// listeners instead of handleClient; a sniffing frontend accepts for it.
+func (f *frontend) listen() error {
+	ls, err := listen(f.addr)
+	if err != nil {
+		return err
+	}
+	for i, l := range ls {
+		ls[i] = tunedListener{l}
+		if f.srv != nil && f.mode != "sniff" {
+			ls[i] = newGuardedListener(ls[i])
+		}
+	}
+	f.ls = ls
+	if f.mode == "sniff" {
+		f.sniffed = newHandoffListener(ls[0].Addr())
+	}
+	return nil
+}
+
+// serve accepts clients on every listener until shutdown, each in a loop of its
+// own so they don't contend for one accept queue.
+func (f *frontend) serve() {
+	if f.sniffed != nil {
+		f.loops.Add(1)
+		go func() {
+			defer f.loops.Done()
+			f.srv.Serve(f.sniffed)
+		}()
+	}
+	for _, l := range f.ls {
+		f.loops.Add(1)
+		go func() {
+			defer f.loops.Done()
+			if f.srv != nil && f.sniffed == nil {
+				f.srv.Serve(l)
+				return
+			}
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				if ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String()); !accepts.allow(ip) {
+					logger.Printf("Refused client %s: accept rate exceeded", conn.RemoteAddr())
+					conn.Close()
+					continue
+				}
+				// handle connection concurrently, on a worker of its own
+				workers.acquire()
+				if f.sniffed != nil {
+					go f.serveSniffed(conn)
+					continue
+				}
+				go func() {
+					defer workers.release()
+					handleClient(conn, f.policyFor(conn.RemoteAddr().String()), f.bySNI)
+				}()
+			}
+		}()
+	}
+}
+
+// policyFor returns the policy a connection from the client at addr is
+// balanced with. The main frontend routes clients by location first, and sends
+// the others to the active blue/green pool if there is one.
+func (f *frontend) policyFor(addr string) load_balancer.Policy {
+	if f.name == "" {
+		if policy, ok := geoRoutes.match(addr); ok {
+			return policy
+		}
+	}
+	return f.pools.policy(f.policy)
+}
+
+// shutdown stops accepting clients; an HTTP frontend also waits for its
+// requests in flight, until ctx is done and it closes them. Connections of a tcp
+// frontend are left to finish, as are those a sniffing frontend doesn't serve
+// as HTTP.
+func (f *frontend) shutdown(ctx context.Context) {
+	if f.sniffed != nil {
+		for _, l := range f.ls {
+			l.Close()
+		}
+	}
+	if f.srv != nil {
+		if f.srv.Shutdown(ctx) != nil {
+			logger.Printf("Closing the HTTP connections still open on %s: %v", f.addr, context.Cause(ctx))
+			f.srv.Close()
+		}
+	} else {
+		for _, l := range f.ls {
+			l.Close()
+		}
+	}
+	f.loops.Wait()
+}
+
+// shutdownAll shuts the frontends down together, so none keeps accepting
+// while another drains.
+func shutdownAll(ctx context.Context, frontends []*frontend) {
+	var wg sync.WaitGroup
+	for _, f := range frontends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// httpFrontends reports whether cfg has a frontend in HTTP or gRPC mode.
+func httpFrontends(cfg *config) bool {
+	if cfg == nil {
+		return false
+	}
+	for _, fc := range cfg.Frontends {
+		if fc.Mode != "tcp" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// ---------------- gRPC mode ---------------- //
+
+// gRPC status codes the balancer answers with
+const (
+	grpcDeadlineExceeded = 4
+	grpcUnavailable      = 14
+)
+
+// grpc-status codes that are the backend's fault rather than the caller's; they
+// count as failures for the policy and circuit breaker
+var grpcServerFault = map[string]bool{
+	"2":  true, // UNKNOWN
+	"4":  true, // DEADLINE_EXCEEDED
+	"13": true, // INTERNAL
+	"14": true, // UNAVAILABLE
+	"15": true, // DATA_LOSS
+}
+
+// grpcError ends a call with a gRPC error, in a trailers-only response: gRPC
+// clients don't understand HTTP errors.
+func grpcError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", message)
+	w.WriteHeader(http.StatusOK)
+}
+
+// grpcStatus returns the grpc-status a response ended with, from its headers for
+// a trailers-only response or else its trailers, "" if it has none.
+func grpcStatus(h http.Header) string {
+	if code := h.Get("Grpc-Status"); code != "" {
+		return code
+	}
+	// trailers the backend didn't announce, as the reverse proxy passes them on
+	if v := h[http.TrailerPrefix+"Grpc-Status"]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
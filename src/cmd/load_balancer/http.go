@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"Load-Balancer/pkg/load_balancer"
+	"Load-Balancer/pkg/tracing"
+)
+
+// ---------------- HTTP mode ---------------- //
+
+// idle keep-alive connections kept per backend, so busy backends aren't redialed
+// for every request
+const httpIdleConnsPerBackend = 64
+
+// httpAttempt is one try of a request on a backend. It travels in the request
+// context from ServeHTTP to the reverse proxy's hooks.
+type httpAttempt struct {
+	id       string // of the request, see requestID
+	backend  string
+	strip    string        // path prefix removed before forwarding
+	stick    bool          // pin the client to the backend, see stickyCookie
+	canRetry bool          // another backend is left to retry on
+	status   int           // of the backend's response, 0 if there was none
+	err      error         // transport error or errRetryStatus, nil if the response went to the client
+	retry    bool          // the try failed and nothing was sent to the client, try the next backend
+	span     *tracing.Span // of the try, nil unless tracing
+}
+
+type httpAttemptKey struct{}
+
+// httpOptions configure HTTP mode, from the -http-* and -sticky-* flags.
+type httpOptions struct {
+	headers      http.Header // set on every request to a backend, see -http-header
+	maintenance  []byte      // answered while backends are in maintenance and none is available
+	noBackend    []byte      // answered when no backend is available otherwise, see -http-no-backend-page
+	sticky       *stickyCookie
+	retry        retryPolicy
+	backendHTTP2 bool // HTTP/2 to backends, h2c without -backend-tls
+	grpc         bool // requests are gRPC calls, see -mode grpc
+	routed       bool // the -http-*-route flags apply, only on the main frontend
+}
+
+// httpProxy balances HTTP requests over the backends of a policy. Each request
+// is balanced on its own, so a keep-alive client is spread over every backend.
+type httpProxy struct {
+	httpOptions
+	policy load_balancer.Policy
+	proxy  *httputil.ReverseProxy
+}
+
+func newHTTPProxy(policy load_balancer.Policy, opts httpOptions) *httpProxy {
+	h := &httpProxy{httpOptions: opts, policy: policy}
+	scheme := "http"
+	if backendTLS != nil {
+		scheme = "https"
+	}
+	h.proxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			attempt := pr.In.Context().Value(httpAttemptKey{}).(*httpAttempt)
+			pr.SetURL(&url.URL{Scheme: scheme, Host: backendURLHost(attempt.backend)})
+			if attempt.strip != "" {
+				pr.Out.URL.Path = stripPrefix(attempt.strip, pr.Out.URL.Path)
+				if pr.Out.URL.RawPath != "" {
+					pr.Out.URL.RawPath = stripPrefix(attempt.strip, pr.Out.URL.RawPath)
+				}
+			}
+			pr.Out.Host = pr.In.Host // backends see the name the client asked for
+			setForwarded(pr)
+			setJA3Header(pr)
+			if requestIDHeader != "" {
+				pr.Out.Header.Set(requestIDHeader, attempt.id)
+			}
+			if attempt.span != nil {
+				pr.Out.Header.Set(traceparentHeader, attempt.span.SpanContext().Traceparent())
+			}
+			for name, values := range h.headers {
+				pr.Out.Header[name] = values
+			}
+		},
+		ModifyResponse: func(res *http.Response) error {
+			attempt := res.Request.Context().Value(httpAttemptKey{}).(*httpAttempt)
+			attempt.status = res.StatusCode
+			if requestIDHeader != "" {
+				res.Header.Del(requestIDHeader) // ServeHTTP set it already
+			}
+			if attempt.canRetry && h.retry.retryResponse(res.Request.Method, res.StatusCode) {
+				return errRetryStatus // ErrorHandler is called, the response is dropped
+			}
+			if attempt.stick {
+				res.Header.Add("Set-Cookie", h.sticky.cookie(attempt.backend).String())
+			}
+			return nil
+		},
+		Transport:  newHTTPTransport(opts.backendHTTP2),
+		BufferPool: copyBuffers,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			attempt := r.Context().Value(httpAttemptKey{}).(*httpAttempt)
+			clog := connLog(attempt.id)
+			if b, ok := r.Body.(*deadlineBody); ok && b.expired() {
+				tooSlow(clog, w, r) // the client's fault, not the backend's
+				return
+			}
+			attempt.err = err
+			if errors.Is(err, errRetryStatus) {
+				attempt.retry = true
+				clog.Printf("Backend %s answered %s %s with %d, retrying", attempt.backend, r.Method, r.URL.Path, attempt.status)
+				return
+			}
+			clog.Printf("ERROR proxying %s %s to backend %s: %v error=%s", r.Method, r.URL.Path, attempt.backend, err, countError(proxyKind(err), attempt.backend))
+			if attempt.canRetry && h.retry.retryError(r.Method, err) {
+				attempt.retry = true
+				return
+			}
+			timedOut := errors.Is(err, context.DeadlineExceeded)
+			switch {
+			case h.grpc && timedOut:
+				grpcError(w, grpcDeadlineExceeded, "backend timed out")
+			case h.grpc:
+				grpcError(w, grpcUnavailable, "backend unreachable")
+			case timedOut:
+				w.WriteHeader(http.StatusGatewayTimeout)
+			default:
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		},
+		ErrorLog: logger,
+	}
+	return h
+}
+
+// newHTTPTransport returns the transport requests go to backends with. Over
+// HTTP/2 requests to a backend share a few connections as streams.
+func newHTTPTransport(http2 bool) *http.Transport {
+	t := &http.Transport{
+		DialContext:         dialHTTP,
+		TLSClientConfig:     backendTLS,
+		TLSHandshakeTimeout: dialer.Timeout,
+		MaxIdleConnsPerHost: httpIdleConnsPerBackend,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	if http2 {
+		t.Protocols = new(http.Protocols)
+		if backendTLS != nil {
+			t.Protocols.SetHTTP2(true)
+		} else {
+			t.Protocols.SetUnencryptedHTTP2(true)
+		}
+	}
+	return t
+}
+
+func (h *httpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	var span *tracing.Span
+	defer func() {
+		proxyLatency.With("http").ObserveWithExemplar(time.Since(start).Seconds(), traceExemplar(span))
+		latencies.add(time.Since(start))
+	}()
+	id := requestID(r)
+	clog := connLog(id)
+	if requestIDHeader != "" {
+		w.Header().Set(requestIDHeader, id)
+	}
+	rec := &responseRecorder{ResponseWriter: w, id: id}
+	w = rec
+	if accessLog != nil {
+		defer logRequest(rec, r, countBody(r), start)
+	}
+	if tracer != nil {
+		span = startRequestSpan(r)
+		defer finishRequestSpan(span, rec)
+	}
+	var limited *deadlineBody
+	if !h.grpc {
+		limited = limitBody(w, r)
+	}
+	policy, strip := h.route(r)
+	policy.Events().OnAccept(r.RemoteAddr)
+	pinned := h.sticky.backend(r, policy)
+	body, replayable := h.retry.buffer(r)
+	if limited.expired() {
+		tooSlow(clog, w, r)
+		return
+	}
+	tries := 1
+	if replayable {
+		tries += h.retry.retries
+	}
+	explain := explainFor(r.RemoteAddr, clog)
+	info := load_balancer.ConnInfo{ClientAddr: r.RemoteAddr, Prefer: pinned, Explain: explain.decisions()}
+	candidates, err := policy.SelectServers(r.Context(), info, tries)
+	if err == nil {
+		if candidates = allowed(candidates, policy, explain); len(candidates) == 0 {
+			err = errors.New("backends are backing off or their circuits are open")
+		}
+	}
+	if err != nil {
+		h.unavailable(clog, w, r, policy, err)
+		return
+	}
+	h.retry.budget.request()
+
+	for i, backend := range candidates {
+		attempt := &httpAttempt{
+			id:       id,
+			backend:  backend,
+			strip:    strip,
+			stick:    h.sticky != nil && backend != pinned,
+			canRetry: i < len(candidates)-1,
+			span:     tracer.Start(r.Method, tracing.KindClient, span.SpanContext()),
+		}
+		if !h.try(rec, r, body, attempt, policy) {
+			for _, rest := range candidates[i+1:] {
+				policy.Release(rest)
+				breaker.Cancel(rest)
+			}
+			return
+		}
+	}
+}
+
+// try sends r to the backend of attempt and reports the outcome to the policy.
+// It reports whether the request should be retried on the next backend.
+func (h *httpProxy) try(rec *responseRecorder, r *http.Request, body []byte, attempt *httpAttempt, policy load_balancer.Policy) bool {
+	ctx := context.WithValue(r.Context(), httpAttemptKey{}, attempt)
+	if h.retry.tryTimeout > 0 && !isUpgrade(r) { // upgraded connections live on
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.retry.tryTimeout)
+		defer cancel()
+	}
+	r = r.WithContext(ctx)
+	if body != nil {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	rec.backend = attempt.backend
+	bytesBefore := rec.bytes
+	start := time.Now()
+	h.proxy.ServeHTTP(rec, r)
+
+	sent, received := max(r.ContentLength, 0), rec.bytes-bytesBefore
+	result := load_balancer.Result{Err: attempt.err, Bytes: sent + received, Duration: time.Since(start), Sent: sent, Received: received}
+	if result.Err == nil && attempt.status >= 500 {
+		result.Err = fmt.Errorf("backend answered %d", attempt.status)
+	}
+	if code := grpcStatus(rec.Header()); result.Err == nil && h.grpc && grpcServerFault[code] {
+		result.Err = fmt.Errorf("backend answered grpc-status %s", code)
+	}
+	finishTrySpan(attempt, sent, received, result.Err)
+	policy.Update(attempt.backend, result)
+	breaker.Record(attempt.backend, result)
+	connDuration.With(attempt.backend).ObserveWithExemplar(result.Duration.Seconds(), traceExemplar(attempt.span))
+	switch {
+	case dialFailed(attempt.err):
+		dialErrors.With(attempt.backend).Inc()
+		if wait := backoff.Failed(attempt.backend); wait > 0 {
+			connLog(attempt.id).Printf("Backing off backend %s for %v", attempt.backend, wait)
+		}
+		markDown(attempt.backend, policy, attempt.err)
+	case attempt.err == nil:
+		backoff.Succeeded(attempt.backend)
+	}
+	return attempt.retry
+}
+
+// countBody counts the bytes read of r's body, for the access log.
+func countBody(r *http.Request) *countingBody {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	b := &countingBody{ReadCloser: r.Body}
+	r.Body = b
+	return b
+}
+
+// logRequest writes the access log line of r, answered through rec.
+func logRequest(rec *responseRecorder, r *http.Request, body *countingBody, start time.Time) {
+	e := &accessEntry{start: start, id: rec.id, client: r.RemoteAddr, mode: "http", backend: rec.backend, ja3: requestJA3(r), out: rec.bytes, status: rec.status, r: r}
+	if body != nil {
+		e.in = body.n
+	}
+	if r.TLS != nil {
+		e.sni = r.TLS.ServerName
+	}
+	accessLog.log(e)
+}
+
+// route picks the pool for a request: by JA3 fingerprint, by header or
+// cookie, by path prefix, by Host, by client location, then from the traffic
+// split, the active blue/green pool or the default. It also returns the prefix
+// to strip from the path, if any.
+func (h *httpProxy) route(r *http.Request) (load_balancer.Policy, string) {
+	if !h.routed {
+		return h.policy, ""
+	}
+	if policy, ok := ja3Route(requestJA3(r)); ok {
+		return policy, ""
+	}
+	if policy, ok := httpMatchRoutes.match(r); ok {
+		return policy, ""
+	}
+	if route, ok := httpPathRoutes.match(r.URL.Path); ok {
+		if route.strip {
+			return route.policy, route.prefix
+		}
+		return route.policy, ""
+	}
+	if policy := httpRoutes.match(requestHost(r), nil); policy != nil {
+		return policy, ""
+	}
+	if policy, ok := geoRoutes.match(r.RemoteAddr); ok {
+		return policy, ""
+	}
+	return httpSplit.pick(r, h.sticky, blueGreen.policy(h.policy)), ""
+}
+
+// unavailable answers a request no backend can take: with the maintenance page
+// if that's why, or the no-backend page, 503 either way.
+func (h *httpProxy) unavailable(clog connLog, w http.ResponseWriter, r *http.Request, policy load_balancer.Policy, err error) {
+	turnedAway.requests.Add(1)
+	clog.Printf("No backend for %s %s from %s: %v error=%s", r.Method, r.URL.Path, clientLabel(r.RemoteAddr), err, countError(kindNoBackend, ""))
+	if h.grpc {
+		grpcError(w, grpcUnavailable, "no backend available")
+		return
+	}
+	if h.maintenance != nil {
+		for _, b := range policy.Stats().Backends {
+			if b.Maintenance {
+				servePage(w, h.maintenance)
+				return
+			}
+		}
+	}
+	if h.noBackend != nil {
+		servePage(w, h.noBackend)
+		return
+	}
+	http.Error(w, "no backend available", http.StatusServiceUnavailable)
+}
+
+// servePage answers 503 with page.
+func servePage(w http.ResponseWriter, page []byte) {
+	w.Header().Set("Content-Type", http.DetectContentType(page))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write(page)
+}
+
+// responseRecorder notes the status and size of a response on its way through.
+type responseRecorder struct {
+	http.ResponseWriter
+	id      string // of the request, see requestID
+	backend string // of the try in progress
+	status  int
+	bytes   int64
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	// informational responses like 103 Early Hints come before the real one
+	if r.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to flush.
+func (r *responseRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+// Hijack takes the client connection over when the backend accepts an upgrade,
+// e.g. to WebSocket. The reverse proxy then copies bytes both ways for as long
+// as the socket lives.
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return newUpgradedConn(conn, r.backend, r.id), rw, nil
+}
+
+// limitBody gives the client -http-body-timeout to send r's body, so a slow
+// client can't hold a backend connection open. Upgrades are exempt, as are gRPC
+// streams: their bodies are meant to last. It returns the limited body, nil if
+// there is none.
+func limitBody(w http.ResponseWriter, r *http.Request) *deadlineBody {
+	if bodyTimeout <= 0 || r.Body == nil || r.Body == http.NoBody || isUpgrade(r) {
+		return nil
+	}
+	rc := http.NewResponseController(w)
+	if rc.SetReadDeadline(time.Now().Add(bodyTimeout)) != nil {
+		return nil
+	}
+	b := &deadlineBody{ReadCloser: r.Body, rc: rc}
+	r.Body = b
+	return b
+}
+
+// deadlineBody is a request body with a read deadline. Once read the deadline
+// is lifted: the server keeps reading to notice the client going away, and would
+// cancel the request if that timed out.
+type deadlineBody struct {
+	io.ReadCloser
+	rc      *http.ResponseController
+	once    sync.Once
+	timeout atomic.Bool
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	switch {
+	case err == io.EOF:
+		b.once.Do(func() { b.rc.SetReadDeadline(time.Time{}) })
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		b.timeout.Store(true)
+	}
+	return n, err
+}
+
+// expired reports whether the deadline passed before the body was read. A nil
+// *deadlineBody never expires.
+func (b *deadlineBody) expired() bool {
+	return b != nil && b.timeout.Load()
+}
+
+// tooSlow answers a request whose body didn't arrive within -http-body-timeout.
+func tooSlow(clog connLog, w http.ResponseWriter, r *http.Request) {
+	clog.Printf("Client %s too slow sending the body of %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+	http.Error(w, "request body timeout", http.StatusRequestTimeout)
+}
+
+// upgradedConn is a client connection switched to another protocol. Like the
+// connections of TCP mode it has an idle timeout, is cut when its backend's
+// drain runs out of time, and is waited for on shutdown. Both directions go
+// through it, and closing it ends the backend side too.
+type upgradedConn struct {
+	net.Conn
+	backend string
+	idle    *idleWatch
+	info    *connInfo
+	once    sync.Once
+}
+
+func newUpgradedConn(conn net.Conn, backend, id string) *upgradedConn {
+	activeWG.Add(1)
+	c := &upgradedConn{Conn: conn, backend: backend}
+	entry := proxied{client: conn, backend: conn}
+	c.info = newConnInfo(id, conn.RemoteAddr().String(), backend, "upgraded", time.Now())
+	openConns.add(entry, c.info)
+	c.idle = watchIdle(entry, idleTimeout)
+	return c
+}
+
+func (c *upgradedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.idle.touch()
+		c.info.sent.Add(int64(n))
+	}
+	return n, err
+}
+
+func (c *upgradedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.idle.touch()
+		c.info.received.Add(int64(n))
+	}
+	return n, err
+}
+
+func (c *upgradedConn) Close() error {
+	c.once.Do(func() {
+		c.idle.stop()
+		clog := connLog(c.info.id)
+		switch {
+		case c.idle.closed():
+			clog.Printf("Closed upgraded connection of client %s via backend %s after %v idle", c.RemoteAddr(), c.backend, idleTimeout)
+		case c.info.killed.Load():
+			clog.Printf("Closed upgraded connection of client %s via backend %s through the admin API", c.RemoteAddr(), c.backend)
+		}
+		openConns.remove(c.backend, proxied{client: c.Conn, backend: c.Conn})
+		activeWG.Done()
+	})
+	return c.Conn.Close()
+}
+
+// unix socket backends get a made-up host in request URLs, see backendURLHost
+const unixURLHost = ".unix-socket"
+
+// backendURLHost returns the host of backend to put in request URLs. A unix
+// socket's path is hex-encoded into a name of its own, so each socket keeps its
+// own pool of connections; dialHTTP decodes it.
+func backendURLHost(backend string) string {
+	if network, path := load_balancer.SplitNetwork(backend); network == "unix" {
+		return hex.EncodeToString([]byte(path)) + unixURLHost
+	}
+	return backend
+}
+
+// dialHTTP dials the backend of a request URL for the HTTP transport.
+func dialHTTP(ctx context.Context, network, addr string) (net.Conn, error) {
+	backend := addr
+	host, _, _ := net.SplitHostPort(addr)
+	if encoded, ok := strings.CutSuffix(host, unixURLHost); ok {
+		if path, err := hex.DecodeString(encoded); err == nil {
+			network, addr, backend = "unix", string(path), load_balancer.UnixScheme+string(path)
+		}
+	}
+	start := time.Now()
+	conn, err := backendDialer{dialer}.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	dialLatency.With(backend).Observe(time.Since(start).Seconds())
+	if network != "unix" {
+		sockOpts.apply(conn)
+	}
+	return conn, nil
+}
+
+// isUpgrade reports whether r asks to switch protocols, e.g. to WebSocket.
+func isUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dialFailed reports whether err is from failing to connect to a backend, so
+// the request never reached it.
+func dialFailed(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// requestHost returns the host name a request is for, without the port.
+func requestHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		return host
+	}
+	return r.Host
+}
+
+// parseHeader parses "Name: value".
+func parseHeader(v string) (string, string, error) {
+	name, value, ok := strings.Cut(v, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("invalid header %q, want \"Name: value\"", v)
+	}
+	return http.CanonicalHeaderKey(name), strings.TrimSpace(value), nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"Load-Balancer/pkg/load_balancer"
+	"Load-Balancer/pkg/sni"
+)
+
+// ---------------- JA3 fingerprints ---------------- //
+
+// pools TLS clients are sent to by JA3 fingerprint, see -ja3-route
+var ja3Routes = make(map[string]load_balancer.Policy)
+
+// JA3 fingerprints of TLS clients that are refused, see -ja3-deny
+var ja3Deny = make(map[string]bool)
+
+// a ClientHello longer than this isn't fingerprinted
+const maxHelloBytes = 16 << 10
+
+var errJA3Denied = errors.New("JA3 fingerprint denied")
+
+// header telling HTTP backends the JA3 fingerprint of the client
+const ja3Header = "X-JA3-Fingerprint"
+
+// parseJA3 checks that v is a JA3 hash, 32 hex digits, and returns it in
+// lower case.
+func parseJA3(v string) (string, error) {
+	v = strings.ToLower(v)
+	if b, err := hex.DecodeString(v); err != nil || len(b) != 16 {
+		return "", fmt.Errorf("invalid JA3 fingerprint %q, want an MD5 hash in hex", v)
+	}
+	return v, nil
+}
+
+// parseJA3Route parses hash=pool, the pool given by name.
+func parseJA3Route(v string) (string, load_balancer.Policy, error) {
+	hash, policy, err := parsePoolRoute(v)
+	if err != nil {
+		return "", nil, err
+	}
+	if hash, err = parseJA3(hash); err != nil {
+		return "", nil, err
+	}
+	return hash, policy, nil
+}
+
+// ja3Route returns the pool of clients with fingerprint ja3, if one is routed.
+func ja3Route(ja3 string) (load_balancer.Policy, bool) {
+	if ja3 == "" {
+		return nil, false
+	}
+	policy, ok := ja3Routes[ja3]
+	return policy, ok
+}
+
+// helloListener records the ClientHello of the connections it accepts, for a
+// TLS listener over it to fingerprint them with fingerprintClient.
+type helloListener struct {
+	net.Listener
+}
+
+func (l helloListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &helloConn{Conn: conn}, nil
+}
+
+// helloConn records the bytes read from a client until the handshake reaches
+// fingerprintClient, which takes its JA3 fingerprint from them.
+type helloConn struct {
+	net.Conn
+	hello []byte
+	done  bool
+	ja3   string // "" if unknown
+}
+
+func (c *helloConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if !c.done {
+		if len(c.hello)+n > maxHelloBytes {
+			c.hello, c.done = nil, true
+		} else {
+			c.hello = append(c.hello, p[:n]...)
+		}
+	}
+	return n, err
+}
+
+// fingerprintClient is the GetConfigForClient of the terminating TLS config:
+// it takes the client's JA3 fingerprint, now that its ClientHello has been
+// read, and refuses denied clients.
+func fingerprintClient(info *tls.ClientHelloInfo) (*tls.Config, error) {
+	c, ok := info.Conn.(*helloConn)
+	if !ok {
+		return nil, nil
+	}
+	if !c.done {
+		c.ja3, _, _ = sni.JA3(c.hello)
+		c.hello, c.done = nil, true
+	}
+	if ja3Deny[c.ja3] {
+		logger.Printf("Refused client %s: JA3 fingerprint %s denied", c.RemoteAddr(), c.ja3)
+		return nil, errJA3Denied
+	}
+	return nil, nil
+}
+
+// connJA3 returns the JA3 fingerprint of a client whose TLS was terminated,
+// "" if unknown.
+func connJA3(conn net.Conn) string {
+	if tc, ok := conn.(*tls.Conn); ok {
+		if c, ok := tc.NetConn().(*helloConn); ok {
+			return c.ja3
+		}
+	}
+	return ""
+}
+
+type clientConnKey struct{}
+
+// withClientConn is the ConnContext of the HTTP server terminating TLS: it
+// keeps the client's connection so requestJA3 can look at it after the
+// handshake.
+func withClientConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, clientConnKey{}, conn)
+}
+
+// requestJA3 returns the JA3 fingerprint of the client that sent r, "" if
+// unknown.
+func requestJA3(r *http.Request) string {
+	conn, _ := r.Context().Value(clientConnKey{}).(net.Conn)
+	return connJA3(conn)
+}
+
+// setJA3Header tells the backend the JA3 fingerprint of a client whose TLS was
+// terminated, replacing any the client sent.
+func setJA3Header(pr *httputil.ProxyRequest) {
+	if pr.In.Context().Value(clientConnKey{}) == nil {
+		return
+	}
+	pr.Out.Header.Del(ja3Header)
+	if ja3 := requestJA3(pr.In); ja3 != "" {
+		pr.Out.Header.Set(ja3Header, ja3)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"gopkg.in/yaml.v3"
+)
+
+// ---------------- JSON config ---------------- //
+
+// parseJSON parses data, the JSON config file at path, into a tree that
+// remembers the line of each value. A key given twice is an error, where
+// encoding/json would keep the last.
+func parseJSON(path string, data []byte) (*yaml.Node, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	// line returns the line of offset in data
+	line := func(offset int64) int { return 1 + bytes.Count(data[:offset], []byte("\n")) }
+	errLine := 0 // of an error found here rather than by the decoder
+	var value func() (*yaml.Node, error)
+	value = func() (*yaml.Node, error) {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		n := &yaml.Node{Line: line(dec.InputOffset())}
+		switch tok := tok.(type) {
+		case json.Delim:
+			if tok == '[' {
+				n.Kind = yaml.SequenceNode
+				for dec.More() {
+					item, err := value()
+					if err != nil {
+						return nil, err
+					}
+					n.Content = append(n.Content, item)
+				}
+				_, err := dec.Token()
+				return n, err
+			}
+			n.Kind = yaml.MappingNode
+			seen := make(map[string]bool)
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				k := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key.(string), Line: line(dec.InputOffset())}
+				if seen[k.Value] {
+					errLine = k.Line
+					return nil, fmt.Errorf("key %s given twice", k.Value)
+				}
+				seen[k.Value] = true
+				v, err := value()
+				if err != nil {
+					return nil, err
+				}
+				n.Content = append(n.Content, k, v)
+			}
+			_, err := dec.Token()
+			return n, err
+		case string:
+			n.Kind, n.Tag, n.Value = yaml.ScalarNode, "!!str", tok
+		case json.Number:
+			n.Kind, n.Tag, n.Value = yaml.ScalarNode, "!!int", tok.String()
+			if strings.ContainsAny(n.Value, ".eE") {
+				n.Tag = "!!float"
+			}
+		case bool:
+			n.Kind, n.Tag, n.Value = yaml.ScalarNode, "!!bool", strconv.FormatBool(tok)
+		case nil:
+			n.Kind, n.Tag, n.Value = yaml.ScalarNode, "!!null", "null"
+		}
+		return n, nil
+	}
+	root, err := value()
+	if err == nil {
+		if _, err = dec.Token(); err == io.EOF {
+			return root, nil
+		} else if err == nil {
+			err = errors.New("data after the end of the config")
+		}
+	}
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		errLine = line(syntaxErr.Offset)
+	case err == io.EOF:
+		err = io.ErrUnexpectedEOF
+	}
+	if errLine == 0 {
+		errLine = line(dec.InputOffset())
+	}
+	return nil, fmt.Errorf("%s:%d: %w", path, errLine, err)
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ---------------- Connection limits ---------------- //
+
+var errTooManyConns = errors.New("too many connections")
+
+// connLimiter caps concurrent client connections, in total and per client IP.
+// Connections over the total limit may wait for a slot in a bounded queue,
+// higher priority classes first; those over the per-IP limit are refused at
+// once. A nil *connLimiter allows everything.
+type connLimiter struct {
+	perIP        int
+	total        int // 0 means no total limit
+	depth        int // most connections waiting for a slot
+	queueTimeout time.Duration
+
+	mu      sync.Mutex
+	byIP    map[string]int
+	open    int
+	waiting [numPriorities][]chan error // per class, first come first served
+	queued  int
+}
+
+// errShed is sent to a waiting connection that gave its place in the queue up
+// to one of a higher priority.
+var errShed = fmt.Errorf("%w, shed for a higher priority connection", errTooManyConns)
+
+// newConnLimiter returns a limiter, or nil if total and perIP are both 0.
+func newConnLimiter(total, perIP, queue int, queueTimeout time.Duration) *connLimiter {
+	if total <= 0 && perIP <= 0 {
+		return nil
+	}
+	return &connLimiter{perIP: perIP, total: max(total, 0), depth: queue, queueTimeout: queueTimeout, byIP: make(map[string]int)}
+}
+
+// acquire takes a slot for a connection from ip of class prio, waiting in the
+// queue if needed. A full queue makes room by shedding the latest connection of
+// the lowest class below prio. Every successful acquire must be followed by
+// release.
+func (l *connLimiter) acquire(ip string, prio priority) error {
+	if l == nil {
+		return nil
+	}
+	if l.perIP > 0 {
+		l.mu.Lock()
+		if l.byIP[ip] >= l.perIP {
+			l.mu.Unlock()
+			return fmt.Errorf("%w from %s", errTooManyConns, ip)
+		}
+		l.byIP[ip]++
+		l.mu.Unlock()
+	}
+	if l.total == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	if l.open < l.total && !l.waitingFrom(prio) {
+		l.open++
+		l.mu.Unlock()
+		return nil
+	}
+	if l.queued >= l.depth && !l.shed(prio) {
+		l.mu.Unlock()
+		l.releaseIP(ip)
+		return fmt.Errorf("%w, queue full", errTooManyConns)
+	}
+	ready := make(chan error, 1)
+	l.waiting[prio] = append(l.waiting[prio], ready)
+	l.queued++
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case err = <-ready:
+	case <-timer.C:
+		l.mu.Lock()
+		if i := slices.Index(l.waiting[prio], ready); i >= 0 {
+			l.waiting[prio] = slices.Delete(l.waiting[prio], i, i+1)
+			l.queued--
+			err = fmt.Errorf("%w, no slot within %v", errTooManyConns, l.queueTimeout)
+		} else {
+			err = <-ready // handed a slot or shed meanwhile
+		}
+		l.mu.Unlock()
+	}
+	if err != nil {
+		l.releaseIP(ip)
+	}
+	return err
+}
+
+// waitingFrom reports whether connections of class prio or higher wait, which
+// go first. Caller holds l.mu.
+func (l *connLimiter) waitingFrom(prio priority) bool {
+	for p := prio; p < numPriorities; p++ {
+		if len(l.waiting[p]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// shed drops the latest waiting connection of the lowest class below prio,
+// and reports whether there was one. Caller holds l.mu.
+func (l *connLimiter) shed(prio priority) bool {
+	for p := priority(0); p < prio; p++ {
+		if n := len(l.waiting[p]); n > 0 {
+			l.waiting[p][n-1] <- errShed
+			l.waiting[p] = l.waiting[p][:n-1]
+			l.queued--
+			return true
+		}
+	}
+	return false
+}
+
+// outranked reports whether a connection admitted as class from, found to be
+// of class to once its server name is known, should give its slot up: it's
+// lower, and connections of a class above to wait for one.
+func (l *connLimiter) outranked(from, to priority) bool {
+	if l == nil || l.total == 0 || to >= from {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waitingFrom(to + 1)
+}
+
+// release frees the slot of a connection from ip, handing it to the first
+// waiting connection of the highest class.
+func (l *connLimiter) release(ip string) {
+	if l == nil {
+		return
+	}
+	l.releaseIP(ip)
+	if l.total == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for p := numPriorities - 1; p >= 0; p-- {
+		if len(l.waiting[p]) > 0 {
+			l.waiting[p][0] <- nil // the slot passes on, open stays
+			l.waiting[p] = l.waiting[p][1:]
+			l.queued--
+			return
+		}
+	}
+	l.open--
+}
+
+func (l *connLimiter) releaseIP(ip string) {
+	if l.perIP <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.byIP[ip]--; l.byIP[ip] <= 0 {
+		delete(l.byIP, ip)
+	}
+}
+
+// tokenBucket lets events through at a rate, with bursts of up to its size.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{tokens: float64(burst), last: now}
+}
+
+// take refills the bucket for the time since the last call and takes a token
+// if there is one.
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) bool {
+	b.refill(now, rate, burst)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// reserve takes n tokens, going into debt if there aren't enough, and returns
+// how long until the debt is paid off.
+func (b *tokenBucket) reserve(now time.Time, rate float64, burst, n int) time.Duration {
+	b.refill(now, rate, burst)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+func (b *tokenBucket) refill(now time.Time, rate float64, burst int) {
+	b.tokens = min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+}
+
+// how often per-IP buckets that refilled are dropped
+const bucketSweepInterval = time.Minute
+
+// acceptLimiter rate limits new connections, in total and per client IP, with
+// token buckets. A nil *acceptLimiter allows everything.
+type acceptLimiter struct {
+	rate, ipRate   float64 // connections per second, 0 means no limit
+	burst, ipBurst int
+
+	mu     sync.Mutex
+	global *tokenBucket
+	byIP   map[string]*tokenBucket
+	swept  time.Time
+}
+
+// newAcceptLimiter returns a limiter, or nil if rate and ipRate are both 0. A
+// burst of 0 allows a second's worth of connections at once.
+func newAcceptLimiter(rate float64, burst int, ipRate float64, ipBurst int) *acceptLimiter {
+	if rate <= 0 && ipRate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = max(1, int(rate))
+	}
+	if ipBurst <= 0 {
+		ipBurst = max(1, int(ipRate))
+	}
+	now := time.Now()
+	return &acceptLimiter{
+		rate: rate, ipRate: ipRate, burst: burst, ipBurst: ipBurst,
+		global: newTokenBucket(burst, now),
+		byIP:   make(map[string]*tokenBucket),
+		swept:  now,
+	}
+}
+
+// allow reports whether a new connection from ip may be accepted.
+func (l *acceptLimiter) allow(ip string) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.ipRate > 0 {
+		l.sweep(now)
+		b := l.byIP[ip]
+		if b == nil {
+			b = newTokenBucket(l.ipBurst, now)
+			l.byIP[ip] = b
+		}
+		if !b.take(now, l.ipRate, l.ipBurst) {
+			return false
+		}
+	}
+	return l.rate <= 0 || l.global.take(now, l.rate, l.burst)
+}
+
+// sweep drops the per-IP buckets that are full again, they'd be recreated the
+// same. Caller holds l.mu.
+func (l *acceptLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < bucketSweepInterval {
+		return
+	}
+	l.swept = now
+	for ip, b := range l.byIP {
+		if b.refill(now, l.ipRate, l.ipBurst); b.tokens >= float64(l.ipBurst) {
+			delete(l.byIP, ip)
+		}
+	}
+}
+
+// guardedListener applies the accept rate and connection limits to connections
+// served by something other than handleClient, e.g. the HTTP server. Its own
+// loop accepts them; one waiting in the limiter's queue does so in a goroutine
+// of its own and is handed to the server once it has a slot, so the others
+// aren't held up behind it.
+type guardedListener struct {
+	net.Listener
+	admitted *handoffListener
+}
+
+func newGuardedListener(l net.Listener) *guardedListener {
+	g := &guardedListener{Listener: l, admitted: newHandoffListener(l.Addr())}
+	go g.acceptLoop()
+	return g
+}
+
+// acceptLoop accepts until the listener fails or is closed.
+func (l *guardedListener) acceptLoop() {
+	defer l.admitted.Close()
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return
+		}
+		ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		if !accepts.allow(ip) {
+			logger.Printf("Refused client %s: accept rate exceeded", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		workers.acquire()
+		go func() {
+			if err := limits.acquire(ip, qosClass(conn, "")); err != nil {
+				logger.Printf("Refused client %s: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				workers.release()
+				return
+			}
+			l.admitted.hand(&limitedConn{Conn: conn, ip: ip})
+		}()
+	}
+}
+
+func (l *guardedListener) Accept() (net.Conn, error) { return l.admitted.Accept() }
+
+func (l *guardedListener) Close() error {
+	l.admitted.Close()
+	return l.Listener.Close()
+}
+
+// limitedConn gives its slot back to the connection limiter, and its worker
+// back, when closed.
+type limitedConn struct {
+	net.Conn
+	ip   string
+	once sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(func() {
+		limits.release(c.ip)
+		workers.release()
+	})
+	return c.Conn.Close()
+}
+
+// workerLimit caps the goroutines serving client connections, so a flood of
+// connections can't spawn without bound. When every worker is busy the accept
+// loops wait for one, leaving new connections in the listen queue. A nil
+// *workerLimit doesn't limit.
+type workerLimit struct {
+	slots chan struct{}
+	full  atomic.Bool // logged that every worker is busy
+}
+
+// newWorkerLimit returns a limit of n workers, or nil if n is not positive.
+func newWorkerLimit(n int) *workerLimit {
+	if n <= 0 {
+		return nil
+	}
+	return &workerLimit{slots: make(chan struct{}, n)}
+}
+
+// acquire takes a worker, waiting for one if all are busy.
+func (w *workerLimit) acquire() {
+	if w == nil {
+		return
+	}
+	select {
+	case w.slots <- struct{}{}:
+		w.full.Store(false)
+		return
+	default:
+	}
+	if w.full.CompareAndSwap(false, true) {
+		logger.Printf("All %d workers busy, new connections wait to be accepted", cap(w.slots))
+	}
+	w.slots <- struct{}{}
+}
+
+// release gives a worker back.
+func (w *workerLimit) release() {
+	if w != nil {
+		<-w.slots
+	}
+}
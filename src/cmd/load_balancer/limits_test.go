@@ -0,0 +1,364 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// withLimits sets the connection limiter for the test.
+func withLimits(t *testing.T, l *connLimiter) {
+	t.Helper()
+	saved := limits
+	t.Cleanup(func() { limits = saved })
+	limits = l
+}
+
+// closedWithin reports whether the other end closes conn within d.
+func closedWithin(conn net.Conn, d time.Duration) bool {
+	conn.SetReadDeadline(time.Now().Add(d))
+	_, err := conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return false
+	}
+	return err != nil
+}
+
+func TestGuardedListenerDoesNotBlockOnQueue(t *testing.T) {
+	withLimits(t, newConnLimiter(1, 0, 1, 5*time.Second))
+	w := newWorkerLimit(3)
+	withWorkers(t, w)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newGuardedListener(inner)
+	defer l.Close()
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+
+	dial()
+	first, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial() // waits in the queue for the only slot
+	time.Sleep(20 * time.Millisecond)
+	// the queue is full: refused at once rather than left behind the waiting one
+	if third := dial(); !closedWithin(third, time.Second) {
+		t.Fatal("connection over a full queue not refused while another waited")
+	}
+
+	accepted := make(chan net.Conn)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+	select {
+	case <-accepted:
+		t.Fatal("queued connection served while the slot was taken")
+	case <-time.After(20 * time.Millisecond):
+	}
+	first.Close()
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(time.Second):
+		t.Fatal("queued connection not served once the slot freed up")
+	}
+
+	// each connection gives its worker back, the refused one too
+	freed := make(chan struct{})
+	go func() {
+		for range 3 {
+			w.acquire()
+		}
+		close(freed)
+	}()
+	select {
+	case <-freed:
+	case <-time.After(time.Second):
+		t.Fatal("workers not given back once their connections closed")
+	}
+}
+
+func TestOutranked(t *testing.T) {
+	l := newConnLimiter(1, 0, 1, time.Second)
+	if err := l.acquire("10.0.0.1", priorityNormal); err != nil {
+		t.Fatal(err)
+	}
+	if l.outranked(priorityNormal, priorityLow) {
+		t.Error("outranked with nobody waiting")
+	}
+	waited := make(chan error)
+	go func() { waited <- l.acquire("10.0.0.2", priorityNormal) }()
+	time.Sleep(20 * time.Millisecond)
+	if l.outranked(priorityNormal, priorityNormal) || l.outranked(priorityLow, priorityHigh) {
+		t.Error("outranked without being found lower")
+	}
+	if !l.outranked(priorityNormal, priorityLow) {
+		t.Fatal("a connection found low kept its slot from a normal one waiting")
+	}
+	l.release("10.0.0.1")
+	if err := <-waited; err != nil {
+		t.Errorf("waiting connection not handed the slot: %v", err)
+	}
+}
+
+func TestConnLimiterPerIP(t *testing.T) {
+	l := newConnLimiter(0, 2, 0, 0)
+	for range 2 {
+		if err := l.acquire("10.0.0.1", priorityNormal); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.acquire("10.0.0.1", priorityNormal); !errors.Is(err, errTooManyConns) {
+		t.Errorf("third connection from one IP: %v, want errTooManyConns", err)
+	}
+	if err := l.acquire("10.0.0.2", priorityNormal); err != nil {
+		t.Errorf("connection from another IP refused: %v", err)
+	}
+	l.release("10.0.0.1")
+	if err := l.acquire("10.0.0.1", priorityNormal); err != nil {
+		t.Errorf("connection refused after one closed: %v", err)
+	}
+	if newConnLimiter(0, 0, 10, time.Second) != nil {
+		t.Error("limiter without limits, want nil")
+	}
+}
+
+func TestConnLimiterQueue(t *testing.T) {
+	l := newConnLimiter(1, 1, 1, 30*time.Millisecond)
+	if err := l.acquire("10.0.0.1", priorityNormal); err != nil {
+		t.Fatal(err)
+	}
+
+	// over the total, a connection waits out the queue timeout
+	start := time.Now()
+	err := l.acquire("10.0.0.2", priorityNormal)
+	if !errors.Is(err, errTooManyConns) || time.Since(start) < 30*time.Millisecond {
+		t.Errorf("got %v after %v, want errTooManyConns after the 30ms queue timeout", err, time.Since(start))
+	}
+	// having given its per-IP count back
+	waited := make(chan error, 2)
+	go func() { waited <- l.acquire("10.0.0.2", priorityNormal) }()
+	time.Sleep(10 * time.Millisecond)
+
+	// the queue is full: refused at once
+	start = time.Now()
+	if err := l.acquire("10.0.0.3", priorityNormal); !errors.Is(err, errTooManyConns) || time.Since(start) > 10*time.Millisecond {
+		t.Errorf("got %v after %v, want errTooManyConns at once", err, time.Since(start))
+	}
+
+	// a closed connection hands its slot to the waiting one
+	l.release("10.0.0.1")
+	if err := <-waited; err != nil {
+		t.Errorf("waiting connection: %v, want the freed slot", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.open != 1 || l.queued != 0 {
+		t.Errorf("%d open, %d queued; want 1, 0", l.open, l.queued)
+	}
+}
+
+func TestConnLimiterPriorities(t *testing.T) {
+	l := newConnLimiter(1, 0, 2, time.Second)
+	if err := l.acquire("10.0.0.1", priorityNormal); err != nil {
+		t.Fatal(err)
+	}
+	var results [numPriorities]chan error
+	for prio := range results {
+		results[prio] = make(chan error, 1)
+	}
+	for _, prio := range []priority{priorityLow, priorityNormal} {
+		go func() { results[prio] <- l.acquire("10.0.0.2", prio) }()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the queue is full: a high priority connection sheds the low one
+	go func() { results[priorityHigh] <- l.acquire("10.0.0.3", priorityHigh) }()
+	if err := <-results[priorityLow]; !errors.Is(err, errShed) {
+		t.Errorf("low priority connection: %v, want errShed", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// and gets the next slot before the normal one that waited longer
+	l.release("10.0.0.1")
+	if err := <-results[priorityHigh]; err != nil {
+		t.Errorf("high priority connection: %v, want the freed slot", err)
+	}
+	select {
+	case err := <-results[priorityNormal]:
+		t.Errorf("normal priority connection done before a second slot freed up: %v", err)
+	default:
+	}
+	l.release("10.0.0.3")
+	if err := <-results[priorityNormal]; err != nil {
+		t.Errorf("normal priority connection: %v, want the freed slot", err)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(2, now)
+	if !b.take(now, 1, 2) || !b.take(now, 1, 2) {
+		t.Fatal("burst of 2 refused")
+	}
+	if b.take(now, 1, 2) {
+		t.Error("took a third token from a burst of 2")
+	}
+	// at a token a second, half a second refills half of one
+	now = now.Add(500 * time.Millisecond)
+	if b.take(now, 1, 2) {
+		t.Error("took a token after half a second at 1/s")
+	}
+	now = now.Add(500 * time.Millisecond)
+	if !b.take(now, 1, 2) {
+		t.Error("no token after a second at 1/s")
+	}
+	// refills no further than the burst
+	now = now.Add(time.Hour)
+	taken := 0
+	for b.take(now, 1, 2) {
+		taken++
+	}
+	if taken != 2 {
+		t.Errorf("took %d tokens after an hour, want the burst of 2", taken)
+	}
+	// reserving beyond what's there goes into debt, paid off at the rate
+	if wait := b.reserve(now, 10, 2, 5); wait != 500*time.Millisecond {
+		t.Errorf("reserving 5 tokens with none at 10/s waits %v, want 500ms", wait)
+	}
+}
+
+func TestAcceptLimiter(t *testing.T) {
+	if newAcceptLimiter(0, 10, 0, 10) != nil {
+		t.Error("limiter without rates, want nil")
+	}
+	var unlimited *acceptLimiter
+	if !unlimited.allow("10.0.0.1") {
+		t.Error("no limiter refused a connection")
+	}
+
+	l := newAcceptLimiter(0, 0, 1, 2) // per IP: 1/s, bursts of 2
+	for range 2 {
+		if !l.allow("10.0.0.1") {
+			t.Fatal("burst of 2 refused")
+		}
+	}
+	if l.allow("10.0.0.1") {
+		t.Error("third connection from one IP within the burst allowed")
+	}
+	if !l.allow("10.0.0.2") {
+		t.Error("another IP refused")
+	}
+
+	g := newAcceptLimiter(1, 0, 0, 0) // in total: 1/s, burst defaults to a second's worth
+	if !g.allow("10.0.0.1") || g.allow("10.0.0.2") {
+		t.Error("total rate of 1/s, want 1 connection at once")
+	}
+}
+
+// withWorkers sets the worker limit for the test.
+func withWorkers(t *testing.T, w *workerLimit) {
+	t.Helper()
+	saved := workers
+	t.Cleanup(func() { workers = saved })
+	workers = w
+}
+
+func TestWorkerLimit(t *testing.T) {
+	var unlimited *workerLimit
+	if newWorkerLimit(0) != nil {
+		t.Fatal("limit of 0 workers, want none")
+	}
+	for range 3 {
+		unlimited.acquire()
+	}
+	unlimited.release()
+
+	w := newWorkerLimit(2)
+	w.acquire()
+	w.acquire()
+	got := make(chan struct{})
+	go func() {
+		w.acquire()
+		close(got)
+	}()
+	select {
+	case <-got:
+		t.Fatal("worker taken with every worker busy")
+	case <-time.After(50 * time.Millisecond):
+	}
+	w.release()
+	select {
+	case <-got:
+	case <-time.After(time.Second):
+		t.Fatal("worker given back not taken by the one waiting")
+	}
+}
+
+func TestWorkerLimitBlocksAccept(t *testing.T) {
+	withWorkers(t, newWorkerLimit(1))
+	withLimits(t, newConnLimiter(0, 5, 0, 0))
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newGuardedListener(inner)
+	defer l.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	for range 2 {
+		c, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+
+	first := <-accepted
+	select {
+	case <-accepted:
+		t.Fatal("second connection accepted with the only worker busy")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// closing the first gives its worker back, once however often it's closed
+	first.Close()
+	first.Close()
+	var second net.Conn
+	select {
+	case second = <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("second connection not accepted once a worker was free")
+	}
+	if n := len(workers.slots); n != 1 {
+		t.Errorf("%d workers busy serving one connection, want 1", n)
+	}
+	limits.mu.Lock()
+	n := limits.byIP["127.0.0.1"]
+	limits.mu.Unlock()
+	if n != 1 {
+		t.Errorf("%d connections counted for one open, want 1", n)
+	}
+	second.Close()
+	if n := len(workers.slots); n != 0 {
+		t.Errorf("%d workers busy with every connection closed", n)
+	}
+}
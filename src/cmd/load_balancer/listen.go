@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// ---------------- Listeners ---------------- //
+
+// listenAddrs returns the addresses to listen on for port: every address of
+// both families (dual-stack) unless bind, a comma-separated list of hosts or
+// IPs, names them, e.g. "0.0.0.0,::" for separate IPv4 and IPv6 sockets.
+func listenAddrs(bind string, port int) string {
+	if bind == "" {
+		return ":" + strconv.Itoa(port)
+	}
+	var addrs []string
+	for _, host := range strings.Split(bind, ",") {
+		host = strings.Trim(strings.TrimSpace(host), "[]")
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	return strings.Join(addrs, ",")
+}
+
+// reusePort is the number of listening sockets opened per TCP address with
+// SO_REUSEPORT, each with its own accept loop, see -reuseport. 0 or 1 for one
+// plain socket.
+var reusePort int
+
+// listen opens the listeners clients connect to, on addr: a TCP address, several
+// of them comma-separated, or unix:///path for a unix socket. Each listener gets
+// an accept loop of its own. Sockets handed over by an upgrade or by systemd are
+// taken over instead of opened.
+func listen(addr string) ([]net.Listener, error) {
+	var ls []net.Listener
+	for _, a := range strings.Split(addr, ",") {
+		a = strings.TrimSpace(a)
+		key := a
+		if _, ok := inherited[key]; !ok {
+			key = boundAddr(a)
+		}
+		opened, ok := inherited[key]
+		delete(inherited, key)
+		if !ok {
+			var err error
+			if opened, err = listenOne(a); err == nil && backlog > 0 {
+				for _, l := range opened {
+					if err = setBacklog(l, backlog); err != nil {
+						break
+					}
+				}
+			}
+			if err != nil {
+				for _, l := range append(ls, opened...) {
+					l.Close()
+				}
+				return nil, err
+			}
+		}
+		for _, l := range opened {
+			sockets = append(sockets, socket{a, l})
+		}
+		ls = append(ls, opened...)
+	}
+	return ls, nil
+}
+
+// listenOne opens the listeners of a single address: one, or with -reuseport
+// that many sockets sharing a TCP address. An IPv4 or IPv6 literal gets sockets
+// of its family only, so 0.0.0.0:80 and [::]:80 can be bound side by side; a
+// host name or an empty host listens on both families. A unix socket file left
+// behind by a run that didn't shut down cleanly is removed first; one still
+// being served is an error.
+func listenOne(addr string) ([]net.Listener, error) {
+	network, address := load_balancer.SplitNetwork(addr)
+	if network == "unix" {
+		if conn, err := net.Dial(network, address); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use", address)
+		}
+		if fi, err := os.Lstat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+		l, err := net.Listen(network, address)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		if ip, err := netip.ParseAddr(host); err == nil {
+			network = "tcp6"
+			if ip.Is4() {
+				network = "tcp4"
+			}
+		}
+	}
+	if reusePort <= 1 {
+		l, err := net.Listen(network, address)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+	lc := net.ListenConfig{Control: setReusePort}
+	ls := make([]net.Listener, 0, reusePort)
+	for range reusePort {
+		l, err := lc.Listen(context.Background(), network, address)
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return nil, err
+		}
+		ls = append(ls, l)
+		address = ls[0].Addr().String() // with port 0, the others share the port the first got
+	}
+	return ls, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ---------------- Log files ---------------- //
+
+// rotation of log files, see -log-max-size, -log-max-age and -log-keep; zero
+// is no limit
+var (
+	logMaxSize int64
+	logMaxAge  time.Duration
+	logKeep    int
+)
+
+// logFiles are the log files open, reopened on SIGUSR1
+var (
+	logFilesMu sync.Mutex
+	logFiles   []*logFile
+)
+
+// logFile is a log file that rotates itself once it grows past logMaxSize or
+// has been written to for logMaxAge, and that can be reopened after something
+// else moved it, e.g. logrotate.
+type logFile struct {
+	path   string
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+// checkLogFile reports whether path could be opened as a log file, without
+// creating it: its directory has to exist.
+func checkLogFile(path string) error {
+	dir := filepath.Dir(path)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+// openLogFile opens path for appending, creating it if needed.
+func openLogFile(path string) (*logFile, error) {
+	l := &logFile{path: path}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	logFilesMu.Lock()
+	logFiles = append(logFiles, l)
+	logFilesMu.Unlock()
+	return l, nil
+}
+
+func (l *logFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size, l.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size > 0 && (logMaxSize > 0 && l.size+int64(len(p)) > logMaxSize || logMaxAge > 0 && time.Since(l.opened) >= logMaxAge) {
+		if err := l.rotate(); err != nil {
+			// not to logger, which may write to this file; keep writing
+			// to the file we have rather than lose lines
+			os.Stderr.WriteString("ERROR rotating " + l.path + ": " + err.Error() + "\n")
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file aside with the time as suffix, e.g.
+// access.log.20261016-170311.000, starts a new one and removes the oldest
+// rotated files past logKeep.
+func (l *logFile) rotate() error {
+	if err := os.Rename(l.path, l.path+"."+time.Now().Format("20060102-150405.000")); err != nil {
+		return err
+	}
+	old := l.f
+	if err := l.open(); err != nil {
+		return err
+	}
+	old.Close()
+	if logKeep > 0 {
+		rotated, _ := filepath.Glob(l.path + ".[0-9]*")
+		slices.Sort(rotated) // oldest first
+		for _, name := range rotated[:max(len(rotated)-logKeep, 0)] {
+			os.Remove(name)
+		}
+	}
+	return nil
+}
+
+// reopen opens the file at the path again, for when it was moved away.
+func (l *logFile) reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	old := l.f
+	if err := l.open(); err != nil {
+		return err
+	}
+	old.Close()
+	return nil
+}
+
+// reopenLogs reopens every log file, on SIGUSR1.
+func reopenLogs() {
+	logFilesMu.Lock()
+	defer logFilesMu.Unlock()
+	for _, l := range logFiles {
+		if err := l.reopen(); err != nil {
+			logger.Printf("ERROR reopening %s: %v", l.path, err)
+		}
+	}
+}
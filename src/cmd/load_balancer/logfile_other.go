@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// ---------------- Log files ---------------- //
+
+// notifyReopen does nothing: there is no SIGUSR1 outside Unix. Log files still
+// rotate by size and age.
+func notifyReopen(c chan<- os.Signal) {}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// withLogRotation sets the rotation limits for the test and forgets the log
+// files it opens.
+func withLogRotation(t *testing.T, maxSize int64, maxAge time.Duration, keep int) {
+	t.Helper()
+	savedSize, savedAge, savedKeep := logMaxSize, logMaxAge, logKeep
+	logFilesMu.Lock()
+	savedFiles := logFiles
+	logFilesMu.Unlock()
+	t.Cleanup(func() {
+		logMaxSize, logMaxAge, logKeep = savedSize, savedAge, savedKeep
+		logFilesMu.Lock()
+		for _, l := range logFiles[len(savedFiles):] {
+			l.f.Close()
+		}
+		logFiles = savedFiles
+		logFilesMu.Unlock()
+	})
+	logMaxSize, logMaxAge, logKeep = maxSize, maxAge, keep
+}
+
+// rotatedFiles returns the contents of the files path was rotated to, oldest first.
+func rotatedFiles(t *testing.T, path string) []string {
+	t.Helper()
+	names, err := filepath.Glob(path + ".[0-9]*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, name := range names { // sorted, the oldest first
+		out = append(out, readFile(t, name))
+	}
+	return out
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// writeLines writes each line to l, a few milliseconds apart so files rotated
+// from one to the next have names of their own.
+func writeLines(t *testing.T, l *logFile, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		time.Sleep(2 * time.Millisecond)
+		if _, err := l.Write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLogFileRotatesBySize(t *testing.T) {
+	withLogRotation(t, 10, 0, 2)
+	path := filepath.Join(t.TempDir(), "lb.log")
+	l, err := openLogFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a line past the limit goes to a new file, one alone is never split
+	writeLines(t, l, "aaaa", "bbbb", "cccc", "a line longer than the limit", "dddd", "eeee")
+	if got, want := readFile(t, path), "dddd\neeee\n"; got != want {
+		t.Errorf("log file %q, want %q", got, want)
+	}
+	// of "aaaa\nbbbb\n", "cccc\n" and the long line, the oldest is removed
+	if got, want := rotatedFiles(t, path), []string{"cccc\n", "a line longer than the limit\n"}; !slices.Equal(got, want) {
+		t.Errorf("rotated files %q, want the last %d: %q", got, logKeep, want)
+	}
+}
+
+func TestLogFileRotatesByAge(t *testing.T) {
+	withLogRotation(t, 0, 50*time.Millisecond, 0)
+	path := filepath.Join(t.TempDir(), "lb.log")
+	if err := os.WriteFile(path, []byte("before\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := openLogFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, l, "one", "two")
+	time.Sleep(60 * time.Millisecond)
+	writeLines(t, l, "three")
+	if got, want := readFile(t, path), "three\n"; got != want {
+		t.Errorf("log file %q, want %q", got, want)
+	}
+	// appended to what was there; with no -log-keep, every rotated file is kept
+	if got, want := rotatedFiles(t, path), []string{"before\none\ntwo\n"}; !slices.Equal(got, want) {
+		t.Errorf("rotated files %q, want %q", got, want)
+	}
+}
+
+func TestLogFileReopen(t *testing.T) {
+	withLogRotation(t, 0, 0, 0)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "lb.log")
+	l, err := openLogFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, l, "one")
+	// moved away, as logrotate does, lines still go to the moved file until
+	// the logs are reopened
+	moved := filepath.Join(dir, "lb.log.1")
+	if err := os.Rename(path, moved); err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, l, "two")
+	reopenLogs()
+	writeLines(t, l, "three")
+	if got, want := readFile(t, moved), "one\ntwo\n"; got != want {
+		t.Errorf("moved file %q, want %q", got, want)
+	}
+	if got, want := readFile(t, path), "three\n"; got != want {
+		t.Errorf("reopened file %q, want %q", got, want)
+	}
+}
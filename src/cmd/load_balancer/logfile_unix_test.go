@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNotifyReopen(t *testing.T) {
+	c := make(chan os.Signal, 1)
+	notifyReopen(c)
+	defer signal.Stop(c)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case sig := <-c:
+		if sig != syscall.SIGUSR1 {
+			t.Errorf("got %v, want SIGUSR1", sig)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("SIGUSR1 not relayed")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------- Syslog and journald ---------------- //
+
+// name the balancer logs under
+const logTag = "load_balancer"
+
+// syslog severities, RFC 5424 section 6.2.1
+const (
+	severityErr     = 3
+	severityWarning = 4
+	severityInfo    = 6
+)
+
+// facility of the balancer's syslog messages: daemon
+const syslogFacility = 3
+
+// local syslog sockets, the first one found is used
+var localSyslogPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// socket of journald's native protocol
+const journalSocket = "/run/systemd/journal/socket"
+
+// isLogSink reports whether v names a log sink rather than a file, see
+// openLogSink.
+func isLogSink(v string) bool {
+	return v == "syslog" || v == "journald" || strings.HasPrefix(v, "syslog://") || strings.HasPrefix(v, "syslog+tcp://")
+}
+
+// openLogSink opens the sink v names: syslog for the local syslog daemon,
+// syslog://host:514 (UDP) or syslog+tcp://host:601 for a remote one, or
+// journald. Each write is a message, whose severity is given by severity;
+// msgID, e.g. access, tells the messages of a log apart, "" for none. It
+// connects on the first write, so opening one only checks v.
+func openLogSink(v, msgID string, severity func(msg []byte) int) (io.Writer, error) {
+	s := &logSink{severity: severity}
+	switch {
+	case v == "journald":
+		s.network, s.addr, s.format = "unixgram", journalSocket, journalFormat(msgID)
+		return s, nil
+	case v == "syslog":
+		for _, path := range localSyslogPaths {
+			if _, err := os.Stat(path); err == nil {
+				s.network, s.addr = "unixgram", path
+				break
+			}
+		}
+		if s.addr == "" {
+			return nil, errors.New("no local syslog socket found")
+		}
+	case strings.HasPrefix(v, "syslog://"):
+		s.network, s.addr = "udp", strings.TrimPrefix(v, "syslog://")
+	case strings.HasPrefix(v, "syslog+tcp://"):
+		s.network, s.addr = "tcp", strings.TrimPrefix(v, "syslog+tcp://")
+	default:
+		return nil, fmt.Errorf("invalid log sink %q, want syslog, syslog://host:port, syslog+tcp://host:port or journald", v)
+	}
+	if _, _, err := net.SplitHostPort(s.addr); s.network != "unixgram" && err != nil {
+		return nil, fmt.Errorf("invalid log sink %q: %v", v, err)
+	}
+	s.format = syslogFormat(msgID, s.network == "tcp")
+	return s, nil
+}
+
+// logSink sends each write as a message over a connection it dials when
+// needed, and redials once a write fails.
+type logSink struct {
+	network, addr string
+	severity      func(msg []byte) int
+	format        func(severity int, msg []byte) []byte
+	mu            sync.Mutex
+	conn          net.Conn
+}
+
+// syslogFormat formats messages as RFC 5424, framed by octet counting (RFC
+// 6587) for TCP.
+func syslogFormat(msgID string, framed bool) func(severity int, msg []byte) []byte {
+	hostname, _ := os.Hostname()
+	header := " " + syslogNil(hostname) + " " + logTag + " " + strconv.Itoa(os.Getpid()) + " " + syslogNil(msgID) + " - "
+	return func(severity int, msg []byte) []byte {
+		// RFC 5424: <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+		b := fmt.Appendf(nil, "<%d>1 %s%s", syslogFacility*8+severity, time.Now().Format("2006-01-02T15:04:05.000000Z07:00"), header)
+		b = append(b, msg...)
+		if framed {
+			b = append(strconv.AppendInt(nil, int64(len(b)), 10), append([]byte{' '}, b...)...)
+		}
+		return b
+	}
+}
+
+// syslogNil returns v, or the syslog nil value "-" if it is empty.
+func syslogNil(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// journalFormat formats messages for journald's native protocol, with msgID
+// in the LB_LOG field.
+func journalFormat(msgID string) func(severity int, msg []byte) []byte {
+	return func(severity int, msg []byte) []byte {
+		b := fmt.Appendf(nil, "PRIORITY=%d\nSYSLOG_FACILITY=%d\nSYSLOG_IDENTIFIER=%s\n", severity, syslogFacility, logTag)
+		if msgID != "" {
+			b = fmt.Appendf(b, "LB_LOG=%s\n", msgID)
+		}
+		if bytes.IndexByte(msg, '\n') < 0 {
+			b = append(append(append(b, "MESSAGE="...), msg...), '\n')
+		} else { // a value with newlines goes with its length
+			b = append(b, "MESSAGE\n"...)
+			b = binary.LittleEndian.AppendUint64(b, uint64(len(msg)))
+			b = append(append(b, msg...), '\n')
+		}
+		return b
+	}
+}
+
+func (s *logSink) Write(p []byte) (int, error) {
+	msg := bytes.TrimRight(p, "\n")
+	b := s.format(s.severity(msg), msg)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for try := 0; ; try++ {
+		if s.conn == nil {
+			conn, err := net.DialTimeout(s.network, s.addr, 5*time.Second)
+			if err != nil {
+				return 0, err
+			}
+			s.conn = conn
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		_, err := s.conn.Write(b)
+		if err == nil {
+			return len(p), nil
+		}
+		s.conn.Close()
+		s.conn = nil
+		if try > 0 {
+			return 0, err
+		}
+	}
+}
+
+// logSeverity maps a log line to its syslog severity: ERROR lines are errors,
+// other lines reporting an error warnings and the rest informational.
+func logSeverity(msg []byte) int {
+	switch {
+	case bytes.HasPrefix(msg, []byte("ERROR")):
+		return severityErr
+	case bytes.Contains(msg, []byte(" error")):
+		return severityWarning
+	}
+	return severityInfo
+}
+
+// accessSeverity is the severity of access log lines: informational.
+func accessSeverity([]byte) int { return severityInfo }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// syslogTime matches the timestamp of a message, microseconds and zone
+var syslogTime = regexp.MustCompile(`^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}(Z|[+-]\d\d:\d\d)$`)
+
+// splitSyslog returns a message without its timestamp, checking its form.
+func splitSyslog(t *testing.T, msg string) string {
+	t.Helper()
+	pri, rest, _ := strings.Cut(msg, " ")
+	ts, rest, _ := strings.Cut(rest, " ")
+	if !syslogTime.MatchString(ts) {
+		t.Errorf("timestamp %q in %q, want RFC 3339 with microseconds", ts, msg)
+	}
+	return pri + " " + rest
+}
+
+func TestSyslogFormat(t *testing.T) {
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		msgID    string
+		severity int
+		msg      string
+		want     string // without the timestamp
+	}{
+		// daemon is facility 3: PRI is 3*8 + severity
+		{"", severityInfo, "Listening on :8080", "<30>1 " + hostname + " load_balancer " + pid + " - - Listening on :8080"},
+		{"", severityErr, "ERROR backend down", "<27>1 " + hostname + " load_balancer " + pid + " - - ERROR backend down"},
+		{"access", severityWarning, "GET / 200", "<28>1 " + hostname + " load_balancer " + pid + " access - GET / 200"},
+	}
+	for _, tt := range tests {
+		got := string(syslogFormat(tt.msgID, false)(tt.severity, []byte(tt.msg)))
+		if got := splitSyslog(t, got); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+
+		// over TCP, the message is framed by its length
+		framed := string(syslogFormat(tt.msgID, true)(tt.severity, []byte(tt.msg)))
+		n, msg, _ := strings.Cut(framed, " ")
+		if strconv.Itoa(len(msg)) != n {
+			t.Errorf("framed as %q, a length of %s for %d bytes", framed, n, len(msg))
+		}
+		if got := splitSyslog(t, msg); got != tt.want {
+			t.Errorf("framed: got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestJournalFormat(t *testing.T) {
+	tests := []struct {
+		msgID    string
+		severity int
+		msg      string
+		want     string
+	}{
+		{"", severityInfo, "Listening on :8080",
+			"PRIORITY=6\nSYSLOG_FACILITY=3\nSYSLOG_IDENTIFIER=load_balancer\nMESSAGE=Listening on :8080\n"},
+		{"access", severityErr, "GET / 502",
+			"PRIORITY=3\nSYSLOG_FACILITY=3\nSYSLOG_IDENTIFIER=load_balancer\nLB_LOG=access\nMESSAGE=GET / 502\n"},
+		// a message with a newline goes as MESSAGE, its length in 64-bit little endian, then itself
+		{"", severityWarning, "panic:\ngoroutine 1",
+			"PRIORITY=4\nSYSLOG_FACILITY=3\nSYSLOG_IDENTIFIER=load_balancer\nMESSAGE\n\x12\x00\x00\x00\x00\x00\x00\x00panic:\ngoroutine 1\n"},
+	}
+	for _, tt := range tests {
+		if got := string(journalFormat(tt.msgID)(tt.severity, []byte(tt.msg))); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestLogSeverity(t *testing.T) {
+	for _, tt := range []struct {
+		msg  string
+		want int
+	}{
+		{"ERROR connecting to backend 10.0.0.1:8000: refused", severityErr},
+		{"Client 10.0.0.9 closed with error reset", severityWarning},
+		{"Backend 10.0.0.1:8000 is healthy", severityInfo},
+		{"Errors today: 0", severityInfo},
+	} {
+		if got := logSeverity([]byte(tt.msg)); got != tt.want {
+			t.Errorf("logSeverity(%q) = %d, want %d", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestLogSinkRemote(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+
+	// a message per write, without its line break
+	w, err := openLogSink("syslog://"+udp.LocalAddr().String(), "", logSeverity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"ERROR one\n", "two\n"} {
+		if _, err := fmt.Fprint(w, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := make([]byte, 1024)
+	for _, want := range []string{"<27>1", "<30>1"} {
+		udp.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := udp.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pri, _, _ := strings.Cut(string(buf[:n]), " "); pri != want || buf[n-1] == '\n' {
+			t.Errorf("UDP message %q, want %s and no line break", buf[:n], want)
+		}
+	}
+
+	// over TCP, messages are framed, one after the other on a connection
+	w, err = openLogSink("syslog+tcp://"+tcp.Addr().String(), "access", accessSeverity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		fmt.Fprint(w, "GET / 200\n")
+		fmt.Fprint(w, "GET /a 404\n")
+	}()
+	conn, err := tcp.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, want := range []string{"GET / 200", "GET /a 404"} {
+		length, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := strconv.Atoi(length[:len(length)-1])
+		if err != nil {
+			t.Fatalf("frame length %q: %v", length, err)
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatal(err)
+		}
+		if got := string(msg); !regexp.MustCompile(`^<30>1 \S+ \S+ load_balancer \d+ access - `+regexp.QuoteMeta(want)+`$`).MatchString(got) {
+			t.Errorf("TCP message %q, want %q from the access log", got, want)
+		}
+	}
+}
@@ -1,17 +1,28 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
+	"Load-Balancer/pkg/discovery"
+	"Load-Balancer/pkg/geoip"
 	"Load-Balancer/pkg/load_balancer"
+	"Load-Balancer/pkg/metrics"
+	"Load-Balancer/pkg/proxyproto"
+	"Load-Balancer/pkg/sni"
+	"Load-Balancer/pkg/tracing"
 )
 
 // ---------------- Proxy and main ---------------- //
@@ -19,132 +30,1376 @@ import (
 var (
 	activeWG sync.WaitGroup
 	logger   = log.New(os.Stdout, "", log.LstdFlags)
+	tries    = 2                          // backends tried per client connection before giving up
+	breaker  *load_balancer.Breaker       // nil unless -breaker-error-rate is set
+	checker  *load_balancer.HealthChecker // nil unless -health-check is set
+	backoff  *load_balancer.Backoff       // nil unless -backoff is set
+	limits   *connLimiter                 // nil unless -max-clients or -max-clients-per-ip is set
+	accepts  *acceptLimiter               // nil unless -accept-rate or -accept-rate-per-ip is set
+	workers  *workerLimit                 // nil unless -max-workers is set
 )
 
-// handle single client connection: pick backend, proxy bidirectionally, update policy when done
-func handleClient(conn net.Conn, policy load_balancer.Policy) {
+// source is a discovery provider and the pause between its lookups
+type source struct {
+	discovery.Provider
+	interval time.Duration
+}
+
+// Consul, etcd and backends file lookups block until something changes; this only spaces out retries
+const watchRetry = time.Second
+
+// every connection to a backend goes through dialer, see -dial-timeout
+var dialer = &net.Dialer{Timeout: 5 * time.Second}
+
+// route TLS connections by the server name in their ClientHello, without
+// terminating them, see -sni-passthrough
+var sniPassthrough bool
+
+// PROXY protocol version announced to backends, 0 for none; see -proxy-protocol
+var proxyProtocol int
+
+// connect to backends from the client's address instead of the balancer's, see
+// -transparent
+var transparent bool
+
+// closeWriter is a connection that can be half-closed, e.g. *net.TCPConn or *tls.Conn
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// how often an unhealthy backend is re-dialed
+var recheckInterval = 2 * time.Second
+
+// handle single client connection: pick backend, proxy bidirectionally, update policy when done.
+// With bySNI the backend is picked by the TLS server name, see -sni-passthrough.
+func handleClient(conn net.Conn, policy load_balancer.Policy, bySNI bool) {
 	defer conn.Close()
 	activeWG.Add(1)
 	defer activeWG.Done()
+	defer countClient("tcp")()
+	accepted := time.Now()
 
 	remoteAddr := conn.RemoteAddr().String()
+	id := newConnID()
+	clog := connLog(id)
+	ctx := context.Background()
+	serverName := "" // asked for in the TLS handshake, if known
+	// counted before the handshake, so slow ones can't hold descriptors uncounted
+	clientIP, _, _ := net.SplitHostPort(remoteAddr)
+	class := qosClass(conn, "")
+	if err := limits.acquire(clientIP, class); err != nil {
+		clog.Printf("Refused client %s: %v", remoteAddr, err)
+		return
+	}
+	defer limits.release(clientIP)
+	// finish the handshake first, backends may be told about the client's certificate
+	if tc, ok := conn.(*tls.Conn); ok {
+		hctx, cancel := context.WithTimeout(ctx, clientHandshakeTimeout)
+		err := tc.HandshakeContext(hctx)
+		cancel()
+		if err != nil {
+			clog.Printf("ERROR TLS handshake with client %s: %v error=%s", remoteAddr, err, countError(kindTLSHandshake, ""))
+			return
+		}
+		serverName = tc.ConnectionState().ServerName
+	}
+	ja3 := connJA3(conn)
+	if bySNI {
+		hello, peeked, err := sni.PeekHello(conn, clientHandshakeTimeout)
+		if err != nil {
+			clog.Printf("ERROR reading TLS server name from client %s: %v", remoteAddr, err)
+			return
+		}
+		conn, policy, ja3, serverName = peeked, hostRoutes.match(hello.ServerName, policy), hello.JA3, hello.ServerName
+		if ja3Deny[ja3] {
+			clog.Printf("Refused client %s: JA3 fingerprint %s denied", remoteAddr, ja3)
+			return
+		}
+	}
+	if routed, ok := ja3Route(ja3); ok {
+		policy = routed
+	}
+	policy.Events().OnAccept(remoteAddr)
+	// classed again once the server name is known, -qos-class may go by it
+	if serverName != "" && limits.outranked(class, qosClass(conn, serverName)) {
+		clog.Printf("Refused client %s: %v", remoteAddr, errShed)
+		return
+	}
+	access := &accessEntry{start: accepted, id: id, client: remoteAddr, mode: "tcp", sni: serverName, ja3: ja3}
+	if accessLog != nil {
+		defer accessLog.log(access)
+	}
+	var span *tracing.Span
+	if tracer != nil {
+		span = tracer.Start("proxy "+access.mode, tracing.KindServer, tracing.SpanContext{})
+		span.Start = accepted
+		defer finishConnSpan(span, access)
+	}
+	picked := time.Now()
+	explain := explainFor(remoteAddr, clog)
+	candidates, err := policy.SelectServers(ctx, load_balancer.ConnInfo{ClientAddr: remoteAddr, Explain: explain.decisions()}, tries)
+	if err != nil {
+		access.err = err
+		noBackend(clog, conn, err.Error())
+		return
+	}
 
-	backend := policy.SelectServer()
-	logger.Printf("Selected backend %s for client %s", backend, remoteAddr)
+	candidates = allowed(candidates, policy, explain)
+	if len(candidates) == 0 {
+		access.err = errors.New("all selected backends are backing off or have open circuits")
+		noBackend(clog, conn, access.err.Error())
+		return
+	}
 
-	backendConn, err := net.Dial("tcp", backend)
+	backend, backendConn, start, err := dialFirst(ctx, id, candidates, policy, conn)
 	if err != nil {
-		logger.Printf("ERROR connecting to backend %s: %v", backend, err)
-		// If policy is LeastConnections we should decrement because selection incremented; Update handles decrement semantics
-		policy.Update(backend)
+		access.err = err
+		noBackend(clog, conn, "no backend reachable")
 		return
 	}
+	access.backend = backend
 	defer backendConn.Close()
-	logger.Printf("Proxying %s <-> %s", remoteAddr, backend)
+	proxyLatency.With("tcp").ObserveWithExemplar(time.Since(picked).Seconds(), traceExemplar(span))
+	latencies.add(time.Since(picked))
+	if ja3 != "" {
+		clog.Printf("Proxying %s ja3=%s <-> %s", clientLabel(remoteAddr), ja3, backend)
+	} else {
+		clog.Printf("Proxying %s <-> %s", clientLabel(remoteAddr), backend)
+	}
+	entry := proxied{client: conn, backend: backendConn}
+	info := newConnInfo(id, remoteAddr, backend, "tcp", accepted)
+	info.sni = serverName
+	openConns.add(entry, info)
+	defer openConns.remove(backend, entry)
+	idle := watchIdle(entry, idleTimeout)
+	lifetime := watchLifetime(entry, maxLifetime)
 
 	// proxy bidirectionally, track when both sides complete
 	var wg sync.WaitGroup
 	wg.Add(2)
+	var sent, received int64
+	var sendErr, recvErr error
 
 	// client -> backend
 	go func() {
 		defer wg.Done()
-		_, err := io.Copy(backendConn, conn)
-		if err != nil {
-			logger.Printf("Copy client->backend error: %v", err)
+		sent, sendErr = relay(backendConn, conn, idle, &info.sent)
+		info.halfClosed.Store(true)
+		if sendErr != nil && !idle.closed() && !lifetime.ended() && !info.killed.Load() {
+			clog.Printf("Copy client->backend %s error: %v error=%s", backend, sendErr, countError(copyKind(sendErr, kindClientReset, kindBackendReset), backend))
 		}
 		// close write to backend so it knows EOF
-		if tcp, ok := backendConn.(*net.TCPConn); ok {
-			_ = tcp.CloseWrite()
+		if cw, ok := backendConn.(closeWriter); ok {
+			_ = cw.CloseWrite()
 		}
 	}()
 
 	// backend -> client
 	go func() {
 		defer wg.Done()
-		_, err := io.Copy(conn, backendConn)
-		if err != nil {
-			logger.Printf("Copy backend->client error: %v", err)
+		received, recvErr = relay(conn, backendConn, idle, &info.received)
+		info.halfClosed.Store(true)
+		if recvErr != nil && !idle.closed() && !lifetime.ended() && !info.killed.Load() {
+			clog.Printf("Copy backend %s->client error: %v error=%s", backend, recvErr, countError(copyKind(recvErr, kindBackendReset, kindClientReset), backend))
 		}
 		// close write to client
-		if tcp, ok := conn.(*net.TCPConn); ok {
-			_ = tcp.CloseWrite()
+		if cw, ok := conn.(closeWriter); ok {
+			_ = cw.CloseWrite()
 		}
 	}()
 
 	wg.Wait()
+	idle.stop()
+	lifetime.stop()
+	// closing it ourselves isn't the backend's fault
+	switch {
+	case idle.closed():
+		sendErr, recvErr = nil, nil
+		clog.Printf("Closed connection of client %s via backend %s after %v idle", remoteAddr, backend, idleTimeout)
+	case lifetime.ended():
+		sendErr, recvErr = nil, nil
+		clog.Printf("Ended connection of client %s via backend %s after its %v lifetime", remoteAddr, backend, maxLifetime)
+	case info.killed.Load():
+		sendErr, recvErr = nil, nil
+		clog.Printf("Closed connection of client %s via backend %s through the admin API", remoteAddr, backend)
+	}
 
 	// connection finished; update policy (decrement counters / measure RTT)
-	policy.Update(backend)
-	logger.Printf("Connection finished for client %s via backend %s", remoteAddr, backend)
+	result := load_balancer.Result{
+		Bytes:      sent + received,
+		Duration:   time.Since(start),
+		Sent:       sent,
+		Received:   received,
+		SendFailed: sendErr != nil,
+		RecvFailed: recvErr != nil,
+	}
+	if recvErr != nil {
+		result.Err = recvErr
+	} else if sendErr != nil {
+		result.Err = sendErr
+	}
+	policy.Update(backend, result)
+	breaker.Record(backend, result)
+	connDuration.With(backend).ObserveWithExemplar(result.Duration.Seconds(), traceExemplar(span))
+	access.in, access.out, access.err = sent, received, result.Err
+	clog.Printf("Connection finished for client %s via backend %s", remoteAddr, backend)
+}
+
+// allowed drops the candidates that are backing off after failed dials or whose
+// circuit is open, releasing them.
+func allowed(candidates []string, policy load_balancer.Policy, explain *explainer) []string {
+	out := candidates[:0]
+	for _, backend := range candidates {
+		switch {
+		case !backoff.Ready(backend):
+			explain.skipped(backend, "backing off after failed dials")
+		case !breaker.Allow(backend):
+			explain.skipped(backend, "its circuit is open")
+		default:
+			out = append(out, backend)
+			continue
+		}
+		policy.Release(backend)
+	}
+	return out
+}
+
+// dialFirst connects to the first reachable candidate, best first, for the
+// connection id of client. Failed candidates are reported to the policy and
+// marked unhealthy, untried ones are released.
+func dialFirst(ctx context.Context, id string, candidates []string, policy load_balancer.Policy, client net.Conn) (string, net.Conn, time.Time, error) {
+	clog := connLog(id)
+	var err error
+	for i, backend := range candidates {
+		clog.Printf("Selected backend %s for client %s", backend, client.RemoteAddr())
+		start := time.Now()
+		var conn net.Conn
+		conn, err = dialBackend(ctx, backend, client, id)
+		policy.Events().OnBackendDial(backend, time.Since(start), err)
+		if err == nil {
+			backoff.Succeeded(backend)
+			for _, rest := range candidates[i+1:] {
+				policy.Release(rest)
+				breaker.Cancel(rest)
+			}
+			return backend, conn, start, nil
+		}
+		clog.Printf("ERROR connecting to backend %s: %v error=%s", backend, err, countError(dialKind(err), backend))
+		dialErrors.With(backend).Inc()
+		if wait := backoff.Failed(backend); wait > 0 {
+			clog.Printf("Backing off backend %s for %v", backend, wait)
+		}
+		// If policy is LeastConnections we should decrement because selection incremented; Update handles decrement semantics
+		failed := load_balancer.Result{Err: err, Duration: time.Since(start)}
+		policy.Update(backend, failed)
+		breaker.Record(backend, failed)
+		markDown(backend, policy, err)
+	}
+	return "", nil, time.Time{}, err
+}
+
+// markDown stops selecting a backend that can't be reached, failing with err,
+// until it accepts connections again.
+func markDown(backend string, policy load_balancer.Policy, err error) {
+	if policy.SetHealthyBecause(backend, false, load_balancer.ReasonConnect, err) {
+		if checker == nil { // active health checks bring it back otherwise
+			go recheck(backend, policy)
+		}
+	}
+}
+
+// dialBackend connects to backend and readies the connection to carry client's
+// bytes: the PROXY header goes first, with id in version 2, then the TLS
+// handshake. Without a client, e.g. for health probes, there is no PROXY header.
+func dialBackend(ctx context.Context, backend string, client net.Conn, id string) (net.Conn, error) {
+	network, address := load_balancer.SplitNetwork(backend)
+	d := backendDialer{dialer}
+	if transparent && client != nil && network != "unix" {
+		d = backendDialer{transparentDialer(client.RemoteAddr())}
+	}
+	start := time.Now()
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if client != nil {
+		dialLatency.With(backend).Observe(time.Since(start).Seconds())
+	}
+	sockOpts.apply(conn)
+	if proxyProtocol != 0 && client != nil {
+		if err := proxyproto.Write(conn, proxyProtocol, client.RemoteAddr(), client.LocalAddr(), append(clientTLVs(client), proxyproto.UniqueID(id))...); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("sending PROXY header: %w", err)
+		}
+	}
+	if backendTLS != nil {
+		tc, err := secureBackend(ctx, conn, backend)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+	return conn, nil
+}
+
+// transparentDialer returns a dialer like dialer whose connections come from
+// client's IP address. Replies only reach the balancer if backends route them
+// through it.
+func transparentDialer(client net.Addr) *net.Dialer {
+	d := *dialer
+	if addr, ok := client.(*net.TCPAddr); ok {
+		d.LocalAddr = &net.TCPAddr{IP: addr.IP, Zone: addr.Zone}
+		control := dialer.Control // -bind-interface and -dscp
+		d.Control = func(network, address string, c syscall.RawConn) error {
+			if control != nil {
+				if err := control(network, address, c); err != nil {
+					return err
+				}
+			}
+			return setTransparent(network, address, c)
+		}
+	}
+	return &d
+}
+
+// recheck dials an unhealthy backend until it answers, then puts it back in
+// rotation; it gives up once the backend is removed.
+func recheck(backend string, policy load_balancer.Policy) {
+	for {
+		time.Sleep(recheckInterval)
+		if !hasBackend(policy, backend) {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), recheckInterval)
+		conn, err := dialBackend(ctx, backend, nil, "")
+		cancel()
+		if err != nil {
+			continue
+		}
+		conn.Close()
+		policy.SetHealthyBecause(backend, true, load_balancer.ReasonConnect, nil)
+		return
+	}
+}
+
+// hasBackend reports whether backend is one of policy's.
+func hasBackend(policy load_balancer.Policy, backend string) bool {
+	for _, b := range policy.Stats().Backends {
+		if b.Address == backend {
+			return true
+		}
+	}
+	return false
+}
+
+// detectOutliers ejects backends that fail much more often than the rest, every interval
+func detectOutliers(pool *load_balancer.Pool, cfg load_balancer.OutlierDetection, interval time.Duration) {
+	for range time.Tick(interval) {
+		pool.EjectOutliers(cfg) // logged as transitions
+	}
+}
+
+// parseHealthOverride parses a -health-override value:
+// host:port,path=/ready,port=9000,interval=2s,timeout=1s,type=http,command=/path/to/check,service=name
+func parseHealthOverride(v string) (string, load_balancer.HealthCheck, error) {
+	server, rest, _ := strings.Cut(v, ",")
+	var c load_balancer.HealthCheck
+	for _, kv := range strings.Split(rest, ",") {
+		if kv == "" {
+			continue
+		}
+		key, val, _ := strings.Cut(kv, "=")
+		var err error
+		switch key {
+		case "type":
+			c.Type = val
+		case "path":
+			c.Path = val
+		case "service":
+			c.Service = val
+		case "command":
+			c.Command = strings.Fields(val)
+		case "port":
+			c.Port, err = strconv.Atoi(val)
+		case "interval":
+			c.Interval, err = time.ParseDuration(val)
+		case "timeout":
+			c.Timeout, err = time.ParseDuration(val)
+		default:
+			err = fmt.Errorf("unknown key %q", key)
+		}
+		if err != nil {
+			return "", c, fmt.Errorf("health override %q: %v", v, err)
+		}
+	}
+	return server, c, nil
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "dashboards" {
+		runDashboards(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Args = checkArgs(os.Args)
+	}
 	// flags
-	policyName := flag.String("a", "RoundRobin", "Policy: N2One, RoundRobin, LeastConnections, LeastResponseTime")
+	policyName := flag.String("a", "RoundRobin", "Policy: N2One, RoundRobin, LeastConnections, LeastResponseTime, LeastPendingRequests, ReportedLoad, Adaptive")
 	port := flag.Int("p", 8080, "Load balancer port")
+	bind := flag.String("bind", "", "Addresses to listen on with -p, comma-separated, e.g. 0.0.0.0,:: for separate IPv4 and IPv6 sockets or ::1 (default: every address, IPv4 and IPv6)")
+	flag.IntVar(&reusePort, "reuseport", 0, "Open this many listening sockets per address with SO_REUSEPORT, each with its own accept loop, so the kernel spreads connections over them on many-core machines (Linux only)")
+	listenFlag := flag.String("listen", "", "Listen on this address instead of -p and -bind: host:port, several comma-separated, or unix:///path/lb.sock for a unix socket")
 	var serversFlag string 
-	flag.StringVar(&serversFlag, "s", "", "Backend server in host:port form; can be repeated. Example: -s localhost:5000 -s localhost:5001")
+	flag.StringVar(&serversFlag, "s", "", "Backend servers in host:port form, unix:///path for a unix socket, srv://name to resolve DNS SRV records, consul://service[?tag=t] to follow a Consul service, or etcd:///prefix/ for backends registered in etcd. Example: -s \"localhost:5000 localhost:5001\"")
+	maxConns := flag.Int("max-conns", 0, "LeastConnections: max concurrent connections per backend (0 = unlimited)")
+	backendQueue := flag.Int("backend-queue", 0, "With -max-conns: connections or requests that may wait for a slot when every backend is full, instead of failing at once (0 = none)")
+	backendQueueTimeout := flag.Duration("backend-queue-timeout", 5*time.Second, "Fail a queued connection or request that got no backend slot within this long (0 = no limit; HTTP requests also stop waiting when the client goes away)")
+	subsetSize := flag.Int("subset", 0, "Only use this many backends, picked deterministically from -instance-id (0 = all)")
+	instanceID := flag.String("instance-id", "", "Identity of this balancer for -subset (default: hostname)")
+	adaptive := flag.String("adaptive", "", "Adaptive: score coefficients as connections,latency,errors (default 1,1,2)")
+	dwell := flag.Duration("dwell", 0, "LeastResponseTime, Adaptive: minimum time on a backend before switching to a better one")
+	margin := flag.Float64("switch-margin", 0, "LeastResponseTime, Adaptive: switch only to a backend scoring this fraction better, e.g. 0.1")
+	adminAddr := flag.String("admin", "", "Admin API listen address, e.g. localhost:9090 (disabled if empty)")
+	flag.BoolVar(&explainAll, "explain", false, "Log how the backend of every connection or request is selected: candidates and their scores, excluded backends and why, and how ties were broken (POST /explain on the admin API does it for one client)")
+	flag.BoolVar(&adminDebug, "admin-debug", false, "Serve expvar on /debug/vars and pprof profiles on /debug/pprof/ on the admin API; they reveal memory contents, so it needs -admin-token or -admin-allow")
+	flag.StringVar(&adminToken, "admin-token", "", "Bearer token the admin API wants in the Authorization header of every request; better set as LB_ADMIN_TOKEN, flags show in ps")
+	flag.Func("admin-allow", "CIDRs the admin API answers, others get 403. Example: 127.0.0.1,10.0.0.0/8", func(v string) error {
+		prefixes, err := parsePrefixes(v)
+		adminAllow = append(adminAllow, prefixes...)
+		return err
+	})
+	statsSummary := flag.Duration("stats-summary", time.Minute, "Log a summary of the traffic this often, for monitoring without a metrics stack: connections accepted, open, failed and turned away, each backend's share of every pool and the p50 and p99 latency (0 disables)")
+	metricsAddr := flag.String("metrics", "", "Serve Prometheus metrics on /metrics at this address, e.g. localhost:9100 (disabled if empty)")
+	statsdAddr := flag.String("statsd", "", "Push the metrics of -metrics to this StatsD server over UDP, e.g. localhost:8125 (disabled if empty)")
+	statsdPrefix := flag.String("statsd-prefix", "", "Prepended to the names of the metrics pushed to -statsd, e.g. lb.")
+	statsdInterval := flag.Duration("statsd-interval", 10*time.Second, "How often counters and gauges are pushed to -statsd")
+	dogstatsd := flag.Bool("dogstatsd", false, "Push to -statsd in the DogStatsD format, with labels as tags")
+	statsdTags := flag.String("statsd-tags", "", "DogStatsD: tags added to every metric pushed, e.g. env:prod,region:eu")
+	logFilePath := flag.String("log-file", "", "Write the log to this file instead of stdout")
+	logSinkFlag := flag.String("log-sink", "", "Send the log to syslog (local), syslog://host:514 (UDP), syslog+tcp://host:601 or journald instead of stdout; also log_sink in -config. -access-log takes these too")
+	logMaxSizeFlag := flag.String("log-max-size", "0", "Rotate -log-file and -access-log once they would grow past this size, e.g. 100M (0 = no limit)")
+	flag.DurationVar(&logMaxAge, "log-max-age", 0, "Rotate -log-file and -access-log once they have been written to for this long, e.g. 24h (0 = no limit)")
+	flag.IntVar(&logKeep, "log-keep", 0, "Rotated log files to keep, the oldest are removed (0 = all)")
+	accessLogPath := flag.String("access-log", "", "Write a line per finished connection or request to this file, - for stdout (disabled if empty)")
+	accessLogFormat := flag.String("access-log-format", defaultAccessFormat, "Format of -access-log lines, with variables like $client, $backend, $status or ${duration}")
+	dialBuckets := flag.String("metrics-dial-buckets", defaultDialBuckets, "Upper bounds of the buckets of the backend dial latency histogram")
+	durationBuckets := flag.String("metrics-duration-buckets", defaultDurationBuckets, "Upper bounds of the buckets of the backend connection duration histogram (request duration in HTTP mode)")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "Export a span per proxied connection (and request in HTTP mode) to this OTLP/HTTP collector, e.g. http://localhost:4318 (disabled if empty)")
+	otlpHeaders := make(map[string]string)
+	flag.Func("otlp-header", "Header of requests to -otlp-endpoint, repeatable: \"Authorization: Bearer token\"", func(v string) error {
+		name, value, err := parseHeader(v)
+		if err != nil {
+			return err
+		}
+		otlpHeaders[name] = value
+		return nil
+	})
+	traceSample := flag.Float64("trace-sample", 1, "Share of new traces sampled, 0 to 1; requests with a traceparent header follow its decision")
+	traceService := flag.String("trace-service", "load_balancer", "service.name of the exported spans")
+	slowStart := flag.Duration("slow-start", 0, "Ramp added and recovered backends up to full weight over this window (0 disables)")
+	initialLatency := flag.String("latency", "", "LeastResponseTime, Adaptive: initial latency estimates, e.g. \"localhost:5000=20ms localhost:5001=80ms\"")
+	stateFile := flag.String("state", "", "Save learned weights and latencies here on shutdown and restore them on start")
+	ejectAfter := flag.Int("eject-after", 0, "Skip a backend after this many consecutive failed connections (0 disables)")
+	ejectFor := flag.Duration("eject-for", 30*time.Second, "How long a backend is skipped after -eject-after failures, before a trial connection")
+	breakerCfg := load_balancer.BreakerConfig{}
+	flag.Float64Var(&breakerCfg.ErrorRate, "breaker-error-rate", 0, "Open a backend's circuit when this share of its connections fails, e.g. 0.5 (0 disables the breaker)")
+	flag.DurationVar(&breakerCfg.SlowCall, "breaker-slow", 0, "Count connections slower than this as failures for the breaker (0 disables)")
+	flag.IntVar(&breakerCfg.MinRequests, "breaker-min-requests", 20, "Connections in a window before the breaker can open")
+	flag.DurationVar(&breakerCfg.Window, "breaker-window", 10*time.Second, "Window over which the breaker counts connections")
+	flag.DurationVar(&breakerCfg.OpenFor, "breaker-open", 5*time.Second, "How long an open circuit waits before letting a probe through")
+	outlierInterval := flag.Duration("outlier-interval", 0, "Compare backend success rates this often and eject outliers (0 disables)")
+	outlierCfg := load_balancer.DefaultOutlierDetection
+	flag.IntVar(&outlierCfg.MinRequests, "outlier-min-requests", outlierCfg.MinRequests, "Connections a backend needs per interval to be judged an outlier")
+	flag.IntVar(&outlierCfg.MaxEjectionPercent, "outlier-max-percent", outlierCfg.MaxEjectionPercent, "Never eject more than this percentage of backends as outliers")
+	var healthCheck load_balancer.HealthCheck
+	flag.StringVar(&healthCheck.Type, "health-check", "", "Actively probe backends: tcp, http, grpc (grpc.health.v1) or exec (disabled if empty; failed dials are rechecked instead)")
+	healthCommand := flag.String("health-command", "", "exec health checks: command to run, gets BACKEND_ADDR, BACKEND_HOST and BACKEND_PORT; exit 0 is healthy")
+	flag.StringVar(&healthCheck.Path, "health-path", "/", "http health checks: path to GET")
+	flag.StringVar(&healthCheck.Service, "health-grpc-service", "", "grpc health checks: service to ask about (default: the whole server)")
+	flag.DurationVar(&healthCheck.Interval, "health-interval", 5*time.Second, "Time between health probes of a backend")
+	flag.DurationVar(&healthCheck.Timeout, "health-timeout", 2*time.Second, "Health probe timeout")
+	flag.Float64Var(&healthCheck.Jitter, "health-jitter", 0.1, "Spread health probes by up to this fraction of the interval")
+	healthOverrides := make(map[string]load_balancer.HealthCheck)
+	flag.Func("health-override", "Per-backend health check, repeatable: host:port,path=/ready,port=9000,interval=2s,timeout=1s,type=http,command=/path/to/check,service=name", func(v string) error {
+		server, c, err := parseHealthOverride(v)
+		healthOverrides[load_balancer.CanonicalAddress(server)] = c
+		return err
+	})
+	panicThreshold := flag.Float64("panic-threshold", 0, "When fewer than this percentage of backends are healthy, ignore health and use them all (0 disables)")
+	flag.DurationVar(&dialer.Timeout, "dial-timeout", dialer.Timeout, "Give up connecting to a backend after this long and try the next one")
+	flag.Func("source-ip", "Connect to backends from this local IP address, e.g. on a multi-homed host (not with -transparent)", func(v string) error {
+		if sourceIP = net.ParseIP(v); sourceIP == nil {
+			return fmt.Errorf("invalid IP address %q", v)
+		}
+		return nil
+	})
+	flag.StringVar(&bindInterface, "bind-interface", "", "Send backend traffic out of this network interface, e.g. eth1 (SO_BINDTODEVICE, Linux only)")
+	flag.Func("dscp", "Mark packets to backends with this DSCP, 0-63 or a class such as EF, CS1 or AF41 (Linux only)", func(v string) error {
+		var err error
+		dscp, err = parseDSCP(v)
+		return err
+	})
+	flag.BoolVar(&sockOpts.noDelay, "tcp-nodelay", sockOpts.noDelay, "Send small writes on client and backend connections at once (TCP_NODELAY); false lets Nagle's algorithm batch them")
+	flag.DurationVar(&sockOpts.keepAlive.Idle, "tcp-keepalive", sockOpts.keepAlive.Idle, "Probe client and backend connections idle this long with TCP keepalives (SO_KEEPALIVE), to find dead peers; 0 disables")
+	flag.DurationVar(&sockOpts.keepAlive.Interval, "tcp-keepalive-interval", sockOpts.keepAlive.Interval, "Time between unanswered TCP keepalive probes")
+	flag.Func("tcp-send-buffer", "Socket send buffer size of client and backend connections (SO_SNDBUF), e.g. 256K (system default if unset)", func(v string) error {
+		size, err := parseBytes(v)
+		sockOpts.sendBuf = int(size)
+		return err
+	})
+	flag.Func("tcp-recv-buffer", "Socket receive buffer size of client and backend connections (SO_RCVBUF), e.g. 256K (system default if unset)", func(v string) error {
+		size, err := parseBytes(v)
+		sockOpts.recvBuf = int(size)
+		return err
+	})
+	flag.IntVar(&backlog, "backlog", 0, "Length of the queue of connections waiting to be accepted, per listening socket; capped by the kernel (0 = system default)")
+	backoffBase := flag.Duration("backoff", 0, "After a failed dial, leave the backend alone this long, doubling per further failure (0 disables)")
+	backoffMax := flag.Duration("backoff-max", time.Minute, "Longest -backoff wait")
+	flag.IntVar(&tries, "tries", tries, "Backends to try per client connection when dialing fails")
+	configFile := flag.String("config", "", "JSON, YAML (.yaml, .yml) or TOML (.toml) file with servers, policy, frontends, pools, routes, health checks and any flag by name; flags given here win over it. See load_balancer config schema. Servers, policy, slow_start and panic_threshold are reloaded on SIGHUP")
+	consulAddr := flag.String("consul", "http://127.0.0.1:8500", "Consul agent for consul://service backends")
+	etcdAddr := flag.String("etcd", "http://127.0.0.1:2379", "etcd endpoint for etcd:///prefix/ backends")
+	backendsFile := flag.String("backends-file", "", "File with one host:port[:weight] backend per line, reloaded whenever it changes")
+	discoveryInterval := flag.Duration("discovery-interval", 30*time.Second, "How often srv:// backends are resolved again")
+	watchConfig := flag.Bool("watch", false, "Reload -config whenever the file changes")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 0, "Close connections with no bytes flowing either way for this long (0 disables)")
+	flag.DurationVar(&maxLifetime, "max-lifetime", 0, "End connections open this long, e.g. 1h, so clients reconnect and rebalance (0 disables)")
+	flag.DurationVar(&headerTimeout, "http-header-timeout", headerTimeout, "In HTTP mode, close connections whose request headers take longer than this to arrive (0 disables)")
+	flag.DurationVar(&bodyTimeout, "http-body-timeout", 0, "In HTTP mode, fail requests whose body takes longer than this to arrive after the headers; not for upgrades or -mode grpc (0 disables)")
+	flag.Func("http-max-header-bytes", "In HTTP mode, largest request line and headers accepted, e.g. 64K (default 1M)", func(v string) error {
+		n, err := parseBytes(v)
+		maxHeaderBytes = int(n)
+		return err
+	})
+	maxClients := flag.Int("max-clients", 0, "Most client connections proxied at once (0 = unlimited)")
+	maxWorkers := flag.Int("max-workers", 0, "Most client connections served at once; more wait in the listen queue until one finishes, instead of each getting a goroutine (0 = unlimited)")
+	maxClientsPerIP := flag.Int("max-clients-per-ip", 0, "Most connections proxied at once per client IP, more are refused (0 = unlimited)")
+	clientQueue := flag.Int("client-queue", 0, "Connections over -max-clients that may wait for a slot; the rest are refused")
+	clientQueueTimeout := flag.Duration("client-queue-timeout", 5*time.Second, "Refuse a queued connection that got no slot within this long")
+	flag.Func("qos-class", "Priority of connections under -max-clients, repeatable, first match wins, others are normal: cidr:10.0.0.0/8=high, sni:*.example.com=high (tcp mode) or listener::8443=low. Higher classes get slots first, lower ones are shed first; sni classes apply once the handshake tells the name, a connection found lower then gives its slot up to higher ones waiting", func(v string) error {
+		r, err := parseQoSRule(v)
+		if err != nil {
+			return err
+		}
+		qosRules = append(qosRules, r)
+		return nil
+	})
+	acceptRate := flag.Float64("accept-rate", 0, "Most new connections accepted per second overall, more are refused (0 = unlimited)")
+	acceptBurst := flag.Int("accept-burst", 0, "New connections -accept-rate lets through at once (default: one second's worth)")
+	acceptRatePerIP := flag.Float64("accept-rate-per-ip", 0, "Most new connections accepted per second from one client IP (0 = unlimited)")
+	acceptBurstPerIP := flag.Int("accept-burst-per-ip", 0, "New connections -accept-rate-per-ip lets through at once (default: one second's worth)")
+	flag.Func("bandwidth-per-conn", "Most bytes per second each connection may move in each direction, e.g. 512K (unlimited if unset)", func(v string) (err error) {
+		connBandwidth, err = parseBytes(v)
+		return err
+	})
+	flag.Func("bandwidth-total", "Most bytes per second all connections together may move, e.g. 100M (unlimited if unset)", func(v string) error {
+		rate, err := parseBytes(v)
+		totalBandwidth = newThrottle(rate)
+		return err
+	})
+	flag.Func("copy-buffer", "Size of the pooled buffers connections and HTTP bodies are proxied through, e.g. 64K (default 32K)", func(v string) error {
+		size, err := parseBytes(v)
+		if err == nil && (size < 1<<10 || size > 16<<20) {
+			err = fmt.Errorf("%s is not between 1K and 16M", v)
+		}
+		copyBuffers.size = int(size)
+		return err
+	})
+	flag.BoolVar(&spliceConns, "splice", false, "In tcp mode, splice client and backend sockets to each other so bytes stay in the kernel (Linux only; not with -idle-timeout or -bandwidth-*). Measure with go test -bench Relay: whether it beats -copy-buffer depends on the host")
+	flag.IntVar(&proxyProtocol, "proxy-protocol", 0, "Send backends a PROXY protocol header with the client's address: 1 (text) or 2 (binary, also with the connection's ID and TLS details); 0 disables")
+	flag.BoolVar(&transparent, "transparent", false, "Connect to backends from the client's IP address (IP_TRANSPARENT), so they see it without PROXY protocol; needs CAP_NET_ADMIN and routing that sends replies back through the balancer (Linux only)")
+	backendTLSOn := flag.Bool("backend-tls", false, "Connect to backends over TLS")
+	backendCA := flag.String("backend-ca", "", "With -backend-tls, verify backends against the CA certificates in this PEM file instead of the system roots")
+	backendServerName := flag.String("backend-server-name", "", "With -backend-tls, expect this name in backend certificates instead of each backend's host")
+	backendCert := flag.String("backend-cert", "", "With -backend-tls, client certificate (PEM) for backends that require one")
+	backendKey := flag.String("backend-key", "", "Private key (PEM) of -backend-cert")
+	backendInsecure := flag.Bool("backend-tls-insecure", false, "With -backend-tls, don't verify backend certificates (testing only)")
+	tlsCert := flag.String("tls-cert", "", "Terminate TLS from clients with this certificate (PEM)")
+	tlsKey := flag.String("tls-key", "", "Private key (PEM) of -tls-cert")
+	tlsClientCA := flag.String("tls-client-ca", "", "With -tls-cert, require client certificates issued by the CAs in this PEM file")
+	tlsClientCRL := flag.String("tls-client-crl", "", "With -tls-client-ca, refuse client certificates revoked by this CRL (PEM or DER)")
+	flag.DurationVar(&sniffTimeout, "sniff-timeout", sniffTimeout, "In -mode sniff, how long to wait for a client's first bytes before taking it for TCP, for protocols where the server speaks first")
+	sniffTLS := flag.String("sniff-tls", "", "In -mode sniff, -pool that TLS connections are passed through to (default the pool of -s, or -sni-route with -sni-passthrough)")
+	sniffTCP := flag.String("sniff-tcp", "", "In -mode sniff, -pool that connections neither TLS nor HTTP go to (default the pool of -s)")
+	flag.BoolVar(&sniPassthrough, "sni-passthrough", false, "Route TLS connections by the server name they ask for, without terminating TLS; see -sni-route")
+	var sniRoutes []string
+	flag.Func("sni-route", "With -sni-passthrough, send a server name to its own backends, repeatable: api.example.com=host:port,host:port or *.example.com=...; other names go to -s", func(v string) error {
+		sniRoutes = append(sniRoutes, v)
+		return nil
+	})
+	mode := flag.String("mode", "tcp", "tcp: balance connections; http: parse HTTP/1.1 and balance each request; grpc: balance each call of HTTP/2 gRPC channels; sniff: tell TLS, HTTP and other TCP apart on one port, passing TLS through, balancing HTTP requests and other connections")
+	httpHeaders := make(http.Header)
+	flag.Func("http-header", "HTTP mode: set this header on requests to backends, repeatable: \"X-Env: prod\"", func(v string) error {
+		name, value, err := parseHeader(v)
+		httpHeaders.Set(name, value)
+		return err
+	})
+	noBackendPage := flag.String("http-no-backend-page", "", "HTTP mode: file answered with 503 when no backend can take a request (default a plain text error)")
+	flag.Func("no-backend-banner", "In tcp mode, bytes sent to clients no backend can take before closing them, with Go escapes: \"421 Service not available\\r\\n\"", func(v string) error {
+		var err error
+		noBackendBanner, err = parseBanner(v)
+		return err
+	})
+	maintenancePage := flag.String("http-maintenance-page", "", "HTTP mode: file answered with 503 while backends are in maintenance and no other can take a request")
+	var poolFlags, httpRouteFlags, pathRouteFlags, headerRouteFlags, cookieRouteFlags []string
+	flag.Func("pool", "Named backend pool for routes, repeatable: api=host:port,host:port", func(v string) error {
+		poolFlags = append(poolFlags, v)
+		return nil
+	})
+	poolPolicies := make(map[string]string)
+	flag.Func("pool-policy", "Policy of a named pool, repeatable: api=LeastConnections; pools use -a otherwise", func(v string) error {
+		name, policy, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("want pool=policy")
+		}
+		poolPolicies[name] = policy
+		return nil
+	})
+	flag.Func("http-route", "HTTP mode: send requests for a Host to a named pool, repeatable: api.example.com=api or *.example.com=web; other hosts go to -s", func(v string) error {
+		httpRouteFlags = append(httpRouteFlags, v)
+		return nil
+	})
+	flag.Func("http-path-route", "HTTP mode: send requests under a path prefix to a named pool, longest prefix wins, repeatable: /api=api, or /static=static,strip to remove the prefix", func(v string) error {
+		pathRouteFlags = append(pathRouteFlags, v)
+		return nil
+	})
+	flag.Func("http-header-route", "HTTP mode: send requests with a header to a named pool, repeatable: X-Canary:true=canary, or X-Canary~^(true|1)$=canary for a regexp", func(v string) error {
+		headerRouteFlags = append(headerRouteFlags, v)
+		return nil
+	})
+	flag.Func("http-cookie-route", "HTTP mode: send requests with a cookie to a named pool, repeatable: beta:on=beta, or beta~regexp=beta", func(v string) error {
+		cookieRouteFlags = append(cookieRouteFlags, v)
+		return nil
+	})
+	geoIPFile := flag.String("geoip", "", "MaxMind DB (.mmdb) file, e.g. GeoLite2-Country.mmdb, to locate clients for -geo-route and logs")
+	var geoRouteFlags []string
+	flag.Func("geo-route", "With -geoip: send clients from some countries or continents to a named pool, repeatable: country:DE,FR=eu or continent:NA=us; first match wins", func(v string) error {
+		geoRouteFlags = append(geoRouteFlags, v)
+		return nil
+	})
+	var ja3RouteFlags []string
+	flag.Func("ja3-route", "With -tls-cert or -sni-passthrough: send TLS clients with a JA3 fingerprint to a named pool, repeatable: e7d705a3286e19ea42f587b344ee6865=tarpit", func(v string) error {
+		ja3RouteFlags = append(ja3RouteFlags, v)
+		return nil
+	})
+	flag.Func("ja3-deny", "With -tls-cert or -sni-passthrough: refuse TLS clients with these JA3 fingerprints, comma-separated, repeatable", func(v string) error {
+		for _, h := range strings.Split(v, ",") {
+			hash, err := parseJA3(strings.TrimSpace(h))
+			if err != nil {
+				return err
+			}
+			ja3Deny[hash] = true
+		}
+		return nil
+	})
+	blueGreenFlag := flag.String("blue-green", "", "Send traffic no route matched to one of two named pools, blue,green, in place of -s; the first is active until POST /cutover on the admin API")
+	splitFlag := flag.String("http-split", "", "HTTP mode: split requests no route matched between named pools by weight, e.g. stable=95,canary=5; default is the pool of -s")
+	stickyName := flag.String("sticky-cookie", "", "HTTP mode: pin clients to a backend with a cookie of this name; a client whose backend is gone is balanced again")
+	stickyTTL := flag.Duration("sticky-ttl", 0, "Lifetime of the -sticky-cookie (0: until the browser closes)")
+	stickySecure := flag.Bool("sticky-secure", false, "Mark the -sticky-cookie Secure, sent over HTTPS only")
+	stickyHTTPOnly := flag.Bool("sticky-httponly", true, "Mark the -sticky-cookie HttpOnly, hidden from scripts")
+	httpRetries := flag.Int("http-retries", 1, "HTTP mode: retries on other backends for a request whose try failed (0 disables)")
+	retryMethods := flag.String("http-retry-methods", "GET,HEAD,OPTIONS,TRACE,PUT,DELETE", "Idempotent methods, retried after a -http-retry-status or a broken connection; any request is retried if it couldn't connect")
+	retryStatuses := flag.String("http-retry-status", "502,503,504", "Backend responses retried on another backend")
+	retryBudget := flag.Float64("http-retry-budget", 20, "Retries allowed as a percentage of requests, so failing backends don't get extra load (0 for no limit)")
+	tryTimeout := flag.Duration("http-try-timeout", 0, "Time limit of each try of a request, response included; 0 for none")
+	flag.Func("trusted-proxies", "HTTP mode: CIDRs of proxies in front of the balancer whose X-Forwarded-For and Forwarded headers are kept; from others they're replaced. Example: 10.0.0.0/8,192.168.1.10", func(v string) error {
+		prefixes, err := parsePrefixes(v)
+		trustedProxies = append(trustedProxies, prefixes...)
+		return err
+	})
+	flag.StringVar(&requestIDHeader, "request-id-header", requestIDHeader, "HTTP mode: header giving backends, and clients in the response, the ID each request is logged with; kept from -trusted-proxies, replaced otherwise (empty sends none). In tcp mode -proxy-protocol 2 sends the connection's ID")
+	http2 := flag.Bool("http2", true, "HTTP mode: speak HTTP/2 with clients that offer it over TLS (ALPN); each stream is balanced on its own")
+	backendProtocol := flag.String("backend-protocol", "http1", "HTTP mode: protocol to backends, http1 or http2 (negotiated with -backend-tls, cleartext h2c otherwise); always http2 in -mode grpc")
+	checkOnly := flag.Bool("check", false, "Check the config and that every backend resolves, print a report and exit non-zero if anything failed")
+	checkDial := flag.Bool("check-dial", false, "With -check, also connect to every backend and run its health check")
+	validateOnly := flag.Bool("validate", false, "Validate the flags and config without binding a socket or connecting anywhere, print the effective configuration as YAML and exit non-zero if anything is wrong; load_balancer check lb.yaml is -validate -config lb.yaml")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "On shutdown, close connections still open after this long (0 waits forever); a second SIGINT or SIGTERM closes them at once")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "On reload, close connections to removed backends still open after this long (0 waits forever)")
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		runConfig(os.Args[2:]) // here, once the flags it describes are defined
+		return
+	}
+	recordFlagValues()
 	flag.Parse()
+	if *validateOnly {
+		logger.SetOutput(os.Stderr) // stdout is for the effective configuration
+	}
+	if err := setEnvFlags(); err != nil {
+		logger.Fatalf("Invalid %v", err)
+	}
+	given := givenFlags()
+	var cfg *config
+	if *configFile != "" {
+		var err error
+		if cfg, err = loadConfig(*configFile); err != nil {
+			logger.Fatalf("%v", err)
+		}
+		if err := cfg.setFlags(*configFile, given); err != nil {
+			logger.Fatalf("%v", err)
+		}
+		cfg = cfg.without(given)
+	}
+	sockOpts.keepAlive.Enable = sockOpts.keepAlive.Idle > 0
+	var err error
+	if logMaxSize, err = parseBytes(*logMaxSizeFlag); err != nil {
+		logger.Fatalf("Invalid -log-max-size: %v", err)
+	}
+	// -validate checks where the logs go without opening them, it has no side effects
+	if *logFilePath != "" && *validateOnly {
+		if err := checkLogFile(*logFilePath); err != nil {
+			logger.Fatalf("Invalid -log-file: %v", err)
+		}
+	} else if *logFilePath != "" {
+		f, err := openLogFile(*logFilePath)
+		if err != nil {
+			logger.Fatalf("Failed to open -log-file: %v", err)
+		}
+		logger.SetOutput(f)
+	}
+	dialHist, err := parseBuckets(*dialBuckets)
+	if err != nil {
+		logger.Fatalf("Invalid -metrics-dial-buckets: %v", err)
+	}
+	durationHist, err := parseBuckets(*durationBuckets)
+	if err != nil {
+		logger.Fatalf("Invalid -metrics-duration-buckets: %v", err)
+	}
+	registerBackendHistograms(dialHist, durationHist)
+	if *accessLogPath != "" && *validateOnly {
+		if err := checkAccessLog(*accessLogPath, *accessLogFormat); err != nil {
+			logger.Fatalf("Invalid -access-log: %v", err)
+		}
+	} else if *accessLogPath != "" {
+		if accessLog, err = newAccessLogger(*accessLogPath, *accessLogFormat); err != nil {
+			logger.Fatalf("Invalid -access-log: %v", err)
+		}
+	}
+	if *otlpEndpoint != "" {
+		endpoint, err := otlpTracesURL(*otlpEndpoint)
+		if err != nil {
+			logger.Fatalf("Invalid -otlp-endpoint: %v", err)
+		}
+		if *traceSample < 0 || *traceSample > 1 {
+			logger.Fatalf("Invalid -trace-sample: %v, want 0 to 1", *traceSample)
+		}
+		exporter := &tracing.OTLPExporter{Endpoint: endpoint, Service: *traceService, Headers: otlpHeaders}
+		tracer = tracing.NewTracer(exporter, *traceSample, func(err error) {
+			logger.Printf("ERROR exporting spans: %v", err)
+		})
+	}
 
-	if len(serversFlag) == 0 {
-		logger.Fatalf("No backend servers specified (-s).")
+	if *logSinkFlag != "" {
+		if *logFilePath != "" {
+			logger.Fatalf("-log-sink and -log-file both say where the log goes, use one")
+		}
+		w, err := openLogSink(*logSinkFlag, "", logSeverity)
+		if err != nil {
+			logger.Fatalf("Invalid -log-sink: %v", err)
+		}
+		if !*validateOnly {
+			logger.SetOutput(w)
+			logger.SetFlags(0) // the sink timestamps messages
+		}
 	}
 
-	// prepare server list (strings)
-	servers := strings.Fields(serversFlag) 
+	// prepare server list, the config file's unless -s or LB_S gave one; srv://
+	// names are resolved in the background
+	var servers []string
+	var providers []source
+	for _, s := range strings.Fields(serversFlag) {
+		switch {
+		case strings.HasPrefix(s, discovery.SRVScheme):
+			providers = append(providers, source{discovery.NewSRV(s), *discoveryInterval})
+		case strings.HasPrefix(s, discovery.ConsulScheme):
+			p, err := discovery.NewConsul(*consulAddr, s)
+			if err != nil {
+				logger.Fatalf("Invalid -s %s: %v", s, err)
+			}
+			providers = append(providers, source{p, watchRetry})
+		case strings.HasPrefix(s, discovery.EtcdScheme):
+			providers = append(providers, source{discovery.NewEtcd(*etcdAddr, s), watchRetry})
+		default:
+			servers = append(servers, load_balancer.CanonicalAddress(s))
+		}
+	}
+	if *backendsFile != "" {
+		providers = append(providers, source{discovery.NewFile(*backendsFile), watchRetry})
+	}
+	if dup := duplicateServer(servers); dup != "" {
+		logger.Fatalf("Invalid -s: %s listed twice", dup)
+	}
+	backends := load_balancer.NewBackends(servers)
+	if cfg != nil && len(cfg.Servers) > 0 {
+		backends = cfg.backends()
+		servers = servers[:0]
+		for _, b := range backends {
+			servers = append(servers, b.Address)
+		}
+	}
+	if len(backends) == 0 && len(providers) == 0 && len(sniRoutes) == 0 && len(poolFlags) == 0 {
+		logger.Fatalf("No backend servers specified (-s, -config or -backends-file).")
+	}
 
-	// init chosen policy
-	var policy load_balancer.Policy
-	switch *policyName {
-	case "N2One":
-		policy = load_balancer.NewN2One(servers)
-	case "RoundRobin":
-		policy = load_balancer.NewRoundRobin(servers)
-	case "LeastConnections":
-		policy = load_balancer.NewLeastConnections(servers)
-	case "LeastResponseTime":
-		policy = load_balancer.NewLeastResponseTime(servers)
+	// init chosen policy; frontends from the config file get pools of their own
+	// with the same settings
+	newPool := func(backends []load_balancer.Backend) *load_balancer.Pool {
+		pool := load_balancer.NewPool(backends)
+		pool.SetEvents(transitionLog{})
+		pool.SetSlowStart(*slowStart)
+		pool.SetPanicThreshold(*panicThreshold)
+		pool.SetFailureEjection(load_balancer.FailureEjection{Threshold: *ejectAfter, CoolDown: *ejectFor})
+		return pool
+	}
+	pool := newPool(backends)
+	if breakerCfg.ErrorRate > 0 {
+		breaker = load_balancer.NewBreaker(breakerCfg)
+		breaker.OnTransition = func(server string, from, to load_balancer.BreakerState) {
+			logger.Printf("Circuit of backend %s %s -> %s", server, from, to)
+		}
+	}
+	if *subsetSize > 0 {
+		if *instanceID == "" {
+			*instanceID, _ = os.Hostname()
+		}
+		pool.SetSubset(*instanceID, *subsetSize)
+	}
+	if *tlsCert != "" || *tlsKey != "" {
+		var err error
+		if listenerTLS, err = loadListenerTLS(*tlsCert, *tlsKey, *tlsClientCA, *tlsClientCRL); err != nil {
+			logger.Fatalf("Invalid TLS settings: %v", err)
+		}
+	} else if *tlsClientCA != "" {
+		logger.Fatalf("-tls-client-ca needs -tls-cert")
+	}
+	// the -http-* flags, for the main frontend in -mode http or grpc and for
+	// HTTP frontends from the config file
+	httpBase := httpOptions{headers: httpHeaders}
+	if *maintenancePage != "" {
+		var err error
+		if httpBase.maintenance, err = os.ReadFile(*maintenancePage); err != nil {
+			logger.Fatalf("%v", err)
+		}
+	}
+	if *noBackendPage != "" {
+		var err error
+		if httpBase.noBackend, err = os.ReadFile(*noBackendPage); err != nil {
+			logger.Fatalf("%v", err)
+		}
+	}
+	if *stickyName != "" {
+		httpBase.sticky = &stickyCookie{name: *stickyName, ttl: *stickyTTL, secure: *stickySecure, httpOnly: *stickyHTTPOnly}
+	}
+	switch *backendProtocol {
+	case "http1":
+	case "http2":
+		httpBase.backendHTTP2 = true
 	default:
-		logger.Fatalf("Unknown policy: %s", *policyName)
+		logger.Fatalf("Invalid -backend-protocol %q, want http1 or http2", *backendProtocol)
 	}
-
-	listenAddr := fmt.Sprintf("0.0.0.0:%d", *port)
-	l, err := net.Listen("tcp", listenAddr)
+	statuses, err := parseStatuses(*retryStatuses)
+	if err != nil {
+		logger.Fatalf("Invalid -http-retry-status: %v", err)
+	}
+	httpBase.retry = retryPolicy{
+		retries:    *httpRetries,
+		methods:    parseMethods(*retryMethods),
+		statuses:   statuses,
+		tryTimeout: *tryTimeout,
+		budget:     newRetryBudget(*retryBudget),
+	}
+	httpOpts := httpBase
+	httpOpts.routed = true
+	switch *mode {
+	case "tcp":
+		if len(httpRouteFlags)+len(pathRouteFlags)+len(headerRouteFlags)+len(cookieRouteFlags) > 0 || *splitFlag != "" {
+			logger.Fatalf("-http-route, -http-path-route, -http-header-route, -http-cookie-route and -http-split need -mode http")
+		}
+		if *stickyName != "" && !httpFrontends(cfg) {
+			logger.Fatalf("-sticky-cookie needs -mode http or an HTTP frontend")
+		}
+	case "sniff":
+		if listenerTLS != nil {
+			logger.Fatalf("-mode sniff passes TLS through, it can't be combined with -tls-cert")
+		}
+	case "http", "grpc":
+		if proxyProtocol != 0 || sniPassthrough || transparent {
+			logger.Fatalf("-proxy-protocol, -sni-passthrough and -transparent work on connections, not in -mode %s", *mode)
+		}
+		if *mode == "grpc" {
+			httpOpts.grpc, httpOpts.backendHTTP2, *http2 = true, true, true
+		}
+		if listenerTLS != nil && *http2 {
+			listenerTLS.NextProtos = []string{"h2", "http/1.1"}
+		}
+	default:
+		logger.Fatalf("Invalid -mode %q, want tcp, http, grpc or sniff", *mode)
+	}
+	if sniPassthrough && listenerTLS != nil {
+		logger.Fatalf("-sni-passthrough passes TLS through, it can't be combined with -tls-cert")
+	}
+	if len(ja3RouteFlags)+len(ja3Deny) > 0 && !sniPassthrough && listenerTLS == nil {
+		logger.Fatalf("-ja3-route and -ja3-deny fingerprint TLS clients, they need -tls-cert or -sni-passthrough")
+	}
+	if listenerTLS != nil {
+		listenerTLS.GetConfigForClient = fingerprintClient
+	}
+	if *backendTLSOn {
+		var err error
+		if backendTLS, err = loadBackendTLS(*backendCA, *backendServerName, *backendCert, *backendKey, *backendInsecure); err != nil {
+			logger.Fatalf("Invalid backend TLS settings: %v", err)
+		}
+	}
+	if proxyProtocol < 0 || proxyProtocol > 2 {
+		logger.Fatalf("Invalid -proxy-protocol %d, want 1 or 2", proxyProtocol)
+	}
+	if transparent {
+		if err := checkTransparent(); err != nil {
+			logger.Fatalf("Invalid -transparent: %v", err)
+		}
+	}
+	if transparent && sourceIP != nil {
+		logger.Fatalf("-transparent connects from the client's address, it can't be combined with -source-ip")
+	}
+	if err := configureDialer(); err != nil {
+		logger.Fatalf("%v", err)
+	}
+	if *adminAddr != "" && adminDebug && adminToken == "" && len(adminAllow) == 0 {
+		logger.Fatalf("-admin-debug needs -admin-token or -admin-allow, profiles reveal memory contents")
+	}
+	accepts = newAcceptLimiter(*acceptRate, *acceptBurst, *acceptRatePerIP, *acceptBurstPerIP)
+	if len(qosRules) > 0 && *maxClients <= 0 {
+		logger.Fatalf("-qos-class orders connections waiting for -max-clients, set it")
+	}
+	limits = newConnLimiter(*maxClients, *maxClientsPerIP, *clientQueue, *clientQueueTimeout)
+	workers = newWorkerLimit(*maxWorkers)
+	if *backoffBase > 0 {
+		backoff = load_balancer.NewBackoff(*backoffBase, *backoffMax)
+	}
+	healthCheck.Command = strings.Fields(*healthCommand)
+	healthCheck.TLS = backendTLS
+	// watch runs the health checks and outlier detection of a pool, if enabled
+	watch := func(pool *load_balancer.Pool) *load_balancer.HealthChecker {
+		if *outlierInterval > 0 {
+			go detectOutliers(pool, outlierCfg, *outlierInterval)
+		}
+		if healthCheck.Type == "" {
+			return nil
+		}
+		checker := load_balancer.NewHealthChecker(pool, healthCheck)
+		for server, c := range healthOverrides {
+			checker.Override(server, c)
+		}
+		checker.Dialer = backendDialer{dialer}
+		if !*checkOnly && !*validateOnly {
+			go checker.Run(context.Background())
+		}
+		return checker
+	}
+	checker = watch(pool)
+	opts := load_balancer.Options{
+		MaxConnsPerBackend: *maxConns,
+		Queue:              load_balancer.Queue{Depth: *backendQueue, Timeout: *backendQueueTimeout},
+		Dampening:          load_balancer.Dampening{MinDwell: *dwell, Margin: *margin},
+	}
+	if *backendQueue > 0 && *maxConns <= 0 {
+		logger.Fatalf("-backend-queue needs -max-conns, it queues for a backend's connection slot")
+	}
+	if *initialLatency != "" {
+		opts.InitialLatency = make(map[string]time.Duration)
+		for _, f := range strings.Fields(*initialLatency) {
+			server, d, _ := strings.Cut(f, "=")
+			estimate, err := time.ParseDuration(d)
+			if err != nil {
+				logger.Fatalf("Invalid -latency %q: %v", f, err)
+			}
+			opts.InitialLatency[load_balancer.CanonicalAddress(server)] = estimate
+		}
+	}
+	if *adaptive != "" {
+		if _, err := fmt.Sscanf(*adaptive, "%g,%g,%g", &opts.Adaptive.Connections, &opts.Adaptive.Latency, &opts.Adaptive.Errors); err != nil {
+			logger.Fatalf("Invalid -adaptive %q: %v", *adaptive, err)
+		}
+	}
+	policy, err := load_balancer.NewSwitchable(*policyName, pool, opts)
 	if err != nil {
-		logger.Fatalf("Failed to listen on %s: %v", listenAddr, err)
+		logger.Fatalf("%v", err)
+	}
+	for _, v := range sniRoutes {
+		pattern, routeServers, err := parseHostRoute(v)
+		if err != nil {
+			logger.Fatalf("Invalid -sni-route: %v", err)
+		}
+		if dup := duplicateServer(routeServers); dup != "" {
+			logger.Fatalf("Invalid -sni-route %s: %s listed twice", pattern, dup)
+		}
+		routePool := load_balancer.NewPool(load_balancer.NewBackends(routeServers))
+		routePool.SetEvents(transitionLog{})
+		routePolicy, err := load_balancer.NewPolicy(*policyName, routePool, opts)
+		if err != nil {
+			logger.Fatalf("%v", err)
+		}
+		hostRoutes = append(hostRoutes, hostRoute{pattern: pattern, policy: routePolicy})
+	}
+	for _, v := range poolFlags {
+		name, poolServers, err := parsePool(v)
+		if err != nil {
+			logger.Fatalf("Invalid -pool: %v", err)
+		}
+		if dup := duplicateServer(poolServers); dup != "" {
+			logger.Fatalf("Invalid -pool %s: %s listed twice", name, dup)
+		}
+		poolPolicy := *policyName
+		if p, ok := poolPolicies[name]; ok {
+			poolPolicy = p
+		}
+		namedPool := load_balancer.NewPool(load_balancer.NewBackends(poolServers))
+		namedPool.SetEvents(transitionLog{})
+		if pools[name], err = load_balancer.NewPolicy(poolPolicy, namedPool, opts); err != nil {
+			logger.Fatalf("Pool %s: %v", name, err)
+		}
+	}
+	for name := range poolPolicies {
+		if pools[name] == nil {
+			logger.Fatalf("-pool-policy for unknown pool %s", name)
+		}
+	}
+	for _, v := range httpRouteFlags {
+		pattern, routePolicy, err := parsePoolRoute(v)
+		if err != nil {
+			logger.Fatalf("Invalid -http-route: %v", err)
+		}
+		httpRoutes = append(httpRoutes, hostRoute{pattern: pattern, policy: routePolicy})
+	}
+	for _, v := range pathRouteFlags {
+		route, err := parsePathRoute(v)
+		if err != nil {
+			logger.Fatalf("Invalid -http-path-route: %v", err)
+		}
+		httpPathRoutes = append(httpPathRoutes, route)
+	}
+	for _, v := range headerRouteFlags {
+		route, err := parseMatchRoute(v, false)
+		if err != nil {
+			logger.Fatalf("Invalid -http-header-route: %v", err)
+		}
+		httpMatchRoutes = append(httpMatchRoutes, route)
+	}
+	for _, v := range cookieRouteFlags {
+		route, err := parseMatchRoute(v, true)
+		if err != nil {
+			logger.Fatalf("Invalid -http-cookie-route: %v", err)
+		}
+		httpMatchRoutes = append(httpMatchRoutes, route)
+	}
+	if *geoIPFile != "" {
+		if geoDB, err = geoip.Open(*geoIPFile); err != nil {
+			logger.Fatalf("Loading -geoip: %v", err)
+		}
+		logger.Printf("Locating clients with %s database %s", geoDB.Type, *geoIPFile)
+	}
+	if len(geoRouteFlags) > 0 && geoDB == nil {
+		logger.Fatalf("-geo-route needs -geoip")
+	}
+	for _, v := range geoRouteFlags {
+		route, err := parseGeoRoute(v)
+		if err != nil {
+			logger.Fatalf("Invalid -geo-route: %v", err)
+		}
+		geoRoutes = append(geoRoutes, route)
+	}
+	for _, v := range ja3RouteFlags {
+		hash, routePolicy, err := parseJA3Route(v)
+		if err != nil {
+			logger.Fatalf("Invalid -ja3-route: %v", err)
+		}
+		if ja3Routes[hash] != nil {
+			logger.Fatalf("Invalid -ja3-route: %s routed twice", hash)
+		}
+		ja3Routes[hash] = routePolicy
+	}
+	if err := checkRoutes(); err != nil {
+		logger.Fatalf("%v", err)
+	}
+	for _, s := range []struct {
+		name, pool string
+		policy     *load_balancer.Policy
+	}{{"-sniff-tls", *sniffTLS, &sniffTLSPolicy}, {"-sniff-tcp", *sniffTCP, &sniffTCPPolicy}} {
+		if s.pool == "" {
+			continue
+		}
+		if *mode != "sniff" {
+			logger.Fatalf("%s needs -mode sniff", s.name)
+		}
+		if *s.policy = pools[s.pool]; *s.policy == nil {
+			logger.Fatalf("%s: unknown pool %s", s.name, s.pool)
+		}
+	}
+	if *splitFlag != "" {
+		if httpSplit, err = parseSplit(*splitFlag, policy); err != nil {
+			logger.Fatalf("Invalid -http-split: %v", err)
+		}
+		logger.Printf("Splitting traffic: %s", httpSplit)
+	}
+	if *blueGreenFlag != "" {
+		if httpSplit != nil {
+			logger.Fatalf("-blue-green and -http-split both pick the pool of requests no route matched, use one")
+		}
+		if blueGreen, err = parseBlueGreen(*blueGreenFlag); err != nil {
+			logger.Fatalf("Invalid -blue-green: %v", err)
+		}
+		active, standby := blueGreen.state()
+		logger.Printf("Blue/green: pool %s active, %s standing by", active, standby)
+	}
+	listenAddr := listenAddrs(*bind, *port)
+	if *listenFlag != "" {
+		listenAddr = *listenFlag
+	}
+	frontends := []*frontend{{addr: listenAddr, mode: *mode, bySNI: sniPassthrough, pool: pool, policy: policy, pools: blueGreen}}
+	if *mode != "tcp" {
+		frontends[0].srv = newHTTPServer(newHTTPProxy(policy, httpOpts), *http2, httpOpts.grpc)
+		if *mode == "sniff" {
+			// clients speaking HTTP/2 without TLS open with the prior knowledge preface
+			frontends[0].srv.Protocols.SetUnencryptedHTTP2(true)
+		}
+	}
+	configs := &reloader{path: *configFile, policy: policy, frontends: make(map[string]*frontend), drainTimeout: *drainTimeout, given: given, good: cfg}
+	staticBackends := backends
+	if cfg != nil {
+		listeners := []string{listenAddr}
+		for _, fc := range cfg.Frontends {
+			listeners = append(listeners, fc.Listen)
+		}
+		if err := checkListeners(listeners); err != nil {
+			logger.Fatalf("%v", err)
+		}
+		if err := applyConfig(policy, cfg, *drainTimeout); err != nil {
+			logger.Fatalf("%v", err)
+		}
+		for _, fc := range cfg.Frontends {
+			if fc.Policy == "" {
+				fc.Policy = *policyName
+			}
+			f, err := newFrontend(fc, newPool(backendsOf(fc.Servers)), opts, httpBase)
+			if err != nil {
+				logger.Fatalf("Frontend %s: %v", fc.Name, err)
+			}
+			watch(f.pool)
+			frontends = append(frontends, f)
+			configs.frontends[f.name] = f
+			backends = append(backends, f.pool.Backends()...)
+		}
+	}
+	if *validateOnly {
+		if err := writeEffectiveConfig(os.Stdout, *configFile, cfg, *policyName, serversFlag, staticBackends); err != nil {
+			logger.Fatalf("Writing the effective configuration: %v", err)
+		}
+		return
+	}
+	if *checkOnly {
+		if !preflight(os.Stdout, backends, providers, *checkDial) {
+			os.Exit(1)
+		}
+		return
+	}
+	if *watchConfig {
+		if *configFile == "" {
+			logger.Fatalf("-watch needs -config")
+		}
+		go func() {
+			if err := configs.watch(); err != nil {
+				logger.Printf("ERROR watching %s: %v", *configFile, err)
+			}
+		}()
+	}
+	if len(certStores) > 0 {
+		go func() {
+			if err := watchCerts(); err != nil {
+				logger.Printf("ERROR watching certificates: %v", err)
+			}
+		}()
+	}
+	for _, p := range providers {
+		go discovery.Watch(context.Background(), p, p.interval, func(backends []load_balancer.Backend) {
+			if err := syncBackends(policy, p.Name(), backends, *drainTimeout); err != nil {
+				logger.Printf("ERROR applying backends from %s: %v", p.Name(), err)
+			}
+		}, func(err error) {
+			logger.Printf("ERROR discovering backends from %s: %v", p.Name(), err)
+		})
+	}
+	if *stateFile != "" {
+		if err := load_balancer.LoadState(policy, *stateFile); err != nil {
+			logger.Printf("ERROR restoring state from %s: %v", *stateFile, err)
+		}
+	}
+	if err := inheritSockets(); err != nil {
+		logger.Fatalf("Failed to take over listeners: %v", err)
+	}
+	if err := systemdSockets(); err != nil {
+		logger.Fatalf("Failed to take over systemd sockets: %v", err)
+	}
+	if *adminAddr != "" {
+		ls, err := listen(*adminAddr)
+		if err != nil {
+			logger.Fatalf("Failed to listen on %s: %v", *adminAddr, err)
+		}
+		go serveAdmin(*adminAddr, ls, policy, configs.frontends)
+	}
+	if *metricsAddr != "" {
+		ls, err := listen(*metricsAddr)
+		if err != nil {
+			logger.Fatalf("Failed to listen on %s: %v", *metricsAddr, err)
+		}
+		go serveMetrics(*metricsAddr, ls)
+	}
+	var statsd *metrics.StatsD
+	if *statsdAddr != "" {
+		cfg := metrics.StatsDConfig{
+			Addr:      *statsdAddr,
+			Prefix:    *statsdPrefix,
+			Interval:  *statsdInterval,
+			DogStatsD: *dogstatsd,
+			OnError: func(err error) {
+				logger.Printf("ERROR pushing metrics to %s: %v", *statsdAddr, err)
+			},
+		}
+		if *statsdTags != "" {
+			if !*dogstatsd {
+				logger.Fatalf("-statsd-tags needs -dogstatsd, plain StatsD has no tags")
+			}
+			cfg.Tags = strings.Split(*statsdTags, ",")
+		}
+		var err error
+		if statsd, err = metrics.NewStatsD(registry, cfg); err != nil {
+			logger.Fatalf("Invalid -statsd: %v", err)
+		}
+		logger.Printf("Pushing metrics to StatsD at %s", *statsdAddr)
+	}
+	if *metricsAddr != "" || statsd != nil {
+		registerPoolMetrics(policy, configs.frontends)
+	}
+	if *statsSummary > 0 {
+		latencies = &latencySample{}
+		go summarize(namedPolicies(policy, configs.frontends), *statsSummary)
+	}
+
+	for _, f := range frontends {
+		if err := f.listen(); err != nil {
+			logger.Fatalf("Failed to listen on %s: %v", f.addr, err)
+		}
+	}
+	if listenerTLS != nil {
+		main := frontends[0]
+		for i, l := range main.ls {
+			main.ls[i] = tls.NewListener(helloListener{l}, listenerTLS)
+		}
+		if main.srv != nil {
+			main.srv.ConnContext = withClientConn
+		}
 	}
 	logger.Printf("Listening on %s, policy=%s, backends=%v", listenAddr, *policyName, servers)
+	for _, f := range frontends[1:] {
+		var addrs []string
+		for _, b := range f.pool.Backends() {
+			addrs = append(addrs, b.Address)
+		}
+		logger.Printf("Frontend %s listening on %s, mode=%s, policy=%s, backends=%v", f.name, f.addr, f.mode, f.policy.Name(), addrs)
+	}
 
 	// graceful shutdown setup
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 
-	acceptDone := make(chan struct{})
+	// SIGHUP: reload the config file and certificates, keeping connections in flight
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if *configFile != "" || len(certStores) == 0 {
+				configs.reload()
+			}
+			reloadCerts()
+		}
+	}()
+
+	// SIGUSR1: reopen the log files, e.g. after logrotate moved them
+	usr1 := make(chan os.Signal, 1)
+	notifyReopen(usr1)
+	go func() {
+		for range usr1 {
+			reopenLogs()
+			logger.Printf("Log files reopened")
+		}
+	}()
 
-	// accept loop in goroutine so we can interrupt
+	// SIGUSR2: upgrade, handing the listeners to the binary on disk, then drain
+	usr2 := make(chan os.Signal, 1)
+	notifyUpgrade(usr2)
+	upgraded := make(chan int, 1)
 	go func() {
-		defer close(acceptDone)
-		for {
-			conn, err := l.Accept()
+		for range usr2 {
+			logger.Printf("Upgrade requested, starting %s", os.Args[0])
+			pid, err := upgrade()
 			if err != nil {
-				return
+				logger.Printf("ERROR upgrading: %v", err)
+				continue
 			}
-			// handle connection concurrently
-			go handleClient(conn, policy)
+			upgraded <- pid
+			return
 		}
 	}()
 
+	for _, f := range frontends {
+		f.serve()
+	}
+	takeOver()
+
 	// wait for signal
-	<-sig
-
-	logger.Printf("Graceful shutdown requested. Stopping accepting new connections...")
-	// close listener to stop accept loop
-	_ = l.Close()
-	// wait accept goroutine to finish
-	<-acceptDone
-	logger.Printf("Waiting for active connections to finish...")
-	// wait for active handlers
-	activeWG.Wait()
+	select {
+	case <-sig:
+		logger.Printf("Graceful shutdown requested. Stopping accepting new connections...")
+	case pid := <-upgraded:
+		logger.Printf("Process %d took over the listeners. Stopping accepting new connections...", pid)
+	}
+	// what's still open after -shutdown-timeout, or on a second signal, is closed
+	ctx, cancel := context.WithCancelCause(context.Background())
+	go func() {
+		<-sig
+		cancel(errors.New("second signal received"))
+	}()
+	if *shutdownTimeout > 0 {
+		time.AfterFunc(*shutdownTimeout, func() { cancel(fmt.Errorf("timed out after %v", *shutdownTimeout)) })
+	}
+	// closes the listeners; HTTP servers wait for their requests in flight
+	shutdownAll(ctx, frontends)
+	logger.Printf("Waiting for active connections to finish (signal again to close them now)...")
+	// upgraded HTTP connections and tcp mode handlers, Shutdown doesn't track them
+	finished := make(chan struct{})
+	go func() {
+		activeWG.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		if n := openConns.closeEverything(); n > 0 {
+			logger.Printf("Closed %d connections still open: %v", n, context.Cause(ctx))
+		}
+	}
+	if *stateFile != "" {
+		if err := load_balancer.SaveState(policy, *stateFile); err != nil {
+			logger.Printf("ERROR saving state to %s: %v", *stateFile, err)
+		}
+	}
+	if statsd != nil {
+		statsd.Close()
+	}
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := tracer.Shutdown(flushCtx); err != nil {
+		logger.Printf("ERROR exporting the last spans: %v", err)
+	}
+	flushCancel()
 	logger.Printf("Shutdown complete.")
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// closedAddr returns an address nothing listens on.
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestRecheck(t *testing.T) {
+	saved := recheckInterval
+	t.Cleanup(func() { recheckInterval = saved })
+	recheckInterval = 10 * time.Millisecond
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	up, down := l.Addr().String(), closedAddr(t)
+	policy := load_balancer.NewRoundRobin(load_balancer.NewBackends([]string{up, down}))
+	health := func(backend string) string {
+		for _, b := range policy.Stats().Backends {
+			if b.Address == backend {
+				return b.Health
+			}
+		}
+		return "removed"
+	}
+
+	// a backend answering again is put back in rotation
+	policy.SetHealthy(up, false)
+	done := make(chan struct{})
+	go func() { recheck(up, policy); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("recheck of a backend that answers still running")
+	}
+	if h := health(up); h != "healthy" {
+		t.Errorf("backend that answers is %s, want healthy", h)
+	}
+
+	// one removed while down is given up on
+	policy.SetHealthy(down, false)
+	done = make(chan struct{})
+	go func() { recheck(down, policy); close(done) }()
+	time.Sleep(5 * recheckInterval)
+	select {
+	case <-done:
+		t.Fatal("recheck of a backend still down gave up")
+	default:
+	}
+	if err := policy.RemoveServer(down); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("recheck of a removed backend still running")
+	}
+}
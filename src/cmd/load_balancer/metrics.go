@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"Load-Balancer/pkg/load_balancer"
+	"Load-Balancer/pkg/metrics"
+)
+
+// ---------------- Prometheus metrics ---------------- //
+
+// registry holds the metrics served on -metrics
+var registry = metrics.NewRegistry()
+
+var (
+	clientConns = registry.Counter("lb_client_connections_total",
+		"Client connections accepted, by protocol.", "protocol")
+	activeClientConns = registry.Gauge("lb_client_connections_active",
+		"Client connections open, by protocol.", "protocol")
+	dialErrors = registry.Counter("lb_backend_dial_errors_total",
+		"Failed connection attempts, by backend.", "backend")
+	proxyLatency = registry.Histogram("lb_proxy_latency_seconds",
+		"Latency added by the balancer: from picking a backend until it is connected for TCP, the whole request for HTTP.",
+		metrics.DefBuckets, "protocol")
+)
+
+// histograms per backend, registered by registerBackendHistograms with the
+// buckets of -metrics-dial-buckets and -metrics-duration-buckets
+var dialLatency, connDuration *metrics.HistogramVec
+
+// default buckets of -metrics-dial-buckets and -metrics-duration-buckets
+const (
+	defaultDialBuckets     = "500us,1ms,2.5ms,5ms,10ms,25ms,50ms,100ms,250ms,500ms,1s"
+	defaultDurationBuckets = "10ms,50ms,100ms,500ms,1s,5s,10s,30s,1m,5m,15m,1h"
+)
+
+// registerBackendHistograms registers the histograms of backend dial latency
+// and connection duration, with bucket upper bounds in seconds.
+func registerBackendHistograms(dialBuckets, durationBuckets []float64) {
+	dialLatency = registry.Histogram("lb_backend_dial_seconds",
+		"Time to connect to a backend, by backend.", dialBuckets, "backend")
+	connDuration = registry.Histogram("lb_backend_connection_duration_seconds",
+		"How long connections to a backend lasted, requests in HTTP mode, by backend.", durationBuckets, "backend")
+}
+
+// parseBuckets parses histogram bucket upper bounds given as increasing
+// durations, e.g. 5ms,10ms,1s, into seconds.
+func parseBuckets(v string) ([]float64, error) {
+	var buckets []float64
+	for _, f := range strings.Split(v, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(f))
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 || len(buckets) > 0 && d.Seconds() <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("buckets %q must be positive and increasing", v)
+		}
+		buckets = append(buckets, d.Seconds())
+	}
+	return buckets, nil
+}
+
+// countClient counts a client connection opened over protocol, tcp or http,
+// and returns the function to call when it closes.
+func countClient(protocol string) func() {
+	clientConns.With(protocol).Inc()
+	active := activeClientConns.With(protocol)
+	active.Inc()
+	return active.Dec
+}
+
+// countHTTPClients is the ConnState of HTTP servers: it counts the client
+// connections they accept. Hijacked connections, e.g. upgraded to WebSocket,
+// stay counted until they close.
+func countHTTPClients(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		clientConns.With("http").Inc()
+		activeClientConns.With("http").Inc()
+	case http.StateHijacked, http.StateClosed:
+		activeClientConns.With("http").Dec()
+	}
+}
+
+// namedPolicies names every pool's policy: the main pool "default", the pools
+// routes use by name, -sni-route backends by pattern and the frontends from
+// the config file by frontend name.
+func namedPolicies(main load_balancer.Policy, frontends map[string]*frontend) map[string]load_balancer.Policy {
+	policies := map[string]load_balancer.Policy{"default": main}
+	maps.Copy(policies, pools)
+	named := slices.Collect(maps.Values(pools))
+	for _, route := range hostRoutes {
+		if !slices.Contains(named, route.policy) {
+			policies[route.pattern] = route.policy
+		}
+	}
+	for name, f := range frontends {
+		policies[name] = f.policy
+	}
+	return policies
+}
+
+// registerPoolMetrics exports the counters the policies keep per backend, by
+// pool as namedPolicies names them. They are read when scraped, so they follow
+// policy switches and backend changes.
+func registerPoolMetrics(main load_balancer.Policy, frontends map[string]*frontend) {
+	policies := namedPolicies(main, frontends)
+	names := slices.Sorted(maps.Keys(policies))
+	each := func(f func(pool string, stats load_balancer.PolicyStats, b load_balancer.BackendStats)) {
+		for _, name := range names {
+			stats := policies[name].Stats()
+			for _, b := range stats.Backends {
+				f(name, stats, b)
+			}
+		}
+	}
+	labels := []string{"pool", "backend"}
+	registry.Func("lb_backend_connections_active", "Connections or requests open to a backend.", metrics.GaugeType, labels,
+		func(emit func(float64, ...string)) {
+			each(func(pool string, _ load_balancer.PolicyStats, b load_balancer.BackendStats) {
+				emit(float64(b.Active), pool, b.Address)
+			})
+		})
+	registry.Func("lb_backend_selections_total", "Times the policy picked a backend.", metrics.CounterType,
+		[]string{"pool", "policy", "backend"}, func(emit func(float64, ...string)) {
+			each(func(pool string, stats load_balancer.PolicyStats, b load_balancer.BackendStats) {
+				emit(float64(b.Selected), pool, stats.Policy, b.Address)
+			})
+		})
+	registry.Func("lb_backend_failures_total", "Connections or requests to a backend that failed.", metrics.CounterType, labels,
+		func(emit func(float64, ...string)) {
+			each(func(pool string, _ load_balancer.PolicyStats, b load_balancer.BackendStats) {
+				emit(float64(b.Failures), pool, b.Address)
+			})
+		})
+	direction := []string{"pool", "backend", "direction"}
+	registry.Func("lb_backend_bytes_total", "Bytes proxied, by direction: sent to the backend or received from it.", metrics.CounterType, direction,
+		func(emit func(float64, ...string)) {
+			each(func(pool string, _ load_balancer.PolicyStats, b load_balancer.BackendStats) {
+				emit(float64(b.BytesSent), pool, b.Address, "sent")
+				emit(float64(b.BytesReceived), pool, b.Address, "received")
+			})
+		})
+	registry.Func("lb_backend_copy_errors_total", "Connections whose copy to or from a backend broke off, by direction.", metrics.CounterType, direction,
+		func(emit func(float64, ...string)) {
+			each(func(pool string, _ load_balancer.PolicyStats, b load_balancer.BackendStats) {
+				emit(float64(b.SendErrors), pool, b.Address, "sent")
+				emit(float64(b.RecvErrors), pool, b.Address, "received")
+			})
+		})
+	registry.Func("lb_backend_healthy", "Whether a backend is usable, 1, or marked unhealthy, 0.", metrics.GaugeType, labels,
+		func(emit func(float64, ...string)) {
+			each(func(pool string, _ load_balancer.PolicyStats, b load_balancer.BackendStats) {
+				healthy := 1.0
+				if b.Health == load_balancer.Unhealthy.String() {
+					healthy = 0
+				}
+				emit(healthy, pool, b.Address)
+			})
+		})
+}
+
+// serveMetrics serves the metrics on /metrics over ls, opened on addr. It
+// only returns once they fail or are closed.
+func serveMetrics(addr string, ls []net.Listener) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", registry.Handler())
+	logger.Printf("Metrics listening on %s", addr)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	for _, l := range ls[1:] {
+		go srv.Serve(l)
+	}
+	if err := srv.Serve(ls[0]); err != nil && !errors.Is(err, net.ErrClosed) {
+		logger.Printf("ERROR metrics on %s: %v", addr, err)
+	}
+}
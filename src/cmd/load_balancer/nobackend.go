@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ---------------- No backend available ---------------- //
+
+// sent to tcp mode clients no backend can take before they're closed, see
+// -no-backend-banner; nil closes them without a word
+var noBackendBanner []byte
+
+// longest a client may take to accept the banner
+const noBackendWriteTimeout = time.Second
+
+// turnedAway counts the clients and requests no backend could take, as served
+// on GET /unavailable.
+var turnedAway struct {
+	conns, requests atomic.Uint64
+}
+
+// unavailableStats is turnedAway's counters.
+type unavailableStats struct {
+	Connections uint64 `json:"connections"` // tcp mode clients closed
+	Requests    uint64 `json:"requests"`    // HTTP and gRPC requests answered with 503
+}
+
+func turnedAwayStats() unavailableStats {
+	return unavailableStats{Connections: turnedAway.conns.Load(), Requests: turnedAway.requests.Load()}
+}
+
+// parseBanner reads -no-backend-banner, which takes Go escapes so it can
+// hold any bytes: "421 Service not available\r\n".
+func parseBanner(v string) ([]byte, error) {
+	s, err := strconv.Unquote(`"` + v + `"`)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s), nil
+}
+
+// noBackend turns away a tcp mode client no backend can take: it's counted,
+// logged and sent the banner, if any, before the caller closes it.
+func noBackend(clog connLog, conn net.Conn, reason string) {
+	turnedAway.conns.Add(1)
+	clog.Printf("No backend for client %s: %s error=%s", clientLabel(conn.RemoteAddr().String()), reason, countError(kindNoBackend, ""))
+	if noBackendBanner != nil {
+		conn.SetWriteDeadline(time.Now().Add(noBackendWriteTimeout))
+		conn.Write(noBackendBanner)
+	}
+}
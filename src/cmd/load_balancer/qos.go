@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ---------------- QoS classes ---------------- //
+
+// priority is the class of a client connection under -max-clients: higher
+// classes are admitted first and lower ones shed first.
+type priority int
+
+const (
+	priorityLow priority = iota
+	priorityNormal
+	priorityHigh
+	numPriorities
+)
+
+var priorityNames = [...]string{"low", "normal", "high"}
+
+func (p priority) String() string { return priorityNames[p] }
+
+func parsePriority(v string) (priority, error) {
+	for p, name := range priorityNames {
+		if v == name {
+			return priority(p), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid priority %q, want high, normal or low", v)
+}
+
+// qosRules class connections, first match wins; others are normal. See
+// -qos-class.
+var qosRules []qosRule
+
+// qosRule classes the connections from some networks, for some server names
+// or on some listener.
+type qosRule struct {
+	nets     []netip.Prefix
+	names    []string // server names, *.example.com matches one label
+	listener string   // local address, host:port or :port for any host
+	prio     priority
+}
+
+// matches reports whether a connection from ip to local, for server name sni
+// ("" if not known), is in r's class.
+func (r qosRule) matches(ip netip.Addr, local, sni string) bool {
+	switch {
+	case r.nets != nil:
+		for _, n := range r.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	case r.names != nil:
+		for _, pattern := range r.names {
+			if sni != "" && matchHost(pattern, sni) {
+				return true
+			}
+		}
+	default:
+		host, port, _ := net.SplitHostPort(local)
+		want, wantPort, _ := net.SplitHostPort(r.listener)
+		return port == wantPort && (want == "" || want == host)
+	}
+	return false
+}
+
+// qosClass returns the class of conn, a client connection asking for server
+// name sni, "" if not known.
+func qosClass(conn net.Conn, sni string) priority {
+	if len(qosRules) == 0 {
+		return priorityNormal
+	}
+	ip := netip.Addr{}
+	if ap, err := netip.ParseAddrPort(conn.RemoteAddr().String()); err == nil {
+		ip = ap.Addr().Unmap()
+	}
+	local := conn.LocalAddr().String()
+	for _, r := range qosRules {
+		if r.matches(ip, local, sni) {
+			return r.prio
+		}
+	}
+	return priorityNormal
+}
+
+// qosBySNI reports whether a rule classes connections by server name.
+func qosBySNI() bool {
+	for _, r := range qosRules {
+		if r.names != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// parseQoSRule parses cidr:10.0.0.0/8,192.168.0.0/16=high,
+// sni:api.example.com,*.internal=high or listener::8443=low.
+func parseQoSRule(v string) (qosRule, error) {
+	kind, rest, ok := strings.Cut(v, ":")
+	i := strings.LastIndex(rest, "=")
+	if !ok || i <= 0 {
+		return qosRule{}, fmt.Errorf("invalid class %q, want cidr:, sni: or listener: matches=priority", v)
+	}
+	prio, err := parsePriority(rest[i+1:])
+	if err != nil {
+		return qosRule{}, err
+	}
+	r := qosRule{prio: prio}
+	match := rest[:i]
+	switch kind {
+	case "cidr":
+		for _, c := range strings.Split(match, ",") {
+			n, err := netip.ParsePrefix(strings.TrimSpace(c))
+			if err != nil {
+				return qosRule{}, fmt.Errorf("class %q: %v", v, err)
+			}
+			r.nets = append(r.nets, n.Masked())
+		}
+	case "sni":
+		for _, name := range strings.Split(match, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || (strings.HasPrefix(name, "*") && !strings.HasPrefix(name, "*.")) {
+				return qosRule{}, fmt.Errorf("class %q: invalid server name %q", v, name)
+			}
+			r.names = append(r.names, name)
+		}
+	case "listener":
+		if _, _, err := net.SplitHostPort(match); err != nil {
+			return qosRule{}, fmt.Errorf("class %q: %v", v, err)
+		}
+		r.listener = match
+	default:
+		return qosRule{}, fmt.Errorf("invalid class %q, want cidr:, sni: or listener: matches=priority", v)
+	}
+	return r, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------- HTTP retries ---------------- //
+
+// request bodies up to this size are kept in memory so the request can be
+// retried; larger ones get a single try
+const httpRetryBodyLimit = 64 << 10
+
+// retries that can be taken at once, before requests have paid into the budget
+const retryBudgetBurst = 10
+
+// errRetryStatus is the error of a try the backend answered with a status worth
+// retrying.
+var errRetryStatus = errors.New("retryable status")
+
+// retryPolicy decides which failed HTTP tries are retried on another backend,
+// see -http-retries.
+type retryPolicy struct {
+	retries    int             // tries after the first, 0 for none
+	methods    map[string]bool // idempotent methods, retried after a response or a broken connection
+	statuses   map[int]bool    // responses worth retrying
+	tryTimeout time.Duration   // for each try, 0 for none
+	budget     *retryBudget
+}
+
+// buffer reads r's body into memory if it is small enough to send again, and
+// reports whether r can be retried at all.
+func (p retryPolicy) buffer(r *http.Request) ([]byte, bool) {
+	if p.retries <= 0 {
+		return nil, false
+	}
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return nil, true
+	}
+	if r.ContentLength < 0 || r.ContentLength > httpRetryBodyLimit {
+		return nil, false
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, httpRetryBodyLimit))
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, err == nil
+}
+
+// retryResponse reports whether a response to method with status may be retried.
+func (p retryPolicy) retryResponse(method string, status int) bool {
+	return p.methods[method] && p.statuses[status] && p.budget.take()
+}
+
+// retryError reports whether a try of method that failed with err may be
+// retried. A request that never reached a backend can always be; others only
+// if the method is idempotent.
+func (p retryPolicy) retryError(method string, err error) bool {
+	if errors.Is(err, context.Canceled) { // the client is gone
+		return false
+	}
+	return (dialFailed(err) || p.methods[method]) && p.budget.take()
+}
+
+// retryBudget caps retries at a share of requests, so retries don't multiply
+// the load on backends that are already failing. A nil *retryBudget allows every
+// retry.
+type retryBudget struct {
+	ratio float64 // retries allowed per request
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+func newRetryBudget(percent float64) *retryBudget {
+	if percent <= 0 {
+		return nil
+	}
+	return &retryBudget{ratio: percent / 100, tokens: retryBudgetBurst}
+}
+
+// fresh returns a budget with b's ratio and none of its spending, for the
+// backends of another frontend.
+func (b *retryBudget) fresh() *retryBudget {
+	if b == nil {
+		return nil
+	}
+	return &retryBudget{ratio: b.ratio, tokens: retryBudgetBurst}
+}
+
+// request pays a request's share into the budget.
+func (b *retryBudget) request() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+b.ratio, retryBudgetBurst)
+}
+
+// take takes a retry from the budget if there is one left.
+func (b *retryBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1-1e-9 { // ten requests at 10% add up to 0.999...
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// parseMethods parses a comma-separated list of HTTP methods.
+func parseMethods(v string) map[string]bool {
+	methods := make(map[string]bool)
+	for _, m := range strings.Split(v, ",") {
+		if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+			methods[m] = true
+		}
+	}
+	return methods
+}
+
+// parseStatuses parses a comma-separated list of HTTP status codes.
+func parseStatuses(v string) (map[int]bool, error) {
+	statuses := make(map[int]bool)
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid status code %q", s)
+		}
+		statuses[code] = true
+	}
+	return statuses, nil
+}
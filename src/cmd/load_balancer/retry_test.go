@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestRetryBudget(t *testing.T) {
+	if newRetryBudget(0) != nil {
+		t.Fatal("a budget of 0% limits retries, want none")
+	}
+	var unlimited *retryBudget
+	for range 2 * retryBudgetBurst {
+		if !unlimited.take() {
+			t.Fatal("no budget refused a retry")
+		}
+	}
+
+	b := newRetryBudget(20)
+	// before any request, a burst of retries is allowed
+	for i := range retryBudgetBurst {
+		if !b.take() {
+			t.Fatalf("retry %d of the first %d refused", i+1, retryBudgetBurst)
+		}
+	}
+	if b.take() {
+		t.Fatal("retry beyond the burst allowed with no requests paid in")
+	}
+
+	// at 20%, five requests pay for a retry, four don't
+	for range 4 {
+		b.request()
+	}
+	if b.take() {
+		t.Error("retry allowed after 4 requests at 20%")
+	}
+	b.request()
+	if !b.take() {
+		t.Error("retry refused after 5 requests at 20%")
+	}
+
+	// the budget saves up no more than the burst
+	for range 1000 {
+		b.request()
+	}
+	taken := 0
+	for b.take() {
+		taken++
+	}
+	if taken != retryBudgetBurst {
+		t.Errorf("took %d retries after 1000 requests, want the burst of %d", taken, retryBudgetBurst)
+	}
+
+	// a fresh budget keeps the ratio, not the spending
+	f := b.fresh()
+	if f.ratio != b.ratio || !f.take() {
+		t.Errorf("fresh budget %+v, want ratio %v with retries left", f, b.ratio)
+	}
+}
+
+func TestRetryRefused(t *testing.T) {
+	statuses, err := parseStatuses("502,503")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := retryPolicy{retries: 2, methods: parseMethods("GET, head"), statuses: statuses, budget: newRetryBudget(10)}
+	dialErr := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+
+	tests := []struct {
+		name  string
+		retry func() bool
+		want  bool
+	}{
+		{"idempotent method, retryable status", func() bool { return p.retryResponse("GET", 503) }, true},
+		{"method given in lower case", func() bool { return p.retryResponse("HEAD", 502) }, true},
+		{"status not retryable", func() bool { return p.retryResponse("GET", 500) }, false},
+		{"method not idempotent", func() bool { return p.retryResponse("POST", 503) }, false},
+		{"connection broken mid-request", func() bool { return p.retryError("GET", io.ErrUnexpectedEOF) }, true},
+		{"not idempotent, broken mid-request", func() bool { return p.retryError("POST", io.ErrUnexpectedEOF) }, false},
+		{"not idempotent, never reached a backend", func() bool { return p.retryError("POST", dialErr) }, true},
+		{"client gone", func() bool { return p.retryError("GET", context.Canceled) }, false},
+	}
+	for _, tt := range tests {
+		if got := tt.retry(); got != tt.want {
+			t.Errorf("%s: retried %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// once the budget is spent, even retryable tries are refused
+	for p.budget.take() {
+	}
+	if p.retryResponse("GET", 503) || p.retryError("POST", dialErr) {
+		t.Error("retry allowed with the budget spent")
+	}
+	for range 10 {
+		p.budget.request()
+	}
+	if !p.retryResponse("GET", 503) {
+		t.Error("retry refused once 10 requests at 10% paid for one")
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"syscall"
+	"golang.org/x/sys/unix"
+)
+
+// ---------------- SO_REUSEPORT ---------------- //
+
+// setReusePort sets SO_REUSEPORT on a listening socket before it is bound, so
+// several sockets can share an address and the kernel spreads connections
+// over them.
+func setReusePort(network, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
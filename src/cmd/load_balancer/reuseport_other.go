@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// ---------------- SO_REUSEPORT ---------------- //
+
+// setReusePort fails: -reuseport is only supported on Linux, where the kernel
+// balances connections over the sockets sharing an address.
+func setReusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("-reuseport is only supported on Linux")
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+	"Load-Balancer/pkg/geoip"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// ---------------- Host routing ---------------- //
+
+// hostRoute sends connections or requests for matching host names to a pool of
+// its own.
+type hostRoute struct {
+	pattern string // "api.example.com", or "*.example.com" for any one label in front
+	policy  load_balancer.Policy
+}
+
+// hostRouter picks a route by host name, first match wins.
+type hostRouter []hostRoute
+
+// hostRoutes are the routes by TLS server name, see -sni-route
+var hostRoutes hostRouter
+
+// httpRoutes are the routes by Host header in HTTP mode, see -http-route
+var httpRoutes hostRouter
+
+// httpPathRoutes are the routes by path prefix in HTTP mode, see -http-path-route
+var httpPathRoutes pathRouter
+
+// httpMatchRoutes are the routes by header or cookie in HTTP mode, see
+// -http-header-route and -http-cookie-route
+var httpMatchRoutes matchRouter
+
+// geoRoutes are the routes by client location, see -geo-route
+var geoRoutes geoRouter
+
+// pools are the named backend pools routes can send traffic to, see -pool
+var pools = make(map[string]load_balancer.Policy)
+
+// match returns the policy of the first route matching host, or def.
+func (r hostRouter) match(host string, def load_balancer.Policy) load_balancer.Policy {
+	for _, route := range r {
+		if matchHost(route.pattern, host) {
+			return route.policy
+		}
+	}
+	return def
+}
+
+// matchHost reports whether host matches pattern, ignoring case.
+func matchHost(pattern, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(strings.TrimSuffix(host, "."))
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		label, found := strings.CutSuffix(host, suffix)
+		return found && label != "" && !strings.Contains(label, ".")
+	}
+	return pattern == host
+}
+
+// parseHostRoute parses pattern=host:port,host:port.
+func parseHostRoute(v string) (string, []string, error) {
+	pattern, servers, ok := strings.Cut(v, "=")
+	if !ok || pattern == "" || servers == "" {
+		return "", nil, fmt.Errorf("invalid route %q, want pattern=host:port[,host:port...]", v)
+	}
+	if strings.HasPrefix(pattern, "*") && !strings.HasPrefix(pattern, "*.") {
+		return "", nil, fmt.Errorf("invalid pattern %q, wildcards cover a whole label: *.example.com", pattern)
+	}
+	return pattern, strings.Split(servers, ","), nil
+}
+
+// pathRoute sends requests under a path prefix to a pool of its own.
+type pathRoute struct {
+	prefix string // "/api" matches /api and /api/..., not /apis
+	policy load_balancer.Policy
+	strip  bool // remove the prefix before forwarding
+}
+
+// pathRouter picks a route by request path, longest prefix wins.
+type pathRouter []pathRoute
+
+// match returns the route with the longest prefix matching path.
+func (r pathRouter) match(path string) (pathRoute, bool) {
+	var best pathRoute
+	found := false
+	for _, route := range r {
+		if matchPrefix(route.prefix, path) && (!found || len(route.prefix) > len(best.prefix)) {
+			best, found = route, true
+		}
+	}
+	return best, found
+}
+
+// matchPrefix reports whether path is prefix or below it.
+func matchPrefix(prefix, path string) bool {
+	rest, ok := strings.CutPrefix(path, prefix)
+	return ok && (rest == "" || rest[0] == '/' || strings.HasSuffix(prefix, "/"))
+}
+
+// stripPrefix removes prefix from path, keeping it absolute.
+func stripPrefix(prefix, path string) string {
+	path = strings.TrimPrefix(path, strings.TrimSuffix(prefix, "/"))
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// matchRoute sends requests with a matching header or cookie to a pool of its own.
+type matchRoute struct {
+	cookie bool // match a cookie rather than a header
+	name   string
+	value  string         // exact value, if re is nil
+	re     *regexp.Regexp // unanchored, like grep
+	policy load_balancer.Policy
+}
+
+// matches reports whether r has the header or cookie, with a matching value.
+func (m matchRoute) matches(r *http.Request) bool {
+	var values []string
+	if m.cookie {
+		for _, c := range r.CookiesNamed(m.name) {
+			values = append(values, c.Value)
+		}
+	} else {
+		values = r.Header.Values(m.name)
+	}
+	for _, v := range values {
+		if m.re != nil && m.re.MatchString(v) || m.re == nil && v == m.value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRouter picks a route by header or cookie, first match wins.
+type matchRouter []matchRoute
+
+// match returns the policy of the first route matching r.
+func (rs matchRouter) match(r *http.Request) (load_balancer.Policy, bool) {
+	for _, route := range rs {
+		if route.matches(r) {
+			return route.policy, true
+		}
+	}
+	return nil, false
+}
+
+// geoDB locates clients for geo routes and logs, see -geoip; nil if not loaded
+var geoDB *geoip.DB
+
+// locate returns where the client at addr, host:port or an IP, is; the zero
+// Location without -geoip or if the database doesn't know it.
+func locate(addr string) geoip.Location {
+	if geoDB == nil {
+		return geoip.Location{}
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return geoip.Location{}
+	}
+	loc, err := geoDB.Lookup(ip)
+	if err != nil {
+		logger.Printf("ERROR locating client %s: %v", addr, err)
+	}
+	return loc
+}
+
+// clientLabel returns addr for logs, with the client's location if -geoip is
+// set, e.g. "203.0.113.7:51234 [DE/EU]".
+func clientLabel(addr string) string {
+	if geoDB == nil {
+		return addr
+	}
+	return addr + " [" + locate(addr).String() + "]"
+}
+
+// geoRoute sends clients from some countries or continents to a pool of their own.
+type geoRoute struct {
+	continent bool     // codes are continents rather than countries
+	codes     []string // upper case, e.g. "DE" or "EU"
+	policy    load_balancer.Policy
+}
+
+// geoRouter picks a route by client location, first match wins.
+type geoRouter []geoRoute
+
+// match returns the policy of the first route matching the client at addr.
+func (rs geoRouter) match(addr string) (load_balancer.Policy, bool) {
+	if len(rs) == 0 {
+		return nil, false
+	}
+	loc := locate(addr)
+	for _, route := range rs {
+		code := loc.Country
+		if route.continent {
+			code = loc.Continent
+		}
+		if code != "" && slices.Contains(route.codes, code) {
+			return route.policy, true
+		}
+	}
+	return nil, false
+}
+
+// parseGeoRoute parses country:DE,FR=pool or continent:EU,AF=pool.
+func parseGeoRoute(v string) (geoRoute, error) {
+	rule, name, ok := strings.Cut(v, "=")
+	kind, codes, _ := strings.Cut(rule, ":")
+	if !ok || name == "" || codes == "" || (kind != "country" && kind != "continent") {
+		return geoRoute{}, fmt.Errorf("invalid route %q, want country:DE[,FR...]=pool or continent:EU[,AF...]=pool", v)
+	}
+	route := geoRoute{continent: kind == "continent"}
+	for _, c := range strings.Split(codes, ",") {
+		if c = strings.ToUpper(strings.TrimSpace(c)); len(c) != 2 {
+			return geoRoute{}, fmt.Errorf("route %q: %q is not a two-letter code", v, c)
+		}
+		route.codes = append(route.codes, c)
+	}
+	policy, ok := pools[name]
+	if !ok {
+		return geoRoute{}, fmt.Errorf("route %q: no pool named %q, see -pool", v, name)
+	}
+	route.policy = policy
+	return route, nil
+}
+
+// parsePool parses name=host:port,host:port.
+func parsePool(v string) (string, []string, error) {
+	name, servers, ok := strings.Cut(v, "=")
+	if !ok || name == "" || servers == "" {
+		return "", nil, fmt.Errorf("invalid pool %q, want name=host:port[,host:port...]", v)
+	}
+	return name, strings.Split(servers, ","), nil
+}
+
+// parsePoolRoute parses pattern=pool, the pool given by name.
+func parsePoolRoute(v string) (string, load_balancer.Policy, error) {
+	pattern, name, ok := strings.Cut(v, "=")
+	if !ok || pattern == "" || name == "" {
+		return "", nil, fmt.Errorf("invalid route %q, want pattern=pool", v)
+	}
+	if strings.HasPrefix(pattern, "*") && !strings.HasPrefix(pattern, "*.") {
+		return "", nil, fmt.Errorf("invalid pattern %q, wildcards cover a whole label: *.example.com", pattern)
+	}
+	policy, ok := pools[name]
+	if !ok {
+		return "", nil, fmt.Errorf("route %q: no pool named %q, see -pool", v, name)
+	}
+	return pattern, policy, nil
+}
+
+// parsePathRoute parses /prefix=pool[,strip].
+func parsePathRoute(v string) (pathRoute, error) {
+	prefix, target, ok := strings.Cut(v, "=")
+	name, option, _ := strings.Cut(target, ",")
+	if !ok || !strings.HasPrefix(prefix, "/") || name == "" || (option != "" && option != "strip") {
+		return pathRoute{}, fmt.Errorf("invalid route %q, want /prefix=pool[,strip]", v)
+	}
+	policy, ok := pools[name]
+	if !ok {
+		return pathRoute{}, fmt.Errorf("route %q: no pool named %q, see -pool", v, name)
+	}
+	return pathRoute{prefix: prefix, policy: policy, strip: option == "strip"}, nil
+}
+
+// parseMatchRoute parses Name:value=pool for an exact value or Name~regexp=pool,
+// the name of a header or, if cookie, a cookie.
+func parseMatchRoute(v string, cookie bool) (matchRoute, error) {
+	i := strings.LastIndex(v, "=")
+	if i < 0 {
+		return matchRoute{}, fmt.Errorf("invalid route %q, want Name:value=pool or Name~regexp=pool", v)
+	}
+	rule, name := v[:i], v[i+1:]
+	route := matchRoute{cookie: cookie}
+	j := strings.IndexAny(rule, ":~")
+	if j <= 0 || name == "" {
+		return matchRoute{}, fmt.Errorf("invalid route %q, want Name:value=pool or Name~regexp=pool", v)
+	}
+	route.name = strings.TrimSpace(rule[:j])
+	if !cookie {
+		route.name = http.CanonicalHeaderKey(route.name)
+	}
+	if rule[j] == '~' {
+		re, err := regexp.Compile(rule[j+1:])
+		if err != nil {
+			return matchRoute{}, fmt.Errorf("route %q: %w", v, err)
+		}
+		route.re = re
+	} else {
+		route.value = strings.TrimSpace(rule[j+1:])
+	}
+	policy, ok := pools[name]
+	if !ok {
+		return matchRoute{}, fmt.Errorf("route %q: no pool named %q, see -pool", v, name)
+	}
+	route.policy = policy
+	return route, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// withPools sets the named pools for the test, each a policy of its own, and
+// clears the HTTP routes.
+func withPools(t *testing.T, names ...string) map[string]load_balancer.Policy {
+	t.Helper()
+	savedPools, savedPath, savedHost, savedMatch := pools, httpPathRoutes, httpRoutes, httpMatchRoutes
+	t.Cleanup(func() {
+		pools, httpPathRoutes, httpRoutes, httpMatchRoutes = savedPools, savedPath, savedHost, savedMatch
+	})
+	pools = make(map[string]load_balancer.Policy)
+	for _, name := range names {
+		pools[name] = load_balancer.NewRoundRobin(load_balancer.NewBackends([]string{name + ":80"}))
+	}
+	httpPathRoutes, httpRoutes, httpMatchRoutes = nil, nil, nil
+	return pools
+}
+
+func TestPathRoutes(t *testing.T) {
+	withPools(t, "api", "v2", "static")
+	for _, v := range []string{"/api=api", "/api/v2=v2,strip", "/static/=static"} {
+		route, err := parsePathRoute(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpPathRoutes = append(httpPathRoutes, route)
+	}
+	tests := []struct {
+		path, prefix string // prefix of the route matched, "" for none
+	}{
+		{"/api", "/api"},
+		{"/api/users", "/api"},
+		{"/apis", ""},
+		{"/api/v2", "/api/v2"}, // longest prefix wins
+		{"/api/v2/users", "/api/v2"},
+		{"/api/v20", "/api"},
+		{"/static/app.js", "/static/"},
+		{"/static", ""},
+		{"/", ""},
+	}
+	for _, tt := range tests {
+		route, ok := httpPathRoutes.match(tt.path)
+		if ok != (tt.prefix != "") || route.prefix != tt.prefix {
+			t.Errorf("%s: matched %q (%v), want %q", tt.path, route.prefix, ok, tt.prefix)
+		}
+	}
+
+	for _, tt := range []struct{ prefix, path, want string }{
+		{"/api/v2", "/api/v2/users", "/users"},
+		{"/api/v2", "/api/v2", "/"},
+		{"/static/", "/static/app.js", "/app.js"},
+	} {
+		if got := stripPrefix(tt.prefix, tt.path); got != tt.want {
+			t.Errorf("stripPrefix(%q, %q) = %q, want %q", tt.prefix, tt.path, got, tt.want)
+		}
+	}
+
+	for _, v := range []string{"api=api", "/api=", "/api=nope", "/api=api,keep"} {
+		if _, err := parsePathRoute(v); err == nil {
+			t.Errorf("parsePathRoute(%q) succeeded", v)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	p := withPools(t, "api", "v2", "web", "beta")
+	for _, v := range []string{"/api=api", "/api/v2=v2,strip"} {
+		route, err := parsePathRoute(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpPathRoutes = append(httpPathRoutes, route)
+	}
+	httpRoutes = hostRouter{{pattern: "*.example.com", policy: p["web"]}}
+	for _, v := range []struct {
+		rule   string
+		cookie bool
+	}{{"X-Beta:1=beta", false}, {"canary~^(on|yes)$=beta", true}} {
+		route, err := parseMatchRoute(v.rule, v.cookie)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpMatchRoutes = append(httpMatchRoutes, route)
+	}
+	fallback := load_balancer.NewRoundRobin(load_balancer.NewBackends([]string{"default:80"}))
+	h := &httpProxy{httpOptions: httpOptions{routed: true}, policy: fallback}
+	name := map[load_balancer.Policy]string{fallback: "default"}
+	for n, policy := range p {
+		name[policy] = n
+	}
+
+	tests := []struct {
+		name, url, beta string // beta: X-Beta's value, or cookie=value
+		want            load_balancer.Policy
+		strip           string
+	}{
+		{"no route matches: the default pool", "http://other.org/", "", fallback, ""},
+		{"path", "http://other.org/api/users", "", p["api"], ""},
+		{"longer path, stripped", "http://other.org/api/v2/users", "", p["v2"], "/api/v2"},
+		{"host", "http://www.example.com/", "", p["web"], ""},
+		{"path before host", "http://www.example.com/api", "", p["api"], ""},
+		{"header before path", "http://other.org/api", "1", p["beta"], ""},
+		{"header value not matching", "http://other.org/", "0", fallback, ""},
+		{"cookie before path", "http://other.org/api/v2/x", "canary=yes", p["beta"], ""},
+		{"cookie before host", "http://www.example.com/", "canary=on", p["beta"], ""},
+		{"cookie not matching the regexp", "http://www.example.com/", "canary=yesterday", p["web"], ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.url, nil)
+		if cookie, value, ok := strings.Cut(tt.beta, "="); ok {
+			r.AddCookie(&http.Cookie{Name: cookie, Value: value})
+		} else if tt.beta != "" {
+			r.Header.Set("X-Beta", tt.beta)
+		}
+		policy, strip := h.route(r)
+		if policy != tt.want || strip != tt.strip {
+			t.Errorf("%s: got pool %s, strip %q; want %s, strip %q", tt.name, name[policy], strip, name[tt.want], tt.strip)
+		}
+	}
+
+	// without routing, e.g. a frontend of its own, every request goes to its pool
+	h.routed = false
+	if policy, _ := h.route(httptest.NewRequest("GET", "http://other.org/api", nil)); policy != fallback {
+		t.Errorf("unrouted proxy picked pool %s", name[policy])
+	}
+}
+
+func TestMatchRoutes(t *testing.T) {
+	p := withPools(t, "v2", "beta", "mobile", "internal")
+	name := make(map[load_balancer.Policy]string)
+	for n, policy := range p {
+		name[policy] = n
+	}
+	for _, v := range []struct {
+		rule   string
+		cookie bool
+	}{
+		{"x-api-version~^v2(\\.|$)=v2", false}, // names are canonical, X-Api-Version
+		{"beta:yes=beta", true},
+		{"User-Agent~(?i)android|iphone=mobile", false},
+		{"X-Team: core =internal", false},   // the value trimmed
+		{"X-Api-Version~.=internal", false}, // after v2, any other version
+	} {
+		route, err := parseMatchRoute(v.rule, v.cookie)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpMatchRoutes = append(httpMatchRoutes, route)
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string][]string
+		cookies []*http.Cookie
+		want    string // "" for none
+	}{
+		{"no header", nil, nil, ""},
+		{"regexp", map[string][]string{"X-Api-Version": {"v2.1"}}, nil, "v2"},
+		{"regexp anchored by its own ^ and $", map[string][]string{"X-Api-Version": {"v20"}}, nil, "internal"},
+		{"any of a header's values", map[string][]string{"X-Api-Version": {"v1", "v2"}}, nil, "v2"},
+		{"exact value", map[string][]string{"X-Team": {"core"}}, nil, "internal"},
+		{"exact value, not a prefix", map[string][]string{"X-Team": {"core-2"}}, nil, ""},
+		{"regexp, unanchored", map[string][]string{"User-Agent": {"Mozilla/5.0 (Linux; Android 14)"}}, nil, "mobile"},
+		{"cookie", nil, []*http.Cookie{{Name: "beta", Value: "yes"}}, "beta"},
+		{"cookie value", nil, []*http.Cookie{{Name: "beta", Value: "no"}}, ""},
+		{"cookie names are case sensitive", nil, []*http.Cookie{{Name: "Beta", Value: "yes"}}, ""},
+		{"header named as the cookie", map[string][]string{"Beta": {"yes"}}, nil, ""},
+		{"first match wins", map[string][]string{"X-Api-Version": {"v2"}, "User-Agent": {"iPhone"}},
+			[]*http.Cookie{{Name: "beta", Value: "yes"}}, "v2"},
+		{"cookie before a later header", map[string][]string{"User-Agent": {"iPhone"}},
+			[]*http.Cookie{{Name: "beta", Value: "yes"}}, "beta"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		for k, v := range tt.headers {
+			r.Header[k] = v
+		}
+		for _, c := range tt.cookies {
+			r.AddCookie(c)
+		}
+		policy, ok := httpMatchRoutes.match(r)
+		if got := name[policy]; got != tt.want || ok != (tt.want != "") {
+			t.Errorf("%s: routed to %q (%v), want %q", tt.name, got, ok, tt.want)
+		}
+	}
+
+	for _, v := range []string{"X-Beta", "X-Beta:1", "X-Beta:1=", ":1=beta", "~x=beta", "X-Beta~[=beta", "X-Beta:1=nope"} {
+		if _, err := parseMatchRoute(v, false); err == nil {
+			t.Errorf("parseMatchRoute(%q) succeeded", v)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// ---------------- Protocol sniffing ---------------- //
+
+// how long a sniffing frontend waits for a client's first bytes, see -sniff-timeout
+var sniffTimeout = time.Second
+
+// pools for TLS and other connections on a sniffing frontend, see -sniff-tls
+// and -sniff-tcp; nil for the frontend's own
+var sniffTLSPolicy, sniffTCPPolicy load_balancer.Policy
+
+// protocol is what a client's first bytes look like.
+type protocol int
+
+const (
+	protoUnknown protocol = iota // not enough bytes to tell yet
+	protoTLS
+	protoHTTP
+	protoTCP
+)
+
+func (p protocol) String() string {
+	return [...]string{"unknown", "TLS", "HTTP", "TCP"}[p]
+}
+
+// httpPreambles start HTTP/1 requests, and the HTTP/2 prior knowledge preface.
+var httpPreambles = [][]byte{
+	[]byte("GET "), []byte("HEAD "), []byte("POST "), []byte("PUT "), []byte("DELETE "),
+	[]byte("OPTIONS "), []byte("PATCH "), []byte("CONNECT "), []byte("TRACE "),
+	[]byte("PRI * HTTP/2"),
+}
+
+// classify tells the protocol from the first bytes of a connection.
+func classify(b []byte) protocol {
+	if len(b) == 0 {
+		return protoUnknown
+	}
+	if b[0] == 0x16 { // TLS handshake record
+		return protoTLS
+	}
+	maybe := false
+	for _, p := range httpPreambles {
+		if bytes.HasPrefix(b, p) {
+			return protoHTTP
+		}
+		maybe = maybe || bytes.HasPrefix(p, b)
+	}
+	if maybe {
+		return protoUnknown
+	}
+	return protoTCP
+}
+
+// sniff reads the first bytes of conn to tell its protocol, and returns a
+// connection that reads them again. A client that sends nothing within timeout
+// is taken for one of the protocols where the server speaks first, e.g. SMTP,
+// and is TCP.
+func sniff(conn net.Conn, timeout time.Duration) (protocol, net.Conn, error) {
+	buf := make([]byte, 0, 16) // longer than any preamble
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	proto := protoUnknown
+	for proto == protoUnknown && len(buf) < cap(buf) {
+		n, err := conn.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if proto = classify(buf); proto != protoUnknown {
+			break
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) || (err != nil && len(buf) > 0) {
+			proto = protoTCP
+		} else if err != nil {
+			return protoUnknown, nil, err
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+	return proto, &sniffedConn{Conn: conn, r: io.MultiReader(bytes.NewReader(buf), conn)}, nil
+}
+
+// sniffedConn is a connection whose first bytes were sniffed and are read again.
+type sniffedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// CloseWrite half-closes the underlying connection, if it supports that.
+func (c *sniffedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// serveSniffed sniffs a connection accepted by a sniffing frontend and hands it
+// on: TLS is passed through, by server name with -sni-passthrough, HTTP goes to
+// the frontend's HTTP server and anything else is proxied as is. It holds a
+// worker, which an HTTP connection keeps until closed.
+func (f *frontend) serveSniffed(conn net.Conn) {
+	remoteAddr := conn.RemoteAddr().String()
+	proto, sniffed, err := sniff(conn, sniffTimeout)
+	if err != nil {
+		logger.Printf("ERROR sniffing client %s: %v", remoteAddr, err)
+		conn.Close()
+		workers.release()
+		return
+	}
+	switch proto {
+	case protoHTTP:
+		ip, _, _ := net.SplitHostPort(remoteAddr)
+		if err := limits.acquire(ip, qosClass(conn, "")); err != nil {
+			logger.Printf("Refused client %s: %v", remoteAddr, err)
+			conn.Close()
+			workers.release()
+			return
+		}
+		f.sniffed.hand(&limitedConn{Conn: sniffed, ip: ip})
+	case protoTLS:
+		defer workers.release()
+		handleClient(sniffed, cmp.Or(sniffTLSPolicy, f.policyFor(remoteAddr)), f.bySNI)
+	default:
+		defer workers.release()
+		handleClient(sniffed, cmp.Or(sniffTCPPolicy, f.policyFor(remoteAddr)), false)
+	}
+}
+
+// handoffListener is a listener whose connections are handed to it rather than
+// accepted from a socket, e.g. the sniffed HTTP connections an HTTP server
+// serves.
+type handoffListener struct {
+	addr   net.Addr
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newHandoffListener(addr net.Addr) *handoffListener {
+	return &handoffListener{addr: addr, conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+// hand passes c to whoever accepts, or closes it if the listener is closed.
+func (l *handoffListener) hand(c net.Conn) {
+	select {
+	case l.conns <- c:
+	case <-l.closed:
+		c.Close()
+	}
+}
+
+func (l *handoffListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *handoffListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *handoffListener) Addr() net.Addr { return l.addr }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		b    string
+		want protocol
+	}{
+		{"", protoUnknown},
+		{"\x16\x03\x01\x02\x00\x01", protoTLS}, // a ClientHello record
+		{"\x16", protoTLS},
+		{"GET / HTTP/1.1\r\n", protoHTTP},
+		{"DELETE /a HTTP/1.1\r\n", protoHTTP},
+		{"PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", protoHTTP}, // the HTTP/2 preface
+		{"G", protoUnknown},
+		{"GE", protoUnknown},
+		{"PRI * HTTP", protoUnknown},
+		{"GET", protoUnknown}, // the space tells GET from GETX
+		{"GETX", protoTCP},
+		{"get / HTTP/1.1", protoTCP}, // methods are case sensitive
+		{"SSH-2.0-OpenSSH_9.6\r\n", protoTCP},
+		{"\x00\x00\x00\x08\x04\xd2\x16\x2f", protoTCP}, // a Postgres SSLRequest
+	}
+	for _, tt := range tests {
+		if got := classify([]byte(tt.b)); got != tt.want {
+			t.Errorf("classify(%q) = %v, want %v", tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSniff(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string // by the client, one after the other
+		silent bool     // and then nothing, rather than closing
+		want   protocol
+	}{
+		{"TLS ClientHello", []string{"\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03"}, false, protoTLS},
+		{"HTTP request", []string{"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"}, false, protoHTTP},
+		{"HTTP request in short reads", []string{"P", "OS", "T /upload HTTP/1.1\r\n\r\n"}, false, protoHTTP},
+		{"HTTP/2 preface", []string{"PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"}, false, protoHTTP},
+		{"opaque", []string{"SSH-2.0-OpenSSH_9.6\r\n"}, false, protoTCP},
+		{"a preamble cut short", []string{"GE"}, true, protoTCP},
+		{"a preamble cut short by close", []string{"GE"}, false, protoTCP},
+		{"server speaks first", nil, true, protoTCP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			defer client.Close()
+			go func() {
+				for _, w := range tt.writes {
+					client.Write([]byte(w))
+					time.Sleep(time.Millisecond)
+				}
+				if !tt.silent {
+					client.Close()
+				}
+			}()
+			proto, sniffed, err := sniff(server, 50*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if proto != tt.want {
+				t.Errorf("sniffed %v, want %v", proto, tt.want)
+			}
+
+			// the backend gets every byte, those sniffed first
+			var want string
+			for _, w := range tt.writes {
+				want += w
+			}
+			if tt.silent {
+				client.Close()
+			}
+			got, err := io.ReadAll(sniffed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Errorf("read %q after sniffing, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSniffDeadlineCleared(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	go client.Write([]byte("\x16\x03\x01"))
+	_, sniffed, err := sniff(server, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a client slower than the sniff timeout once known isn't cut off
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		client.Write([]byte("rest"))
+	}()
+	buf := make([]byte, 7)
+	if _, err := io.ReadFull(sniffed, buf); err != nil || string(buf) != "\x16\x03\x01rest" {
+		t.Errorf("read %q, %v; want the sniffed bytes then the rest", buf, err)
+	}
+}
+
+func TestSniffClosedSilently(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	client.Close()
+	if _, _, err := sniff(server, time.Second); err == nil {
+		t.Error("client gone without a byte sniffed, want an error")
+	}
+}
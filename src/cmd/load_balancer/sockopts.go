@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"time"
+)
+
+// ---------------- Socket options ---------------- //
+
+// socketOptions are the TCP options set on client and backend connections, see
+// the -tcp-* flags. Zero buffer sizes keep the system's.
+type socketOptions struct {
+	noDelay          bool
+	keepAlive        net.KeepAliveConfig
+	sendBuf, recvBuf int
+}
+
+var sockOpts = socketOptions{
+	noDelay:   true,
+	keepAlive: net.KeepAliveConfig{Enable: true, Idle: 15 * time.Second, Interval: 15 * time.Second},
+}
+
+// listen queue length of listening sockets, 0 for the system's; see -backlog
+var backlog int
+
+// apply sets the options on conn if it's a TCP connection.
+func (o socketOptions) apply(conn net.Conn) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	tc.SetNoDelay(o.noDelay)
+	tc.SetKeepAliveConfig(o.keepAlive)
+	if o.sendBuf > 0 {
+		tc.SetWriteBuffer(o.sendBuf)
+	}
+	if o.recvBuf > 0 {
+		tc.SetReadBuffer(o.recvBuf)
+	}
+}
+
+// tunedListener sets the socket options on the client connections it accepts.
+type tunedListener struct {
+	net.Listener
+}
+
+func (l tunedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		sockOpts.apply(conn)
+	}
+	return conn, err
+}
@@ -0,0 +1,23 @@
+package main
+
+import "net"
+
+// ---------------- Copy buffers ---------------- //
+
+// canSplice is whether splice can copy at all.
+const canSplice = true
+
+// splice copies src to dst with splice(2) if both are TCP connections, which
+// (*net.TCPConn).ReadFrom does through a pipe. ok is false if they aren't.
+func splice(dst, src net.Conn) (n int64, ok bool, err error) {
+	d, ok := dst.(*net.TCPConn)
+	if !ok {
+		return 0, false, nil
+	}
+	s, ok := src.(*net.TCPConn)
+	if !ok {
+		return 0, false, nil
+	}
+	n, err = d.ReadFrom(s)
+	return n, true, err
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// ---------------- Copy buffers ---------------- //
+
+const canSplice = false
+
+// splice never copies: without splice(2), (*net.TCPConn).ReadFrom would copy
+// through a buffer of its own instead of a pooled one.
+func splice(dst, src net.Conn) (n int64, ok bool, err error) {
+	return 0, false, nil
+}
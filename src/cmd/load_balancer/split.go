@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// ---------------- Traffic split ---------------- //
+
+// splitShare is a pool's part of split traffic.
+type splitShare struct {
+	name   string
+	policy load_balancer.Policy
+	weight int
+}
+
+// trafficSplit divides requests between named pools by weight, e.g. 95% to a
+// stable pool and 5% to a canary, whatever policy each pool balances its own
+// backends with. Weights can be changed at runtime from the admin API. A nil
+// *trafficSplit splits nothing.
+type trafficSplit struct {
+	mu     sync.RWMutex
+	shares []splitShare
+	total  int
+}
+
+// httpSplit splits the requests no route matched between pools, see -http-split
+var httpSplit *trafficSplit
+
+// parseSplit parses pool=weight,pool=weight. The pool named default, unless
+// -pool defines one, is def: the backends of -s.
+func parseSplit(v string, def load_balancer.Policy) (*trafficSplit, error) {
+	s := &trafficSplit{}
+	for _, part := range strings.Split(v, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		w, err := strconv.Atoi(weight)
+		if !ok || name == "" || err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid split %q, want pool=weight[,pool=weight...]", v)
+		}
+		policy, ok := pools[name]
+		if !ok && name == "default" {
+			policy, ok = def, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("split %q: no pool named %q, see -pool", v, name)
+		}
+		for _, sh := range s.shares {
+			if sh.name == name {
+				return nil, fmt.Errorf("split %q: pool %s given twice", v, name)
+			}
+		}
+		s.shares = append(s.shares, splitShare{name: name, policy: policy, weight: w})
+		s.total += w
+	}
+	if s.total == 0 {
+		return nil, fmt.Errorf("split %q: every weight is 0", v)
+	}
+	return s, nil
+}
+
+// pick returns the pool for r, at random by weight, or def if s is nil. A
+// client the sticky cookie pins to a backend of one of the pools stays with that
+// pool, so it doesn't flip between stable and canary from one request to the next.
+func (s *trafficSplit) pick(r *http.Request, sticky *stickyCookie, def load_balancer.Policy) load_balancer.Policy {
+	if s == nil {
+		return def
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if sticky != nil {
+		for _, sh := range s.shares {
+			if sh.weight > 0 && sticky.backend(r, sh.policy) != "" {
+				return sh.policy
+			}
+		}
+	}
+	n := rand.IntN(s.total)
+	for _, sh := range s.shares {
+		if n < sh.weight {
+			return sh.policy
+		}
+		n -= sh.weight
+	}
+	return def // unreachable, the weights add up to total
+}
+
+// set changes the weights of the named pools; the others keep theirs. Nothing
+// changes if a pool isn't part of the split or every weight would be 0.
+func (s *trafficSplit) set(weights map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := 0
+	for _, sh := range s.shares {
+		w, ok := weights[sh.name]
+		if !ok {
+			w = sh.weight
+		}
+		total += w
+	}
+	for name, w := range weights {
+		if w < 0 {
+			return fmt.Errorf("negative weight %d for pool %s", w, name)
+		}
+		if !s.has(name) {
+			return fmt.Errorf("pool %s is not part of the split", name)
+		}
+	}
+	if total == 0 {
+		return fmt.Errorf("every weight would be 0")
+	}
+	for i, sh := range s.shares {
+		if w, ok := weights[sh.name]; ok {
+			s.shares[i].weight = w
+		}
+	}
+	s.total = total
+	return nil
+}
+
+func (s *trafficSplit) has(name string) bool {
+	for _, sh := range s.shares {
+		if sh.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// percentages returns the share of requests each pool gets, in percent.
+func (s *trafficSplit) percentages() map[string]float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p := make(map[string]float64, len(s.shares))
+	for _, sh := range s.shares {
+		p[sh.name] = 100 * float64(sh.weight) / float64(s.total)
+	}
+	return p
+}
+
+// String lists the shares in percent, e.g. "canary=5% stable=95%".
+func (s *trafficSplit) String() string {
+	var parts []string
+	for name, p := range s.percentages() {
+		parts = append(parts, fmt.Sprintf("%s=%.4g%%", name, p))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+func TestParseSplit(t *testing.T) {
+	withPools(t, "stable", "canary")
+	def := load_balancer.NewRoundRobin(load_balancer.NewBackends([]string{"default:80"}))
+	tests := []struct {
+		v    string
+		want map[string]float64 // percentages, nil for an error
+	}{
+		{"stable=95,canary=5", map[string]float64{"stable": 95, "canary": 5}},
+		{" stable=3 , canary=1 ", map[string]float64{"stable": 75, "canary": 25}},
+		{"stable=1,canary=0", map[string]float64{"stable": 100, "canary": 0}},
+		{"default=1,canary=1", map[string]float64{"default": 50, "canary": 50}}, // the backends of -s
+		{"stable=0,canary=0", nil},
+		{"stable=-1,canary=5", nil},
+		{"stable=ten,canary=5", nil},
+		{"stable,canary=5", nil},
+		{"=5", nil},
+		{"stable=1,nope=1", nil},
+		{"stable=1,stable=2", nil},
+	}
+	for _, tt := range tests {
+		s, err := parseSplit(tt.v, def)
+		switch {
+		case tt.want == nil && err == nil:
+			t.Errorf("parseSplit(%q) succeeded", tt.v)
+		case tt.want != nil && err != nil:
+			t.Errorf("parseSplit(%q): %v", tt.v, err)
+		case tt.want != nil:
+			if got := s.percentages(); !equalShares(got, tt.want) {
+				t.Errorf("parseSplit(%q) splits %v, want %v", tt.v, got, tt.want)
+			}
+		}
+	}
+	if s, _ := parseSplit("default=1", def); s.shares[0].policy != def {
+		t.Error("default is not the backends of -s")
+	}
+}
+
+func equalShares(a, b map[string]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || math.Abs(v-w) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSplitPick(t *testing.T) {
+	p := withPools(t, "stable", "canary", "off")
+	s, err := parseSplit("stable=90,canary=10,off=0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 20000
+	counts := make(map[load_balancer.Policy]int)
+	r := httptest.NewRequest("GET", "/", nil)
+	for range n {
+		counts[s.pick(r, nil, nil)]++
+	}
+	if counts[p["off"]] != 0 {
+		t.Errorf("pool of weight 0 picked %d times", counts[p["off"]])
+	}
+	// 10% of 20000 is 2000, with a standard deviation of about 42
+	if c := counts[p["canary"]]; c < 1800 || c > 2200 {
+		t.Errorf("canary picked %d times in %d, want about 10%%", c, n)
+	}
+	if counts[p["stable"]]+counts[p["canary"]] != n {
+		t.Errorf("picked %v, want every pick in stable or canary", counts)
+	}
+
+	// a client pinned to a canary backend stays with the canary
+	sticky := &stickyCookie{name: "lb"}
+	r.AddCookie(sticky.cookie("canary:80"))
+	for range 100 {
+		if got := s.pick(r, sticky, nil); got != p["canary"] {
+			t.Fatal("client pinned to the canary sent elsewhere")
+		}
+	}
+
+	var none *trafficSplit
+	def := p["stable"]
+	if none.pick(r, nil, def) != def {
+		t.Error("no split, want the default pool")
+	}
+}
+
+func TestSplitAdmin(t *testing.T) {
+	withPools(t, "stable", "canary")
+	saved := httpSplit
+	t.Cleanup(func() { httpSplit = saved })
+	url := startAdmin(t, nil)
+
+	httpSplit = nil
+	if code, _ := adminCall(t, "GET", url+"/split"); code != http.StatusNotFound {
+		t.Errorf("GET /split without a split: %d, want 404", code)
+	}
+	var err error
+	if httpSplit, err = parseSplit("stable=95,canary=5", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		code  int
+		want  map[string]float64 // after the call
+	}{
+		{"canary=25&stable=75", http.StatusOK, map[string]float64{"stable": 75, "canary": 25}},
+		{"canary=50", http.StatusOK, map[string]float64{"stable": 60, "canary": 40}}, // stable keeps 75
+		{"canary=ten", http.StatusBadRequest, map[string]float64{"stable": 60, "canary": 40}},
+		{"canary=-1", http.StatusBadRequest, map[string]float64{"stable": 60, "canary": 40}},
+		{"nope=1", http.StatusBadRequest, map[string]float64{"stable": 60, "canary": 40}},
+		{"canary=0&stable=0", http.StatusBadRequest, map[string]float64{"stable": 60, "canary": 40}},
+		{"canary=0", http.StatusOK, map[string]float64{"stable": 100, "canary": 0}},
+	}
+	for _, tt := range tests {
+		if code, body := adminCall(t, "POST", url+"/split?"+tt.query); code != tt.code {
+			t.Errorf("POST /split?%s: %d %s, want %d", tt.query, code, body, tt.code)
+		}
+		_, body := adminCall(t, "GET", url+"/split")
+		var got map[string]float64
+		if err := json.Unmarshal([]byte(body), &got); err != nil {
+			t.Fatalf("GET /split: %v in %s", err, body)
+		}
+		if !equalShares(got, tt.want) {
+			t.Errorf("after POST /split?%s: %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"time"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// ---------------- Sticky sessions ---------------- //
+
+// stickyCookie pins HTTP clients to a backend with a cookie the balancer sets,
+// see -sticky-cookie. The cookie holds a hash of the backend's address, so
+// addresses aren't exposed. A nil *stickyCookie pins nothing.
+type stickyCookie struct {
+	name     string
+	ttl      time.Duration // 0 for a cookie that ends with the browser session
+	secure   bool
+	httpOnly bool
+}
+
+// backend returns the backend of policy r is pinned to, or "" if it has no
+// cookie or its backend is gone.
+func (s *stickyCookie) backend(r *http.Request, policy load_balancer.Policy) string {
+	if s == nil {
+		return ""
+	}
+	c, err := r.Cookie(s.name)
+	if err != nil {
+		return ""
+	}
+	for _, b := range policy.Stats().Backends {
+		if stickyValue(b.Address) == c.Value {
+			return b.Address
+		}
+	}
+	return ""
+}
+
+// cookie returns the cookie pinning a client to backend.
+func (s *stickyCookie) cookie(backend string) *http.Cookie {
+	c := &http.Cookie{
+		Name:     s.name,
+		Value:    stickyValue(backend),
+		Path:     "/",
+		Secure:   s.secure,
+		HttpOnly: s.httpOnly,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if s.ttl > 0 {
+		c.MaxAge = int(s.ttl.Seconds())
+	}
+	return c
+}
+
+func stickyValue(backend string) string {
+	h := fnv.New64a()
+	h.Write([]byte(backend))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
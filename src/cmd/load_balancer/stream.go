@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// ---------------- Stats stream ---------------- //
+
+// statsDelta is an event of GET /stats/stream: what changed since the last
+// one, as rates per second, and the state of each backend.
+type statsDelta struct {
+	Time          time.Time      `json:"time"`
+	Policy        string         `json:"policy"`
+	Connections   float64        `json:"connections_per_sec"` // requests in HTTP mode
+	Failures      float64        `json:"failures_per_sec"`
+	BytesSent     float64        `json:"bytes_sent_per_sec"`
+	BytesReceived float64        `json:"bytes_received_per_sec"`
+	Active        int64          `json:"active"`
+	Backends      []backendDelta `json:"backends"`
+}
+
+type backendDelta struct {
+	Address       string  `json:"address"`
+	Health        string  `json:"health"`
+	Draining      bool    `json:"draining,omitempty"`
+	Maintenance   bool    `json:"maintenance,omitempty"`
+	Ejected       bool    `json:"ejected,omitempty"`
+	Active        int64   `json:"active"`
+	Connections   float64 `json:"connections_per_sec"`
+	Failures      float64 `json:"failures_per_sec"`
+	BytesSent     float64 `json:"bytes_sent_per_sec"`
+	BytesReceived float64 `json:"bytes_received_per_sec"`
+}
+
+// diffStats returns the rates between two snapshots of a policy's stats taken
+// elapsed apart. Backends new since prev count from zero, as do counters that
+// went down, e.g. of a backend removed and added again.
+func diffStats(prev, cur load_balancer.PolicyStats, elapsed time.Duration) statsDelta {
+	old := make(map[string]load_balancer.BackendStats, len(prev.Backends))
+	for _, b := range prev.Backends {
+		old[b.Address] = b
+	}
+	secs := elapsed.Seconds()
+	rate := func(cur, prev uint64) float64 {
+		if cur < prev {
+			prev = 0
+		}
+		return float64(cur-prev) / secs
+	}
+	d := statsDelta{Time: time.Now(), Policy: cur.Policy, Backends: []backendDelta{}}
+	for _, b := range cur.Backends {
+		p := old[b.Address]
+		bd := backendDelta{
+			Address:       b.Address,
+			Health:        b.Health,
+			Draining:      b.Draining,
+			Maintenance:   b.Maintenance,
+			Ejected:       b.Ejected,
+			Active:        b.Active,
+			Connections:   rate(b.Selected, p.Selected),
+			Failures:      rate(b.Failures, p.Failures),
+			BytesSent:     rate(b.BytesSent, p.BytesSent),
+			BytesReceived: rate(b.BytesReceived, p.BytesReceived),
+		}
+		d.Connections += bd.Connections
+		d.Failures += bd.Failures
+		d.BytesSent += bd.BytesSent
+		d.BytesReceived += bd.BytesReceived
+		d.Active += bd.Active
+		d.Backends = append(d.Backends, bd)
+	}
+	return d
+}
+
+// streamStats sends the rates of policy every interval as Server-Sent Events,
+// until the client goes away.
+func streamStats(w http.ResponseWriter, r *http.Request, policy load_balancer.Policy, interval time.Duration) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	prev, last := policy.Stats(), time.Now()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case now := <-ticker.C:
+			cur := policy.Stats()
+			data, _ := json.Marshal(diffStats(prev, cur, now.Sub(last)))
+			prev, last = cur, now
+			w.Write([]byte("event: stats\ndata: "))
+			w.Write(data)
+			if _, err := w.Write([]byte("\n\n")); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
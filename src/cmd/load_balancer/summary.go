@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// ---------------- Stats summary ---------------- //
+
+// latencies samples the latency the balancer adds, as lb_proxy_latency_seconds
+// measures it, over each -stats-summary interval; nil without one
+var latencies *latencySample
+
+// summaryLatency is the p50 and p99 of the last -stats-summary interval
+var summaryLatency = registry.Gauge("lb_summary_latency_seconds",
+	"Latency the balancer added over the last -stats-summary interval, by quantile (0.5 and 0.99), as lb_proxy_latency_seconds measures it.", "quantile")
+
+// latencySampleSize bounds the memory of a latency sample; busier intervals
+// are sampled
+const latencySampleSize = 4096
+
+// latencySample keeps a uniform sample of the latencies observed since it was
+// last taken (reservoir sampling).
+type latencySample struct {
+	mu     sync.Mutex
+	seen   int
+	values []float64 // seconds
+}
+
+// add records a latency. A nil *latencySample records nothing.
+func (s *latencySample) add(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen++
+	if len(s.values) < latencySampleSize {
+		s.values = append(s.values, d.Seconds())
+	} else if i := rand.IntN(s.seen); i < latencySampleSize {
+		s.values[i] = d.Seconds()
+	}
+}
+
+// take returns the sample, sorted, and starts a new one.
+func (s *latencySample) take() []float64 {
+	s.mu.Lock()
+	values := s.values
+	s.values, s.seen = nil, 0
+	s.mu.Unlock()
+	slices.Sort(values)
+	return values
+}
+
+// percentile returns the p-th percentile of sorted values, nearest rank.
+func percentile(sorted []float64, p float64) float64 {
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
+
+// summaryCounters are the process-wide counters a summary reports the
+// increase of.
+type summaryCounters struct {
+	accepted, turnedAway uint64
+}
+
+func readSummaryCounters() summaryCounters {
+	u := turnedAwayStats()
+	return summaryCounters{
+		accepted:   uint64(clientConns.With("tcp").Value() + clientConns.With("http").Value()),
+		turnedAway: u.Connections + u.Requests,
+	}
+}
+
+// summarize logs a summary of the traffic of every pool each interval, so
+// basic monitoring works without a metrics stack: client connections accepted,
+// open and failed, each backend's share of the selections per pool, and the
+// p50 and p99 latency.
+func summarize(policies map[string]load_balancer.Policy, interval time.Duration) {
+	names := slices.Sorted(maps.Keys(policies))
+	prev := make(map[string]load_balancer.PolicyStats, len(policies))
+	for _, name := range names {
+		prev[name] = policies[name].Stats()
+	}
+	counters := readSummaryCounters()
+	last := time.Now()
+	for range time.Tick(interval) {
+		now := time.Now()
+		cur := readSummaryCounters()
+		pools := make([]statsDelta, 0, len(names))
+		for _, name := range names {
+			stats := policies[name].Stats()
+			// over "a second" the rates are what happened in the interval
+			pools = append(pools, diffStats(prev[name], stats, time.Second))
+			prev[name] = stats
+		}
+		logger.Print(summaryLine(now.Sub(last), counters, cur, names, pools, latencies.take()))
+		counters, last = cur, now
+	}
+}
+
+// summaryLine formats the summary of elapsed: the increase of the counters
+// from prev to cur, what happened in each of the named pools and the
+// percentiles of the sorted latency sample, also set in
+// lb_summary_latency_seconds.
+func summaryLine(elapsed time.Duration, prev, cur summaryCounters, names []string, pools []statsDelta, sample []float64) string {
+	var active, failed int64
+	for _, d := range pools {
+		active += d.Active
+		failed += int64(d.Failures)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Summary of the last %v: %d connections accepted, %d active, %d failed, %d turned away",
+		elapsed.Round(time.Second), cur.accepted-prev.accepted, active, failed, cur.turnedAway-prev.turnedAway)
+	for i, d := range pools {
+		fmt.Fprintf(&b, "; %s: %d selected", names[i], int64(d.Connections))
+		for _, bd := range d.Backends {
+			if d.Connections > 0 {
+				fmt.Fprintf(&b, ", %s %.1f%%", bd.Address, 100*bd.Connections/d.Connections)
+			}
+		}
+	}
+	if len(sample) == 0 {
+		return b.String()
+	}
+	p50, p99 := percentile(sample, 50), percentile(sample, 99)
+	summaryLatency.With("0.5").Set(p50)
+	summaryLatency.With("0.99").Set(p99)
+	fmt.Fprintf(&b, "; latency p50 %v, p99 %v", roundLatency(p50), roundLatency(p99))
+	return b.String()
+}
+
+// roundLatency turns seconds into a duration rounded for reading.
+func roundLatency(s float64) time.Duration {
+	d := time.Duration(s * float64(time.Second))
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
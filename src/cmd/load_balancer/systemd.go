@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// ---------------- Socket activation ---------------- //
+
+// systemdSockets takes over the sockets systemd passes with socket activation
+// (LISTEN_FDS), so they stay open, and queue clients, while the balancer
+// restarts. listen uses one in place of opening an address it's bound to, e.g.
+// ListenStream=8080 for -p 8080.
+func systemdSockets() error {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	// not for health check scripts and the like
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if err != nil {
+		return fmt.Errorf("LISTEN_FDS: %v", err)
+	}
+	for fd := 3; fd < 3+n; fd++ {
+		closeOnExec(fd)
+		f := os.NewFile(uintptr(fd), "systemd")
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("socket %d: %v", fd, err)
+		}
+		addr := l.Addr().String()
+		if l.Addr().Network() == "unix" {
+			addr = load_balancer.UnixScheme + addr
+		}
+		inherited[addr] = append(inherited[addr], l)
+		logger.Printf("Socket activation: got listener on %s", addr)
+	}
+	return nil
+}
+
+// boundAddr returns the address a socket opened for addr is bound to, as
+// systemd's sockets are known by: an empty host is [::], IPs are canonical.
+func boundAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if host == "" {
+		host = "::"
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return net.JoinHostPort(ip.Unmap().String(), port)
+	}
+	return addr
+}
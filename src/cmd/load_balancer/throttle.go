@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------- Bandwidth throttling ---------------- //
+
+var (
+	connBandwidth  int64     // bytes per second per connection and direction, see -bandwidth-per-conn
+	totalBandwidth *throttle // shared by every connection; nil unless -bandwidth-total is set
+)
+
+// throttle paces byte streams to a rate, letting up to a second's worth through
+// at once. A nil *throttle doesn't slow anything down.
+type throttle struct {
+	rate  float64
+	burst int
+
+	mu     sync.Mutex
+	bucket *tokenBucket
+}
+
+// newThrottle returns a throttle to rate bytes per second, or nil if rate is not positive.
+func newThrottle(rate int64) *throttle {
+	if rate <= 0 {
+		return nil
+	}
+	burst := int(min(rate, 1<<30))
+	return &throttle{rate: float64(rate), burst: burst, bucket: newTokenBucket(burst, time.Now())}
+}
+
+// wait blocks until n more bytes may pass.
+func (t *throttle) wait(n int) {
+	if t == nil || n <= 0 {
+		return
+	}
+	t.mu.Lock()
+	delay := t.bucket.reserve(time.Now(), t.rate, t.burst, n)
+	t.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// throttled returns r paced by both throttles, either of which may be nil.
+func throttled(r io.Reader, conn, total *throttle) io.Reader {
+	if conn == nil && total == nil {
+		return r
+	}
+	return throttledReader{r, conn, total}
+}
+
+type throttledReader struct {
+	io.Reader
+	conn, total *throttle
+}
+
+func (r throttledReader) Read(p []byte) (int, error) {
+	// never read more than a throttle lets through at once, so slow rates
+	// trickle rather than stall in bursts
+	for _, t := range []*throttle{r.conn, r.total} {
+		if t != nil && len(p) > t.burst {
+			p = p[:t.burst]
+		}
+	}
+	n, err := r.Reader.Read(p)
+	r.conn.wait(n)
+	r.total.wait(n)
+	return n, err
+}
+
+// parseBytes parses a byte count with an optional K, M or G suffix, in
+// multiples of 1024, e.g. "512K".
+func parseBytes(s string) (int64, error) {
+	shift := 0
+	switch strings.ToUpper(s[len(s)-min(len(s), 1):]) {
+	case "K":
+		shift = 10
+	case "M":
+		shift = 20
+	case "G":
+		shift = 30
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid byte count %q", s)
+	}
+	return n << shift, nil
+}
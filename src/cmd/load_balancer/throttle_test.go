@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"1500", 1500, true},
+		{"512K", 512 << 10, true},
+		{"2m", 2 << 20, true},
+		{"1G", 1 << 30, true},
+		{"", 0, false},
+		{"K", 0, false},
+		{"-1", 0, false},
+		{"1.5M", 0, false},
+		{"1T", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseBytes(tt.s)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseBytes(%q) = %d, %v; want %d", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+// readAllWithin reads r to the end, failing unless it takes between least and most.
+func readAllWithin(t *testing.T, r io.Reader, least, most time.Duration) []byte {
+	t.Helper()
+	start := time.Now()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took < least || took > most {
+		t.Errorf("read %d bytes in %v, want between %v and %v", len(b), took, least, most)
+	}
+	return b
+}
+
+func TestThrottle(t *testing.T) {
+	if r := strings.NewReader("x"); throttled(r, nil, nil) != io.Reader(r) {
+		t.Error("no throttles, want the reader itself")
+	}
+	if newThrottle(0) != nil {
+		t.Error("newThrottle(0), want no throttle")
+	}
+
+	// a second's worth passes at once, the rest at the rate: 150 KiB at
+	// 100 KiB/s takes half a second
+	data := bytes.Repeat([]byte("x"), 150<<10)
+	got := readAllWithin(t, throttled(bytes.NewReader(data), newThrottle(100<<10), nil), 400*time.Millisecond, 900*time.Millisecond)
+	if !bytes.Equal(got, data) {
+		t.Errorf("read %d bytes through the throttle, want the %d written", len(got), len(data))
+	}
+
+	// within the burst, nothing waits
+	readAllWithin(t, throttled(bytes.NewReader(data[:50<<10]), newThrottle(100<<10), nil), 0, 100*time.Millisecond)
+}
+
+func TestThrottleShared(t *testing.T) {
+	// two connections share the total: 2*75 KiB at 100 KiB/s takes half a
+	// second, however fast each is let through on its own
+	total := newThrottle(100 << 10)
+	data := bytes.Repeat([]byte("x"), 75<<10)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			io.Copy(io.Discard, throttled(bytes.NewReader(data), newThrottle(1<<30), total))
+		}()
+	}
+	wg.Wait()
+	if took := time.Since(start); took < 400*time.Millisecond || took > 900*time.Millisecond {
+		t.Errorf("two connections took %v, want about half a second", took)
+	}
+}
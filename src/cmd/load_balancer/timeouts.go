@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ---------------- Connection timeouts ---------------- //
+
+// close connections with no bytes flowing either way for this long, see -idle-timeout
+var idleTimeout time.Duration
+
+// end connections this long after they were opened, see -max-lifetime
+var maxLifetime time.Duration
+
+// how long a connection past its lifetime has to wind down before it is cut
+const lifetimeGrace = 10 * time.Second
+
+// HTTP mode limits on slow clients, see -http-header-timeout, -http-body-timeout
+// and -http-max-header-bytes
+var (
+	headerTimeout  = 10 * time.Second
+	bodyTimeout    time.Duration
+	maxHeaderBytes = http.DefaultMaxHeaderBytes
+)
+
+// idleWatch closes a proxied connection once no bytes have flowed in either
+// direction for its timeout. A nil *idleWatch watches nothing.
+type idleWatch struct {
+	conn    proxied
+	timeout time.Duration
+	last    atomic.Int64 // unix nanoseconds of the last read on either side
+	fired   atomic.Bool
+	timer   *time.Timer
+}
+
+// watchIdle starts watching c, or returns nil if timeout is not positive.
+func watchIdle(c proxied, timeout time.Duration) *idleWatch {
+	if timeout <= 0 {
+		return nil
+	}
+	w := &idleWatch{conn: c, timeout: timeout}
+	w.last.Store(time.Now().UnixNano())
+	w.timer = time.AfterFunc(timeout, w.check)
+	return w
+}
+
+// check runs when the connection may have gone idle: it closes it, or waits for
+// the rest of the timeout counted from the last activity.
+func (w *idleWatch) check() {
+	idle := time.Since(time.Unix(0, w.last.Load()))
+	if idle < w.timeout {
+		w.timer.Reset(w.timeout - idle)
+		return
+	}
+	w.fired.Store(true)
+	w.conn.client.Close()
+	w.conn.backend.Close()
+}
+
+// reader returns r, counting every read from it as activity.
+func (w *idleWatch) reader(r io.Reader) io.Reader {
+	if w == nil {
+		return r
+	}
+	return activityReader{r, w}
+}
+
+// touch notes activity on the connection.
+func (w *idleWatch) touch() {
+	if w != nil {
+		w.last.Store(time.Now().UnixNano())
+	}
+}
+
+// closed reports whether the connection was closed for being idle.
+func (w *idleWatch) closed() bool { return w != nil && w.fired.Load() }
+
+// stop stops watching.
+func (w *idleWatch) stop() {
+	if w != nil {
+		w.timer.Stop()
+	}
+}
+
+type activityReader struct {
+	io.Reader
+	w *idleWatch
+}
+
+func (r activityReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.w.touch()
+	}
+	return n, err
+}
+
+// lifetimeWatch ends a proxied connection once it reaches its maximum lifetime.
+// The backend first sees the client's side end, as if the client had closed it,
+// and can finish the response in flight; lifetimeGrace later both sides are
+// closed. A nil *lifetimeWatch watches nothing.
+type lifetimeWatch struct {
+	conn  proxied
+	fired atomic.Bool
+	timer *time.Timer
+}
+
+// watchLifetime starts the lifetime of c, or returns nil if lifetime is not positive.
+func watchLifetime(c proxied, lifetime time.Duration) *lifetimeWatch {
+	if lifetime <= 0 {
+		return nil
+	}
+	w := &lifetimeWatch{conn: c}
+	w.timer = time.AfterFunc(lifetime, w.end)
+	return w
+}
+
+func (w *lifetimeWatch) end() {
+	if w.fired.CompareAndSwap(false, true) {
+		if cw, ok := w.conn.backend.(closeWriter); ok {
+			_ = cw.CloseWrite()
+		}
+		w.timer.Reset(lifetimeGrace)
+		return
+	}
+	w.conn.client.Close()
+	w.conn.backend.Close()
+}
+
+// ended reports whether the connection was ended for reaching its lifetime.
+func (w *lifetimeWatch) ended() bool { return w != nil && w.fired.Load() }
+
+// stop stops watching.
+func (w *lifetimeWatch) stop() {
+	if w != nil {
+		w.timer.Stop()
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveHTTP serves handler as an HTTP frontend does, with the timeouts set
+// for the test, and returns its address.
+func serveHTTP(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newHTTPServer(handler, false, false)
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return l.Addr().String()
+}
+
+func TestHeaderTimeout(t *testing.T) {
+	saved := headerTimeout
+	t.Cleanup(func() { headerTimeout = saved })
+	headerTimeout = 50 * time.Millisecond
+	addr := serveHTTP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	start := time.Now()
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n") // and never the rest
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("connection with headers left unfinished not closed: %v", err)
+	}
+	if d := time.Since(start); d < headerTimeout {
+		t.Errorf("closed after %v, before the %v header timeout", d, headerTimeout)
+	}
+}
+
+func TestBodyTimeout(t *testing.T) {
+	saved := bodyTimeout
+	t.Cleanup(func() { bodyTimeout = saved })
+	bodyTimeout = 50 * time.Millisecond
+	addr := serveHTTP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := limitBody(w, r)
+		io.ReadAll(r.Body)
+		if body.expired() {
+			tooSlow(connLog("test"), w, r)
+		}
+	}))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	start := time.Now()
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 10\r\n\r\nab") // 8 bytes short
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("no answer to a body left unfinished: %v", err)
+	}
+	if res.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("got status %d, want 408", res.StatusCode)
+	}
+	if d := time.Since(start); d < bodyTimeout {
+		t.Errorf("answered after %v, before the %v body timeout", d, bodyTimeout)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	client, clientPeer := net.Pipe()
+	backend, backendPeer := net.Pipe()
+	defer clientPeer.Close()
+	defer backendPeer.Close()
+	w := watchIdle(proxied{client, backend}, 50*time.Millisecond)
+	defer w.stop()
+
+	// reads through the watch keep the connection open past the timeout
+	r := w.reader(client)
+	go func() {
+		for range 4 {
+			io.WriteString(clientPeer, "x")
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	for range 4 {
+		if _, err := r.Read(make([]byte, 1)); err != nil {
+			t.Fatalf("active connection closed: %v", err)
+		}
+	}
+	if w.closed() {
+		t.Fatal("active connection closed for being idle")
+	}
+
+	// then, idle, it's closed on both sides
+	time.Sleep(100 * time.Millisecond)
+	if !w.closed() {
+		t.Fatal("idle connection still open")
+	}
+	if _, err := backend.Write([]byte("x")); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("backend side still open: %v", err)
+	}
+	if watchIdle(proxied{client, backend}, 0) != nil {
+		t.Error("watch without a timeout, want nil")
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+	"Load-Balancer/pkg/load_balancer"
+	"Load-Balancer/pkg/proxyproto"
+)
+
+// ---------------- TLS to backends ---------------- //
+
+// backendTLS, when set, encrypts every connection to a backend, see -backend-tls
+var backendTLS *tls.Config
+
+// certStore holds a certificate and its key, read from files. Reloading swaps
+// it for handshakes to come; connections already open are unaffected.
+type certStore struct {
+	certFile, keyFile string
+	cert              atomic.Pointer[tls.Certificate]
+}
+
+func loadCertStore(certFile, keyFile string) (*certStore, error) {
+	s := &certStore{certFile: certFile, keyFile: keyFile}
+	return s, s.reload()
+}
+
+// reload reads the files again. On error the current certificate stays.
+func (s *certStore) reload() error {
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return err
+	}
+	s.cert.Store(&cert)
+	return nil
+}
+
+// current returns the certificate in use.
+func (s *certStore) current() *tls.Certificate { return s.cert.Load() }
+
+// serverCert is a tls.Config GetCertificate callback.
+func (s *certStore) serverCert(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.current(), nil
+}
+
+// clientCert is a tls.Config GetClientCertificate callback.
+func (s *certStore) clientCert(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return s.current(), nil
+}
+
+// certStores are the certificates reloaded on SIGHUP or when their files change
+var certStores []*certStore
+
+// reloadCerts reloads every certificate, logging the outcome.
+func reloadCerts() {
+	for _, s := range certStores {
+		if err := s.reload(); err != nil {
+			logger.Printf("ERROR reloading certificate %s, keeping the current one: %v", s.certFile, err)
+			continue
+		}
+		logger.Printf("Reloaded certificate %s (%s, valid until %s)", s.certFile, s.current().Leaf.Subject, s.current().Leaf.NotAfter.Format(time.DateOnly))
+	}
+}
+
+// watchCerts reloads the certificates whenever their files change.
+func watchCerts() error {
+	var paths []string
+	for _, s := range certStores {
+		paths = append(paths, s.certFile, s.keyFile)
+	}
+	return watchFiles(paths, reloadCerts)
+}
+
+// loadBackendTLS builds the TLS config for backend connections: caFile replaces
+// the system roots, serverName the name checked against backend certificates
+// (their host by default), and certFile and keyFile are the balancer's client
+// certificate, for backends that require one.
+func loadBackendTLS(caFile, serverName, certFile, keyFile string, insecure bool) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecure,
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", caFile)
+		}
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("a client certificate needs both a cert and a key file")
+	}
+	if certFile != "" {
+		store, err := loadCertStore(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		certStores = append(certStores, store)
+		cfg.GetClientCertificate = store.clientCert
+	}
+	return cfg, nil
+}
+
+// errBackendHandshake is the error of a failed TLS handshake with a backend,
+// whatever failed it, a timeout included
+var errBackendHandshake = errors.New("TLS handshake")
+
+// secureBackend runs the TLS handshake with backend over conn.
+func secureBackend(ctx context.Context, conn net.Conn, backend string) (net.Conn, error) {
+	cfg := backendTLS
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName = load_balancer.Host(backend)
+	}
+	ctx, cancel := context.WithTimeout(ctx, dialer.Timeout)
+	defer cancel()
+	tc := tls.Client(conn, cfg)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("%w: %w", errBackendHandshake, err)
+	}
+	return tc, nil
+}
+
+// ---------------- TLS termination ---------------- //
+
+// listenerTLS, when set, terminates TLS from clients, see -tls-cert
+var listenerTLS *tls.Config
+
+// how long a client gets to complete its TLS handshake
+const clientHandshakeTimeout = 10 * time.Second
+
+// loadListenerTLS builds the TLS config clients connect with. With clientCAFile
+// clients must present a certificate issued by one of its CAs, and with crlFile
+// one not revoked by that CA list.
+func loadListenerTLS(certFile, keyFile, clientCAFile, crlFile string) (*tls.Config, error) {
+	store, err := loadCertStore(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	certStores = append(certStores, store)
+	cfg := &tls.Config{GetCertificate: store.serverCert}
+	if clientCAFile == "" {
+		if crlFile != "" {
+			return nil, errors.New("a CRL needs a client CA file")
+		}
+		return cfg, nil
+	}
+	cas, err := readCertificates(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	cfg.ClientCAs = x509.NewCertPool()
+	for _, ca := range cas {
+		cfg.ClientCAs.AddCert(ca)
+	}
+	if crlFile != "" {
+		revoked, err := readCRL(crlFile, cas)
+		if err != nil {
+			return nil, err
+		}
+		cfg.VerifyPeerCertificate = func(_ [][]byte, chains [][]*x509.Certificate) error {
+			for _, chain := range chains {
+				if revoked[chain[0].SerialNumber.String()] {
+					return fmt.Errorf("client certificate %s is revoked", chain[0].Subject)
+				}
+			}
+			return nil
+		}
+	}
+	return cfg, nil
+}
+
+// readCertificates reads every certificate in a PEM file.
+func readCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s: no certificates found", path)
+	}
+	return certs, nil
+}
+
+// readCRL reads a PEM or DER revocation list signed by one of cas and returns
+// the revoked serial numbers.
+func readCRL(path string, cas []*x509.Certificate) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	signed := false
+	for _, ca := range cas {
+		if crl.CheckSignatureFrom(ca) == nil {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return nil, fmt.Errorf("%s: not signed by a client CA", path)
+	}
+	revoked := make(map[string]bool, len(crl.RevokedCertificateEntries))
+	for _, e := range crl.RevokedCertificateEntries {
+		revoked[e.SerialNumber.String()] = true
+	}
+	return revoked, nil
+}
+
+// clientTLVs returns the PROXY protocol TLVs describing client's TLS session,
+// if it has one.
+func clientTLVs(client net.Conn) []proxyproto.TLV {
+	tc, ok := client.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	st := tc.ConnectionState()
+	cn := ""
+	if len(st.VerifiedChains) > 0 {
+		cn = st.VerifiedChains[0][0].Subject.CommonName
+	}
+	return []proxyproto.TLV{proxyproto.SSL(tlsVersionName(st.Version), cn)}
+}
+
+// tlsVersionName names a TLS version the way the PROXY protocol does.
+func tlsVersionName(v uint16) string {
+	return strings.Replace(tls.VersionName(v), "TLS 1.", "TLSv1.", 1)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for name, and its key, to
+// certFile and keyFile.
+func writeCert(t *testing.T, certFile, keyFile, name string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedCert runs a handshake with cfg and returns the name on the
+// certificate the client was served.
+func servedCert(t *testing.T, cfg *tls.Config) string {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go tls.Server(server, cfg).Handshake()
+	tc := tls.Client(client, &tls.Config{InsecureSkipVerify: true})
+	if err := tc.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	return tc.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestReloadCerts(t *testing.T) {
+	saved := certStores
+	t.Cleanup(func() { certStores = saved })
+	certStores = nil
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "lb.crt"), filepath.Join(dir, "lb.key")
+	writeCert(t, certFile, keyFile, "one.example.com")
+	cfg, err := loadListenerTLS(certFile, keyFile, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := servedCert(t, cfg); got != "one.example.com" {
+		t.Fatalf("served %s, want one.example.com", got)
+	}
+
+	// replaced on disk, the old certificate is served until the reload, the
+	// new one to every handshake after it
+	writeCert(t, certFile, keyFile, "two.example.com")
+	if got := servedCert(t, cfg); got != "one.example.com" {
+		t.Errorf("served %s before the reload, want one.example.com", got)
+	}
+	reloadCerts()
+	if got := servedCert(t, cfg); got != "two.example.com" {
+		t.Errorf("served %s after the reload, want two.example.com", got)
+	}
+
+	// a reload that fails keeps the certificate in use
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	reloadCerts()
+	if got := servedCert(t, cfg); got != "two.example.com" {
+		t.Errorf("served %s after a failed reload, want two.example.com", got)
+	}
+}
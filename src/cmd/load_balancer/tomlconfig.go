@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+// ---------------- TOML config ---------------- //
+
+// tomlTree builds the tree of a TOML config file from its expressions.
+type tomlTree struct {
+	path string
+	p    unstable.Parser
+	root *yaml.Node
+}
+
+// tomlKey is a part of a dotted TOML key.
+type tomlKey struct {
+	name string
+	line int
+}
+
+// parseTOML parses data, the TOML config file at path, into a tree that
+// remembers the line of each value. Tables are the sections of the config,
+// arrays of tables its lists, e.g. [[servers]].
+func parseTOML(path string, data []byte) (*yaml.Node, error) {
+	t := &tomlTree{path: path, root: &yaml.Node{Kind: yaml.MappingNode, Line: 1}}
+	t.p.Reset(data)
+	table := t.root
+	for t.p.NextExpression() {
+		expr := t.p.Expression()
+		var err error
+		switch expr.Kind {
+		case unstable.Table, unstable.ArrayTable:
+			table, err = t.table(t.keys(expr.Key(), 0), expr.Kind == unstable.ArrayTable)
+		case unstable.KeyValue:
+			err = t.set(table, expr)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	var parseErr *unstable.ParserError
+	switch err := t.p.Error(); {
+	case errors.As(err, &parseErr) && len(parseErr.Highlight) > 0:
+		return nil, fmt.Errorf("%s:%d: %s", path, t.p.Shape(t.p.Range(parseErr.Highlight)).Start.Line, parseErr.Message)
+	case err != nil:
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t.root, nil
+}
+
+// line returns the line r starts on, def if the parser didn't record it.
+func (t *tomlTree) line(r unstable.Range, def int) int {
+	if r.Length == 0 {
+		return def
+	}
+	return t.p.Shape(r).Start.Line
+}
+
+// keys returns the parts of a dotted key.
+func (t *tomlTree) keys(it unstable.Iterator, def int) []tomlKey {
+	var keys []tomlKey
+	for it.Next() {
+		k := it.Node()
+		keys = append(keys, tomlKey{string(k.Data), t.line(k.Raw, def)})
+	}
+	return keys
+}
+
+// table returns the table a [table] or, if array, [[table]] header opens.
+func (t *tomlTree) table(keys []tomlKey, array bool) (*yaml.Node, error) {
+	n := t.root
+	for i, k := range keys {
+		last := i == len(keys)-1
+		child := mappingValue(n, k.name)
+		switch {
+		case child == nil && last && array:
+			child = &yaml.Node{Kind: yaml.SequenceNode, Line: k.line}
+			n.Content = append(n.Content, tomlKeyNode(k), child)
+		case child == nil:
+			child = &yaml.Node{Kind: yaml.MappingNode, Line: k.line}
+			n.Content = append(n.Content, tomlKeyNode(k), child)
+		}
+		switch {
+		case child.Kind == yaml.SequenceNode && last && array:
+			item := &yaml.Node{Kind: yaml.MappingNode, Line: k.line}
+			child.Content = append(child.Content, item)
+			return item, nil
+		case child.Kind == yaml.SequenceNode && len(child.Content) > 0 && !last:
+			child = child.Content[len(child.Content)-1] // [[a]] then [a.b] is in the last a
+		case array && last:
+			return nil, fmt.Errorf("%s:%d: %s is not an array of tables", t.path, k.line, k.name)
+		}
+		if child.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s:%d: %s is a value, not a table", t.path, k.line, k.name)
+		}
+		n = child
+	}
+	return n, nil
+}
+
+// set adds kv, a key = value expression, to table.
+func (t *tomlTree) set(table *yaml.Node, kv *unstable.Node) error {
+	keys := t.keys(kv.Key(), table.Line)
+	n := table
+	for _, k := range keys[:len(keys)-1] {
+		child := mappingValue(n, k.name)
+		if child == nil {
+			child = &yaml.Node{Kind: yaml.MappingNode, Line: k.line}
+			n.Content = append(n.Content, tomlKeyNode(k), child)
+		}
+		if child.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s:%d: %s is a value, not a table", t.path, k.line, k.name)
+		}
+		n = child
+	}
+	k := keys[len(keys)-1]
+	if mappingValue(n, k.name) != nil {
+		return fmt.Errorf("%s:%d: key %s given twice", t.path, k.line, k.name)
+	}
+	v, err := t.value(kv.Value(), k.line)
+	if err != nil {
+		return err
+	}
+	n.Content = append(n.Content, tomlKeyNode(k), v)
+	return nil
+}
+
+// value returns the tree of v, a value on line unless it says otherwise.
+func (t *tomlTree) value(v *unstable.Node, line int) (*yaml.Node, error) {
+	line = t.line(v.Raw, line)
+	n := &yaml.Node{Kind: yaml.ScalarNode, Line: line, Value: string(v.Data)}
+	switch v.Kind {
+	case unstable.String:
+		n.Tag = "!!str"
+	case unstable.Bool:
+		n.Tag = "!!bool"
+	case unstable.Integer:
+		i, err := strconv.ParseInt(strings.ReplaceAll(n.Value, "_", ""), 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid integer %s", t.path, line, n.Value)
+		}
+		n.Tag, n.Value = "!!int", strconv.FormatInt(i, 10)
+	case unstable.Float:
+		f, err := strconv.ParseFloat(strings.ReplaceAll(n.Value, "_", ""), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid float %s", t.path, line, n.Value)
+		}
+		n.Tag, n.Value = "!!float", strconv.FormatFloat(f, 'g', -1, 64)
+	case unstable.Array:
+		n.Kind, n.Value = yaml.SequenceNode, ""
+		for it := v.Children(); it.Next(); {
+			item, err := t.value(it.Node(), line)
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, item)
+		}
+	case unstable.InlineTable:
+		n.Kind, n.Value = yaml.MappingNode, ""
+		for it := v.Children(); it.Next(); {
+			if err := t.set(n, it.Node()); err != nil {
+				return nil, err
+			}
+		}
+	default: // dates and times, as written
+		n.Tag = "!!str"
+	}
+	return n, nil
+}
+
+func tomlKeyNode(k tomlKey) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k.name, Line: k.line}
+}
+
+// mappingValue returns the value of key in the mapping n, nil if it has none.
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"Load-Balancer/pkg/tracing"
+)
+
+// ---------------- OpenTelemetry tracing ---------------- //
+
+// tracer records a span per proxied connection, and per request in HTTP mode,
+// see -otlp-endpoint; nil if off
+var tracer *tracing.Tracer
+
+// W3C trace context header of requests to backends
+const traceparentHeader = "Traceparent"
+
+// otlpTracesURL returns the OTLP/HTTP traces URL of endpoint: as given if it
+// has a path, e.g. http://collector:4318/v1/traces, otherwise with
+// /v1/traces added.
+func otlpTracesURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid OTLP endpoint %q, want http(s)://host:port", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	return u.String(), nil
+}
+
+// traceExemplar returns the trace ID that latency observations of span's
+// connection or request link to as exemplars: its trace's if sampled, so the
+// trace is exported, "" otherwise.
+func traceExemplar(span *tracing.Span) string {
+	if c := span.SpanContext(); c.Sampled {
+		return c.TraceID.String()
+	}
+	return ""
+}
+
+// clientAttributes describe the client at addr.
+func clientAttributes(addr string) []tracing.Attribute {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return []tracing.Attribute{tracing.String("client.address", addr)}
+	}
+	p, _ := strconv.ParseInt(port, 10, 64)
+	return []tracing.Attribute{tracing.String("client.address", host), tracing.Int("client.port", p)}
+}
+
+// finishConnSpan ends the span of a tcp mode connection, as the access log
+// entry describes it.
+func finishConnSpan(span *tracing.Span, e *accessEntry) {
+	span.SetAttributes(clientAttributes(e.client)...)
+	span.SetAttributes(tracing.String("lb.mode", e.mode))
+	if e.sni != "" {
+		span.SetAttributes(tracing.String("tls.client.server_name", e.sni))
+	}
+	if e.ja3 != "" {
+		span.SetAttributes(tracing.String("tls.client.ja3", e.ja3))
+	}
+	if e.backend != "" {
+		span.SetAttributes(tracing.String("lb.backend", e.backend))
+	}
+	span.SetAttributes(tracing.Int("lb.bytes_sent", e.in), tracing.Int("lb.bytes_received", e.out))
+	span.SetError(e.err)
+	span.Finish()
+}
+
+// startRequestSpan starts the span of an HTTP request, a child of the trace
+// the client sent, if any.
+func startRequestSpan(r *http.Request) *tracing.Span {
+	parent, _ := tracing.ParseTraceparent(r.Header.Get(traceparentHeader))
+	span := tracer.Start(r.Method, tracing.KindServer, parent)
+	span.SetAttributes(clientAttributes(r.RemoteAddr)...)
+	span.SetAttributes(
+		tracing.String("http.request.method", r.Method),
+		tracing.String("url.path", r.URL.Path),
+		tracing.String("server.address", r.Host),
+	)
+	return span
+}
+
+// finishRequestSpan ends the span of an HTTP request, answered through rec.
+func finishRequestSpan(span *tracing.Span, rec *responseRecorder) {
+	if rec.backend != "" {
+		span.SetAttributes(tracing.String("lb.backend", rec.backend))
+	}
+	span.SetAttributes(tracing.Int("http.response.status_code", int64(rec.status)))
+	if rec.status >= 500 {
+		span.SetError(fmt.Errorf("answered %d", rec.status))
+	}
+	span.Finish()
+}
+
+// finishTrySpan ends the span of a try of a request on a backend.
+func finishTrySpan(attempt *httpAttempt, sent, received int64, err error) {
+	span := attempt.span
+	span.SetAttributes(
+		tracing.String("lb.backend", attempt.backend),
+		tracing.Int("lb.bytes_sent", sent),
+		tracing.Int("lb.bytes_received", received),
+	)
+	if attempt.status != 0 {
+		span.SetAttributes(tracing.Int("http.response.status_code", int64(attempt.status)))
+	}
+	span.SetError(err)
+	span.Finish()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+	"Load-Balancer/pkg/load_balancer"
+)
+
+// ---------------- Backend state changes ---------------- //
+
+var backendTransitions = registry.Counter("lb_backend_transitions_total",
+	"Backends changing state, e.g. healthy to unhealthy or ejected, by backend, previous and new state and reason.",
+	"backend", "from", "to", "reason")
+
+// maxTransitions is how many of the last state changes GET /transitions keeps
+const maxTransitions = 200
+
+// transitions are the last state changes of every pool's backends, oldest first
+var transitions struct {
+	mu   sync.Mutex
+	list []load_balancer.Transition
+}
+
+// transitionLog are the Events of every pool: it logs and counts the state
+// changes of its backends, and keeps the last ones for GET /transitions.
+type transitionLog struct {
+	load_balancer.NoEvents
+}
+
+func (transitionLog) OnTransition(t load_balancer.Transition) {
+	why := t.Reason
+	if t.Detail != "" {
+		why += ": " + t.Detail
+	}
+	logger.Printf("Backend %s %s -> %s (%s) after %v %s", t.Server, t.From, t.To, why, t.After.Round(time.Millisecond), t.From)
+	backendTransitions.With(t.Server, t.From, t.To, t.Reason).Inc()
+
+	transitions.mu.Lock()
+	defer transitions.mu.Unlock()
+	if len(transitions.list) == maxTransitions {
+		transitions.list = append(transitions.list[:0], transitions.list[1:]...)
+	}
+	transitions.list = append(transitions.list, t)
+}
+
+// transitionStatus is a state change as listed on GET /transitions.
+type transitionStatus struct {
+	Server string    `json:"server"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason"`
+	Detail string    `json:"detail,omitempty"`
+	At     time.Time `json:"at"`
+	After  float64   `json:"after_seconds"` // in From
+}
+
+// recentTransitions returns the last state changes of server, of every backend
+// if empty, oldest first.
+func recentTransitions(server string) []transitionStatus {
+	transitions.mu.Lock()
+	defer transitions.mu.Unlock()
+	out := []transitionStatus{}
+	for _, t := range transitions.list {
+		if server == "" || t.Server == server {
+			out = append(out, transitionStatus{t.Server, t.From, t.To, t.Reason, t.Detail, t.At, t.After.Seconds()})
+		}
+	}
+	return out
+}
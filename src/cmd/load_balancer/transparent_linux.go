@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"golang.org/x/sys/unix"
+)
+
+// ---------------- Transparent proxy ---------------- //
+
+// setTransparent sets IP_TRANSPARENT on a backend connection's socket before it
+// is bound, so it can be bound to the client's address, which isn't local.
+func setTransparent(network, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		if network == "tcp6" {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+		} else {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+		}
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// checkTransparent reports whether sockets can be made transparent, which
+// takes CAP_NET_ADMIN.
+func checkTransparent() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	if err := unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_TRANSPARENT, 1); err != nil {
+		return fmt.Errorf("setting IP_TRANSPARENT: %w (needs CAP_NET_ADMIN)", err)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// ---------------- Transparent proxy ---------------- //
+
+var errTransparent = errors.New("-transparent is only supported on Linux")
+
+// setTransparent fails: binding to a non-local address takes IP_TRANSPARENT,
+// which is Linux only.
+func setTransparent(network, address string, c syscall.RawConn) error {
+	return errTransparent
+}
+
+func checkTransparent() error {
+	return errTransparent
+}
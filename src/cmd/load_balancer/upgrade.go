@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ---------------- Binary upgrade ---------------- //
+
+// On SIGUSR2 the balancer starts its binary again, as found on disk, and hands
+// it the listening sockets. Once the new process serves them the old one stops
+// accepting and drains, so an upgrade doesn't refuse a single connection.
+
+// upgradeEnv names, for a process started by an upgrade, the addresses of the
+// sockets it was handed, comma-separated: the first on fd 4, the next on fd 5
+// and so on. Fd 3 is a pipe to write to once they are served.
+const upgradeEnv = "LB_UPGRADE_LISTENERS"
+
+// socket is a listening socket and the address it was opened for.
+type socket struct {
+	addr string
+	l    net.Listener
+}
+
+var (
+	sockets   []socket                      // every socket listen opened or took over, handed on upgrade
+	inherited = map[string][]net.Listener{} // sockets handed over by the old process or systemd, until listen takes them
+	ready     *os.File                      // pipe to the old process; nil unless started by an upgrade
+)
+
+// inheritSockets takes over the sockets of the process that started this one
+// on upgrade. listen picks them up by address instead of opening new ones.
+func inheritSockets() error {
+	addrs, ok := os.LookupEnv(upgradeEnv)
+	if !ok {
+		return nil
+	}
+	// not for health check scripts and the like
+	os.Unsetenv(upgradeEnv)
+	closeOnExec(3)
+	ready = os.NewFile(3, "upgrade")
+	if addrs == "" {
+		return nil
+	}
+	for i, addr := range strings.Split(addrs, ",") {
+		f := os.NewFile(uintptr(4+i), addr)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("socket for %s: %v", addr, err)
+		}
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(true)
+		}
+		inherited[addr] = append(inherited[addr], l)
+	}
+	return nil
+}
+
+// takeOver closes the inherited sockets nothing listens on and, after an
+// upgrade, tells the old process the others are served here now, so it can
+// drain.
+func takeOver() {
+	for addr, ls := range inherited {
+		for _, l := range ls {
+			l.Close()
+		}
+		logger.Printf("Closed inherited listener on %s, not configured", addr)
+	}
+	inherited = nil
+	if ready == nil {
+		return
+	}
+	ready.Write([]byte{1})
+	ready.Close()
+	logger.Printf("Took over the listeners of process %d", os.Getppid())
+}
+
+// upgrade starts the binary again with the same arguments and hands it every
+// listening socket. It returns the new process's pid once the process serves
+// them, after closing them here; the caller drains. If the new process exits
+// first, this one keeps serving.
+func upgrade() (int, error) {
+	path, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return 0, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	files := []*os.File{w}
+	addrs := make([]string, 0, len(sockets))
+	for _, s := range sockets {
+		fl, ok := s.l.(interface{ File() (*os.File, error) })
+		if !ok {
+			w.Close()
+			return 0, fmt.Errorf("listener on %s can't be handed over", s.addr)
+		}
+		f, err := fl.File()
+		if err != nil {
+			w.Close()
+			return 0, err
+		}
+		defer f.Close()
+		files = append(files, f)
+		addrs = append(addrs, s.addr)
+	}
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), upgradeEnv+"="+strings.Join(addrs, ","))
+	cmd.ExtraFiles = files
+	err = cmd.Start()
+	w.Close()
+	// handing the files over made the sockets blocking, here too: they share
+	// the open file description
+	for _, f := range files[1:] {
+		setNonblock(f)
+	}
+	if err != nil {
+		return 0, err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	if n, _ := r.Read(make([]byte, 1)); n == 0 {
+		err := <-exited
+		if err == nil {
+			err = errors.New("exit status 0")
+		}
+		return 0, fmt.Errorf("new process exited before serving: %v", err)
+	}
+	for _, s := range sockets {
+		// the path is the new process's to remove now
+		if ul, ok := s.l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		s.l.Close()
+	}
+	return cmd.Process.Pid, nil
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// ---------------- Binary upgrade ---------------- //
+
+// notifyUpgrade does nothing: there is no SIGUSR2 to ask for an upgrade, and
+// sockets can't be handed to a child process, outside Unix.
+func notifyUpgrade(c chan<- os.Signal) {}
+
+func closeOnExec(fd int) {}
+
+func setNonblock(f *os.File) {}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ---------------- Binary upgrade ---------------- //
+
+// notifyUpgrade relays SIGUSR2, which asks for a binary upgrade, to c.
+func notifyUpgrade(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
+
+func closeOnExec(fd int) {
+	syscall.CloseOnExec(fd)
+}
+
+// setNonblock puts f back in non-blocking mode, which Fd takes it out of.
+func setNonblock(f *os.File) {
+	if c, err := f.SyscallConn(); err == nil {
+		c.Control(func(fd uintptr) { syscall.SetNonblock(int(fd), true) })
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"time"
+	"Load-Balancer/pkg/discovery"
+	"Load-Balancer/pkg/load_balancer"
+	"gopkg.in/yaml.v3"
+)
+
+// ---------------- Config validation ---------------- //
+
+// checkArgs turns the arguments of load_balancer check [config file] [flags]
+// into those of -validate.
+func checkArgs(args []string) []string {
+	out := []string{args[0], "-validate"}
+	rest := args[2:]
+	if len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		out = append(out, "-config", rest[0])
+		rest = rest[1:]
+	}
+	return append(out, rest...)
+}
+
+// recordedValue is a flag.Value that keeps the values it was set to, for the
+// flags whose Value can't say, the repeatable ones among them.
+type recordedValue struct {
+	flag.Value
+	values []string
+}
+
+func (v *recordedValue) Set(s string) error {
+	if err := v.Value.Set(s); err != nil {
+		return err
+	}
+	v.values = append(v.values, s)
+	return nil
+}
+
+func (v *recordedValue) String() string {
+	if v == nil || v.Value == nil {
+		return "" // the zero value -h compares defaults with
+	}
+	return v.Value.String()
+}
+
+func (v *recordedValue) IsBoolFlag() bool {
+	b, ok := v.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// recordFlagValues makes the flags whose values the flag package doesn't
+// keep, those of flag.Func, record them for -validate to print.
+func recordFlagValues() {
+	flag.VisitAll(func(f *flag.Flag) {
+		if _, ok := f.Value.(flag.Getter); !ok {
+			f.Value = &recordedValue{Value: f.Value}
+		}
+	})
+}
+
+// duplicateServer returns the first server listed twice, "" if none is.
+func duplicateServer(servers []string) string {
+	seen := make(map[string]bool)
+	for _, s := range servers {
+		s = load_balancer.CanonicalAddress(s)
+		if seen[s] {
+			return s
+		}
+		seen[s] = true
+	}
+	return ""
+}
+
+// checkRoutes returns an error for a route no traffic can reach, because one
+// before it, or one as specific, takes everything it matches.
+func checkRoutes() error {
+	for _, r := range []struct {
+		flag   string
+		routes hostRouter
+	}{{"-sni-route", hostRoutes}, {"-http-route", httpRoutes}} {
+		for j, later := range r.routes {
+			for _, earlier := range r.routes[:j] {
+				if strings.EqualFold(earlier.pattern, later.pattern) || !strings.HasPrefix(later.pattern, "*") && matchHost(earlier.pattern, later.pattern) {
+					return fmt.Errorf("%s %s is never used, %s comes first and matches it", r.flag, later.pattern, earlier.pattern)
+				}
+			}
+		}
+	}
+	for j, later := range httpPathRoutes {
+		for _, earlier := range httpPathRoutes[:j] {
+			if earlier.prefix == later.prefix {
+				return fmt.Errorf("-http-path-route %s is routed twice", later.prefix)
+			}
+		}
+	}
+	for j, later := range httpMatchRoutes {
+		for _, earlier := range httpMatchRoutes[:j] {
+			if earlier.cookie != later.cookie || earlier.name != later.name {
+				continue
+			}
+			shadowed := false
+			switch {
+			case earlier.re == nil && later.re == nil:
+				shadowed = earlier.value == later.value
+			case earlier.re != nil && later.re == nil:
+				shadowed = earlier.re.MatchString(later.value)
+			case earlier.re != nil && later.re != nil:
+				shadowed = earlier.re.String() == later.re.String()
+			}
+			if shadowed {
+				what, rule := "-http-header-route", later.name+":"+later.value
+				if later.cookie {
+					what = "-http-cookie-route"
+				}
+				if later.re != nil {
+					rule = later.name + "~" + later.re.String()
+				}
+				return fmt.Errorf("%s %s is never used, an earlier route takes every request it matches", what, rule)
+			}
+		}
+	}
+	for j, later := range geoRoutes {
+		for _, earlier := range geoRoutes[:j] {
+			if earlier.continent != later.continent {
+				continue
+			}
+			for _, code := range later.codes {
+				if slices.Contains(earlier.codes, code) {
+					return fmt.Errorf("-geo-route: %s is in two routes, the first takes its clients", code)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkListeners returns an error if two of addrs, the main listener's
+// (comma-separated) and the frontends', would listen on the same address.
+func checkListeners(addrs []string) error {
+	var all []string
+	for _, a := range addrs {
+		all = append(all, strings.Split(a, ",")...)
+	}
+	for j, later := range all {
+		for _, earlier := range all[:j] {
+			if sameListener(earlier, later) {
+				return fmt.Errorf("two listeners on %s", later)
+			}
+		}
+	}
+	return nil
+}
+
+// sameListener reports whether listening on a and b would take the same
+// address: the same path for unix sockets, the same port on an address or
+// every address for TCP.
+func sameListener(a, b string) bool {
+	if strings.HasPrefix(a, "unix://") || strings.HasPrefix(b, "unix://") {
+		return a == b
+	}
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	every := func(host string) bool { return host == "" || host == "0.0.0.0" || host == "::" }
+	return portA == portB && (hostA == hostB || every(hostA) || every(hostB))
+}
+
+// writeEffectiveConfig writes, as a YAML config file, the configuration the
+// balancer would run with: every flag not at its default, whether given on
+// the command line, in the environment or in the config file at path, the
+// main listener's static backends as servers, with discovered ones left in
+// s, and the frontends of cfg, with their defaults filled in. Loaded as a
+// config, it gives the same balancer, but for -admin-token, a secret left out.
+func writeEffectiveConfig(w io.Writer, path string, cfg *config, policyName, serversFlag string, backends []load_balancer.Backend) error {
+	out := make(map[string]any)
+	flag.VisitAll(func(f *flag.Flag) {
+		key := strings.ReplaceAll(f.Name, "-", "_")
+		switch f.Name {
+		case "config", "validate", "check", "check-dial", "s", "a", "admin-token":
+			return
+		}
+		if v, ok := f.Value.(*recordedValue); ok {
+			if len(v.values) > 0 {
+				out[key] = v.values
+			}
+			return
+		}
+		if f.Value.String() == f.DefValue {
+			return
+		}
+		switch v := f.Value.(flag.Getter).Get().(type) {
+		case time.Duration:
+			out[key] = v.String()
+		default:
+			out[key] = v
+		}
+	})
+	out["a"] = policyName
+	var discovered []string
+	for _, s := range strings.Fields(serversFlag) {
+		if strings.HasPrefix(s, discovery.SRVScheme) || strings.HasPrefix(s, discovery.ConsulScheme) || strings.HasPrefix(s, discovery.EtcdScheme) {
+			discovered = append(discovered, s)
+		}
+	}
+	if len(discovered) > 0 {
+		out["s"] = strings.Join(discovered, " ")
+	}
+	if len(backends) > 0 {
+		out["servers"] = effectiveServers(backends)
+	}
+	if cfg != nil {
+		if cfg.SlowStart != 0 {
+			out["slow_start"] = time.Duration(cfg.SlowStart).String()
+		}
+		if cfg.PanicThreshold != 0 {
+			out["panic_threshold"] = cfg.PanicThreshold
+		}
+		var frontends []map[string]any
+		for _, fc := range cfg.Frontends {
+			policy := fc.Policy
+			if policy == "" {
+				policy = policyName
+			}
+			frontends = append(frontends, map[string]any{
+				"name": fc.Name, "listen": fc.Listen, "mode": fc.Mode, "policy": policy,
+				"servers": effectiveServers(backendsOf(fc.Servers)),
+			})
+		}
+		if len(frontends) > 0 {
+			out["frontends"] = frontends
+		}
+	}
+	from := "the command line and LB_* variables"
+	if path != "" {
+		from = "the command line, LB_* variables and " + path
+	}
+	if _, err := fmt.Fprintf(w, "# Effective configuration, from %s\n", from); err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// effectiveServers returns backends as the servers of a config file, leaving
+// out what they have by default.
+func effectiveServers(backends []load_balancer.Backend) []map[string]any {
+	out := make([]map[string]any, 0, len(backends))
+	for _, b := range backends {
+		s := map[string]any{"address": b.Address}
+		if b.Weight != 0 && b.Weight != load_balancer.DefaultWeight {
+			s["weight"] = b.Weight
+		}
+		if b.Zone != "" {
+			s["zone"] = b.Zone
+		}
+		if len(b.Metadata) > 0 {
+			s["metadata"] = b.Metadata
+		}
+		if b.Maintenance {
+			s["maintenance"] = true
+		}
+		out = append(out, s)
+	}
+	return out
+}
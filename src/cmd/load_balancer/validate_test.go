@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"Load-Balancer/pkg/load_balancer"
+	"gopkg.in/yaml.v3"
+)
+
+func TestCheckArgs(t *testing.T) {
+	tests := []struct {
+		args, want []string
+	}{
+		{[]string{"lb", "check"}, []string{"lb", "-validate"}},
+		{[]string{"lb", "check", "lb.yaml"}, []string{"lb", "-validate", "-config", "lb.yaml"}},
+		{[]string{"lb", "check", "lb.yaml", "-p", "8080"}, []string{"lb", "-validate", "-config", "lb.yaml", "-p", "8080"}},
+		{[]string{"lb", "check", "-config", "lb.yaml"}, []string{"lb", "-validate", "-config", "lb.yaml"}},
+	}
+	for _, tt := range tests {
+		if got := checkArgs(tt.args); !slices.Equal(got, tt.want) {
+			t.Errorf("checkArgs(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestDuplicateServer(t *testing.T) {
+	tests := []struct {
+		servers []string
+		want    string
+	}{
+		{nil, ""},
+		{[]string{"localhost:8000", "localhost:8001"}, ""},
+		{[]string{"localhost:8000", "localhost:8001", "localhost:8000"}, "localhost:8000"},
+		{[]string{"[::ffff:10.0.0.1]:8000", "10.0.0.1:8000"}, "10.0.0.1:8000"}, // one backend written two ways
+		{[]string{"[2001:db8:0::1]:8000", "[2001:db8::1]:8000"}, "[2001:db8::1]:8000"},
+	}
+	for _, tt := range tests {
+		if got := duplicateServer(tt.servers); got != tt.want {
+			t.Errorf("duplicateServer(%q) = %q, want %q", tt.servers, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRoutes(t *testing.T) {
+	p := withPools(t, "api", "web")
+	savedSNI, savedGeo := hostRoutes, geoRoutes
+	t.Cleanup(func() { hostRoutes, geoRoutes = savedSNI, savedGeo })
+
+	host := func(patterns ...string) hostRouter {
+		var r hostRouter
+		for _, pattern := range patterns {
+			r = append(r, hostRoute{pattern: pattern, policy: p["api"]})
+		}
+		return r
+	}
+	tests := []struct {
+		name  string
+		sni   []string
+		host  []string
+		path  []string
+		match []string // Name:value=pool or Name~regexp=pool, cookie: for a cookie route
+		geo   []string
+		want  string // in the error, "" for none
+	}{
+		{name: "no routes"},
+		{name: "exact name, then wildcard", sni: []string{"api.example.com", "*.example.com"}},
+		{name: "wildcard takes the exact name", sni: []string{"*.example.com", "api.example.com"},
+			want: "-sni-route api.example.com is never used, *.example.com comes first"},
+		{name: "the same name in another case", host: []string{"api.example.com", "API.example.com"},
+			want: "-http-route API.example.com is never used"},
+		{name: "the same wildcard twice", host: []string{"*.example.com", "*.example.com"}, want: "-http-route *.example.com"},
+		{name: "wildcard, then a name a level below", host: []string{"*.example.com", "a.b.example.com"}},
+		{name: "nested paths", path: []string{"/api=api", "/api/v2=web"}},
+		{name: "the same path twice", path: []string{"/api=api", "/api=web"}, want: "-http-path-route /api is routed twice"},
+		{name: "header values", match: []string{"X-Beta:1=api", "X-Beta:2=web"}},
+		{name: "the same header value twice", match: []string{"X-Beta:1=api", "x-beta:1=web"},
+			want: "-http-header-route X-Beta:1 is never used"},
+		{name: "regexp takes a value", match: []string{"X-Beta~^[0-9]=api", "X-Beta:1=web"}, want: "-http-header-route X-Beta:1"},
+		{name: "value, then regexp", match: []string{"X-Beta:1=api", "X-Beta~^[0-9]=web"}},
+		{name: "the same regexp twice", match: []string{"X-Beta~^1=api", "X-Beta~^1=web"}, want: "-http-header-route X-Beta~^1"},
+		{name: "header and cookie of a name", match: []string{"X-Beta:1=api", "cookie:X-Beta:1=web"}},
+		{name: "the same cookie twice", match: []string{"cookie:beta:1=api", "cookie:beta:1=web"},
+			want: "-http-cookie-route beta:1 is never used"},
+		{name: "countries", geo: []string{"country:DE,FR=api", "country:ES=web"}},
+		{name: "country in two routes", geo: []string{"country:DE,FR=api", "country:fr=web"}, want: "-geo-route: FR is in two routes"},
+		{name: "country and continent", geo: []string{"country:DE=api", "continent:EU=web"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostRoutes, httpRoutes, httpPathRoutes, httpMatchRoutes, geoRoutes = host(tt.sni...), host(tt.host...), nil, nil, nil
+			for _, v := range tt.path {
+				route, err := parsePathRoute(v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				httpPathRoutes = append(httpPathRoutes, route)
+			}
+			for _, v := range tt.match {
+				v, cookie := strings.CutPrefix(v, "cookie:")
+				route, err := parseMatchRoute(v, cookie)
+				if err != nil {
+					t.Fatal(err)
+				}
+				httpMatchRoutes = append(httpMatchRoutes, route)
+			}
+			for _, v := range tt.geo {
+				route, err := parseGeoRoute(v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				geoRoutes = append(geoRoutes, route)
+			}
+			err := checkRoutes()
+			if tt.want == "" && err != nil {
+				t.Errorf("got error %v, want none", err)
+			}
+			if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+				t.Errorf("got error %v, want one with %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckListeners(t *testing.T) {
+	tests := []struct {
+		addrs []string
+		clash bool
+	}{
+		{[]string{":80", ":443"}, false},
+		{[]string{":80", "0.0.0.0:80"}, true},
+		{[]string{"[::]:80", "127.0.0.1:80"}, true},
+		{[]string{"127.0.0.1:80", "127.0.0.2:80"}, false},
+		{[]string{"127.0.0.1:80", "127.0.0.1:80"}, true},
+		{[]string{":80,:443", ":443"}, true}, // the main listener's list
+		{[]string{":80,:443"}, false},
+		{[]string{"unix:///run/lb.sock", "unix:///run/lb.sock"}, true},
+		{[]string{"unix:///run/lb.sock", "unix:///run/admin.sock"}, false},
+		{[]string{"unix:///run/lb.sock", ":80"}, false},
+	}
+	for _, tt := range tests {
+		if err := checkListeners(tt.addrs); (err != nil) != tt.clash {
+			t.Errorf("checkListeners(%q) = %v, want a clash: %v", tt.addrs, err, tt.clash)
+		}
+	}
+}
+
+// withValidateFlags replaces the command line flags for the test by a few of
+// each kind -validate writes, parsed from args, and returns them by name.
+func withValidateFlags(t *testing.T, args ...string) map[string]*flag.Flag {
+	t.Helper()
+	withFlags(t)
+	flag.Int("p", 8080, "")
+	flag.Duration("idle-timeout", 0, "")
+	flag.Bool("proxy-protocol", false, "")
+	flag.String("admin-token", "", "")
+	flag.String("config", "", "")
+	flag.Bool("validate", false, "")
+	var routes []string
+	flag.Func("http-route", "", func(v string) error { routes = append(routes, v); return nil })
+	recordFlagValues()
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]*flag.Flag)
+	flag.VisitAll(func(f *flag.Flag) { out[f.Name] = f })
+	return out
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	args := []string{"-validate", "-a", "LeastConnections", "-p", "9000", "-idle-timeout", "90s", "-admin-token", "secret",
+		"-http-route", "a.example.com=api", "-http-route", "b.example.com=web",
+		"-s", "localhost:8000 srv://_http._tcp.example.com localhost:8001"}
+	withValidateFlags(t, args...)
+	backends := []load_balancer.Backend{
+		{Address: "localhost:8000", Weight: load_balancer.DefaultWeight},
+		{Address: "localhost:8001", Weight: 3, Zone: "b", Maintenance: true},
+	}
+	cfg := &config{
+		SlowStart: duration(30 * time.Second),
+		Frontends: []frontendConfig{{Name: "admin", Listen: ":9100", Mode: "http", Servers: []serverConfig{{Address: "localhost:9101"}}}},
+	}
+	var buf bytes.Buffer
+	if err := writeEffectiveConfig(&buf, "lb.yaml", cfg, "LeastConnections", flag.Lookup("s").Value.String(), backends); err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]any
+	if err := yaml.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("%v in\n%s", err, buf.String())
+	}
+
+	want := map[string]any{
+		"a":            "LeastConnections",
+		"p":            9000,
+		"idle_timeout": "1m30s",
+		"http_route":   []any{"a.example.com=api", "b.example.com=web"},
+		"s":            "srv://_http._tcp.example.com", // discovered, the static ones are servers
+		"servers": []any{
+			map[string]any{"address": "localhost:8000"},
+			map[string]any{"address": "localhost:8001", "weight": 3, "zone": "b", "maintenance": true},
+		},
+		"slow_start": "30s",
+		"frontends": []any{map[string]any{
+			"name": "admin", "listen": ":9100", "mode": "http", "policy": "LeastConnections",
+			"servers": []any{map[string]any{"address": "localhost:9101"}},
+		}},
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("effective config\n%s\nwant\n%v", buf.String(), want)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Error("effective config has the admin token")
+	}
+	if !strings.HasPrefix(buf.String(), "# Effective configuration, from the command line, LB_* variables and lb.yaml\n") {
+		t.Errorf("effective config starts %q", strings.SplitN(buf.String(), "\n", 2)[0])
+	}
+}
+
+func TestEffectiveConfigRoundTrip(t *testing.T) {
+	args := []string{"-a", "LeastConnections", "-p", "9000", "-idle-timeout", "90s", "-proxy-protocol",
+		"-http-route", "a.example.com=api", "-http-route", "b.example.com=web", "-s", "srv://_http._tcp.example.com"}
+	withValidateFlags(t, args...)
+	written := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) { written[f.Name] = f.Value.String() })
+	backends := []load_balancer.Backend{{Address: "localhost:8000", Weight: 3}}
+	var buf bytes.Buffer
+	if err := writeEffectiveConfig(&buf, "", nil, "LeastConnections", flag.Lookup("s").Value.String(), backends); err != nil {
+		t.Fatal(err)
+	}
+
+	// loaded with no flags given, it sets them as they were
+	flags := withValidateFlags(t)
+	path := writeConfig(t, "lb.yaml", buf.String())
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("%v in\n%s", err, buf.String())
+	}
+	if err := cfg.setFlags(path, givenFlags()); err != nil {
+		t.Fatal(err)
+	}
+	for name, f := range flags {
+		if got := f.Value.String(); got != written[name] {
+			t.Errorf("-%s %q, want %q", name, got, written[name])
+		}
+	}
+	routes := flags["http-route"].Value.(*recordedValue).values
+	if want := []string{"a.example.com=api", "b.example.com=web"}; !slices.Equal(routes, want) {
+		t.Errorf("-http-route %q, want %q", routes, want)
+	}
+	if got := cfg.backends(); !reflect.DeepEqual(got, backends) {
+		t.Errorf("servers %+v, want %+v", got, backends)
+	}
+}
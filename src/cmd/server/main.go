@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
+	"log"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 	"github.com/gin-gonic/gin"
 )
@@ -25,15 +29,33 @@ func leibnizPiPrecision(precision int) float64 {
 // Global mutex for single-threaded behavior
 var mu sync.Mutex
 
+// Requests waiting for or holding the mutex
+var queueDepth atomic.Int64
+
 // Middleware: make Gin single-threaded
 func singleThreaded() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		queueDepth.Add(1)
+		defer queueDepth.Add(-1)
 		mu.Lock()
 		defer mu.Unlock()
 		c.Next()
 	}
 }
 
+// Report queue depth to the load balancer admin API every interval
+func reportLoad(url, self string, interval time.Duration) {
+	for range time.Tick(interval) {
+		body, _ := json.Marshal(map[string]any{"server": self, "queue_depth": queueDepth.Load()})
+		resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("load report to %s failed: %v", url, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
 func piHandler(c *gin.Context) {
 	// Get precision value
 	precisionStr := c.Params.ByName("precision")
@@ -52,8 +74,17 @@ func piHandler(c *gin.Context) {
 
 func main() {
 	port := flag.Int("p", 8080, "HTTP port")
+	reportURL := flag.String("report", "", "Load balancer report URL, e.g. http://localhost:9090/report (disabled if empty)")
+	reportAs := flag.String("report-as", "", "Address the load balancer knows this server by (default localhost:<port>)")
 	flag.Parse()
 
+	if *reportURL != "" {
+		if *reportAs == "" {
+			*reportAs = "localhost:" + strconv.Itoa(*port)
+		}
+		go reportLoad(*reportURL, *reportAs, time.Second)
+	}
+
 	// Initialize Gin
 	r := gin.Default()
 	r.StaticFile("/favicon.ico", "../resources/favicon.ico")
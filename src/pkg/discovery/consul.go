@@ -0,0 +1,137 @@
+package discovery
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConsulScheme prefixes backends given as Consul services, e.g. consul://web or
+// consul://web?tag=primary.
+const ConsulScheme = "consul://"
+
+// consulWait is how long a blocking query waits for a change before Consul
+// answers with the unchanged list.
+const consulWait = 5 * time.Minute
+
+// Consul follows the passing instances of a service through the Consul health
+// API, using blocking queries so changes arrive as soon as Consul sees them.
+// Instance tags "zone=<zone>" and "weight=<n>" set the backend's zone and weight;
+// without a weight tag the service's passing weight is used.
+type Consul struct {
+	Agent   string // Consul HTTP address, e.g. http://127.0.0.1:8500
+	Service string
+	Tag     string // only instances with this tag, if set
+
+	Client *http.Client // nil uses one without a timeout, as queries block
+
+	index uint64 // X-Consul-Index of the last answer, 0 for none
+}
+
+// NewConsul returns the Consul provider for a consul:// address.
+func NewConsul(agent, addr string) (*Consul, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("no service in %s", addr)
+	}
+	return &Consul{Agent: strings.TrimSuffix(agent, "/"), Service: u.Host, Tag: u.Query().Get("tag")}, nil
+}
+
+func (c *Consul) Name() string {
+	if c.Tag != "" {
+		return ConsulScheme + c.Service + "?tag=" + c.Tag
+	}
+	return ConsulScheme + c.Service
+}
+
+// consulEntry is the part of a /v1/health/service entry we use.
+type consulEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+		Tags    []string
+		Meta    map[string]string
+		Weights struct {
+			Passing int
+		}
+	}
+}
+
+// Backends returns the passing instances of the service. Except on the first
+// call it blocks until they change or consulWait passes.
+func (c *Consul) Backends(ctx context.Context) ([]load_balancer.Backend, error) {
+	q := url.Values{"passing": {"true"}}
+	if c.Tag != "" {
+		q.Set("tag", c.Tag)
+	}
+	if c.index > 0 {
+		q.Set("index", strconv.FormatUint(c.index, 10))
+		q.Set("wait", consulWait.String())
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Agent+"/v1/health/service/"+url.PathEscape(c.Service)+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul %s: %s", c.Service, resp.Status)
+	}
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("consul %s: %w", c.Service, err)
+	}
+
+	// an index going backwards means Consul's state was reset; start over
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if index < c.index {
+		index = 0
+	}
+	c.index = index
+
+	out := make([]load_balancer.Backend, 0, len(entries))
+	for _, e := range entries {
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		b := load_balancer.Backend{
+			Address:  net.JoinHostPort(host, strconv.Itoa(e.Service.Port)),
+			Weight:   e.Service.Weights.Passing,
+			Metadata: e.Service.Meta,
+		}
+		for _, tag := range e.Service.Tags {
+			key, val, _ := strings.Cut(tag, "=")
+			switch key {
+			case "zone":
+				b.Zone = val
+			case "weight":
+				if w, err := strconv.Atoi(val); err == nil {
+					b.Weight = w
+				}
+			}
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
@@ -0,0 +1,67 @@
+package discovery_test
+
+import (
+	"Load-Balancer/pkg/discovery"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConsul(t *testing.T) {
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/web" {
+			http.NotFound(w, r)
+			return
+		}
+		queries = append(queries, r.URL.RawQuery)
+		w.Header().Set("X-Consul-Index", "42")
+		w.Write([]byte(`[
+			{"Node": {"Address": "10.0.0.1"}, "Service": {"Port": 8000, "Tags": ["zone=eu-west-1a", "weight=3"], "Weights": {"Passing": 1}}},
+			{"Node": {"Address": "10.0.0.1"}, "Service": {"Address": "10.0.0.2", "Port": 8001, "Meta": {"version": "2"}, "Weights": {"Passing": 2}}}
+		]`))
+	}))
+	defer srv.Close()
+
+	p, err := discovery.NewConsul(srv.URL, "consul://web?tag=primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name() != "consul://web?tag=primary" {
+		t.Errorf("got name %s", p.Name())
+	}
+	backends, err := p.Backends(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backends) != 2 {
+		t.Fatalf("got %+v, want 2 backends", backends)
+	}
+	if b := backends[0]; b.Address != "10.0.0.1:8000" || b.Zone != "eu-west-1a" || b.Weight != 3 {
+		t.Errorf("got %+v, want tags mapped to zone and weight", b)
+	}
+	if b := backends[1]; b.Address != "10.0.0.2:8001" || b.Weight != 2 || b.Metadata["version"] != "2" {
+		t.Errorf("got %+v, want service address, passing weight and meta", b)
+	}
+
+	// the next query blocks on the index of the last answer
+	if _, err := p.Backends(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"passing=true&tag=primary", "index=42&passing=true&tag=primary&wait=5m0s"}
+	if len(queries) != 2 || queries[0] != expected[0] || queries[1] != expected[1] {
+		t.Errorf("got queries %q, want %q", queries, expected)
+	}
+}
+
+func TestConsulError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no leader", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	p, _ := discovery.NewConsul(srv.URL, "consul://web")
+	if _, err := p.Backends(context.Background()); err == nil {
+		t.Error("got no error from a failing agent")
+	}
+}
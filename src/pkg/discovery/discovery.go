@@ -0,0 +1,59 @@
+// Package discovery finds backends in external registries, so the pool can
+// follow a service as its instances come and go.
+package discovery
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"context"
+	"time"
+)
+
+// SourceKey is the Metadata key under which backends record the provider that
+// found them, so backends from different sources can be kept apart.
+const SourceKey = "discovery"
+
+// Provider looks up the current backends of a service.
+type Provider interface {
+	// Name identifies the provider, e.g. "srv://_http._tcp.example.com".
+	Name() string
+	// Backends returns the backends of the service. Providers backed by a
+	// registry that supports it block until the set may have changed.
+	Backends(ctx context.Context) ([]load_balancer.Backend, error)
+}
+
+// Watch calls update with the backends of p, again and again until ctx is done,
+// waiting interval between lookups. Failed lookups are passed to onError and
+// don't call update, so a registry outage doesn't empty the pool.
+func Watch(ctx context.Context, p Provider, interval time.Duration, update func([]load_balancer.Backend), onError func(error)) {
+	for {
+		backends, err := p.Backends(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			onError(err)
+		} else {
+			update(tag(p.Name(), backends))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// tag records source in the Metadata of every backend, and makes their
+// addresses canonical so they match the ones given directly.
+func tag(source string, backends []load_balancer.Backend) []load_balancer.Backend {
+	for i, b := range backends {
+		backends[i].Address = load_balancer.CanonicalAddress(b.Address)
+		meta := make(map[string]string, len(b.Metadata)+1)
+		for k, v := range b.Metadata {
+			meta[k] = v
+		}
+		meta[SourceKey] = source
+		backends[i].Metadata = meta
+	}
+	return backends
+}
@@ -0,0 +1,164 @@
+package discovery
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// EtcdScheme prefixes backends given as an etcd key prefix, e.g. etcd:///services/web/.
+const EtcdScheme = "etcd://"
+
+// Etcd follows backends that register themselves under a key prefix in etcd.
+// Each key holds a JSON backend spec:
+//
+//	{"address": "10.0.0.1:8000", "weight": 2, "zone": "eu-west-1a", "metadata": {"version": "2"}}
+//
+// where the address defaults to the last element of the key. It talks to the
+// JSON gateway of etcd v3, so it needs no client library.
+type Etcd struct {
+	Endpoint string // etcd HTTP address, e.g. http://127.0.0.1:2379
+	Prefix   string
+
+	Client *http.Client // nil uses one without a timeout, as watches block
+
+	revision int64 // of the last range, 0 before the first
+}
+
+// NewEtcd returns the etcd provider for an etcd:// address.
+func NewEtcd(endpoint, addr string) *Etcd {
+	return &Etcd{Endpoint: strings.TrimSuffix(endpoint, "/"), Prefix: strings.TrimPrefix(addr, EtcdScheme)}
+}
+
+func (e *Etcd) Name() string { return EtcdScheme + e.Prefix }
+
+// etcdSpec is the value of a registration key.
+type etcdSpec struct {
+	Address  string            `json:"address"`
+	Weight   int               `json:"weight"`
+	Zone     string            `json:"zone"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// etcdKV and etcdHeader are the parts of gateway responses we use; keys and
+// values are base64, revisions are strings.
+type etcdKV struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type etcdHeader struct {
+	Revision string `json:"revision"`
+}
+
+// Backends returns the backends registered under the prefix. Except on the first
+// call it first waits for a change under the prefix.
+func (e *Etcd) Backends(ctx context.Context) ([]load_balancer.Backend, error) {
+	if e.revision > 0 {
+		if err := e.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	var resp struct {
+		Header etcdHeader `json:"header"`
+		KVs    []etcdKV   `json:"kvs"`
+	}
+	if err := e.post(ctx, "/v3/kv/range", e.rangeRequest(nil), func(body *json.Decoder) error {
+		return body.Decode(&resp)
+	}); err != nil {
+		return nil, err
+	}
+	e.revision, _ = strconv.ParseInt(resp.Header.Revision, 10, 64)
+
+	out := make([]load_balancer.Backend, 0, len(resp.KVs))
+	for _, kv := range resp.KVs {
+		var spec etcdSpec
+		if err := json.Unmarshal(kv.Value, &spec); err != nil {
+			return nil, fmt.Errorf("etcd %s: %w", kv.Key, err)
+		}
+		if spec.Address == "" {
+			spec.Address = path.Base(string(kv.Key))
+		}
+		out = append(out, load_balancer.Backend{Address: spec.Address, Weight: spec.Weight, Zone: spec.Zone, Metadata: spec.Metadata})
+	}
+	return out, nil
+}
+
+// wait watches the prefix from after the last range until something changes.
+func (e *Etcd) wait(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // ends the watch stream
+	req := map[string]any{"create_request": e.rangeRequest(map[string]any{
+		"start_revision": strconv.FormatInt(e.revision+1, 10),
+	})}
+	return e.post(ctx, "/v3/watch", req, func(body *json.Decoder) error {
+		for {
+			var msg struct {
+				Result struct {
+					Events          []json.RawMessage `json:"events"`
+					CompactRevision string            `json:"compact_revision"`
+				} `json:"result"`
+			}
+			if err := body.Decode(&msg); err != nil {
+				return fmt.Errorf("etcd watch %s: %w", e.Prefix, err)
+			}
+			// compacted past our revision: something may have changed, range again
+			if len(msg.Result.Events) > 0 || msg.Result.CompactRevision != "" {
+				return nil
+			}
+		}
+	})
+}
+
+// rangeRequest selects every key under the prefix, plus extra fields.
+func (e *Etcd) rangeRequest(extra map[string]any) map[string]any {
+	end := []byte(e.Prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			end = end[:i+1]
+			break
+		}
+	}
+	req := map[string]any{
+		"key":       base64.StdEncoding.EncodeToString([]byte(e.Prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(end),
+	}
+	for k, v := range extra {
+		req[k] = v
+	}
+	return req
+}
+
+// post sends a JSON request to the gateway and hands the response body to read.
+func (e *Etcd) post(ctx context.Context, endpoint string, body any, read func(*json.Decoder) error) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint+endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd %s: %s", endpoint, resp.Status)
+	}
+	return read(json.NewDecoder(resp.Body))
+}
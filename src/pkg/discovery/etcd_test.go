@@ -0,0 +1,86 @@
+package discovery_test
+
+import (
+	"Load-Balancer/pkg/discovery"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeEtcd serves a range over registrations and a watch that reports one change.
+func fakeEtcd(t *testing.T, registrations map[string]string) (*httptest.Server, *[]map[string]any) {
+	t.Helper()
+	var watches []map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v3/kv/range", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if key, _ := base64.StdEncoding.DecodeString(req["key"]); string(key) != "/services/web/" {
+			t.Errorf("range over %q", key)
+		}
+		if end, _ := base64.StdEncoding.DecodeString(req["range_end"]); string(end) != "/services/web0" {
+			t.Errorf("range end %q", end)
+		}
+		var kvs []map[string][]byte
+		for k, v := range registrations {
+			kvs = append(kvs, map[string][]byte{"key": []byte(k), "value": []byte(v)})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"header": map[string]string{"revision": "7"}, "kvs": kvs})
+	})
+	mux.HandleFunc("POST /v3/watch", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		json.NewDecoder(r.Body).Decode(&req)
+		watches = append(watches, req["create_request"].(map[string]any))
+		fmt.Fprintln(w, `{"result":{"created":true}}`)
+		w.(http.Flusher).Flush()
+		fmt.Fprintln(w, `{"result":{"events":[{"type":"DELETE"}]}}`)
+	})
+	return httptest.NewServer(mux), &watches
+}
+
+func TestEtcd(t *testing.T) {
+	srv, watches := fakeEtcd(t, map[string]string{
+		"/services/web/a":             `{"address": "10.0.0.1:8000", "weight": 2, "zone": "eu-west-1a"}`,
+		"/services/web/10.0.0.2:8001": `{"metadata": {"version": "2"}}`,
+	})
+	defer srv.Close()
+	p := discovery.NewEtcd(srv.URL, "etcd:///services/web/")
+
+	backends, err := p.Backends(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, b := range backends {
+		got[b.Address] = fmt.Sprintf("%d %s %s", b.Weight, b.Zone, b.Metadata["version"])
+	}
+	expected := map[string]string{"10.0.0.1:8000": "2 eu-west-1a ", "10.0.0.2:8001": "0  2"}
+	if len(got) != len(expected) {
+		t.Fatalf("got %v, want %v", got, expected)
+	}
+	for a, want := range expected {
+		if got[a] != want {
+			t.Errorf("%s: got %q, want %q", a, got[a], want)
+		}
+	}
+
+	// the next call watches from after the revision of the range
+	if _, err := p.Backends(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(*watches) != 1 || (*watches)[0]["start_revision"] != "8" {
+		t.Errorf("got watches %v, want one from revision 8", *watches)
+	}
+}
+
+func TestEtcdBadSpec(t *testing.T) {
+	srv, _ := fakeEtcd(t, map[string]string{"/services/web/a": `not json`})
+	defer srv.Close()
+	if _, err := discovery.NewEtcd(srv.URL, "etcd:///services/web/").Backends(context.Background()); err == nil {
+		t.Error("got no error for a malformed registration")
+	}
+}
@@ -0,0 +1,140 @@
+package discovery
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileSettle is how long the file has to stay quiet before it is read again, so
+// a write in several steps is read once, complete.
+const fileSettle = 200 * time.Millisecond
+
+// File reads backends from a text file with one host:port[:weight] or
+// unix:///path[:weight] per line. Blank lines and everything after a # are
+// ignored:
+//
+//	# web tier
+//	10.0.0.1:8000:3
+//	10.0.0.2:8000    # default weight
+//	unix:///var/run/web.sock:2
+type File struct {
+	Path string
+
+	watcher *fsnotify.Watcher // set up by the first call
+}
+
+func NewFile(path string) *File { return &File{Path: path} }
+
+func (f *File) Name() string { return "file://" + f.Path }
+
+// Backends returns the backends listed in the file. Except on the first call it
+// first waits for the file to change.
+func (f *File) Backends(ctx context.Context) ([]load_balancer.Backend, error) {
+	if f.watcher == nil {
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			return nil, err
+		}
+		// the directory, so replacing the file by a rename is seen too
+		if err := w.Add(filepath.Dir(f.Path)); err != nil {
+			w.Close()
+			return nil, err
+		}
+		f.watcher = w
+	} else if err := f.wait(ctx); err != nil {
+		return nil, err
+	}
+	return ReadBackendsFile(f.Path)
+}
+
+// wait returns once the file has changed and settled.
+func (f *File) wait(ctx context.Context) error {
+	name := filepath.Clean(f.Path)
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			f.watcher.Close()
+			return ctx.Err()
+		case ev := <-f.watcher.Events:
+			if filepath.Clean(ev.Name) == name {
+				settle = time.After(fileSettle)
+			}
+		case err := <-f.watcher.Errors:
+			return err
+		case <-settle:
+			return nil
+		}
+	}
+}
+
+// ReadBackendsFile parses a backends file, see File.
+func ReadBackendsFile(path string) ([]load_balancer.Backend, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var out []load_balancer.Backend
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		b, err := parseBackend(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		out = append(out, b)
+	}
+	return out, scanner.Err()
+}
+
+// parseBackend parses host:port[:weight] or unix:///path[:weight]; the host may
+// be a bracketed IPv6 address.
+func parseBackend(s string) (load_balancer.Backend, error) {
+	if strings.HasPrefix(s, load_balancer.UnixScheme) {
+		return parseSocket(s)
+	}
+	if i := strings.LastIndex(s, ":"); i > 0 {
+		if _, _, err := net.SplitHostPort(s[:i]); err == nil {
+			w, err := strconv.Atoi(s[i+1:])
+			if err != nil || w < 0 {
+				return load_balancer.Backend{}, fmt.Errorf("invalid weight in %q", s)
+			}
+			return load_balancer.Backend{Address: s[:i], Weight: w}, nil
+		}
+	}
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		return load_balancer.Backend{}, err
+	}
+	return load_balancer.Backend{Address: s}, nil
+}
+
+// parseSocket parses unix:///path[:weight].
+func parseSocket(s string) (load_balancer.Backend, error) {
+	b := load_balancer.Backend{Address: s}
+	if i := strings.LastIndex(s, ":"); i >= len(load_balancer.UnixScheme) {
+		w, err := strconv.Atoi(s[i+1:])
+		if err != nil || w < 0 {
+			return load_balancer.Backend{}, fmt.Errorf("invalid weight in %q", s)
+		}
+		b = load_balancer.Backend{Address: s[:i], Weight: w}
+	}
+	if b.Address == load_balancer.UnixScheme {
+		return load_balancer.Backend{}, fmt.Errorf("no socket path in %q", s)
+	}
+	return b, nil
+}
@@ -0,0 +1,62 @@
+package discovery_test
+
+import (
+	"Load-Balancer/pkg/discovery"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadBackendsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.txt")
+	os.WriteFile(path, []byte("# web tier\n10.0.0.1:8000:3\n\n  10.0.0.2:8000   # default weight\n[::1]:8001:2\n[::1]:8002\nunix:///run/web.sock:4\nunix:///run/api.sock\n"), 0o644)
+
+	backends, err := discovery.ReadBackendsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		addr   string
+		weight int
+	}{{"10.0.0.1:8000", 3}, {"10.0.0.2:8000", 0}, {"[::1]:8001", 2}, {"[::1]:8002", 0}, {"unix:///run/web.sock", 4}, {"unix:///run/api.sock", 0}}
+	if len(backends) != len(expected) {
+		t.Fatalf("got %+v, want %+v", backends, expected)
+	}
+	for i, e := range expected {
+		if backends[i].Address != e.addr || backends[i].Weight != e.weight {
+			t.Errorf("line %d: got %s weight %d, want %s weight %d", i, backends[i].Address, backends[i].Weight, e.addr, e.weight)
+		}
+	}
+}
+
+func TestReadBackendsFileErrors(t *testing.T) {
+	for _, content := range []string{"localhost\n", "localhost:8000:x\n", "localhost:8000:-1\n", "unix://\n", "unix:///run/web.sock:x\n"} {
+		path := filepath.Join(t.TempDir(), "servers.txt")
+		os.WriteFile(path, []byte(content), 0o644)
+		if _, err := discovery.ReadBackendsFile(path); err == nil {
+			t.Errorf("%q: got no error", content)
+		}
+	}
+}
+
+func TestFileWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.txt")
+	os.WriteFile(path, []byte("10.0.0.1:8000\n"), 0o644)
+	p := discovery.NewFile(path)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if b, err := p.Backends(ctx); err != nil || len(b) != 1 {
+		t.Fatalf("got %v, %v", b, err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		os.WriteFile(path, []byte("10.0.0.1:8000\n10.0.0.2:8000\n"), 0o644)
+	}()
+	// blocks until the rewrite
+	if b, err := p.Backends(ctx); err != nil || len(b) != 2 {
+		t.Errorf("got %v, %v after rewrite, want 2 backends", b, err)
+	}
+}
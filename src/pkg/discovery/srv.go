@@ -0,0 +1,59 @@
+package discovery
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// SRVScheme prefixes backends given as SRV names, e.g. srv://_http._tcp.example.com.
+const SRVScheme = "srv://"
+
+// SRV resolves a DNS SRV name into backends. Only the records with the lowest
+// priority are used, the others being fallbacks by definition; their SRV
+// weights become backend weights, a weight of 0 counting as 1.
+type SRV struct {
+	Domain string // full SRV name, e.g. _http._tcp.example.com
+
+	// Lookup resolves SRV records; nil uses net.DefaultResolver.
+	Lookup func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// NewSRV returns the SRV provider for a srv:// address.
+func NewSRV(addr string) *SRV {
+	return &SRV{Domain: strings.TrimPrefix(addr, SRVScheme)}
+}
+
+func (s *SRV) Name() string { return SRVScheme + s.Domain }
+
+func (s *SRV) Backends(ctx context.Context) ([]load_balancer.Backend, error) {
+	lookup := s.Lookup
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupSRV
+	}
+	_, records, err := lookup(ctx, "", "", s.Domain)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	lowest := records[0].Priority
+	for _, r := range records {
+		lowest = min(lowest, r.Priority)
+	}
+	var out []load_balancer.Backend
+	for _, r := range records {
+		if r.Priority != lowest {
+			continue
+		}
+		out = append(out, load_balancer.Backend{
+			Address:  net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port))),
+			Weight:   max(int(r.Weight), 1),
+			Metadata: map[string]string{"srv_priority": strconv.Itoa(int(r.Priority))},
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,75 @@
+package discovery_test
+
+import (
+	"Load-Balancer/pkg/discovery"
+	"Load-Balancer/pkg/load_balancer"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func fakeSRV(records []*net.SRV, err error) func(context.Context, string, string, string) (string, []*net.SRV, error) {
+	return func(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		return name, records, err
+	}
+}
+
+func TestSRV(t *testing.T) {
+	p := discovery.NewSRV("srv://_http._tcp.example.com")
+	p.Lookup = fakeSRV([]*net.SRV{
+		{Target: "a.example.com.", Port: 8000, Priority: 10, Weight: 5},
+		{Target: "b.example.com.", Port: 8001, Priority: 10, Weight: 0},
+		{Target: "backup.example.com.", Port: 8000, Priority: 20, Weight: 1},
+	}, nil)
+	if p.Name() != "srv://_http._tcp.example.com" {
+		t.Errorf("got name %s", p.Name())
+	}
+
+	backends, err := p.Backends(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{"a.example.com:8000": 5, "b.example.com:8001": 1}
+	if len(backends) != len(expected) {
+		t.Fatalf("got %v, want %v", backends, expected)
+	}
+	for _, b := range backends {
+		if w, ok := expected[b.Address]; !ok || b.Weight != w {
+			t.Errorf("got %s weight %d, want %v", b.Address, b.Weight, expected)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	p := discovery.NewSRV("srv://_http._tcp.example.com")
+	p.Lookup = fakeSRV([]*net.SRV{{Target: "a.example.com.", Port: 8000}}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := make(chan []load_balancer.Backend)
+	go discovery.Watch(ctx, p, time.Millisecond, func(b []load_balancer.Backend) {
+		select {
+		case updates <- b:
+		case <-ctx.Done():
+		}
+	}, func(err error) {})
+	got := <-updates
+	cancel()
+	if len(got) != 1 || got[0].Metadata[discovery.SourceKey] != p.Name() {
+		t.Errorf("got %+v, want one backend tagged with %s", got, p.Name())
+	}
+}
+
+func TestWatchKeepsBackendsOnError(t *testing.T) {
+	p := discovery.NewSRV("srv://_http._tcp.example.com")
+	p.Lookup = fakeSRV(nil, errors.New("no such host"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var updates, failures int
+	discovery.Watch(ctx, p, time.Millisecond, func([]load_balancer.Backend) { updates++ }, func(error) { failures++ })
+	if updates != 0 || failures == 0 {
+		t.Errorf("got %d updates and %d failures, want only failures", updates, failures)
+	}
+}
@@ -0,0 +1,368 @@
+// Package geoip looks client addresses up in a MaxMind DB (.mmdb) file, such as
+// GeoLite2-Country or GeoIP2-City, for the country and continent they are in.
+// Only what routing needs is decoded; the format is described at
+// https://maxmind.github.io/MaxMind-DB/.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"sync"
+)
+
+// Location is where an address is, as ISO codes; fields are "" if unknown.
+type Location struct {
+	Country   string // ISO 3166-1 alpha-2, e.g. "DE"
+	Continent string // two letters, e.g. "EU"
+}
+
+// String returns the location as country/continent, e.g. "DE/EU", or "-" if
+// nothing is known.
+func (l Location) String() string {
+	if l == (Location{}) {
+		return "-"
+	}
+	return l.Country + "/" + l.Continent
+}
+
+// metadataStart marks the metadata section at the end of the file.
+var metadataStart = []byte("\xab\xcd\xefMaxMind.com")
+
+// ErrFormat is returned for a file that isn't a valid MaxMind DB.
+var ErrFormat = errors.New("invalid MaxMind DB")
+
+// DB is a database read into memory. It is safe for concurrent use.
+type DB struct {
+	Type       string // database_type from the metadata, e.g. "GeoLite2-Country"
+	tree, data []byte
+	nodeCount  uint
+	recordSize uint
+	ipv4Start  uint // node IPv4 lookups start from, in an IPv6 tree
+	ipv6       bool
+	cache      sync.Map // data offset -> Location, many networks share a record
+}
+
+// Open reads the database at path.
+func Open(path string) (*DB, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := New(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+// New reads a database from its bytes.
+func New(b []byte) (*DB, error) {
+	i := bytes.LastIndex(b, metadataStart)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: no metadata", ErrFormat)
+	}
+	meta, _, err := (&decoder{buf: b[i+len(metadataStart):]}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %v", ErrFormat, err)
+	}
+	m, ok := meta.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrFormat)
+	}
+	nodeCount, _ := m["node_count"].(uint64)
+	recordSize, _ := m["record_size"].(uint64)
+	ipVersion, _ := m["ip_version"].(uint64)
+	dbType, _ := m["database_type"].(string)
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return nil, fmt.Errorf("%w: record size %d", ErrFormat, recordSize)
+	}
+	if ipVersion != 4 && ipVersion != 6 {
+		return nil, fmt.Errorf("%w: IP version %d", ErrFormat, ipVersion)
+	}
+	treeSize := nodeCount * recordSize / 4
+	if treeSize+16 > uint64(i) {
+		return nil, fmt.Errorf("%w: search tree of %d nodes is larger than the file", ErrFormat, nodeCount)
+	}
+	db := &DB{
+		Type:       dbType,
+		tree:       b[:treeSize],
+		data:       b[treeSize+16 : i],
+		nodeCount:  uint(nodeCount),
+		recordSize: uint(recordSize),
+		ipv6:       ipVersion == 6,
+	}
+	if db.ipv6 {
+		// IPv4 addresses live under ::/96
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// Lookup returns the location of ip, the zero Location if the database doesn't
+// know it.
+func (db *DB) Lookup(ip netip.Addr) (Location, error) {
+	ip = ip.Unmap()
+	if ip.Is6() && !db.ipv6 {
+		return Location{}, nil
+	}
+	node, bits := uint(0), ip.AsSlice()
+	if ip.Is4() && db.ipv6 {
+		node = db.ipv4Start
+	}
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, bits[i/8]>>(7-i%8)&1)
+	}
+	switch {
+	case node == db.nodeCount:
+		return Location{}, nil // no data
+	case node < db.nodeCount+16:
+		return Location{}, fmt.Errorf("%w: lookup of %s ended on record %d", ErrFormat, ip, node)
+	}
+	offset := node - db.nodeCount - 16
+	if loc, ok := db.cache.Load(offset); ok {
+		return loc.(Location), nil
+	}
+	v, _, err := (&decoder{buf: db.data}).decode(offset)
+	if err != nil {
+		return Location{}, fmt.Errorf("%w: record of %s: %v", ErrFormat, ip, err)
+	}
+	loc := location(v)
+	db.cache.Store(offset, loc)
+	return loc, nil
+}
+
+// location picks the codes out of a record, the registered country standing in
+// for an address whose country isn't known.
+func location(v any) Location {
+	m, _ := v.(map[string]any)
+	code := func(key, field string) string {
+		sub, _ := m[key].(map[string]any)
+		s, _ := sub[field].(string)
+		return s
+	}
+	loc := Location{Country: code("country", "iso_code"), Continent: code("continent", "code")}
+	if loc.Country == "" {
+		loc.Country = code("registered_country", "iso_code")
+	}
+	return loc
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (db *DB) record(node uint, bit byte) uint {
+	size := db.recordSize * 2 / 8
+	b := db.tree[node*size : (node+1)*size]
+	switch db.recordSize {
+	case 24:
+		if bit == 0 {
+			return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3])<<16 | uint(b[4])<<8 | uint(b[5])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// Data section types.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDepth bounds the nesting of maps, arrays and pointers, so a corrupt file
+// can't recurse without end.
+const maxDepth = 32
+
+// decoder reads values from a data section. Numbers decode to uint64, int64
+// and float64, maps to map[string]any and arrays to []any; 128-bit integers
+// and bytes stay []byte.
+type decoder struct {
+	buf   []byte
+	depth int
+}
+
+var errShort = errors.New("data runs past the end of the section")
+
+// decode reads the value at offset and returns it with the offset after it.
+func (d *decoder) decode(offset uint) (any, uint, error) {
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ == typePointer {
+		target, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		if d.depth++; d.depth > maxDepth {
+			return nil, 0, errors.New("data nested too deep")
+		}
+		v, _, err := d.decode(target)
+		d.depth--
+		return v, next, err
+	}
+	return d.value(typ, size, offset)
+}
+
+// control reads the control byte(s) at offset: the type and size of the value
+// that follows.
+func (d *decoder) control(offset uint) (typ int, size, next uint, err error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, errShort
+	}
+	c := d.buf[offset]
+	offset++
+	typ = int(c >> 5)
+	if typ == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, errShort
+		}
+		typ = 7 + int(d.buf[offset])
+		offset++
+	}
+	size = uint(c & 0x1f)
+	if typ == typePointer || size < 29 {
+		return typ, size, offset, nil
+	}
+	n := size - 28
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, 0, errShort
+	}
+	extra := uint(0)
+	for _, b := range d.buf[offset : offset+n] {
+		extra = extra<<8 | uint(b)
+	}
+	switch size {
+	case 29:
+		size = 29 + extra
+	case 30:
+		size = 285 + extra
+	default:
+		size = 65821 + extra
+	}
+	return typ, size, offset + n, nil
+}
+
+// pointer decodes a pointer whose control byte held size, returning its target
+// and the offset after it.
+func (d *decoder) pointer(size, offset uint) (uint, uint, error) {
+	n := size>>3&3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errShort
+	}
+	p := uint(0)
+	if n < 4 {
+		p = size & 7
+	}
+	for _, b := range d.buf[offset : offset+n] {
+		p = p<<8 | uint(b)
+	}
+	switch n {
+	case 2:
+		p += 2048
+	case 3:
+		p += 526336
+	}
+	return p, offset + n, nil
+}
+
+// value decodes a value of typ and size starting at offset.
+func (d *decoder) value(typ int, size, offset uint) (any, uint, error) {
+	switch typ {
+	case typeMap, typeArray:
+		if d.depth++; d.depth > maxDepth {
+			return nil, 0, errors.New("data nested too deep")
+		}
+		defer func() { d.depth-- }()
+		if typ == typeArray {
+			a := make([]any, 0, min(size, 64))
+			for range size {
+				v, next, err := d.decode(offset)
+				if err != nil {
+					return nil, 0, err
+				}
+				a, offset = append(a, v), next
+			}
+			return a, offset, nil
+		}
+		m := make(map[string]any, min(size, 64))
+		for range size {
+			k, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key of type %T", k)
+			}
+			v, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key], offset = v, next
+		}
+		return m, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, 0, fmt.Errorf("unexpected data type %d", typ)
+	}
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errShort
+	}
+	b, next := d.buf[offset:offset+size], offset+size
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes, typeUint128:
+		return b, next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("double of %d bytes", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("float of %d bytes", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case typeUint16, typeUint32, typeUint64, typeInt32:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("integer of %d bytes", size)
+		}
+		u := uint64(0)
+		for _, c := range b {
+			u = u<<8 | uint64(c)
+		}
+		if typ == typeInt32 {
+			return int64(int32(u)), next, nil
+		}
+		return u, next, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type %d", typ)
+}
@@ -0,0 +1,192 @@
+package geoip_test
+
+import (
+	"Load-Balancer/pkg/geoip"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+const dbType = "GeoLite2-Country-Test-Database" // long enough for an extended size
+
+func TestLookup(t *testing.T) {
+	nets := map[string]geoip.Location{
+		"81.0.0.0/8":    {Country: "DE", Continent: "EU"},
+		"8.8.8.0/24":    {Country: "US", Continent: "NA"},
+		"2001:db8::/32": {Country: "JP", Continent: "AS"},
+	}
+	cases := []struct {
+		ip     string
+		want   geoip.Location
+		ipv6DB bool // only found in an IPv6 database
+	}{
+		{"81.1.2.3", nets["81.0.0.0/8"], false},
+		{"::ffff:81.1.2.3", nets["81.0.0.0/8"], false},
+		{"8.8.8.8", nets["8.8.8.0/24"], false},
+		{"8.8.9.1", geoip.Location{}, false},
+		{"2001:db8::1", nets["2001:db8::/32"], true},
+		{"2001:db9::1", geoip.Location{}, false},
+	}
+	for _, ipVersion := range []int{4, 6} {
+		for _, recordSize := range []int{24, 28, 32} {
+			db, err := geoip.New(mmdb(t, recordSize, ipVersion, nets))
+			if err != nil {
+				t.Fatalf("IPv%d, %d-bit records: %v", ipVersion, recordSize, err)
+			}
+			if db.Type != dbType {
+				t.Errorf("database type %q, want %q", db.Type, dbType)
+			}
+			for _, c := range cases {
+				want := c.want
+				if c.ipv6DB && ipVersion == 4 {
+					want = geoip.Location{}
+				}
+				got, err := db.Lookup(netip.MustParseAddr(c.ip))
+				if err != nil {
+					t.Errorf("IPv%d, %d-bit records: lookup of %s: %v", ipVersion, recordSize, c.ip, err)
+				} else if got != want {
+					t.Errorf("IPv%d, %d-bit records: %s is in %v, want %v", ipVersion, recordSize, c.ip, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	valid := mmdb(t, 24, 6, map[string]geoip.Location{"81.0.0.0/8": {Country: "DE", Continent: "EU"}})
+	for name, b := range map[string][]byte{
+		"no metadata": []byte("not a database"),
+		"truncated":   valid[len(valid)/2:],
+	} {
+		if _, err := geoip.New(b); !errors.Is(err, geoip.ErrFormat) {
+			t.Errorf("%s: got %v, want ErrFormat", name, err)
+		}
+	}
+}
+
+// mmdb builds a MaxMind DB of nets, which must not overlap. Records share
+// their continent through pointers, as real databases do.
+func mmdb(t *testing.T, recordSize, ipVersion int, nets map[string]geoip.Location) []byte {
+	t.Helper()
+	type node struct{ rec [2]any } // *node, a data offset (int), or nil for no data
+	root := &node{}
+	var data []byte
+	continents := make(map[string]int)
+	for prefix, loc := range nets {
+		p := netip.MustParsePrefix(prefix)
+		addr, bits := p.Addr().As16(), p.Bits()
+		if p.Addr().Is4() {
+			bits += 96
+			addr = [16]byte{}
+			v4 := p.Addr().As4()
+			copy(addr[12:], v4[:])
+		}
+		start := 0
+		if ipVersion == 4 {
+			if !p.Addr().Is4() {
+				continue
+			}
+			start = 96
+		}
+		if _, ok := continents[loc.Continent]; !ok {
+			continents[loc.Continent] = len(data)
+			data = append(data, encMap(encString("code"), encString(loc.Continent))...)
+		}
+		offset := len(data)
+		data = append(data, encMap(
+			encString("country"), encMap(encString("iso_code"), encString(loc.Country)),
+			encString("continent"), encPointer(continents[loc.Continent]),
+		)...)
+		n := root
+		for i := start; i < bits; i++ {
+			b := addr[i/8] >> (7 - i%8) & 1
+			if i == bits-1 {
+				n.rec[b] = offset
+				break
+			}
+			child, ok := n.rec[b].(*node)
+			if !ok {
+				child = &node{}
+				n.rec[b] = child
+			}
+			n = child
+		}
+	}
+
+	var nodes []*node
+	ids := make(map[*node]int)
+	var number func(n *node)
+	number = func(n *node) {
+		ids[n] = len(nodes)
+		nodes = append(nodes, n)
+		for _, r := range n.rec {
+			if c, ok := r.(*node); ok {
+				number(c)
+			}
+		}
+	}
+	number(root)
+	var tree []byte
+	for _, n := range nodes {
+		var v [2]uint32
+		for i, r := range n.rec {
+			switch r := r.(type) {
+			case *node:
+				v[i] = uint32(ids[r])
+			case int:
+				v[i] = uint32(len(nodes) + 16 + r)
+			default:
+				v[i] = uint32(len(nodes))
+			}
+		}
+		switch recordSize {
+		case 24:
+			tree = append(tree, byte(v[0]>>16), byte(v[0]>>8), byte(v[0]), byte(v[1]>>16), byte(v[1]>>8), byte(v[1]))
+		case 28:
+			tree = append(tree, byte(v[0]>>16), byte(v[0]>>8), byte(v[0]), byte(v[0]>>20&0xf0|v[1]>>24&0x0f), byte(v[1]>>16), byte(v[1]>>8), byte(v[1]))
+		case 32:
+			tree = binary.BigEndian.AppendUint32(tree, v[0])
+			tree = binary.BigEndian.AppendUint32(tree, v[1])
+		}
+	}
+
+	b := append(tree, make([]byte, 16)...)
+	b = append(b, data...)
+	b = append(b, "\xab\xcd\xefMaxMind.com"...)
+	return append(b, encMap(
+		encString("node_count"), encUint(6, uint32(len(nodes))),
+		encString("record_size"), encUint(5, uint32(recordSize)),
+		encString("ip_version"), encUint(5, uint32(ipVersion)),
+		encString("database_type"), encString(dbType),
+		encString("build_epoch"), append([]byte{4, 2}, 0x66, 0, 0, 0), // extended type: uint64
+	)...)
+}
+
+func encString(s string) []byte {
+	if len(s) < 29 {
+		return append([]byte{2<<5 | byte(len(s))}, s...)
+	}
+	return append([]byte{2<<5 | 29, byte(len(s) - 29)}, s...)
+}
+
+func encMap(kv ...[]byte) []byte {
+	b := []byte{7<<5 | byte(len(kv)/2)}
+	for _, e := range kv {
+		b = append(b, e...)
+	}
+	return b
+}
+
+func encUint(typ byte, v uint32) []byte {
+	b := binary.BigEndian.AppendUint32(nil, v)
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return append([]byte{typ<<5 | byte(len(b))}, b...)
+}
+
+// encPointer points at offset, below 2048.
+func encPointer(offset int) []byte {
+	return []byte{1<<5 | byte(offset>>8), byte(offset)}
+}
@@ -0,0 +1,194 @@
+package load_balancer
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ewmaAlpha is how much a new sample moves the Adaptive averages.
+const ewmaAlpha = 0.2
+
+// AdaptiveCoefficients weigh the terms of the Adaptive score. Zero values all
+// round mean DefaultAdaptiveCoefficients.
+type AdaptiveCoefficients struct {
+	Connections float64 // active connections per unit of weight, relative to the busiest backend
+	Latency     float64 // EWMA latency relative to the slowest backend
+	Errors      float64 // EWMA error rate, 0 to 1
+}
+
+// DefaultAdaptiveCoefficients count errors double so a backend that answers fast
+// only because it fails fast still loses.
+var DefaultAdaptiveCoefficients = AdaptiveCoefficients{Connections: 1, Latency: 1, Errors: 2}
+
+// Adaptive: lowest combined score of load, latency and recent errors. Each term is
+// normalized to 0..1 across the candidates before the coefficients are applied.
+type Adaptive struct {
+	*Pool
+	coef    AdaptiveCoefficients
+	mu      sync.Mutex
+	latency map[string]float64 // EWMA of successful connection durations, seconds
+	errRate map[string]float64 // EWMA of failures (1) and successes (0)
+	next    atomic.Uint64      // where the next scan starts, ties rotate
+	damp    damper
+}
+
+func NewAdaptive(backends []Backend, coef AdaptiveCoefficients) *Adaptive {
+	return newAdaptive(NewPool(backends), coef, Dampening{})
+}
+
+// NewDampedAdaptive is Adaptive that sticks to its backend as described by d.
+func NewDampedAdaptive(backends []Backend, coef AdaptiveCoefficients, d Dampening) *Adaptive {
+	return newAdaptive(NewPool(backends), coef, d)
+}
+
+func newAdaptive(pool *Pool, coef AdaptiveCoefficients, d Dampening) *Adaptive {
+	if coef == (AdaptiveCoefficients{}) {
+		coef = DefaultAdaptiveCoefficients
+	}
+	return &Adaptive{
+		Pool:    pool,
+		coef:    coef,
+		latency: make(map[string]float64),
+		errRate: make(map[string]float64),
+		damp:    damper{Dampening: d},
+	}
+}
+
+func (p *Adaptive) SelectServer(ctx context.Context, info ConnInfo) (string, error) {
+	return p.pinned(info, p.pick)(ctx, nil)
+}
+
+func (p *Adaptive) SelectServers(ctx context.Context, info ConnInfo, n int) ([]string, error) {
+	return selectN(ctx, n, p.pinned(info, p.pick), p.Release)
+}
+
+func (p *Adaptive) pick(ctx context.Context, exclude map[string]bool) (string, error) {
+	candidates := p.candidates(exclude)
+	if err := checkSelect(ctx, len(candidates)); err != nil {
+		return "", err
+	}
+	scores := p.scores(candidates)
+	start := int(p.next.Add(1)-1) % len(candidates)
+	min := math.Inf(1)
+	var selected string
+	for i := range candidates {
+		j := (start + i) % len(candidates)
+		if scores[j] < min {
+			min = scores[j]
+			selected = candidates[j].Address
+		}
+	}
+	best := selected
+	if len(exclude) == 0 { // fallback picks don't move the dampened choice
+		selected = p.damp.choose(selected, func(server string) (float64, bool) {
+			for i, c := range candidates {
+				if c.Address == server {
+					return scores[i], true
+				}
+			}
+			return 0, false
+		})
+	}
+	p.selected(selected)
+	p.explain(ctx, Decision{
+		Policy:  "Adaptive",
+		Chosen:  selected,
+		Reason:  lowestWins(scores, indexOf(candidates, best), indexOf(candidates, selected), "rotating through the tied ones"),
+		Scoring: "connections, latency and error rate, each relative to the worst, weighted by the coefficients; lowest wins",
+	}, exclude, candidates, func(i int) float64 { return scores[i] }, nil)
+	return selected, nil
+}
+
+// scores computes the score of every candidate, in order.
+func (p *Adaptive) scores(candidates []candidate) []float64 {
+	active := p.activeCounts()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	maxLoad, maxLatency := 0.0, 0.0
+	for _, c := range candidates {
+		maxLoad = max(maxLoad, float64(active[c.Address])/c.weight)
+		maxLatency = max(maxLatency, p.latency[c.Address])
+	}
+	scores := make([]float64, len(candidates))
+	for i, c := range candidates {
+		score := p.coef.Errors * p.errRate[c.Address]
+		if maxLoad > 0 {
+			score += p.coef.Connections * float64(active[c.Address]) / c.weight / maxLoad
+		}
+		if maxLatency > 0 {
+			score += p.coef.Latency * p.latency[c.Address] / maxLatency
+		}
+		scores[i] = score
+	}
+	return scores
+}
+
+func (p *Adaptive) Update(server string, result Result) {
+	p.finished(server, result)
+	if p.counters(server) == nil {
+		return // removed meanwhile, don't bring its averages back
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	failed := 0.0
+	if result.Failed() {
+		failed = 1
+	}
+	p.errRate[server] = ewma(p.errRate[server], failed)
+	// only successes say how fast a backend is
+	if !result.Failed() && result.Duration > 0 {
+		if _, ok := p.latency[server]; ok {
+			p.latency[server] = ewma(p.latency[server], result.Duration.Seconds())
+		} else {
+			p.latency[server] = result.Duration.Seconds()
+		}
+	}
+}
+
+func ewma(avg, sample float64) float64 { return avg + ewmaAlpha*(sample-avg) }
+
+func (p *Adaptive) Release(server string) { p.released(server) }
+
+func (p *Adaptive) RemoveServer(server string) error {
+	if err := p.Pool.RemoveServer(server); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.latency, server)
+	delete(p.errRate, server)
+	return nil
+}
+
+// Prime seeds the latency averages with estimates, e.g. from a previous run, so
+// the first connections aren't spread by tie-breaking alone.
+func (p *Adaptive) Prime(latency map[string]time.Duration) {
+	for server, d := range latency {
+		p.restore(server, BackendState{Latency: d.Seconds()})
+	}
+}
+
+// restore seeds the averages of server with saved ones.
+func (p *Adaptive) restore(server string, st BackendState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if st.Latency > 0 {
+		p.latency[server] = st.Latency
+	}
+	p.errRate[server] = st.ErrorRate
+}
+
+func (p *Adaptive) Stats() PolicyStats {
+	st := p.snapshot("Adaptive")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range st.Backends {
+		st.Backends[i].AvgResponseTime = p.latency[st.Backends[i].Address]
+		st.Backends[i].ErrorRate = p.errRate[st.Backends[i].Address]
+	}
+	return st
+}
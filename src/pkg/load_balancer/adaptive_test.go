@@ -0,0 +1,52 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdaptive(t *testing.T) {
+	p := load_balancer.NewAdaptive(servers[:2], load_balancer.AdaptiveCoefficients{})
+
+	// no data yet: ties rotate
+	var res []string
+	for range 2 {
+		s := selectServer(t, p)
+		res = append(res, s)
+		p.Update(s, load_balancer.Result{Duration: 100 * time.Millisecond})
+	}
+	expected := []string{"localhost:5000", "localhost:5001"}
+	if !equal(res, expected) {
+		t.Errorf("got %v, want %v", res, expected)
+	}
+
+	// localhost:5000 starts failing fast, localhost:5001 keeps answering slowly
+	for range 3 {
+		p.Update("localhost:5000", load_balancer.Result{Err: errors.New("reset"), Duration: time.Millisecond})
+		p.Update("localhost:5001", load_balancer.Result{Duration: 300 * time.Millisecond})
+	}
+	for range 4 {
+		s := selectServer(t, p)
+		if s != "localhost:5001" {
+			t.Errorf("got %s, want localhost:5001", s)
+		}
+		p.Release(s)
+	}
+}
+
+func TestAdaptiveCoefficients(t *testing.T) {
+	// latency only: the fast-failing backend wins, which is why errors are weighed by default
+	p := load_balancer.NewAdaptive(servers[:2], load_balancer.AdaptiveCoefficients{Latency: 1})
+	p.Update("localhost:5000", load_balancer.Result{Duration: 10 * time.Millisecond})
+	p.Update("localhost:5000", load_balancer.Result{Err: errors.New("reset"), Duration: time.Millisecond})
+	p.Update("localhost:5001", load_balancer.Result{Duration: 300 * time.Millisecond})
+
+	if s := selectServer(t, p); s != "localhost:5000" {
+		t.Errorf("got %s, want localhost:5000", s)
+	}
+	if st := p.Stats().Backends[0]; st.ErrorRate <= 0 {
+		t.Errorf("error rate %v, want > 0", st.ErrorRate)
+	}
+}
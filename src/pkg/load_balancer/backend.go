@@ -0,0 +1,105 @@
+package load_balancer
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// DefaultWeight is the weight given to backends constructed without one.
+const DefaultWeight = 1
+
+// UnixScheme prefixes backends listening on a unix socket, e.g.
+// unix:///var/run/app.sock.
+const UnixScheme = "unix://"
+
+// HealthState of a backend as seen by the balancer.
+type HealthState int
+
+const (
+	HealthUnknown HealthState = iota // never checked; treated as usable
+	Healthy
+	Unhealthy
+)
+
+func (h HealthState) String() string {
+	switch h {
+	case Healthy:
+		return "healthy"
+	case Unhealthy:
+		return "unhealthy"
+	default:
+		return "unknown"
+	}
+}
+
+// Backend is a server traffic can be sent to.
+type Backend struct {
+	Address  string            // host:port, or unix:///path for a unix socket
+	Weight   int               // relative share of traffic
+	Zone     string            // locality label, e.g. "eu-west-1a"
+	Metadata map[string]string // free-form labels
+	Health   HealthState
+	Draining bool // takes no new connections, open ones finish
+	// Maintenance takes no new connections and isn't health checked, so planned
+	// work on it raises no alarms
+	Maintenance bool
+
+	recovered time.Time // when the backend was added or last came back to healthy; starts slow start
+	status    string    // state as of its last Transition, see Pool.status
+	since     time.Time // when it entered status
+}
+
+// NewBackends turns a list of host:port addresses into backends with the default
+// weight. Addresses are made canonical, see CanonicalAddress.
+func NewBackends(addrs []string) []Backend {
+	backends := make([]Backend, 0, len(addrs))
+	for _, a := range addrs {
+		backends = append(backends, Backend{Address: CanonicalAddress(a), Weight: DefaultWeight})
+	}
+	return backends
+}
+
+// CanonicalAddress returns the form backends are known by, so one backend written
+// two ways is one backend in pools, logs and hashes: an IP address and port as
+// netip formats them (IPv6 bracketed and shortened, IPv4-mapped IPv6 as IPv4),
+// anything else, e.g. a host name, unchanged.
+func CanonicalAddress(addr string) string {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return addr
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()).String()
+}
+
+// copyBackends gives a policy its own copy of the backend list, filling in defaults.
+func copyBackends(backends []Backend) []*Backend {
+	out := make([]*Backend, 0, len(backends))
+	for _, b := range backends {
+		if b.Weight <= 0 {
+			b.Weight = DefaultWeight
+		}
+		out = append(out, &b)
+	}
+	return out
+}
+
+// SplitNetwork returns the network and address to dial a backend address on:
+// "unix" and the socket's path, or "tcp" and addr.
+func SplitNetwork(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, UnixScheme); ok {
+		return "unix", path
+	}
+	return "tcp", addr
+}
+
+// Host returns the host of a backend address, for certificates and logs;
+// a unix socket is on localhost.
+func Host(addr string) string {
+	if strings.HasPrefix(addr, UnixScheme) {
+		return "localhost"
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	return host
+}
@@ -0,0 +1,67 @@
+package load_balancer
+
+import (
+	"sync"
+	"time"
+)
+
+// Backoff spaces out attempts to dial a backend that keeps refusing: after n
+// failures in a row the backend is left alone for Base*2^(n-1), up to Max. It
+// works below the policy, so a down host costs one dial timeout per backoff step
+// rather than one per client. A nil Backoff never holds anything back.
+type Backoff struct {
+	base, max time.Duration
+	mu        sync.Mutex
+	entries   map[string]*backoffEntry
+}
+
+type backoffEntry struct {
+	failures int
+	until    time.Time
+}
+
+func NewBackoff(base, max time.Duration) *Backoff {
+	return &Backoff{base: base, max: max, entries: make(map[string]*backoffEntry)}
+}
+
+// Ready reports whether server may be dialed now.
+func (b *Backoff) Ready(server string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := b.entries[server]
+	return e == nil || !time.Now().Before(e.until)
+}
+
+// Failed records a failed dial and returns how long server is now left alone.
+func (b *Backoff) Failed(server string) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := b.entries[server]
+	if e == nil {
+		e = &backoffEntry{}
+		b.entries[server] = e
+	}
+	e.failures++
+	wait := b.max
+	if shift := e.failures - 1; shift < 32 && b.base<<shift < b.max && b.base<<shift > 0 {
+		wait = b.base << shift
+	}
+	e.until = time.Now().Add(wait)
+	return wait
+}
+
+// Succeeded forgets the failures of server.
+func (b *Backoff) Succeeded(server string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.entries, server)
+}
@@ -0,0 +1,40 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := load_balancer.NewBackoff(10*time.Millisecond, 35*time.Millisecond)
+	expected := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 35 * time.Millisecond, 35 * time.Millisecond}
+	for i, want := range expected {
+		if got := b.Failed("localhost:5000"); got != want {
+			t.Errorf("failure %d: got %v, want %v", i+1, got, want)
+		}
+	}
+	if b.Ready("localhost:5000") {
+		t.Error("ready while backing off")
+	}
+	if !b.Ready("localhost:5001") {
+		t.Error("other backend held back")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if !b.Ready("localhost:5000") {
+		t.Error("not ready after the backoff")
+	}
+	b.Succeeded("localhost:5000")
+	if got := b.Failed("localhost:5000"); got != 10*time.Millisecond {
+		t.Errorf("after success got %v, want the base again", got)
+	}
+}
+
+func TestNilBackoff(t *testing.T) {
+	var b *load_balancer.Backoff
+	b.Failed("localhost:5000")
+	if !b.Ready("localhost:5000") {
+		t.Error("nil backoff held a backend back")
+	}
+}
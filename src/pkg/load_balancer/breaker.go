@@ -0,0 +1,199 @@
+package load_balancer
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BreakerState of one backend's circuit.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // traffic flows
+	BreakerOpen                         // traffic is refused until the probe interval passes
+	BreakerHalfOpen                     // one probe connection decides whether to close again
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerConfig sets when a circuit trips. A result counts as bad if it failed
+// or, with SlowCall set, took longer than SlowCall.
+type BreakerConfig struct {
+	ErrorRate   float64       // open when this share of results in a window is bad, 0 to 1
+	SlowCall    time.Duration // results slower than this count as bad, 0 disables
+	MinRequests int           // results a window needs before it can trip
+	Window      time.Duration // results are counted over windows of this length
+	OpenFor     time.Duration // probe interval: how long a circuit stays open
+}
+
+// BreakerStats are the state and transition counters of one backend's circuit.
+type BreakerStats struct {
+	Address  string `json:"address"`
+	State    string `json:"state"`
+	Opened   uint64 `json:"opened"`    // transitions to open
+	HalfOpen uint64 `json:"half_open"` // probes let through
+	Closed   uint64 `json:"closed"`    // recoveries
+}
+
+// Breaker is a circuit breaker per backend. It sits between policy selection and
+// dialing: Allow filters the selected backends, Record feeds back the outcome.
+// A nil Breaker allows everything.
+type Breaker struct {
+	cfg      BreakerConfig
+	mu       sync.Mutex
+	circuits map[string]*circuit
+
+	// OnTransition, if set, is called on every state change, with the lock held.
+	OnTransition func(server string, from, to BreakerState)
+}
+
+type circuit struct {
+	state       BreakerState
+	since       time.Time // when the state or the counting window started
+	total, bad  int       // results in the current window
+	probing     bool      // half-open probe in flight
+	transitions [3]uint64 // by target state
+}
+
+func NewBreaker(cfg BreakerConfig) *Breaker {
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 1
+	}
+	return &Breaker{cfg: cfg, circuits: make(map[string]*circuit)}
+}
+
+// circuit returns the circuit of server, creating a closed one. Caller holds b.mu.
+func (b *Breaker) circuit(server string, now time.Time) *circuit {
+	c := b.circuits[server]
+	if c == nil {
+		c = &circuit{since: now}
+		b.circuits[server] = c
+	}
+	return c
+}
+
+func (b *Breaker) transition(server string, c *circuit, to BreakerState, now time.Time) {
+	from := c.state
+	c.state, c.since = to, now
+	c.total, c.bad, c.probing = 0, 0, false
+	c.transitions[to]++
+	if b.OnTransition != nil {
+		b.OnTransition(server, from, to)
+	}
+}
+
+// Allow reports whether a connection to server may be attempted. An open circuit
+// lets a single probe through once OpenFor has passed. A probe that is allowed
+// but never used must be handed back with Cancel.
+func (b *Breaker) Allow(server string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	c := b.circuit(server, now)
+	switch c.state {
+	case BreakerOpen:
+		if now.Sub(c.since) < b.cfg.OpenFor {
+			return false
+		}
+		b.transition(server, c, BreakerHalfOpen, now)
+		c.probing = true
+		return true
+	case BreakerHalfOpen:
+		if c.probing {
+			return false
+		}
+		c.probing = true
+	}
+	return true
+}
+
+// Cancel hands back an Allow that didn't lead to a connection.
+func (b *Breaker) Cancel(server string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c := b.circuits[server]; c != nil {
+		c.probing = false
+	}
+}
+
+// Record feeds the outcome of a connection to server into its circuit.
+func (b *Breaker) Record(server string, result Result) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	c := b.circuit(server, now)
+	bad := result.Failed() || (b.cfg.SlowCall > 0 && result.Duration > b.cfg.SlowCall)
+	switch c.state {
+	case BreakerHalfOpen:
+		if bad {
+			b.transition(server, c, BreakerOpen, now)
+		} else {
+			b.transition(server, c, BreakerClosed, now)
+		}
+	case BreakerClosed:
+		if b.cfg.Window > 0 && now.Sub(c.since) >= b.cfg.Window {
+			c.since, c.total, c.bad = now, 0, 0
+		}
+		c.total++
+		if bad {
+			c.bad++
+		}
+		if c.total >= b.cfg.MinRequests && float64(c.bad) >= b.cfg.ErrorRate*float64(c.total) && c.bad > 0 {
+			b.transition(server, c, BreakerOpen, now)
+		}
+	}
+}
+
+// State returns the state of server's circuit.
+func (b *Breaker) State(server string) BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c := b.circuits[server]; c != nil {
+		return c.state
+	}
+	return BreakerClosed
+}
+
+// Stats returns the circuits of every backend seen so far.
+func (b *Breaker) Stats() []BreakerStats {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]BreakerStats, 0, len(b.circuits))
+	for server, c := range b.circuits {
+		out = append(out, BreakerStats{
+			Address:  server,
+			State:    c.state.String(),
+			Opened:   c.transitions[BreakerOpen],
+			HalfOpen: c.transitions[BreakerHalfOpen],
+			Closed:   c.transitions[BreakerClosed],
+		})
+	}
+	slices.SortFunc(out, func(a, b BreakerStats) int { return strings.Compare(a.Address, b.Address) })
+	return out
+}
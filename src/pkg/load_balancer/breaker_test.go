@@ -0,0 +1,91 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerTrips(t *testing.T) {
+	failed := load_balancer.Result{Err: errors.New("reset")}
+	b := load_balancer.NewBreaker(load_balancer.BreakerConfig{ErrorRate: 0.5, MinRequests: 4, OpenFor: 50 * time.Millisecond})
+	var transitions []string
+	b.OnTransition = func(server string, from, to load_balancer.BreakerState) {
+		transitions = append(transitions, from.String()+">"+to.String())
+	}
+
+	for _, r := range []load_balancer.Result{{}, failed, {}} {
+		b.Record("localhost:5000", r)
+	}
+	if s := b.State("localhost:5000"); s != load_balancer.BreakerClosed {
+		t.Fatalf("got %s before MinRequests, want closed", s)
+	}
+	b.Record("localhost:5000", failed)
+	if b.Allow("localhost:5000") {
+		t.Fatal("open circuit allowed a connection")
+	}
+
+	// after the probe interval one probe goes through, a second waits for it
+	time.Sleep(60 * time.Millisecond)
+	if !b.Allow("localhost:5000") || b.Allow("localhost:5000") {
+		t.Fatal("half-open circuit must allow exactly one probe")
+	}
+	b.Record("localhost:5000", failed)
+	if s := b.State("localhost:5000"); s != load_balancer.BreakerOpen {
+		t.Fatalf("got %s after failed probe, want open", s)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !b.Allow("localhost:5000") {
+		t.Fatal("probe not allowed")
+	}
+	b.Record("localhost:5000", load_balancer.Result{})
+	if s := b.State("localhost:5000"); s != load_balancer.BreakerClosed {
+		t.Fatalf("got %s after good probe, want closed", s)
+	}
+
+	expected := []string{"closed>open", "open>half-open", "half-open>open", "open>half-open", "half-open>closed"}
+	if !equal(transitions, expected) {
+		t.Errorf("got transitions %v, want %v", transitions, expected)
+	}
+	st := b.Stats()
+	if len(st) != 1 || st[0].Opened != 2 || st[0].HalfOpen != 2 || st[0].Closed != 1 {
+		t.Errorf("got stats %+v", st)
+	}
+}
+
+func TestBreakerSlowCalls(t *testing.T) {
+	b := load_balancer.NewBreaker(load_balancer.BreakerConfig{ErrorRate: 1, SlowCall: 100 * time.Millisecond, MinRequests: 2, OpenFor: time.Minute})
+	b.Record("localhost:5000", load_balancer.Result{Duration: 50 * time.Millisecond})
+	b.Record("localhost:5000", load_balancer.Result{Duration: 200 * time.Millisecond})
+	if !b.Allow("localhost:5000") {
+		t.Fatal("opened with one fast result in the window")
+	}
+	b.Record("localhost:5001", load_balancer.Result{Duration: 200 * time.Millisecond})
+	b.Record("localhost:5001", load_balancer.Result{Duration: 300 * time.Millisecond})
+	if b.Allow("localhost:5001") {
+		t.Error("slow backend not cut off")
+	}
+}
+
+func TestBreakerCancelledProbe(t *testing.T) {
+	b := load_balancer.NewBreaker(load_balancer.BreakerConfig{ErrorRate: 1, OpenFor: time.Millisecond})
+	b.Record("localhost:5000", load_balancer.Result{Err: errors.New("refused")})
+	time.Sleep(5 * time.Millisecond)
+	if !b.Allow("localhost:5000") {
+		t.Fatal("probe not allowed")
+	}
+	b.Cancel("localhost:5000")
+	if !b.Allow("localhost:5000") {
+		t.Error("cancelled probe not handed back")
+	}
+}
+
+func TestNilBreaker(t *testing.T) {
+	var b *load_balancer.Breaker
+	b.Record("localhost:5000", load_balancer.Result{Err: errors.New("refused")})
+	if !b.Allow("localhost:5000") {
+		t.Error("nil breaker refused a connection")
+	}
+}
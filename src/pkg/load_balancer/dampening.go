@@ -0,0 +1,46 @@
+package load_balancer
+
+import (
+	"sync"
+	"time"
+)
+
+// Dampening keeps latency-based policies from flapping between backends with
+// close scores: once on a backend they only move to a better one after MinDwell,
+// and only if it beats the current one by Margin. The zero value disables it.
+type Dampening struct {
+	MinDwell time.Duration // minimum time on a backend before switching away
+	Margin   float64       // required improvement, as a fraction of the current score (0.1 = 10% lower)
+}
+
+// damper applies Dampening to a stream of picks.
+type damper struct {
+	Dampening
+	mu      sync.Mutex
+	current string
+	since   time.Time
+}
+
+// choose returns the backend to use when best is the policy's raw pick. score
+// looks up a backend's current score (lower is better) and reports whether it
+// can still be selected.
+func (d *damper) choose(best string, score func(server string) (float64, bool)) string {
+	if d.Dampening == (Dampening{}) {
+		return best
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if d.current != "" && d.current != best {
+		if current, ok := score(d.current); ok {
+			challenger, _ := score(best)
+			if now.Sub(d.since) < d.MinDwell || challenger > current*(1-d.Margin) {
+				return d.current
+			}
+		}
+	}
+	if d.current != best {
+		d.current, d.since = best, now
+	}
+	return best
+}
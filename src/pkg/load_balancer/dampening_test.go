@@ -0,0 +1,66 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"testing"
+	"time"
+)
+
+// flap feeds a latency-based policy response times that make its preferred
+// backend swap after every connection, and records the picks.
+func flap(t *testing.T, p load_balancer.Policy) []string {
+	t.Helper()
+	durations := map[string][]time.Duration{
+		"localhost:5000": {100 * time.Millisecond, 160 * time.Millisecond, 100 * time.Millisecond},
+		"localhost:5001": {120 * time.Millisecond, 80 * time.Millisecond, 120 * time.Millisecond},
+	}
+	var res []string
+	for range 4 {
+		s := selectServer(t, p)
+		res = append(res, s)
+		if d := durations[s]; len(d) > 0 {
+			p.Update(s, load_balancer.Result{Duration: d[0]})
+			durations[s] = d[1:]
+		}
+	}
+	return res
+}
+
+func TestLeastResponseTimeFlaps(t *testing.T) {
+	res := flap(t, load_balancer.NewLeastResponseTime(servers[:2]))
+	expected := []string{"localhost:5000", "localhost:5001", "localhost:5000", "localhost:5001"}
+	if !equal(res, expected) {
+		t.Errorf("got %v, want %v", res, expected)
+	}
+}
+
+func TestDampingMargin(t *testing.T) {
+	p := load_balancer.NewDampedLeastResponseTime(servers[:2], load_balancer.Dampening{Margin: 0.5})
+	res := flap(t, p)
+	// 100ms is not 50% better than 120ms, so it stays on localhost:5001
+	expected := []string{"localhost:5000", "localhost:5001", "localhost:5001", "localhost:5001"}
+	if !equal(res, expected) {
+		t.Errorf("got %v, want %v", res, expected)
+	}
+}
+
+func TestDampingDwell(t *testing.T) {
+	p := load_balancer.NewDampedLeastResponseTime(servers[:2], load_balancer.Dampening{MinDwell: time.Hour})
+	res := flap(t, p)
+	expected := []string{"localhost:5000", "localhost:5000", "localhost:5000", "localhost:5000"}
+	if !equal(res, expected) {
+		t.Errorf("got %v, want %v", res, expected)
+	}
+}
+
+func TestDampedAdaptiveLeavesUnavailable(t *testing.T) {
+	p := load_balancer.NewDampedAdaptive(servers[:2], load_balancer.AdaptiveCoefficients{}, load_balancer.Dampening{MinDwell: time.Hour})
+	first := selectServer(t, p)
+	p.Release(first)
+
+	// dwell time doesn't keep a backend that went down
+	p.SetHealthy(first, false)
+	if s := selectServer(t, p); s == first {
+		t.Errorf("still selected unhealthy %s", s)
+	}
+}
@@ -0,0 +1,77 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAddRemoveServer(t *testing.T) {
+	for _, name := range []string{"N2One", "RoundRobin", "LeastConnections", "LeastResponseTime", "LeastPendingRequests", "ReportedLoad", "Adaptive"} {
+		p, err := load_balancer.NewPolicy(name, load_balancer.NewPool(servers[:1]), load_balancer.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.AddServer(load_balancer.Backend{Address: "localhost:5001"}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := p.AddServer(load_balancer.Backend{Address: "localhost:5001"}); err == nil {
+			t.Errorf("%s: added a backend twice", name)
+		}
+		if err := p.RemoveServer("localhost:5000"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := p.RemoveServer("localhost:5000"); !errors.Is(err, load_balancer.ErrUnknownBackend) {
+			t.Errorf("%s: got %v removing twice, want ErrUnknownBackend", name, err)
+		}
+
+		// the added backend is the only one left, and is timed like any other
+		for range 3 {
+			s := selectServer(t, p)
+			if s != "localhost:5001" {
+				t.Fatalf("%s: got %s, want localhost:5001", name, s)
+			}
+			p.Update(s, load_balancer.Result{Duration: 10 * time.Millisecond})
+		}
+		st := p.Stats()
+		if len(st.Backends) != 1 || st.Backends[0].Selected != 3 || st.Backends[0].Active != 0 {
+			t.Errorf("%s: got stats %+v", name, st.Backends)
+		}
+	}
+}
+
+func TestRemoveServerForgetsHistory(t *testing.T) {
+	p := load_balancer.NewLeastResponseTime(servers[:2])
+	for range 2 {
+		s := selectServer(t, p)
+		p.Update(s, load_balancer.Result{Duration: time.Second})
+	}
+	if err := p.RemoveServer("localhost:5000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddServer(load_balancer.Backend{Address: "localhost:5000"}); err != nil {
+		t.Fatal(err)
+	}
+	// back with no history, it scores as fast and is picked before the slow one
+	if s := selectServer(t, p); s != "localhost:5000" {
+		t.Errorf("got %s, want the re-added localhost:5000", s)
+	}
+}
+
+func TestRemoveServerWithOpenConnection(t *testing.T) {
+	s, err := load_balancer.NewSwitchable("LeastConnections", load_balancer.NewPool(servers[:2]), load_balancer.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := selectServer(t, s)
+	if err := s.RemoveServer(open); err != nil {
+		t.Fatal(err)
+	}
+	s.Update(open, load_balancer.Result{})
+	for range 3 {
+		if got := selectServer(t, s); got == open {
+			t.Fatalf("selected removed %s", open)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package load_balancer
+
+import (
+	"fmt"
+	"time"
+)
+
+// FailureEjection configures passive health checking in the pool: a backend whose
+// connections keep failing is taken out of selection for a while, then let back
+// in with a single trial connection.
+type FailureEjection struct {
+	Threshold int           // consecutive failures before ejection, 0 disables
+	CoolDown  time.Duration // how long an ejected backend is skipped
+}
+
+// ejection is the passive health state of one backend.
+type ejection struct {
+	failures int       // consecutive failed connections
+	until    time.Time // ejected before this; zero if not ejected
+	trial    bool      // the trial connection after the cool-down is in flight
+}
+
+// SetFailureEjection enables or, with a zero Threshold, disables ejecting
+// backends after consecutive failures. Disabling re-admits every ejected backend.
+func (p *Pool) SetFailureEjection(e FailureEjection) {
+	var moved []Transition
+	defer func() { p.announce(moved) }()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ejection = e
+	p.ejections = make(map[string]*ejection)
+	now := time.Now()
+	for _, b := range p.backends {
+		moved = p.moved(moved, b, ReasonAdmin, "failure ejection reset", now)
+	}
+}
+
+// ejected reports whether server must be skipped: it is cooling down, or its
+// trial connection hasn't finished yet, or it was ejected as an outlier. Caller
+// holds p.mu.
+func (p *Pool) ejected(server string, now time.Time) bool {
+	if o := p.outliers[server]; o != nil && now.Before(o.until) {
+		return true
+	}
+	e := p.ejections[server]
+	if e == nil || e.until.IsZero() {
+		return false
+	}
+	return now.Before(e.until) || e.trial
+}
+
+// Ejected reports whether server is currently ejected for failing, either after
+// consecutive failures or as an outlier.
+func (p *Pool) Ejected(server string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.ejected(server, time.Now())
+}
+
+// failing reports whether server has a failure record, without taking the
+// write lock that updating the record needs.
+func (p *Pool) failing(server string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.ejections[server] != nil
+}
+
+// trialStarted marks the trial connection of a re-admitted backend as in flight,
+// so it gets no more traffic until the trial has an outcome.
+func (p *Pool) trialStarted(server string) {
+	if !p.failing(server) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e := p.ejections[server]; e != nil && !e.until.IsZero() {
+		e.trial = true
+	}
+}
+
+// trialCancelled undoes trialStarted for a selection that was never used.
+func (p *Pool) trialCancelled(server string) {
+	if !p.failing(server) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e := p.ejections[server]; e != nil {
+		e.trial = false
+	}
+}
+
+// observe counts consecutive failures of server and ejects it when they reach
+// the threshold. A failed trial ejects it again straight away; any success
+// clears the record.
+func (p *Pool) observe(server string, result Result) {
+	if !result.Failed() && !p.failing(server) {
+		return
+	}
+	var moved []Transition
+	defer func() { p.announce(moved) }()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ejection.Threshold <= 0 {
+		return
+	}
+	if !result.Failed() {
+		delete(p.ejections, server)
+		moved = p.ejectionMoved(server, ReasonRecovered, "")
+		return
+	}
+	e := p.ejections[server]
+	if e == nil {
+		e = &ejection{}
+		p.ejections[server] = e
+	}
+	e.failures++
+	if e.failures >= p.ejection.Threshold || e.trial {
+		e.until = time.Now().Add(p.ejection.CoolDown)
+		e.trial = false
+		moved = p.ejectionMoved(server, ReasonFailures, fmt.Sprintf("%d consecutive failures, the last: %v", e.failures, result.Err))
+	}
+}
+
+// ejectionMoved returns the transition of server, if its ejection changed its
+// state. Caller holds p.mu.
+func (p *Pool) ejectionMoved(server, reason, detail string) []Transition {
+	for _, b := range p.backends {
+		if b.Address == server {
+			return p.moved(nil, b, reason, detail, time.Now())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFailureEjection(t *testing.T) {
+	failed := load_balancer.Result{Err: errors.New("refused")}
+	p := load_balancer.NewN2One(servers[:2])
+	p.SetFailureEjection(load_balancer.FailureEjection{Threshold: 3, CoolDown: 50 * time.Millisecond})
+
+	for i := range 3 {
+		if s := selectServer(t, p); s != "localhost:5000" {
+			t.Fatalf("failure %d: got %s, want localhost:5000", i, s)
+		}
+		p.Update("localhost:5000", failed)
+	}
+	if !p.Ejected("localhost:5000") {
+		t.Fatal("not ejected after 3 failures")
+	}
+	if s := selectServer(t, p); s != "localhost:5001" {
+		t.Errorf("during cool-down got %s, want localhost:5001", s)
+	}
+
+	// after the cool-down one trial connection goes through; until it finishes
+	// the backend stays out
+	time.Sleep(60 * time.Millisecond)
+	if s := selectServer(t, p); s != "localhost:5000" {
+		t.Fatalf("after cool-down got %s, want the trial on localhost:5000", s)
+	}
+	if s := selectServer(t, p); s != "localhost:5001" {
+		t.Errorf("during trial got %s, want localhost:5001", s)
+	}
+
+	// a failed trial ejects it again without waiting for the threshold
+	p.Update("localhost:5000", failed)
+	if !p.Ejected("localhost:5000") {
+		t.Fatal("not ejected after failed trial")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	p.Update(selectServer(t, p), load_balancer.Result{})
+	if p.Ejected("localhost:5000") {
+		t.Error("still ejected after successful trial")
+	}
+	if s := selectServer(t, p); s != "localhost:5000" {
+		t.Errorf("after recovery got %s, want localhost:5000", s)
+	}
+}
+
+func TestFailureEjectionResetsOnSuccess(t *testing.T) {
+	failed := load_balancer.Result{Err: errors.New("refused")}
+	p := load_balancer.NewN2One(servers[:2])
+	p.SetFailureEjection(load_balancer.FailureEjection{Threshold: 2, CoolDown: time.Minute})
+
+	for _, r := range []load_balancer.Result{failed, {}, failed} {
+		p.Update(selectServer(t, p), r)
+	}
+	if p.Ejected("localhost:5000") {
+		t.Error("ejected although failures weren't consecutive")
+	}
+}
@@ -0,0 +1,59 @@
+package load_balancer
+
+import "time"
+
+// Events are hooks into the life of a pool's connections, for embedders to
+// feed their own metrics or audit trail. The pool calls OnSelect, OnClose,
+// OnHealthChange and OnTransition itself; OnAccept and OnBackendDial are for the proxy in
+// front of it to call, through the pool's Events. Hooks run on the proxy's
+// goroutines, with no locks held, so they must be safe for concurrent use and
+// should return quickly. Embed NoEvents to implement only some.
+type Events interface {
+	// OnAccept is called when a client connection, or request, arrives for
+	// the pool, before a backend is selected.
+	OnAccept(client string)
+	// OnSelect is called when a policy selects server. Like the Selected
+	// counter, it counts fallbacks SelectServers returns along with the first.
+	OnSelect(server string)
+	// OnBackendDial is called once connecting to server succeeded or failed,
+	// after elapsed.
+	OnBackendDial(server string, elapsed time.Duration, err error)
+	// OnClose is called when a connection to server ends, with the Result the
+	// policy was updated with.
+	OnClose(server string, result Result)
+	// OnHealthChange is called when server turns healthy or unhealthy.
+	OnHealthChange(server string, healthy bool)
+	// OnTransition is called when a backend changes state, healthy, unhealthy,
+	// ejected or in maintenance, with why and how long it was in the last one.
+	OnTransition(t Transition)
+}
+
+// NoEvents ignores every event.
+type NoEvents struct{}
+
+func (NoEvents) OnAccept(string)                            {}
+func (NoEvents) OnSelect(string)                            {}
+func (NoEvents) OnBackendDial(string, time.Duration, error) {}
+func (NoEvents) OnClose(string, Result)                     {}
+func (NoEvents) OnHealthChange(string, bool)                {}
+func (NoEvents) OnTransition(Transition)                    {}
+
+// events holds the Events of a pool.
+type events struct{ Events }
+
+// SetEvents sets the hooks the pool calls, nil for none. Policies sharing the
+// pool, e.g. through a Switchable, share them too.
+func (p *Pool) SetEvents(e Events) {
+	if e == nil {
+		e = NoEvents{}
+	}
+	p.events.Store(&events{e})
+}
+
+// Events returns the hooks set with SetEvents, NoEvents if none.
+func (p *Pool) Events() Events {
+	if e := p.events.Load(); e != nil {
+		return e.Events
+	}
+	return NoEvents{}
+}
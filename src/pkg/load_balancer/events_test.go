@@ -0,0 +1,73 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// recorder records the events the pool calls, ignoring the others.
+type recorder struct {
+	load_balancer.NoEvents
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) record(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) OnSelect(server string) { r.record("select %s", server) }
+func (r *recorder) OnClose(server string, result load_balancer.Result) {
+	r.record("close %s failed=%v", server, result.Failed())
+}
+func (r *recorder) OnHealthChange(server string, healthy bool) {
+	r.record("health %s %v", server, healthy)
+}
+
+func TestEvents(t *testing.T) {
+	pool := load_balancer.NewPool(servers[:2])
+	p, err := load_balancer.NewSwitchable("RoundRobin", pool, load_balancer.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Events().(load_balancer.NoEvents); !ok {
+		t.Errorf("Events() = %T before SetEvents, want NoEvents", p.Events())
+	}
+	r := &recorder{}
+	pool.SetEvents(r)
+
+	a := servers[0].Address
+	s, err := p.SelectServer(context.Background(), load_balancer.ConnInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Update(s, load_balancer.Result{Err: errors.New("reset")})
+	p.SetHealthy(a, false)
+	p.SetHealthy(a, false) // no change, no event
+	if err := p.Switch("LeastConnections"); err != nil {
+		t.Fatal(err)
+	}
+	p.SetHealthy(a, true)
+
+	want := []string{
+		"select " + s,
+		"close " + s + " failed=true",
+		"health " + a + " false",
+		"health " + a + " true",
+	}
+	if !slices.Equal(r.events, want) {
+		t.Errorf("events %q, want %q", r.events, want)
+	}
+
+	pool.SetEvents(nil)
+	if _, ok := p.Events().(load_balancer.NoEvents); !ok {
+		t.Errorf("Events() = %T after SetEvents(nil), want NoEvents", p.Events())
+	}
+}
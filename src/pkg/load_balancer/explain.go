@@ -0,0 +1,170 @@
+package load_balancer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Decision explains how a policy selected a backend, see ConnInfo.Explain.
+type Decision struct {
+	Policy     string      `json:"policy"`
+	Chosen     string      `json:"chosen"`
+	Reason     string      `json:"reason"`            // why Chosen won, and how ties were broken
+	Scoring    string      `json:"scoring,omitempty"` // what Score is, empty if the policy doesn't score
+	Candidates []Candidate `json:"candidates"`        // the backends chosen among, in pool order
+	Excluded   []Exclusion `json:"excluded,omitempty"`
+	Panic      bool        `json:"panic,omitempty"` // health ignored, see SetPanicThreshold
+}
+
+// Candidate is a backend a policy chose among.
+type Candidate struct {
+	Address string  `json:"address"`
+	Weight  float64 `json:"weight"` // as the policy saw it, e.g. during slow start
+	Score   float64 `json:"score"`
+	Note    string  `json:"note,omitempty"` // e.g. why it couldn't win
+}
+
+// Exclusion is a backend of the pool that wasn't chosen among, and why.
+type Exclusion struct {
+	Address string `json:"address"`
+	Reason  string `json:"reason"`
+}
+
+func (d Decision) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s chose %s: %s", d.Policy, d.Chosen, d.Reason)
+	if d.Scoring != "" {
+		fmt.Fprintf(&b, "; scores (%s):", d.Scoring)
+	} else {
+		b.WriteString("; candidates:")
+	}
+	for _, c := range d.Candidates {
+		b.WriteString(" " + c.Address)
+		if d.Scoring != "" {
+			fmt.Fprintf(&b, "=%.4g", c.Score)
+		}
+		if c.Note != "" {
+			b.WriteString(" (" + c.Note + ")")
+		}
+	}
+	if d.Panic {
+		b.WriteString("; panic mode, health ignored")
+	}
+	if len(d.Excluded) > 0 {
+		b.WriteString("; excluded:")
+		for _, e := range d.Excluded {
+			b.WriteString(" " + e.Address + " (" + e.Reason + ")")
+		}
+	}
+	return b.String()
+}
+
+type explainKey struct{}
+
+// explainer returns the function picks report their decision to, nil if no
+// one asked, see ConnInfo.Explain.
+func explainer(ctx context.Context) func(Decision) {
+	f, _ := ctx.Value(explainKey{}).(func(Decision))
+	return f
+}
+
+// explained wraps pick to report its decisions to explain.
+func explained(explain func(Decision), pick pickFunc) pickFunc {
+	return func(ctx context.Context, exclude map[string]bool) (string, error) {
+		return pick(context.WithValue(ctx, explainKey{}, explain), exclude)
+	}
+}
+
+// explain reports a pick among candidates to the explainer in ctx, if any.
+// score returns the score of the i-th candidate, nil if the policy doesn't
+// score, and note a note on it, nil for none.
+func (p *Pool) explain(ctx context.Context, d Decision, exclude map[string]bool, candidates []candidate, score func(i int) float64, note func(i int) string) {
+	f := explainer(ctx)
+	if f == nil {
+		return
+	}
+	for i, c := range candidates {
+		cand := Candidate{Address: c.Address, Weight: c.weight}
+		if score != nil {
+			cand.Score = score(i)
+		}
+		if note != nil {
+			cand.Note = note(i)
+		}
+		d.Candidates = append(d.Candidates, cand)
+	}
+	d.Excluded, d.Panic = p.exclusions(exclude, candidates)
+	f(d)
+}
+
+// exclusions returns why the backends of the pool that aren't among
+// candidates were left out, and whether the pool is in panic mode.
+func (p *Pool) exclusions(exclude map[string]bool, candidates []candidate) ([]Exclusion, bool) {
+	in := make(map[string]bool, len(candidates))
+	for _, c := range candidates {
+		in[c.Address] = true
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	now := time.Now()
+	panicked := p.panicked(now)
+	var out []Exclusion
+	for _, b := range p.backends {
+		if in[b.Address] {
+			continue
+		}
+		reason := "outside this instance's subset"
+		switch {
+		case exclude[b.Address]:
+			reason = "already selected"
+		case b.Weight <= 0:
+			reason = "weight 0"
+		case b.Maintenance:
+			reason = "in maintenance"
+		case b.Draining:
+			reason = "draining"
+		case panicked: // unhealthy and ejected backends are candidates too
+		case b.Health == Unhealthy:
+			reason = "unhealthy"
+		case p.ejected(b.Address, now):
+			reason = "ejected after failing"
+		}
+		out = append(out, Exclusion{b.Address, reason})
+	}
+	return out, panicked
+}
+
+// lowestWins is the reason the candidate with the lowest of scores won, chosen
+// by the policy as best: ties are broken as tieBreak says, and the choice kept
+// by dampening if it isn't the best.
+func lowestWins(scores []float64, best, chosen int, tieBreak string) string {
+	if chosen != best {
+		return "kept by dampening, the best isn't enough better or it hasn't been long enough"
+	}
+	tied := 0
+	for _, s := range scores {
+		if s == scores[best] {
+			tied++
+		}
+	}
+	switch tied {
+	case 1:
+	case 2:
+		return "lowest score, tied with another, " + tieBreak
+	default:
+		return fmt.Sprintf("lowest score, tied with %d others, %s", tied-1, tieBreak)
+	}
+	return "lowest score"
+}
+
+// indexOf returns the index of the candidate with address, -1 if none.
+func indexOf(candidates []candidate, address string) int {
+	for i, c := range candidates {
+		if c.Address == address {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,84 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	p := load_balancer.NewRoundRobin(servers)
+	p.SetHealthy("localhost:5001", false)
+	p.SetDraining("localhost:5002", true)
+
+	var decisions []load_balancer.Decision
+	info := load_balancer.ConnInfo{Explain: func(d load_balancer.Decision) { decisions = append(decisions, d) }}
+	got, err := p.SelectServers(context.Background(), info, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decisions) != 2 {
+		t.Fatalf("got %d decisions for 2 picks", len(decisions))
+	}
+	d := decisions[0]
+	if d.Policy != "RoundRobin" || d.Chosen != got[0] || d.Reason == "" {
+		t.Errorf("decision %+v for %v", d, got)
+	}
+	if len(d.Candidates) != 2 || d.Candidates[0].Address != "localhost:5000" || d.Candidates[0].Score != 1 {
+		t.Errorf("candidates %+v, want localhost:5000 and :5003 with a credit of 1", d.Candidates)
+	}
+	want := []load_balancer.Exclusion{{"localhost:5001", "unhealthy"}, {"localhost:5002", "draining"}}
+	if len(d.Excluded) != 2 || d.Excluded[0] != want[0] || d.Excluded[1] != want[1] {
+		t.Errorf("excluded %+v, want %+v", d.Excluded, want)
+	}
+	if e := decisions[1].Excluded; len(e) != 3 || e[0] != (load_balancer.Exclusion{Address: got[0], Reason: "already selected"}) {
+		t.Errorf("fallback excluded %+v, want %s already selected first", e, got[0])
+	}
+	if s := d.String(); !strings.Contains(s, "localhost:5001 (unhealthy)") {
+		t.Errorf("String() = %q", s)
+	}
+
+	// without Explain nothing is reported
+	decisions = nil
+	p.SelectServer(context.Background(), load_balancer.ConnInfo{})
+	if decisions != nil {
+		t.Errorf("reported %v without Explain", decisions)
+	}
+}
+
+func TestExplainLeastConnections(t *testing.T) {
+	p := load_balancer.NewCappedLeastConnections(servers[:2], 1)
+	ctx := context.Background()
+	first, _ := p.SelectServer(ctx, load_balancer.ConnInfo{})
+
+	var d load_balancer.Decision
+	got, err := p.SelectServer(ctx, load_balancer.ConnInfo{Explain: func(dd load_balancer.Decision) { d = dd }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Chosen != got || d.Reason != "lowest score" {
+		t.Errorf("decision %+v", d)
+	}
+	for _, c := range d.Candidates {
+		if full := c.Address == first; full != strings.Contains(c.Note, "cap") {
+			t.Errorf("candidate %+v, %s is full", c, first)
+		}
+	}
+}
+
+func TestExplainPinned(t *testing.T) {
+	p := load_balancer.NewRoundRobin(servers)
+	var d load_balancer.Decision
+	explain := func(dd load_balancer.Decision) { d = dd }
+	p.SelectServer(context.Background(), load_balancer.ConnInfo{Prefer: "localhost:5002", Explain: explain})
+	if d.Chosen != "localhost:5002" || !strings.Contains(d.Reason, "pinned") || d.Excluded != nil {
+		t.Errorf("pinned decision %+v", d)
+	}
+
+	p.SetHealthy("localhost:5002", false)
+	p.SelectServer(context.Background(), load_balancer.ConnInfo{Prefer: "localhost:5002", Explain: explain})
+	if d.Chosen == "localhost:5002" || !strings.Contains(d.Reason, "unavailable") {
+		t.Errorf("decision %+v with the pinned backend down", d)
+	}
+}
@@ -0,0 +1,129 @@
+package load_balancer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// gRPC health checking protocol, grpc.health.v1. The messages are small enough
+// to encode by hand rather than pull in protobuf.
+
+// servingStatus of grpc.health.v1.HealthCheckResponse.
+type servingStatus uint64
+
+const (
+	statusUnknown servingStatus = iota
+	statusServing
+	statusNotServing
+	statusServiceUnknown
+)
+
+func (s servingStatus) String() string {
+	switch s {
+	case statusServing:
+		return "SERVING"
+	case statusNotServing:
+		return "NOT_SERVING"
+	case statusServiceUnknown:
+		return "SERVICE_UNKNOWN"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// checkGRPC calls grpc.health.v1.Health/Check on addr, dialed with d, for
+// service ("" for the whole server) over HTTP/2, cleartext unless tlsCfg is
+// set. Only SERVING is healthy.
+func checkGRPC(ctx context.Context, d Dialer, addr, service string, tlsCfg *tls.Config) error {
+	transport := &http.Transport{DialContext: dialTo(d, addr), Protocols: new(http.Protocols)}
+	defer transport.CloseIdleConnections()
+	scheme := "http"
+	if tlsCfg != nil {
+		scheme = "https"
+		transport.TLSClientConfig = tlsFor(tlsCfg, addr)
+		transport.Protocols.SetHTTP2(true)
+	} else {
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	// HealthCheckRequest{service = 1}, in a gRPC message frame
+	var msg []byte
+	if service != "" {
+		msg = binary.AppendUvarint([]byte{0x0a}, uint64(len(service)))
+		msg = append(msg, service...)
+	}
+	body := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg)))
+	body = append(body, msg...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, scheme+"://"+urlHost(addr)+"/grpc.health.v1.Health/Check", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("grpc health check %s: %s", addr, resp.Status)
+	}
+	reply, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return err
+	}
+	// a failed call has only headers, a finished one trailers
+	code, message := resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	if code == "" {
+		code, message = resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	}
+	if code != "0" {
+		return fmt.Errorf("grpc health check %s: status %s %s", addr, code, message)
+	}
+
+	if len(reply) < 5 || int(binary.BigEndian.Uint32(reply[1:5])) != len(reply)-5 {
+		return errors.New("grpc health check: malformed response")
+	}
+	if status := parseServingStatus(reply[5:]); status != statusServing {
+		return fmt.Errorf("grpc health check %s: %s", addr, status)
+	}
+	return nil
+}
+
+// parseServingStatus reads field 1 of a HealthCheckResponse, skipping others.
+func parseServingStatus(msg []byte) servingStatus {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			break
+		}
+		msg = msg[n:]
+		switch tag & 7 { // wire type
+		case 0: // varint
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return statusUnknown
+			}
+			if tag>>3 == 1 {
+				return servingStatus(v)
+			}
+			msg = msg[n:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return statusUnknown
+			}
+			msg = msg[n+int(l):]
+		default:
+			return statusUnknown
+		}
+	}
+	return statusUnknown
+}
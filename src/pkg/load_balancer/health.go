@@ -0,0 +1,293 @@
+package load_balancer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HealthCheck describes how a backend is probed. Zero fields in a per-backend
+// override fall back to the checker's default.
+type HealthCheck struct {
+	Type     string        // "tcp" (connect only), "http", "grpc" or "exec"
+	Path     string        // http: path to GET, any 2xx or 3xx is healthy
+	Service  string        // grpc: service asked about with grpc.health.v1, "" for the whole server
+	Command  []string      // exec: program and arguments, healthy if it exits 0
+	Port     int           // probe this port instead of the backend's
+	Interval time.Duration // between probes
+	Timeout  time.Duration // per probe
+	Jitter   float64       // spread probes by up to this fraction of Interval either way
+	TLS      *tls.Config   // probe over TLS: tcp completes a handshake, http uses https
+}
+
+// DefaultHealthCheck is used for fields left zero in the checker's default.
+var DefaultHealthCheck = HealthCheck{Type: "tcp", Path: "/", Interval: 5 * time.Second, Timeout: 2 * time.Second, Jitter: 0.1}
+
+// healthClient reports redirects instead of following them; a 3xx is healthy.
+var healthClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// merge fills the zero fields of c from def.
+func (c HealthCheck) merge(def HealthCheck) HealthCheck {
+	if c.Type == "" {
+		c.Type = def.Type
+	}
+	if c.Path == "" {
+		c.Path = def.Path
+	}
+	if c.Service == "" {
+		c.Service = def.Service
+	}
+	if len(c.Command) == 0 {
+		c.Command = def.Command
+	}
+	if c.Port == 0 {
+		c.Port = def.Port
+	}
+	if c.Interval <= 0 {
+		c.Interval = def.Interval
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = def.Timeout
+	}
+	if c.Jitter == 0 {
+		c.Jitter = def.Jitter
+	}
+	if c.TLS == nil {
+		c.TLS = def.TLS
+	}
+	return c
+}
+
+// tlsFor returns cfg for a connection to addr, naming its host as the server
+// unless cfg names one.
+func tlsFor(cfg *tls.Config, addr string) *tls.Config {
+	if cfg.ServerName != "" {
+		return cfg
+	}
+	cfg = cfg.Clone()
+	cfg.ServerName = Host(addr)
+	return cfg
+}
+
+// Dialer opens connections to backends; *net.Dialer is one. Give health checks
+// the dialer traffic goes through, so they leave by the same route.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// dialTo returns a DialContext for transports that dials addr, a host:port or
+// unix:// backend address, with d, whatever address the request is for.
+func dialTo(d Dialer, addr string) func(ctx context.Context, _, _ string) (net.Conn, error) {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		network, address := SplitNetwork(addr)
+		return d.DialContext(ctx, network, address)
+	}
+}
+
+// urlHost returns the host of addr to put in a probe's URL; a unix socket
+// has none of its own.
+func urlHost(addr string) string {
+	if strings.HasPrefix(addr, UnixScheme) {
+		return "localhost"
+	}
+	return addr
+}
+
+// next returns the delay before the following probe: Interval give or take Jitter.
+func (c HealthCheck) next() time.Duration {
+	return time.Duration(float64(c.Interval) * (1 + c.Jitter*(2*rand.Float64()-1)))
+}
+
+// HealthChecker actively probes the backends of a pool and marks them healthy or
+// unhealthy. Each backend is probed on its own schedule, started at a random
+// point of its first interval so probes don't all fire at once.
+type HealthChecker struct {
+	pool      *Pool
+	def       HealthCheck
+	mu        sync.Mutex
+	overrides map[string]HealthCheck
+
+	// OnChange, if set, is called when a probe changes a backend's health.
+	OnChange func(server string, healthy bool, err error)
+	// Dialer, if set, connects probes to backends instead of a zero net.Dialer.
+	Dialer Dialer
+}
+
+func NewHealthChecker(pool *Pool, def HealthCheck) *HealthChecker {
+	return &HealthChecker{pool: pool, def: def.merge(DefaultHealthCheck), overrides: make(map[string]HealthCheck)}
+}
+
+// Override sets how server is probed; zero fields keep the default.
+func (h *HealthChecker) Override(server string, c HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.overrides[server] = c
+}
+
+// config returns the effective health check of server.
+func (h *HealthChecker) config(server string) HealthCheck {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.overrides[server].merge(h.def)
+}
+
+// Run probes every backend of the pool until ctx is done. Backends added to or
+// removed from the pool are picked up within one default interval. Backends in
+// maintenance aren't probed.
+func (h *HealthChecker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	watching := make(map[string]context.CancelFunc)
+	for {
+		current := make(map[string]bool)
+		for _, b := range h.pool.Backends() {
+			if b.Maintenance {
+				continue // stopped below if it was watched
+			}
+			current[b.Address] = true
+			if _, ok := watching[b.Address]; ok {
+				continue
+			}
+			wctx, cancel := context.WithCancel(ctx)
+			watching[b.Address] = cancel
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				h.watch(wctx, b.Address)
+			}()
+		}
+		for server, cancel := range watching {
+			if !current[server] {
+				cancel()
+				delete(watching, server)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(h.def.Interval):
+		}
+	}
+}
+
+// watch probes server on its schedule until ctx is done.
+func (h *HealthChecker) watch(ctx context.Context, server string) {
+	delay := time.Duration(rand.Int64N(int64(h.config(server).Interval)))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if h.pool.inMaintenance(server) {
+			delay = h.config(server).next()
+			continue // Run stops watching it soon
+		}
+		err := h.Check(ctx, server)
+		if h.pool.SetHealthyBecause(server, err == nil, ReasonProbe, err) && h.OnChange != nil {
+			h.OnChange(server, err == nil, err)
+		}
+		delay = h.config(server).next()
+	}
+}
+
+// Check probes server once and returns why it is unhealthy, or nil. An exec check
+// gets the probed address in BACKEND_ADDR, BACKEND_HOST and BACKEND_PORT (a
+// unix socket's path in BACKEND_SOCKET), so one script can speak protocols the
+// built-in checks can't, e.g. a Redis PING.
+func (h *HealthChecker) Check(ctx context.Context, server string) error {
+	c := h.config(server)
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	addr := server
+	network, _ := SplitNetwork(server)
+	if c.Port != 0 {
+		if network == "unix" {
+			return fmt.Errorf("health check %s: a unix socket has no port to override", server)
+		}
+		host, _, err := net.SplitHostPort(server)
+		if err != nil {
+			return err
+		}
+		addr = net.JoinHostPort(host, strconv.Itoa(c.Port))
+	}
+
+	var d Dialer = &net.Dialer{}
+	if h.Dialer != nil {
+		d = h.Dialer
+	}
+	switch c.Type {
+	case "tcp":
+		conn, err := dialTo(d, addr)(ctx, "", "")
+		if err != nil {
+			return err
+		}
+		if c.TLS != nil {
+			tc := tls.Client(conn, tlsFor(c.TLS, addr))
+			err = tc.HandshakeContext(ctx)
+			conn = tc
+		}
+		conn.Close()
+		return err
+	case "http":
+		scheme, client := "http", healthClient
+		if c.TLS != nil || network == "unix" || h.Dialer != nil {
+			transport := &http.Transport{DialContext: dialTo(d, addr), DisableKeepAlives: true}
+			if c.TLS != nil {
+				scheme = "https"
+				transport.TLSClientConfig = tlsFor(c.TLS, addr)
+			}
+			client = &http.Client{CheckRedirect: healthClient.CheckRedirect, Transport: transport}
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+urlHost(addr)+c.Path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("health check %s: %s", req.URL, resp.Status)
+		}
+		return nil
+	case "grpc":
+		return checkGRPC(ctx, d, addr, c.Service, c.TLS)
+	case "exec":
+		if len(c.Command) == 0 {
+			return errors.New("exec health check without a command")
+		}
+		env := []string{"BACKEND_ADDR=" + addr}
+		if network, path := SplitNetwork(addr); network == "unix" {
+			env = append(env, "BACKEND_SOCKET="+path)
+		} else {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return err
+			}
+			env = append(env, "BACKEND_HOST="+host, "BACKEND_PORT="+port)
+		}
+		cmd := exec.CommandContext(ctx, c.Command[0], c.Command[1:]...)
+		cmd.Env = append(os.Environ(), env...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("health check %s: %v: %s", c.Command[0], err, bytes.TrimSpace(out))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown health check type: %s", c.Type)
+}
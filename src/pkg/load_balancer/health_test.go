@@ -0,0 +1,254 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// closedAddr returns an address nothing listens on.
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestHealthCheckTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	up, down := l.Addr().String(), closedAddr(t)
+
+	h := load_balancer.NewHealthChecker(load_balancer.NewPool(load_balancer.NewBackends([]string{up, down})), load_balancer.HealthCheck{})
+	if err := h.Check(context.Background(), up); err != nil {
+		t.Errorf("%s: %v", up, err)
+	}
+	if err := h.Check(context.Background(), down); err == nil {
+		t.Errorf("%s: closed port reported healthy", down)
+	}
+}
+
+func TestHealthCheckOverrides(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	_, port, _ := net.SplitHostPort(addr)
+	checkPort, _ := strconv.Atoi(port)
+
+	// the backend's own port is closed; its checks go to the HTTP server
+	backend := closedAddr(t)
+	h := load_balancer.NewHealthChecker(load_balancer.NewPool(load_balancer.NewBackends([]string{addr, backend})), load_balancer.HealthCheck{Type: "http"})
+	if err := h.Check(context.Background(), addr); err == nil {
+		t.Error("default path / reported healthy")
+	}
+	h.Override(addr, load_balancer.HealthCheck{Path: "/ready"})
+	if err := h.Check(context.Background(), addr); err != nil {
+		t.Errorf("override path: %v", err)
+	}
+	h.Override(backend, load_balancer.HealthCheck{Path: "/ready", Port: checkPort})
+	if err := h.Check(context.Background(), backend); err != nil {
+		t.Errorf("override port: %v", err)
+	}
+}
+
+// countingDialer counts the connections it dials.
+type countingDialer struct {
+	net.Dialer
+	dials int
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.dials++
+	return d.Dialer.DialContext(ctx, network, address)
+}
+
+func TestHealthCheckDialer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	for _, typ := range []string{"tcp", "http"} {
+		d := &countingDialer{}
+		h := load_balancer.NewHealthChecker(load_balancer.NewPool(load_balancer.NewBackends([]string{addr})), load_balancer.HealthCheck{Type: typ})
+		h.Dialer = d
+		if err := h.Check(context.Background(), addr); err != nil {
+			t.Errorf("%s: %v", typ, err)
+		}
+		if d.dials != 1 {
+			t.Errorf("%s check dialed %d times through the Dialer, want 1", typ, d.dials)
+		}
+	}
+}
+
+func TestHealthCheckTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "https://")
+	trusted := srv.Client().Transport.(*http.Transport).TLSClientConfig
+
+	for _, typ := range []string{"tcp", "http"} {
+		h := load_balancer.NewHealthChecker(load_balancer.NewPool(load_balancer.NewBackends([]string{addr})), load_balancer.HealthCheck{Type: typ, TLS: trusted})
+		if err := h.Check(context.Background(), addr); err != nil {
+			t.Errorf("%s: %v", typ, err)
+		}
+		// a certificate that doesn't verify is unhealthy
+		h.Override(addr, load_balancer.HealthCheck{TLS: &tls.Config{}})
+		if err := h.Check(context.Background(), addr); err == nil {
+			t.Errorf("%s: untrusted certificate reported healthy", typ)
+		}
+	}
+}
+
+func TestHealthCheckGRPC(t *testing.T) {
+	// serving status per service, as grpc.health.v1 encodes it
+	statuses := map[string]byte{"": 1, "payments": 2}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/grpc.health.v1.Health/Check" || r.ProtoMajor != 2 {
+			http.Error(w, "unexpected call", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		service := ""
+		if len(body) > 7 {
+			service = string(body[7:])
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		status, ok := statuses[service]
+		if !ok {
+			w.Header().Set("Grpc-Status", "5") // NOT_FOUND, trailers-only
+			return
+		}
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte{0, 0, 0, 0, 2, 0x08, status})
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	h := load_balancer.NewHealthChecker(load_balancer.NewPool(load_balancer.NewBackends([]string{addr})), load_balancer.HealthCheck{Type: "grpc"})
+	if err := h.Check(context.Background(), addr); err != nil {
+		t.Errorf("serving server: %v", err)
+	}
+	for _, service := range []string{"payments", "missing"} {
+		h.Override(addr, load_balancer.HealthCheck{Service: service})
+		if err := h.Check(context.Background(), addr); err == nil {
+			t.Errorf("service %s reported healthy", service)
+		}
+	}
+}
+
+func TestHealthCheckUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+	addr := load_balancer.UnixScheme + path
+
+	for _, c := range []load_balancer.HealthCheck{{Type: "tcp"}, {Type: "http", Path: "/ready"}} {
+		h := load_balancer.NewHealthChecker(load_balancer.NewPool(load_balancer.NewBackends([]string{addr})), c)
+		if err := h.Check(context.Background(), addr); err != nil {
+			t.Errorf("%s: %v", c.Type, err)
+		}
+	}
+	gone := load_balancer.UnixScheme + filepath.Join(t.TempDir(), "gone.sock")
+	h := load_balancer.NewHealthChecker(load_balancer.NewPool(load_balancer.NewBackends([]string{gone})), load_balancer.HealthCheck{})
+	if err := h.Check(context.Background(), gone); err == nil {
+		t.Error("missing socket reported healthy")
+	}
+}
+
+func TestHealthCheckerRun(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	up, down := l.Addr().String(), closedAddr(t)
+
+	pool := load_balancer.NewPool(load_balancer.NewBackends([]string{up, down}))
+	h := load_balancer.NewHealthChecker(pool, load_balancer.HealthCheck{Interval: 10 * time.Millisecond, Jitter: 0.5})
+	changes := make(chan string, 10)
+	h.OnChange = func(server string, healthy bool, err error) { changes <- server }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	go h.Run(ctx)
+	for range 2 {
+		select {
+		case <-changes:
+		case <-ctx.Done():
+			t.Fatal("backends not checked in time")
+		}
+	}
+	for _, b := range pool.Backends() {
+		want := load_balancer.Healthy
+		if b.Address == down {
+			want = load_balancer.Unhealthy
+		}
+		if b.Health != want {
+			t.Errorf("%s: got %s, want %s", b.Address, b.Health, want)
+		}
+	}
+}
+
+func TestHealthCheckerSkipsMaintenance(t *testing.T) {
+	down := closedAddr(t)
+	pool := load_balancer.NewPool(load_balancer.NewBackends([]string{down}))
+	pool.SetMaintenance(down, true)
+	h := load_balancer.NewHealthChecker(pool, load_balancer.HealthCheck{Interval: 10 * time.Millisecond})
+	h.OnChange = func(server string, healthy bool, err error) {
+		t.Errorf("%s in maintenance was probed: %v", server, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	h.Run(ctx)
+	if b := pool.Backends()[0]; b.Health != load_balancer.HealthUnknown {
+		t.Errorf("got %s, want unknown", b.Health)
+	}
+}
+
+func TestHealthCheckExec(t *testing.T) {
+	h := load_balancer.NewHealthChecker(load_balancer.NewPool(servers[:2]), load_balancer.HealthCheck{
+		Type:    "exec",
+		Command: []string{"sh", "-c", `test "$BACKEND_HOST:$BACKEND_PORT" = "$BACKEND_ADDR" && test "$BACKEND_PORT" = 5000`},
+	})
+	if err := h.Check(context.Background(), "localhost:5000"); err != nil {
+		t.Errorf("localhost:5000: %v", err)
+	}
+	if err := h.Check(context.Background(), "localhost:5001"); err == nil {
+		t.Error("localhost:5001: non-zero exit reported healthy")
+	}
+}
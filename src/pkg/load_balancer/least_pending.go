@@ -0,0 +1,67 @@
+package load_balancer
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+)
+
+// LeastPendingRequests: fewest outstanding requests per unit of weight. In HTTP
+// mode every request is selected and updated on its own, so this balances
+// requests rather than TCP connections (in TCP mode the two are the same). Ties
+// rotate, so idle backends share bursts instead of the first one taking them all.
+type LeastPendingRequests struct {
+	*Pool
+	next atomic.Uint64 // where the next scan starts
+}
+
+func NewLeastPendingRequests(backends []Backend) *LeastPendingRequests {
+	return &LeastPendingRequests{Pool: NewPool(backends)}
+}
+
+func (p *LeastPendingRequests) SelectServer(ctx context.Context, info ConnInfo) (string, error) {
+	return p.pinned(info, p.pick)(ctx, nil)
+}
+
+func (p *LeastPendingRequests) SelectServers(ctx context.Context, info ConnInfo, n int) ([]string, error) {
+	return selectN(ctx, n, p.pinned(info, p.pick), p.Release)
+}
+
+func (p *LeastPendingRequests) pick(ctx context.Context, exclude map[string]bool) (string, error) {
+	candidates := p.candidates(exclude)
+	if err := checkSelect(ctx, len(candidates)); err != nil {
+		return "", err
+	}
+	pending := p.activeCounts()
+	start := int(p.next.Add(1)-1) % len(candidates)
+	min := math.Inf(1)
+	var selected string
+	for i := range candidates {
+		c := candidates[(start+i)%len(candidates)]
+		if load := float64(pending[c.Address]) / c.weight; load < min {
+			min = load
+			selected = c.Address
+		}
+	}
+	p.selected(selected)
+	if explainer(ctx) != nil {
+		loads := make([]float64, len(candidates))
+		for i, c := range candidates {
+			loads[i] = float64(pending[c.Address]) / c.weight
+		}
+		best := indexOf(candidates, selected)
+		p.explain(ctx, Decision{
+			Policy:  "LeastPendingRequests",
+			Chosen:  selected,
+			Reason:  lowestWins(loads, best, best, "rotating through the tied ones"),
+			Scoring: "requests in flight per unit of weight; lowest wins",
+		}, exclude, candidates, func(i int) float64 { return loads[i] }, nil)
+	}
+	return selected, nil
+}
+
+func (p *LeastPendingRequests) Update(server string, result Result) { p.finished(server, result) }
+
+func (p *LeastPendingRequests) Release(server string) { p.released(server) }
+
+func (p *LeastPendingRequests) Stats() PolicyStats { return p.snapshot("LeastPendingRequests") }
@@ -0,0 +1,44 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"testing"
+)
+
+func TestLeastPendingRequests(t *testing.T) {
+	p := load_balancer.NewLeastPendingRequests(servers)
+
+	var res []string
+	next := releaseSocket()
+	for i := range 8 {
+		res = append(res, selectServer(t, p))
+		if i > 3 {
+			p.Update(next(), load_balancer.Result{})
+		}
+	}
+
+	expected := []string{
+		"localhost:5000", "localhost:5001", "localhost:5002", "localhost:5003",
+		"localhost:5000", "localhost:5001", "localhost:5003", "localhost:5002",
+	}
+	if !equal(res, expected) {
+		t.Errorf("got %v, want %v", res, expected)
+	}
+}
+
+func TestLeastPendingRequestsRotatesTies(t *testing.T) {
+	p := load_balancer.NewLeastPendingRequests(servers)
+
+	// every request finishes before the next: all backends always tie
+	var res []string
+	for range 4 {
+		s := selectServer(t, p)
+		res = append(res, s)
+		p.Update(s, load_balancer.Result{})
+	}
+
+	expected := []string{"localhost:5000", "localhost:5001", "localhost:5002", "localhost:5003"}
+	if !equal(res, expected) {
+		t.Errorf("got %v, want %v", res, expected)
+	}
+}
@@ -1,156 +1,656 @@
 package load_balancer
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	// ErrNoBackend is returned by SelectServer when there is no backend to pick.
+	ErrNoBackend = errors.New("no backend available")
+	// ErrNoCapacity is returned by SelectServer when every backend is at its connection cap.
+	ErrNoCapacity = errors.New("all backends at connection capacity")
+)
+
 // Policy interface
 type Policy interface {
-	SelectServer() string
-	Update(server string)
+	SelectServer(ctx context.Context, info ConnInfo) (string, error)
+	// SelectServers picks up to n distinct servers, best first, so the caller can
+	// fall back when the first can't be reached. Every returned server counts as
+	// selected: the caller must Update the ones it tried and Release the rest.
+	SelectServers(ctx context.Context, info ConnInfo, n int) ([]string, error)
+	Update(server string, result Result)
+	// Release gives back a server returned by SelectServers that was never tried.
+	Release(server string)
+	SetHealthy(server string, healthy bool) bool
+	// SetHealthyBecause is SetHealthy with the reason and error to report in
+	// the Transition, see Events.OnTransition.
+	SetHealthyBecause(server string, healthy bool, reason string, err error) bool
+	// AddServer and RemoveServer change the backend set at runtime. Removing a
+	// backend also drops what the policy learned about it; connections already
+	// open to it are left alone.
+	AddServer(b Backend) error
+	RemoveServer(server string) error
+	Stats() PolicyStats
+	// Events returns the hooks of the policy's pool, see Pool.SetEvents.
+	Events() Events
+}
+
+// ConnInfo describes the client connection a backend is selected for.
+type ConnInfo struct {
+	ClientAddr string // remote address of the client, host:port
+	// Prefer is a backend the client is pinned to, e.g. by a sticky session. It
+	// is selected whenever it is available; otherwise the policy picks as usual.
+	Prefer string
+	// Explain, if set, is called with how each backend was selected, for
+	// debugging, e.g. why one backend gets all the traffic.
+	Explain func(Decision)
+}
+
+// Result describes how a connection to a backend ended. The zero value is a
+// successful connection with no measurements.
+type Result struct {
+	Err      error         // nil on success
+	Bytes    int64         // bytes transferred in both directions
+	Duration time.Duration // time spent on the backend (0 if unknown)
+
+	// By direction, when known: bytes sent to the backend and received from
+	// it, whose sum is Bytes, and whether copying broke off each way.
+	Sent, Received         int64
+	SendFailed, RecvFailed bool
+}
+
+// Failed reports whether the connection ended in an error.
+func (r Result) Failed() bool { return r.Err != nil }
+
+// checkSelect returns the error SelectServer should fail with, if any.
+func checkSelect(ctx context.Context, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoBackend
+	}
+	return nil
+}
+
+// pickFunc selects one server, skipping those in exclude.
+type pickFunc func(ctx context.Context, exclude map[string]bool) (string, error)
+
+// selectN implements SelectServers on top of a policy's pick and Release.
+func selectN(ctx context.Context, n int, pick pickFunc, release func(string)) ([]string, error) {
+	var out []string
+	exclude := make(map[string]bool, n)
+	for len(out) < n {
+		s, err := pick(ctx, exclude)
+		if errors.Is(err, ErrNoBackend) || errors.Is(err, ErrNoCapacity) {
+			if len(out) > 0 {
+				break // fewer fallbacks than asked for
+			}
+		}
+		if err != nil {
+			for _, s := range out {
+				release(s)
+			}
+			return nil, err
+		}
+		out = append(out, s)
+		exclude[s] = true
+	}
+	return out, nil
+}
+
+// Options tunes the policies built by NewPolicy. The zero value is the default
+// behaviour; policies ignore options that don't apply to them.
+type Options struct {
+	MaxConnsPerBackend int                  // LeastConnections: connection cap per backend, 0 for none
+	Queue              Queue                // LeastConnections with a cap: wait for a slot when every backend is full
+	Adaptive           AdaptiveCoefficients // Adaptive: score coefficients, zero for the defaults
+	Dampening          Dampening            // LeastResponseTime, Adaptive: anti-flap settings
+
+	// LeastResponseTime, Adaptive: latency estimates to start from instead of
+	// treating every backend as equally fast
+	InitialLatency map[string]time.Duration
+}
+
+// NewPolicy builds the named policy on top of an existing pool.
+func NewPolicy(name string, pool *Pool, opts Options) (Policy, error) {
+	switch name {
+	case "N2One":
+		return &N2One{Pool: pool}, nil
+	case "RoundRobin":
+		return newRoundRobin(pool), nil
+	case "LeastConnections":
+		p := newLeastConnections(pool, opts.MaxConnsPerBackend)
+		if opts.MaxConnsPerBackend > 0 {
+			p.queue = newWaitQueue(opts.Queue)
+		}
+		return p, nil
+	case "LeastResponseTime":
+		p := newLeastResponseTime(pool, opts.Dampening)
+		p.Prime(opts.InitialLatency)
+		return p, nil
+	case "LeastPendingRequests":
+		return &LeastPendingRequests{Pool: pool}, nil
+	case "ReportedLoad":
+		return newReportedLoad(pool), nil
+	case "Adaptive":
+		p := newAdaptive(pool, opts.Adaptive, opts.Dampening)
+		p.Prime(opts.InitialLatency)
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown policy: %s", name)
 }
 
 // ---------------- Policies ---------------- //
 
-// N2One: always first server
+// N2One: always first server, weights are ignored
 type N2One struct {
-	servers []string
+	*Pool
 }
 
-func NewN2One(servers []string) *N2One { return &N2One{servers: servers} }
+func NewN2One(backends []Backend) *N2One { return &N2One{Pool: NewPool(backends)} }
 
-func (p *N2One) SelectServer() string { return p.servers[0] }
-func (p *N2One) Update(server string) {}
+func (p *N2One) SelectServer(ctx context.Context, info ConnInfo) (string, error) {
+	return p.pinned(info, p.pick)(ctx, nil)
+}
 
-// RoundRobin
+func (p *N2One) SelectServers(ctx context.Context, info ConnInfo, n int) ([]string, error) {
+	return selectN(ctx, n, p.pinned(info, p.pick), p.Release)
+}
+
+func (p *N2One) pick(ctx context.Context, exclude map[string]bool) (string, error) {
+	candidates := p.candidates(exclude)
+	if err := checkSelect(ctx, len(candidates)); err != nil {
+		return "", err
+	}
+	p.selected(candidates[0].Address)
+	p.explain(ctx, Decision{Policy: "N2One", Chosen: candidates[0].Address, Reason: "first available in pool order"}, exclude, candidates, nil, nil)
+	return candidates[0].Address, nil
+}
+
+func (p *N2One) Update(server string, result Result) { p.finished(server, result) }
+
+func (p *N2One) Release(server string) { p.released(server) }
+
+func (p *N2One) Stats() PolicyStats { return p.snapshot("N2One") }
+
+// RoundRobin: smooth weighted round robin; equal weights cycle through servers in order
 type RoundRobin struct {
-	servers []string
-	idx     int
+	*Pool
+	current map[string]float64
 	mu      sync.Mutex
 }
 
-func NewRoundRobin(servers []string) *RoundRobin { return &RoundRobin{servers: servers} }
+func NewRoundRobin(backends []Backend) *RoundRobin { return newRoundRobin(NewPool(backends)) }
+
+func newRoundRobin(pool *Pool) *RoundRobin {
+	return &RoundRobin{Pool: pool, current: make(map[string]float64)}
+}
+
+func (p *RoundRobin) SelectServer(ctx context.Context, info ConnInfo) (string, error) {
+	return p.pinned(info, p.pick)(ctx, nil)
+}
+
+func (p *RoundRobin) SelectServers(ctx context.Context, info ConnInfo, n int) ([]string, error) {
+	return selectN(ctx, n, p.pinned(info, p.pick), p.Release)
+}
 
-func (p *RoundRobin) SelectServer() string {
+func (p *RoundRobin) pick(ctx context.Context, exclude map[string]bool) (string, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	s := p.servers[p.idx]
-	p.idx = (p.idx + 1) % len(p.servers)
-	return s
+	candidates := p.candidates(exclude)
+	if err := checkSelect(ctx, len(candidates)); err != nil {
+		return "", err
+	}
+	credits := smoothCredits(ctx, p.current, candidates)
+	selected := smoothPick(p.current, candidates)
+	p.selected(selected)
+	p.explain(ctx, Decision{Policy: "RoundRobin", Chosen: selected, Reason: smoothReason, Scoring: smoothScoring},
+		exclude, candidates, func(i int) float64 { return credits[i] }, nil)
+	return selected, nil
 }
 
-func (p *RoundRobin) Update(server string) {}
+// explanation of smoothPick's choice, see Decision
+const (
+	smoothReason  = "highest credit, ties go to the first in pool order"
+	smoothScoring = "credit: every pick each backend gains its weight and the one picked pays their total; highest wins"
+)
 
-// LeastConnections
-type LeastConnections struct {
-	servers     []string
-	connections map[string]int
-	mu          sync.Mutex
+// smoothCredits returns the credit each of candidates has in the smoothPick
+// about to run, if someone is to be told, see ConnInfo.Explain.
+func smoothCredits(ctx context.Context, current map[string]float64, candidates []candidate) []float64 {
+	if explainer(ctx) == nil {
+		return nil
+	}
+	credits := make([]float64, len(candidates))
+	for i, c := range candidates {
+		credits[i] = current[c.Address] + c.weight
+	}
+	return credits
 }
 
-func NewLeastConnections(servers []string) *LeastConnections {
-	conn := make(map[string]int, len(servers))
-	for _, s := range servers {
-		conn[s] = 0
+// smoothPick is one step of smooth weighted round robin over candidates, with the
+// running credit of each server kept in current.
+func smoothPick(current map[string]float64, candidates []candidate) string {
+	// every server gains its weight, the richest is picked and pays the total
+	total := 0.0
+	selected := ""
+	for _, c := range candidates {
+		current[c.Address] += c.weight
+		total += c.weight
+		if selected == "" || current[c.Address] > current[selected] {
+			selected = c.Address
+		}
 	}
-	return &LeastConnections{servers: servers, connections: conn}
+	current[selected] -= total
+	return selected
 }
 
-func (p *LeastConnections) SelectServer() string {
+func (p *RoundRobin) Update(server string, result Result) { p.finished(server, result) }
+
+func (p *RoundRobin) Release(server string) { p.released(server) }
+
+func (p *RoundRobin) RemoveServer(server string) error {
+	if err := p.Pool.RemoveServer(server); err != nil {
+		return err
+	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	// choose min
-	min := int(^uint(0) >> 1) // max int
-	var selected string
-	for _, s := range p.servers {
-		if p.connections[s] < min {
-			min = p.connections[s]
-			selected = s
+	delete(p.current, server)
+	return nil
+}
+
+func (p *RoundRobin) Stats() PolicyStats { return p.snapshot("RoundRobin") }
+
+// LeastConnections: counters are per-backend atomics, so selections and updates
+// don't serialize on a lock. The min scan reads them without one and is approximate
+// under concurrent selections.
+type LeastConnections struct {
+	*Pool
+	connections sync.Map   // server -> *atomic.Int64
+	maxConns    int        // per backend, 0 for no cap
+	queue       *waitQueue // selections waiting for a slot when all are full, nil for none
+}
+
+func NewLeastConnections(backends []Backend) *LeastConnections {
+	return newLeastConnections(NewPool(backends), 0)
+}
+
+// NewCappedLeastConnections is LeastConnections that never gives a backend more
+// than maxConns concurrent connections; when all are full SelectServer returns ErrNoCapacity.
+func NewCappedLeastConnections(backends []Backend, maxConns int) *LeastConnections {
+	return newLeastConnections(NewPool(backends), maxConns)
+}
+
+func newLeastConnections(pool *Pool, maxConns int) *LeastConnections {
+	p := &LeastConnections{Pool: pool, maxConns: maxConns}
+	for _, b := range pool.Backends() {
+		p.connections.Store(b.Address, new(atomic.Int64))
+	}
+	return p
+}
+
+func (p *LeastConnections) SelectServer(ctx context.Context, info ConnInfo) (string, error) {
+	return queued(ctx, p.queue, func() (string, error) {
+		return p.pinned(info, p.pick)(ctx, nil)
+	})
+}
+
+// SelectServers waits in the queue, if there is one, while every backend is
+// full; fallbacks are only picked among backends with a free slot.
+func (p *LeastConnections) SelectServers(ctx context.Context, info ConnInfo, n int) ([]string, error) {
+	return queued(ctx, p.queue, func() ([]string, error) {
+		return selectN(ctx, n, p.pinned(info, p.pick), p.Release)
+	})
+}
+
+func (p *LeastConnections) pick(ctx context.Context, exclude map[string]bool) (string, error) {
+	candidates := p.candidates(exclude)
+	if err := checkSelect(ctx, len(candidates)); err != nil {
+		return "", err
+	}
+	explaining := explainer(ctx) != nil
+	for {
+		// choose min connections per unit of weight
+		min := math.Inf(1)
+		var selected string
+		var counter *atomic.Int64
+		var loads []float64 // of each candidate, if explaining
+		var full []bool
+		for _, c := range candidates {
+			n := p.counter(c.Address)
+			conns := n.Load()
+			isFull := p.maxConns > 0 && conns >= int64(p.maxConns)
+			if explaining {
+				loads, full = append(loads, float64(conns)/c.weight), append(full, isFull)
+			}
+			if isFull {
+				continue // full, fall through to the next candidate
+			}
+			if load := float64(conns) / c.weight; load < min {
+				min = load
+				selected, counter = c.Address, n
+			}
+		}
+		if selected == "" {
+			return "", ErrNoCapacity
+		}
+		// increment; may lose the race for a backend's last slot, then scan again
+		if p.reserve(counter) {
+			p.selected(selected)
+			if explaining {
+				ranked := slices.Clone(loads)
+				for i := range ranked {
+					if full[i] {
+						ranked[i] = math.Inf(1)
+					}
+				}
+				best := indexOf(candidates, selected)
+				p.explain(ctx, Decision{
+					Policy:  "LeastConnections",
+					Chosen:  selected,
+					Reason:  lowestWins(ranked, best, best, "the first in pool order wins"),
+					Scoring: "open connections per unit of weight; lowest wins",
+				}, exclude, candidates, func(i int) float64 { return loads[i] }, func(i int) string {
+					if full[i] {
+						return fmt.Sprintf("at its cap of %d connections", p.maxConns)
+					}
+					return ""
+				})
+			}
+			return selected, nil
 		}
 	}
-	// increment
-	p.connections[selected]++
-	return selected
 }
 
-func (p *LeastConnections) Update(server string) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	if _, ok := p.connections[server]; ok && p.connections[server] > 0 {
-		p.connections[server]--
+// counter returns the connection counter of server, creating it if needed.
+func (p *LeastConnections) counter(server string) *atomic.Int64 {
+	if n, ok := p.connections.Load(server); ok {
+		return n.(*atomic.Int64)
 	}
+	n, _ := p.connections.LoadOrStore(server, new(atomic.Int64))
+	return n.(*atomic.Int64)
 }
 
+// reserve takes a connection slot on n, failing if the cap is reached.
+func (p *LeastConnections) reserve(n *atomic.Int64) bool {
+	if p.maxConns <= 0 {
+		n.Add(1)
+		return true
+	}
+	for {
+		conns := n.Load()
+		if conns >= int64(p.maxConns) {
+			return false
+		}
+		if n.CompareAndSwap(conns, conns+1) {
+			return true
+		}
+	}
+}
+
+// seed replaces the connection counters, used when taking over from another policy.
+func (p *LeastConnections) seed(active map[string]int) {
+	p.connections.Range(func(_, n any) bool {
+		n.(*atomic.Int64).Store(0)
+		return true
+	})
+	for s, n := range active {
+		p.counter(s).Store(int64(n))
+	}
+}
+
+func (p *LeastConnections) RemoveServer(server string) error {
+	if err := p.Pool.RemoveServer(server); err != nil {
+		return err
+	}
+	p.connections.Delete(server)
+	return nil
+}
+
+func (p *LeastConnections) Stats() PolicyStats {
+	st := p.snapshot("LeastConnections")
+	st.Queue = p.queue.stats()
+	return st
+}
+
+func (p *LeastConnections) Update(server string, result Result) {
+	p.finished(server, result)
+	p.decrement(server)
+}
+
+func (p *LeastConnections) Release(server string) {
+	p.released(server)
+	p.decrement(server)
+}
+
+// closeQueue fails the selections waiting for a slot, see Switchable.Switch.
+func (p *LeastConnections) closeQueue() { p.queue.close() }
+
+// decrement frees a connection slot on server, for the selection waiting
+// longest if any.
+func (p *LeastConnections) decrement(server string) {
+	v, ok := p.connections.Load(server)
+	if !ok {
+		return
+	}
+	n := v.(*atomic.Int64)
+	for {
+		conns := n.Load()
+		if conns <= 0 {
+			return
+		}
+		if n.CompareAndSwap(conns, conns-1) {
+			p.queue.signal()
+			return
+		}
+	}
+}
+
+// FailurePenalty is the response time LeastResponseTime records for a failed
+// connection when the measured one is shorter, so backends that fail fast don't look fast.
+const FailurePenalty = 1 * time.Second
+
+// startTimesBuffer is how many connections per backend LeastResponseTime can
+// time at once; beyond it the oldest start times are dropped.
+const startTimesBuffer = 10000
+
 // LeastResponseTime
 type LeastResponseTime struct {
-	servers		[]string
-	avgTime		map[string]float64
-	startTimes	map[string]chan time.Time // FIFO of start times per server
-	pastTimes	map[string][]float64
-	current		int
-	mu			sync.Mutex
-}
-
-func NewLeastResponseTime(servers []string) *LeastResponseTime {
-	avg := make(map[string]float64, len(servers))
-	starts := make(map[string]chan time.Time, len(servers))
-	past := make(map[string][]float64, len(servers))
-	for _, s := range servers {
+	*Pool
+	avgTime    map[string]float64
+	startTimes map[string]chan time.Time // FIFO of start times per server
+	pastTimes  map[string][]float64
+	current    int
+	damp       damper
+	mu         sync.Mutex
+}
+
+func NewLeastResponseTime(backends []Backend) *LeastResponseTime {
+	return newLeastResponseTime(NewPool(backends), Dampening{})
+}
+
+// NewDampedLeastResponseTime is LeastResponseTime that sticks to its backend as
+// described by d instead of chasing every change in the averages.
+func NewDampedLeastResponseTime(backends []Backend, d Dampening) *LeastResponseTime {
+	return newLeastResponseTime(NewPool(backends), d)
+}
+
+func newLeastResponseTime(pool *Pool, d Dampening) *LeastResponseTime {
+	backends := pool.Backends()
+	avg := make(map[string]float64, len(backends))
+	starts := make(map[string]chan time.Time, len(backends))
+	past := make(map[string][]float64, len(backends))
+	for _, b := range backends {
+		s := b.Address
 		avg[s] = 0.0
 		// buffered channel to queue start times. buffer large enough for typical concurrency.
-		starts[s] = make(chan time.Time, 10000)
+		starts[s] = make(chan time.Time, startTimesBuffer)
 		past[s] = []float64{}
 	}
 	return &LeastResponseTime{
-		servers:    servers,
+		Pool:       pool,
 		avgTime:    avg,
 		startTimes: starts,
 		pastTimes:  past,
-		current: -1,
+		current:    -1,
+		damp:       damper{Dampening: d},
 	}
 }
 
-func (p *LeastResponseTime) SelectServer() string {
+func (p *LeastResponseTime) SelectServer(ctx context.Context, info ConnInfo) (string, error) {
+	return p.pinned(info, p.pick)(ctx, nil)
+}
+
+func (p *LeastResponseTime) SelectServers(ctx context.Context, info ConnInfo, n int) ([]string, error) {
+	return selectN(ctx, n, p.pinned(info, p.pick), p.Release)
+}
+
+func (p *LeastResponseTime) pick(ctx context.Context, exclude map[string]bool) (string, error) {
 	p.mu.Lock()
-	// pick server with minimal avgTime (if tie: first occurrence)
-	selected := p.servers[0]
-	min := p.avgTime[selected]
-
-	for _, s := range p.servers {
-		if p.avgTime[s] < min {
-			min = p.avgTime[s]
-			selected = s
+	candidates := p.candidates(exclude)
+	if err := checkSelect(ctx, len(candidates)); err != nil {
+		p.mu.Unlock()
+		return "", err
+	}
+	// pick server with minimal avgTime per unit of weight (if tie: rotate through the tied ones)
+	score := func(c candidate) float64 { return p.avgTime[c.Address] / c.weight }
+	min := score(candidates[0])
+
+	for _, c := range candidates {
+		if score(c) < min {
+			min = score(c)
 		}
 	}
 
-	for range len(p.servers) {
-		p.current = (p.current + 1) % len(p.servers)
-		if p.avgTime[p.servers[p.current]] == p.avgTime[selected] {
+	for range len(candidates) {
+		p.current = (p.current + 1) % len(candidates)
+		if score(candidates[p.current]) == min {
 			break
 		}
 	}
+	best := p.current
+	var scores []float64
+	if explainer(ctx) != nil {
+		scores = make([]float64, len(candidates))
+		for i, c := range candidates {
+			scores[i] = score(c)
+		}
+	}
+	chosen := candidates[p.current].Address
+	if len(exclude) == 0 { // fallback picks don't move the dampened choice
+		chosen = p.damp.choose(chosen, func(server string) (float64, bool) {
+			for _, c := range candidates {
+				if c.Address == server {
+					return score(c), true
+				}
+			}
+			return 0, false
+		})
+	}
 
 	// push start time into its FIFO channel
 	now := time.Now()
+	starts := p.starts(chosen)
 	select {
-	case p.startTimes[p.servers[p.current]] <- now:
+	case starts <- now:
 		// ok
 	default:
 		// in unlikely event channel full, use non-blocking fallback (drop oldest)
 		// try to drain one and then push
 		select {
-		case <-p.startTimes[p.servers[p.current]]:
+		case <-starts:
 		default:
 		}
-		p.startTimes[p.servers[p.current]] <- now
+		starts <- now
 	}
 	p.mu.Unlock()
-	return p.servers[p.current]
+	p.selected(chosen)
+	p.explain(ctx, Decision{
+		Policy:  "LeastResponseTime",
+		Chosen:  chosen,
+		Reason:  lowestWins(scores, best, indexOf(candidates, chosen), "rotating through the tied ones"),
+		Scoring: "average response time in seconds per unit of weight; lowest wins",
+	}, exclude, candidates, func(i int) float64 { return scores[i] }, nil)
+	return chosen, nil
+}
+
+// starts returns the start time FIFO of server, creating it for backends added
+// after construction. Caller holds p.mu.
+func (p *LeastResponseTime) starts(server string) chan time.Time {
+	ch, ok := p.startTimes[server]
+	if !ok {
+		ch = make(chan time.Time, startTimesBuffer)
+		p.startTimes[server] = ch
+		p.pastTimes[server] = []float64{}
+		p.avgTime[server] = 0.0
+	}
+	return ch
 }
 
-func (p *LeastResponseTime) Update(server string) {
-	// pop a start time, compute elapsed, append to pastTimes, recompute avg
+func (p *LeastResponseTime) RemoveServer(server string) error {
+	if err := p.Pool.RemoveServer(server); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.startTimes, server)
+	delete(p.pastTimes, server)
+	delete(p.avgTime, server)
+	return nil
+}
+
+// Prime seeds the averages with estimates, e.g. from a previous run, so the first
+// connections aren't spread by tie-breaking alone. Unknown backends are ignored.
+func (p *LeastResponseTime) Prime(latency map[string]time.Duration) {
+	for server, d := range latency {
+		p.restore(server, BackendState{Latency: d.Seconds()})
+	}
+}
+
+// restore seeds the average of server with a saved one, as a single sample.
+func (p *LeastResponseTime) restore(server string, st BackendState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.avgTime[server]; !ok || st.Latency <= 0 {
+		return
+	}
+	p.avgTime[server] = st.Latency
+	p.pastTimes[server] = []float64{st.Latency}
+}
+
+func (p *LeastResponseTime) Stats() PolicyStats {
+	st := p.snapshot("LeastResponseTime")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range st.Backends {
+		st.Backends[i].AvgResponseTime = p.avgTime[st.Backends[i].Address]
+	}
+	return st
+}
+
+func (p *LeastResponseTime) Release(server string) {
+	p.released(server)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// drop the start time queued for it, nothing was measured
+	select {
+	case <-p.startTimes[server]:
+	default:
+	}
+}
+
+func (p *LeastResponseTime) Update(server string, result Result) {
+	// pop a start time, compute elapsed, append to pastTimes, recompute avg.
+	// A duration reported by the caller wins over the FIFO measurement.
+	p.finished(server, result)
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	ch, ok := p.startTimes[server]
@@ -166,6 +666,12 @@ func (p *LeastResponseTime) Update(server string) {
 		return
 	}
 	elapsed := time.Since(start).Seconds()
+	if result.Duration > 0 {
+		elapsed = result.Duration.Seconds()
+	}
+	if result.Failed() && elapsed < FailurePenalty.Seconds() {
+		elapsed = FailurePenalty.Seconds()
+	}
 	p.pastTimes[server] = append(p.pastTimes[server], elapsed)
 	// recompute avg
 	sum := 0.0
@@ -174,4 +680,3 @@ func (p *LeastResponseTime) Update(server string) {
 	}
 	p.avgTime[server] = sum / float64(len(p.pastTimes[server]))
 }
-
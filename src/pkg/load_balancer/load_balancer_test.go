@@ -2,16 +2,20 @@ package load_balancer_test
 
 import (
 	"Load-Balancer/pkg/load_balancer"
+	"context"
+	"errors"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 )
 
-var servers = []string{
+var servers = load_balancer.NewBackends([]string{
 	"localhost:5000",
 	"localhost:5001",
 	"localhost:5002",
 	"localhost:5003",
-}
+})
 
 // releaseSocket yields a fixed sequence of finished servers.
 func releaseSocket() func() string {
@@ -29,7 +33,7 @@ func TestN2One(t *testing.T) {
 
 	var res []string
 	for range 8 {
-		res = append(res, p.SelectServer())
+		res = append(res, selectServer(t, p))
 	}
 
 	expected := []string{
@@ -46,7 +50,7 @@ func TestRoundRobin(t *testing.T) {
 
 	var res []string
 	for range 8 {
-		res = append(res, p.SelectServer())
+		res = append(res, selectServer(t, p))
 	}
 
 	expected := []string{
@@ -64,9 +68,9 @@ func TestRoundRobinUpdate(t *testing.T) {
 	var res []string
 	next := releaseSocket()
 	for i := range 8 {
-		res = append(res, p.SelectServer())
+		res = append(res, selectServer(t, p))
 		if i > 3 {
-			p.Update(next())
+			p.Update(next(), load_balancer.Result{})
 		}
 	}
 
@@ -84,7 +88,7 @@ func TestLeastConnections(t *testing.T) {
 
 	var res []string
 	for range 8 {
-		res = append(res, p.SelectServer())
+		res = append(res, selectServer(t, p))
 	}
 
 	expected := []string{
@@ -102,9 +106,9 @@ func TestLeastConnectionsUpdate(t *testing.T) {
 	var res []string
 	next := releaseSocket()
 	for i := range 8 {
-		res = append(res, p.SelectServer())
+		res = append(res, selectServer(t, p))
 		if i > 3 {
-			p.Update(next())
+			p.Update(next(), load_balancer.Result{})
 		}
 	}
 
@@ -117,12 +121,68 @@ func TestLeastConnectionsUpdate(t *testing.T) {
 	}
 }
 
+func TestCappedLeastConnections(t *testing.T) {
+	p := load_balancer.NewCappedLeastConnections(servers[:2], 2)
+
+	var res []string
+	for range 4 {
+		res = append(res, selectServer(t, p))
+	}
+	expected := []string{"localhost:5000", "localhost:5001", "localhost:5000", "localhost:5001"}
+	if !equal(res, expected) {
+		t.Errorf("got %v, want %v", res, expected)
+	}
+
+	if _, err := p.SelectServer(context.Background(), load_balancer.ConnInfo{}); !errors.Is(err, load_balancer.ErrNoCapacity) {
+		t.Errorf("got %v, want ErrNoCapacity", err)
+	}
+
+	// a finished connection frees a slot
+	p.Update("localhost:5001", load_balancer.Result{})
+	if s := selectServer(t, p); s != "localhost:5001" {
+		t.Errorf("got %s, want localhost:5001", s)
+	}
+}
+
+func TestCappedLeastConnectionsConcurrent(t *testing.T) {
+	p := load_balancer.NewCappedLeastConnections(servers, 2)
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	active := map[string]int{}
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				s, err := p.SelectServer(ctx, load_balancer.ConnInfo{})
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				active[s]++
+				if active[s] > 2 {
+					t.Errorf("%s has %d connections, cap is 2", s, active[s])
+				}
+				mu.Unlock()
+				runtime.Gosched()
+				mu.Lock()
+				active[s]--
+				mu.Unlock()
+				p.Update(s, load_balancer.Result{})
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func TestLeastResponseTime(t *testing.T) {
 	p := load_balancer.NewLeastResponseTime(servers)
 
 	var res []string
 	for range 8 {
-		res = append(res, p.SelectServer())
+		res = append(res, selectServer(t, p))
 	}
 
 	expected := []string{
@@ -140,9 +200,9 @@ func TestLeastResponseTimeUpdate(t *testing.T) {
 	var res []string
 	next := releaseSocket()
 	for i := range 8 {
-		res = append(res, p.SelectServer())
+		res = append(res, selectServer(t, p))
 		if i > 3 {
-			p.Update(next())
+			p.Update(next(), load_balancer.Result{})
 		}
 		time.Sleep(100 * time.Millisecond) // simulate elapsed time
 	}
@@ -156,6 +216,126 @@ func TestLeastResponseTimeUpdate(t *testing.T) {
 	}
 }
 
+func TestLeastResponseTimeFailurePenalty(t *testing.T) {
+	p := load_balancer.NewLeastResponseTime(servers[:2])
+
+	// first backend fails fast, second succeeds slowly
+	p.Update(selectServer(t, p), load_balancer.Result{Err: errors.New("refused"), Duration: time.Millisecond})
+	p.Update(selectServer(t, p), load_balancer.Result{Duration: 200 * time.Millisecond})
+
+	var res []string
+	for range 3 {
+		res = append(res, selectServer(t, p))
+	}
+
+	expected := []string{"localhost:5001", "localhost:5001", "localhost:5001"}
+	if !equal(res, expected) {
+		t.Errorf("got %v, want %v", res, expected)
+	}
+}
+
+func TestSelectServers(t *testing.T) {
+	p := load_balancer.NewLeastConnections(servers)
+	ctx := context.Background()
+
+	res, err := p.SelectServers(ctx, load_balancer.ConnInfo{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"localhost:5000", "localhost:5001"}
+	if !equal(res, expected) {
+		t.Errorf("got %v, want %v", res, expected)
+	}
+
+	// first choice failed, second one used: the released slot is free again
+	p.Update("localhost:5000", load_balancer.Result{Err: errors.New("refused")})
+	p.Release("localhost:5001")
+	if s := selectServer(t, p); s != "localhost:5000" {
+		t.Errorf("got %s, want localhost:5000", s)
+	}
+	if st := p.Stats().Backends[1]; st.Active != 0 || st.Selected != 0 {
+		t.Errorf("released backend has active %d selected %d, want 0 and 0", st.Active, st.Selected)
+	}
+
+	// asking for more than the pool has returns the whole pool
+	res, err = p.SelectServers(ctx, load_balancer.ConnInfo{}, 10)
+	if err != nil || len(res) != len(servers) {
+		t.Errorf("got %v, %v, want all %d servers", res, err, len(servers))
+	}
+
+	if _, err := load_balancer.NewRoundRobin(nil).SelectServers(ctx, load_balancer.ConnInfo{}, 2); !errors.Is(err, load_balancer.ErrNoBackend) {
+		t.Errorf("got %v, want ErrNoBackend", err)
+	}
+}
+
+func TestSelectServerErrors(t *testing.T) {
+	policies := map[string]load_balancer.Policy{
+		"N2One":             load_balancer.NewN2One(nil),
+		"RoundRobin":        load_balancer.NewRoundRobin(nil),
+		"LeastConnections":  load_balancer.NewLeastConnections(nil),
+		"LeastResponseTime": load_balancer.NewLeastResponseTime(nil),
+	}
+	for name, p := range policies {
+		if _, err := p.SelectServer(context.Background(), load_balancer.ConnInfo{}); !errors.Is(err, load_balancer.ErrNoBackend) {
+			t.Errorf("%s: got %v, want ErrNoBackend", name, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p := load_balancer.NewRoundRobin(servers)
+	if _, err := p.SelectServer(ctx, load_balancer.ConnInfo{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestSelectServerPrefer(t *testing.T) {
+	p := load_balancer.NewCappedLeastConnections(servers, 1)
+	ctx := context.Background()
+	pinned := load_balancer.ConnInfo{Prefer: "localhost:5002"}
+
+	if s, _ := p.SelectServer(ctx, pinned); s != "localhost:5002" {
+		t.Errorf("got %s, want the preferred localhost:5002", s)
+	}
+	// the preferred backend is full: the policy picks as usual
+	if s, _ := p.SelectServer(ctx, pinned); s != "localhost:5000" {
+		t.Errorf("got %s, want localhost:5000", s)
+	}
+	p.Update("localhost:5000", load_balancer.Result{})
+	p.Update("localhost:5002", load_balancer.Result{})
+
+	// preferred first, then the fallbacks
+	res, err := p.SelectServers(ctx, pinned, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"localhost:5002", "localhost:5000"}; !equal(res, expected) {
+		t.Errorf("got %v, want %v", res, expected)
+	}
+	p.Release("localhost:5002")
+	p.Release("localhost:5000")
+
+	// gone or unhealthy backends aren't preferred
+	p.SetHealthy("localhost:5002", false)
+	for _, prefer := range []string{"localhost:5002", "localhost:9999"} {
+		s, err := p.SelectServer(ctx, load_balancer.ConnInfo{Prefer: prefer})
+		if err != nil || s != "localhost:5000" {
+			t.Errorf("prefer %s: got %s, %v, want localhost:5000", prefer, s, err)
+		}
+		p.Update(s, load_balancer.Result{})
+	}
+}
+
+// selectServer picks a backend and fails the test on error.
+func selectServer(t *testing.T, p load_balancer.Policy) string {
+	t.Helper()
+	s, err := p.SelectServer(context.Background(), load_balancer.ConnInfo{})
+	if err != nil {
+		t.Fatalf("SelectServer: %v", err)
+	}
+	return s
+}
+
 // helper to compare two string slices
 func equal(a, b []string) bool {
 	if len(a) != len(b) {
@@ -168,3 +348,23 @@ func equal(a, b []string) bool {
 	}
 	return true
 }
+
+func BenchmarkLeastConnections(b *testing.B) {
+	p := load_balancer.NewLeastConnections(servers)
+	ctx := context.Background()
+	for b.Loop() {
+		s, _ := p.SelectServer(ctx, load_balancer.ConnInfo{})
+		p.Update(s, load_balancer.Result{})
+	}
+}
+
+func BenchmarkLeastConnectionsParallel(b *testing.B) {
+	p := load_balancer.NewLeastConnections(servers)
+	ctx := context.Background()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s, _ := p.SelectServer(ctx, load_balancer.ConnInfo{})
+			p.Update(s, load_balancer.Result{})
+		}
+	})
+}
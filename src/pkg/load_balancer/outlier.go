@@ -0,0 +1,132 @@
+package load_balancer
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// OutlierDetection configures EjectOutliers. Zero fields take the defaults noted.
+type OutlierDetection struct {
+	MinRequests        int           // connections a backend needs since the last sweep to be judged, default 100
+	MinHosts           int           // judged backends needed to compute a mean at all, default 5
+	StdevFactor        float64       // outliers succeed less than mean - StdevFactor*stdev, default 1.9
+	BaseEjection       time.Duration // first ejection; each repeat ejects for one more, default 30s
+	MaxEjectionPercent int           // never eject more than this share of the pool, default 10
+}
+
+// DefaultOutlierDetection mirrors Envoy's defaults.
+var DefaultOutlierDetection = OutlierDetection{
+	MinRequests:        100,
+	MinHosts:           5,
+	StdevFactor:        1.9,
+	BaseEjection:       30 * time.Second,
+	MaxEjectionPercent: 10,
+}
+
+// outlier is what outlier detection knows about one backend.
+type outlier struct {
+	successes, failures uint64    // counter values at the last sweep
+	until               time.Time // ejected before this
+	times               int       // ejections so far, lengthens the next one
+}
+
+// EjectOutliers compares the success rate of every backend since the previous
+// call with the pool mean, and ejects those more than StdevFactor standard
+// deviations below it, worst first, within MaxEjectionPercent. It is meant to be
+// called on a fixed interval and returns the backends it ejected.
+func (p *Pool) EjectOutliers(cfg OutlierDetection) []string {
+	def := DefaultOutlierDetection
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = def.MinRequests
+	}
+	if cfg.MinHosts <= 0 {
+		cfg.MinHosts = def.MinHosts
+	}
+	if cfg.StdevFactor <= 0 {
+		cfg.StdevFactor = def.StdevFactor
+	}
+	if cfg.BaseEjection <= 0 {
+		cfg.BaseEjection = def.BaseEjection
+	}
+	if cfg.MaxEjectionPercent <= 0 {
+		cfg.MaxEjectionPercent = def.MaxEjectionPercent
+	}
+
+	var moved []Transition
+	defer func() { p.announce(moved) }()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.outliers == nil {
+		p.outliers = make(map[string]*outlier)
+	}
+	now := time.Now()
+
+	type judged struct {
+		b    *Backend
+		rate float64
+	}
+	var rates []judged
+	ejected := 0
+	for _, b := range p.backends {
+		o := p.outliers[b.Address]
+		if o == nil {
+			o = &outlier{}
+			p.outliers[b.Address] = o
+		}
+		c := p.stats[b.Address]
+		successes, failures := c.successes.Load(), c.failures.Load()
+		ds, df := successes-o.successes, failures-o.failures
+		o.successes, o.failures = successes, failures
+		if now.Before(o.until) {
+			ejected++
+			continue
+		}
+		if !o.until.IsZero() { // its ejection ended since the last sweep
+			end := o.until
+			o.until = time.Time{}
+			moved = p.moved(moved, b, ReasonExpired, "", end)
+		}
+		if ds+df >= uint64(cfg.MinRequests) {
+			rates = append(rates, judged{b, float64(ds) / float64(ds+df)})
+		}
+	}
+	if len(rates) < cfg.MinHosts {
+		return nil
+	}
+
+	var mean, variance float64
+	for _, r := range rates {
+		mean += r.rate
+	}
+	mean /= float64(len(rates))
+	for _, r := range rates {
+		variance += (r.rate - mean) * (r.rate - mean)
+	}
+	limit := mean - cfg.StdevFactor*math.Sqrt(variance/float64(len(rates)))
+
+	slices.SortStableFunc(rates, func(a, b judged) int {
+		switch {
+		case a.rate < b.rate:
+			return -1
+		case a.rate > b.rate:
+			return 1
+		}
+		return 0
+	})
+	var out []string
+	for _, r := range rates {
+		// the pool is never emptied, whatever the percentage allows
+		if r.rate >= limit || ejected*100 >= cfg.MaxEjectionPercent*len(p.backends) || ejected+1 >= len(p.backends) {
+			break
+		}
+		o := p.outliers[r.b.Address]
+		o.times++
+		o.until = now.Add(time.Duration(o.times) * cfg.BaseEjection)
+		ejected++
+		out = append(out, r.b.Address)
+		moved = p.moved(moved, r.b, ReasonOutlier, fmt.Sprintf("success rate %.1f%%, pool mean %.1f%%", 100*r.rate, 100*mean), now)
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// outlierPool has ten backends; the last two fail every connection.
+func outlierPool(t *testing.T) *load_balancer.RoundRobin {
+	t.Helper()
+	var addrs []string
+	for i := range 10 {
+		addrs = append(addrs, fmt.Sprintf("localhost:%d", 5000+i))
+	}
+	p := load_balancer.NewRoundRobin(load_balancer.NewBackends(addrs))
+	for i, a := range addrs {
+		result := load_balancer.Result{}
+		if i >= 8 {
+			result.Err = errors.New("reset")
+		}
+		for range 10 {
+			p.Update(a, result)
+		}
+	}
+	return p
+}
+
+func TestEjectOutliers(t *testing.T) {
+	p := outlierPool(t)
+	cfg := load_balancer.OutlierDetection{MinRequests: 10}
+
+	// the default 10% of ten backends allows one ejection
+	ejected := p.EjectOutliers(cfg)
+	if len(ejected) != 1 || ejected[0] != "localhost:5008" {
+		t.Fatalf("got %v, want [localhost:5008]", ejected)
+	}
+	if !p.Ejected("localhost:5008") || p.Ejected("localhost:5009") {
+		t.Error("ejected state doesn't match")
+	}
+	for range 20 {
+		if s := selectServer(t, p); s == "localhost:5008" {
+			t.Fatal("selected ejected outlier")
+		}
+	}
+
+	// without new traffic nothing is judged
+	if ejected := p.EjectOutliers(cfg); len(ejected) != 0 {
+		t.Errorf("got %v with no new traffic", ejected)
+	}
+}
+
+func TestEjectOutliersMaxPercent(t *testing.T) {
+	p := outlierPool(t)
+	ejected := p.EjectOutliers(load_balancer.OutlierDetection{MinRequests: 10, MaxEjectionPercent: 50})
+	if !equal(ejected, []string{"localhost:5008", "localhost:5009"}) {
+		t.Errorf("got %v, want both failing backends", ejected)
+	}
+}
+
+func TestEjectOutliersMinHosts(t *testing.T) {
+	p := load_balancer.NewRoundRobin(servers)
+	p.Update("localhost:5000", load_balancer.Result{Err: errors.New("reset")})
+	for _, b := range servers[1:] {
+		p.Update(b.Address, load_balancer.Result{})
+	}
+	if ejected := p.EjectOutliers(load_balancer.OutlierDetection{MinRequests: 1}); len(ejected) != 0 {
+		t.Errorf("got %v from fewer than MinHosts backends", ejected)
+	}
+}
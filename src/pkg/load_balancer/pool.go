@@ -0,0 +1,349 @@
+package load_balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minSlowStartFactor is the share of its weight a backend gets as soon as it
+// enters slow start, so it still sees some traffic to warm up with.
+const minSlowStartFactor = 0.1
+
+// Pool is the set of backends a policy picks from. Every policy embeds one, so
+// the pool operations below are available directly on the policy.
+type Pool struct {
+	mu             sync.RWMutex
+	backends       []*Backend
+	slowStart      time.Duration
+	instanceID     string // subsetting, see SetSubset
+	subsetSize     int
+	stats          map[string]*backendCounters
+	reports        map[string]timedReport // latest load report per backend
+	ejection       FailureEjection
+	ejections      map[string]*ejection   // backends with recent failures
+	outliers       map[string]*outlier    // outlier detection state, see EjectOutliers
+	panicThreshold float64                // percent of backends that must be usable, see SetPanicThreshold
+	events         atomic.Pointer[events] // hooks, see SetEvents
+}
+
+// ErrUnknownBackend is returned by pool operations naming a backend not in the pool.
+var ErrUnknownBackend = errors.New("unknown backend")
+
+// candidate is a backend that may be selected, with its effective weight.
+type candidate struct {
+	Backend
+	weight float64
+}
+
+func NewPool(backends []Backend) *Pool {
+	p := &Pool{backends: copyBackends(backends), stats: make(map[string]*backendCounters, len(backends))}
+	now := time.Now()
+	for _, b := range p.backends {
+		p.stats[b.Address] = &backendCounters{}
+		b.status, b.since = p.status(b), now
+	}
+	return p
+}
+
+// SetHealthy marks a backend healthy or unhealthy. Unhealthy backends stay in the
+// pool but are skipped by SelectServer. Reports whether the state changed.
+func (p *Pool) SetHealthy(server string, healthy bool) bool {
+	return p.SetHealthyBecause(server, healthy, ReasonAdmin, nil)
+}
+
+// SetHealthyBecause is SetHealthy for reason, one of the Reason constants, and
+// err, what made the backend unhealthy if anything: both go in the Transition.
+func (p *Pool) SetHealthyBecause(server string, healthy bool, reason string, err error) bool {
+	changed, moved := p.setHealthy(server, healthy, reason, err)
+	if changed {
+		p.Events().OnHealthChange(server, healthy)
+	}
+	p.announce(moved)
+	return changed
+}
+
+func (p *Pool) setHealthy(server string, healthy bool, reason string, err error) (bool, []Transition) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state := Unhealthy
+	if healthy {
+		state = Healthy
+	}
+	for _, b := range p.backends {
+		if b.Address == server {
+			now := time.Now()
+			// backends added to a running pool ramp up again once they pass
+			// their first check; the pool's first ones start together, with
+			// nothing to ramp against
+			if state == Healthy && (b.Health == Unhealthy || b.Health == HealthUnknown && !b.recovered.IsZero()) {
+				b.recovered = now
+			}
+			changed := b.Health != state
+			b.Health = state
+			var detail string
+			if err != nil {
+				detail = err.Error()
+			}
+			return changed, p.moved(nil, b, reason, detail, now)
+		}
+	}
+	return false, nil
+}
+
+// SetWeight changes a backend's weight, shifting traffic gradually without a
+// restart. A weight of 0 stops new traffic to the backend.
+func (p *Pool) SetWeight(server string, weight int) error {
+	if weight < 0 {
+		return fmt.Errorf("invalid weight %d for %s", weight, server)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, b := range p.backends {
+		if b.Address == server {
+			b.Weight = weight
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownBackend, server)
+}
+
+// AddServer adds a backend to the pool; it can be selected straight away,
+// ramped up by slow start.
+func (p *Pool) AddServer(b Backend) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, existing := range p.backends {
+		if existing.Address == b.Address {
+			return fmt.Errorf("backend %s already in pool", b.Address)
+		}
+	}
+	added := copyBackends([]Backend{b})[0]
+	now := time.Now()
+	added.status, added.since = p.status(added), now
+	added.recovered = now // slow start ramps it up like a recovered one
+	p.backends = append(p.backends, added)
+	p.stats[b.Address] = &backendCounters{}
+	return nil
+}
+
+// RemoveServer removes a backend and everything the pool tracks about it.
+// Connections still open to it finish normally; their results are ignored.
+func (p *Pool) RemoveServer(server string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := slices.IndexFunc(p.backends, func(b *Backend) bool { return b.Address == server })
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownBackend, server)
+	}
+	p.backends = slices.Delete(p.backends, i, i+1)
+	delete(p.stats, server)
+	delete(p.reports, server)
+	delete(p.ejections, server)
+	delete(p.outliers, server)
+	return nil
+}
+
+// SetDraining stops or resumes new connections to server. Unlike a weight of 0,
+// draining is meant to be temporary, e.g. while a backend is redeployed, and
+// shows in the stats.
+func (p *Pool) SetDraining(server string, draining bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, b := range p.backends {
+		if b.Address == server {
+			b.Draining = draining
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownBackend, server)
+}
+
+// SetMaintenance takes server out for maintenance or puts it back. Like a
+// drained one it gets no new connections; it also isn't health checked, and its
+// health is unknown again when it comes back.
+func (p *Pool) SetMaintenance(server string, maintenance bool) error {
+	var moved []Transition
+	defer func() { p.announce(moved) }()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, b := range p.backends {
+		if b.Address == server {
+			b.Maintenance = maintenance
+			b.Health = HealthUnknown
+			moved = p.moved(moved, b, ReasonAdmin, "", time.Now())
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownBackend, server)
+}
+
+// inMaintenance reports whether server is in the pool and in maintenance.
+func (p *Pool) inMaintenance(server string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, b := range p.backends {
+		if b.Address == server {
+			return b.Maintenance
+		}
+	}
+	return false
+}
+
+// SetSlowStart sets the window over which a backend added or coming back to
+// healthy ramps up from a tenth of its weight to all of it. Zero disables the ramp.
+func (p *Pool) SetSlowStart(window time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.slowStart = window
+}
+
+// Backends returns a snapshot of every backend in the pool.
+func (p *Pool) Backends() []Backend {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]Backend, 0, len(p.backends))
+	for _, b := range p.backends {
+		out = append(out, *b)
+	}
+	return out
+}
+
+// available returns the backends that may take new connections, in pool order.
+func (p *Pool) available() []candidate {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	now := time.Now()
+	out := make([]candidate, 0, len(p.backends))
+	all := make([]candidate, 0, len(p.backends))
+	for _, b := range p.backends {
+		if b.Weight <= 0 || b.Draining || b.Maintenance {
+			continue
+		}
+		c := candidate{Backend: *b, weight: p.weight(b, now)}
+		all = append(all, c)
+		if p.usable(b, now) {
+			out = append(out, c)
+		}
+	}
+	if p.panicking(len(out), len(all)) {
+		out = all
+	}
+	return subset(out, p.instanceID, p.subsetSize)
+}
+
+// usable reports whether b's health allows new connections. Caller holds p.mu.
+func (p *Pool) usable(b *Backend, now time.Time) bool {
+	return b.Health != Unhealthy && !p.ejected(b.Address, now)
+}
+
+// panicking reports whether so few of total backends are usable that health is
+// ignored. Caller holds p.mu.
+func (p *Pool) panicking(usable, total int) bool {
+	return total > 0 && float64(usable) < p.panicThreshold/100*float64(total)
+}
+
+// SetPanicThreshold sets the percentage of backends that must be usable. Below
+// it the pool panics and spreads traffic over every backend whatever its health,
+// rather than pile it all onto the last survivors. Zero disables panic mode.
+func (p *Pool) SetPanicThreshold(percent float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.panicThreshold = percent
+}
+
+// Panicking reports whether the pool is currently in panic mode.
+func (p *Pool) Panicking() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.panicked(time.Now())
+}
+
+// panicked is Panicking for a caller holding p.mu.
+func (p *Pool) panicked(now time.Time) bool {
+	usable, total := 0, 0
+	for _, b := range p.backends {
+		if b.Weight <= 0 || b.Draining || b.Maintenance {
+			continue
+		}
+		total++
+		if p.usable(b, now) {
+			usable++
+		}
+	}
+	return p.panicking(usable, total)
+}
+
+// candidates returns the available backends that aren't in exclude.
+func (p *Pool) candidates(exclude map[string]bool) []candidate {
+	all := p.available()
+	if len(exclude) == 0 {
+		return all
+	}
+	out := all[:0]
+	for _, c := range all {
+		if !exclude[c.Address] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// pinned wraps a policy's pick so that it picks info.Prefer first, if that is
+// available and not excluded, and reports the picks to info.Explain, if set.
+func (p *Pool) pinned(info ConnInfo, pick pickFunc) pickFunc {
+	if info.Prefer == "" {
+		if info.Explain != nil {
+			return explained(info.Explain, pick)
+		}
+		return pick
+	}
+	return func(ctx context.Context, exclude map[string]bool) (string, error) {
+		explain := info.Explain
+		if !exclude[info.Prefer] {
+			others := make(map[string]bool)
+			for _, b := range p.Backends() {
+				if b.Address != info.Prefer {
+					others[b.Address] = true
+				}
+			}
+			pinnedPick := pick
+			if explain != nil {
+				pinnedPick = explained(func(d Decision) {
+					d.Reason, d.Excluded = "the client is pinned to it, e.g. by a sticky session", nil
+					explain(d)
+				}, pick)
+			}
+			if server, err := pinnedPick(ctx, others); err == nil {
+				return server, nil
+			}
+			if explain != nil {
+				explain = func(d Decision) {
+					d.Reason = "the client is pinned to " + info.Prefer + ", which is unavailable; " + d.Reason
+					info.Explain(d)
+				}
+			}
+		}
+		if explain != nil {
+			pick = explained(explain, pick)
+		}
+		return pick(ctx, exclude)
+	}
+}
+
+// weight returns b's weight, scaled down while b is inside the slow-start window.
+func (p *Pool) weight(b *Backend, now time.Time) float64 {
+	w := float64(b.Weight)
+	if p.slowStart <= 0 || b.recovered.IsZero() {
+		return w
+	}
+	elapsed := now.Sub(b.recovered)
+	if elapsed >= p.slowStart {
+		return w
+	}
+	return w * max(float64(elapsed)/float64(p.slowStart), minSlowStartFactor)
+}
@@ -0,0 +1,282 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSetHealthy(t *testing.T) {
+	policies := map[string]load_balancer.Policy{
+		"N2One":             load_balancer.NewN2One(servers),
+		"RoundRobin":        load_balancer.NewRoundRobin(servers),
+		"LeastConnections":  load_balancer.NewLeastConnections(servers),
+		"LeastResponseTime": load_balancer.NewLeastResponseTime(servers),
+	}
+	for name, p := range policies {
+		if !p.SetHealthy("localhost:5000", false) {
+			t.Errorf("%s: SetHealthy(false) reported no change", name)
+		}
+		if p.SetHealthy("localhost:5000", false) {
+			t.Errorf("%s: repeated SetHealthy(false) reported a change", name)
+		}
+		for range 8 {
+			if s := selectServer(t, p); s == "localhost:5000" {
+				t.Errorf("%s: selected unhealthy backend", name)
+			}
+		}
+	}
+}
+
+func TestSetHealthyRecovers(t *testing.T) {
+	p := load_balancer.NewN2One(servers)
+	p.SetHealthy("localhost:5000", false)
+	if s := selectServer(t, p); s != "localhost:5001" {
+		t.Errorf("got %s, want localhost:5001", s)
+	}
+	p.SetHealthy("localhost:5000", true)
+	if s := selectServer(t, p); s != "localhost:5000" {
+		t.Errorf("got %s, want localhost:5000", s)
+	}
+}
+
+func TestAllUnhealthy(t *testing.T) {
+	p := load_balancer.NewRoundRobin(servers)
+	for _, b := range servers {
+		p.SetHealthy(b.Address, false)
+	}
+	if _, err := p.SelectServer(context.Background(), load_balancer.ConnInfo{}); !errors.Is(err, load_balancer.ErrNoBackend) {
+		t.Errorf("got %v, want ErrNoBackend", err)
+	}
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	p := load_balancer.NewRoundRobin([]load_balancer.Backend{
+		{Address: "localhost:5000", Weight: 3},
+		{Address: "localhost:5001", Weight: 1},
+	})
+
+	var res []string
+	for range 8 {
+		res = append(res, selectServer(t, p))
+	}
+
+	expected := []string{
+		"localhost:5000", "localhost:5000", "localhost:5001", "localhost:5000",
+		"localhost:5000", "localhost:5000", "localhost:5001", "localhost:5000",
+	}
+	if !equal(res, expected) {
+		t.Errorf("got %v, want %v", res, expected)
+	}
+}
+
+func TestWeightedLeastConnections(t *testing.T) {
+	p := load_balancer.NewLeastConnections([]load_balancer.Backend{
+		{Address: "localhost:5000", Weight: 2},
+		{Address: "localhost:5001", Weight: 1},
+	})
+
+	var res []string
+	for range 6 {
+		res = append(res, selectServer(t, p))
+	}
+
+	expected := []string{
+		"localhost:5000", "localhost:5001", "localhost:5000",
+		"localhost:5000", "localhost:5001", "localhost:5000",
+	}
+	if !equal(res, expected) {
+		t.Errorf("got %v, want %v", res, expected)
+	}
+}
+
+func TestSetWeight(t *testing.T) {
+	p := load_balancer.NewRoundRobin(servers[:2])
+	if err := p.SetWeight("localhost:5000", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	var res []string
+	for range 4 {
+		res = append(res, selectServer(t, p))
+	}
+	expected := []string{"localhost:5000", "localhost:5000", "localhost:5001", "localhost:5000"}
+	if !equal(res, expected) {
+		t.Errorf("got %v, want %v", res, expected)
+	}
+
+	// weight 0 takes the backend out of rotation
+	if err := p.SetWeight("localhost:5000", 0); err != nil {
+		t.Fatal(err)
+	}
+	for range 4 {
+		if s := selectServer(t, p); s != "localhost:5001" {
+			t.Errorf("got %s, want localhost:5001", s)
+		}
+	}
+
+	if err := p.SetWeight("localhost:6000", 1); !errors.Is(err, load_balancer.ErrUnknownBackend) {
+		t.Errorf("got %v, want ErrUnknownBackend", err)
+	}
+	if err := p.SetWeight("localhost:5000", -1); err == nil {
+		t.Error("negative weight accepted")
+	}
+}
+
+func TestSlowStart(t *testing.T) {
+	p := load_balancer.NewRoundRobin(servers[:2])
+	p.SetSlowStart(time.Hour)
+	p.SetHealthy("localhost:5000", false)
+	p.SetHealthy("localhost:5000", true)
+
+	// just recovered: localhost:5000 runs at a tenth of its weight
+	count := 0
+	for range 11 {
+		if selectServer(t, p) == "localhost:5000" {
+			count++
+		}
+	}
+	if count < 1 || count > 2 {
+		t.Errorf("recovering backend got %d of 11 selections, want 1-2", count)
+	}
+
+	// added backends ramp up too, again once they pass their first check
+	a := load_balancer.NewRoundRobin(servers[:1])
+	a.SetSlowStart(time.Hour)
+	if err := a.AddServer(load_balancer.Backend{Address: "localhost:5001", Weight: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for _, check := range []bool{false, true} {
+		if check {
+			a.SetHealthy("localhost:5001", true)
+		}
+		count := 0
+		for range 11 {
+			if selectServer(t, a) == "localhost:5001" {
+				count++
+			}
+		}
+		if count < 1 || count > 2 {
+			t.Errorf("added backend got %d of 11 selections, want 1-2", count)
+		}
+	}
+
+	// backends that never went down are not ramped
+	q := load_balancer.NewRoundRobin(servers[:2])
+	q.SetSlowStart(time.Hour)
+	var res []string
+	for range 4 {
+		res = append(res, selectServer(t, q))
+	}
+	expected := []string{"localhost:5000", "localhost:5001", "localhost:5000", "localhost:5001"}
+	if !equal(res, expected) {
+		t.Errorf("got %v, want %v", res, expected)
+	}
+}
+
+func TestPanicThreshold(t *testing.T) {
+	p := load_balancer.NewRoundRobin(servers)
+	p.SetPanicThreshold(50)
+	p.SetHealthy("localhost:5000", false)
+	p.SetHealthy("localhost:5001", false)
+	if p.Panicking() {
+		t.Fatal("panicking with half the backends usable")
+	}
+	for range 4 {
+		if s := selectServer(t, p); s == "localhost:5000" || s == "localhost:5001" {
+			t.Fatalf("selected unhealthy %s outside panic mode", s)
+		}
+	}
+
+	// with one survivor out of four, traffic goes everywhere again
+	p.SetHealthy("localhost:5002", false)
+	if !p.Panicking() || !p.Stats().Panic {
+		t.Fatal("not panicking with a quarter of the backends usable")
+	}
+	seen := make(map[string]bool)
+	for range 4 {
+		seen[selectServer(t, p)] = true
+	}
+	if len(seen) != 4 {
+		t.Errorf("panic mode selected %v, want all four backends", seen)
+	}
+}
+
+func TestSetDraining(t *testing.T) {
+	p := load_balancer.NewLeastConnections(servers[:2])
+	open := selectServer(t, p)
+	if err := p.SetDraining(open, true); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if s := selectServer(t, p); s == open {
+			t.Fatalf("draining %s got a new connection", open)
+		}
+	}
+	// the open connection still finishes normally
+	p.Update(open, load_balancer.Result{})
+	for _, b := range p.Stats().Backends {
+		if b.Address == open && (!b.Draining || b.Active != 0) {
+			t.Errorf("got %+v, want draining with no active connections", b)
+		}
+	}
+
+	if err := p.SetDraining(open, false); err != nil {
+		t.Fatal(err)
+	}
+	if s := selectServer(t, p); s != open {
+		t.Errorf("got %s after resuming, want the idle %s", s, open)
+	}
+	if err := p.SetDraining("localhost:9999", true); !errors.Is(err, load_balancer.ErrUnknownBackend) {
+		t.Errorf("got %v, want ErrUnknownBackend", err)
+	}
+}
+
+func TestSetMaintenance(t *testing.T) {
+	p := load_balancer.NewRoundRobin(servers[:2])
+	p.SetPanicThreshold(100)
+	p.SetHealthy(servers[1].Address, false)
+	if err := p.SetMaintenance(servers[0].Address, true); err != nil {
+		t.Fatal(err)
+	}
+	// not even panic mode sends traffic to a backend in maintenance
+	for range 4 {
+		if s := selectServer(t, p); s == servers[0].Address {
+			t.Fatalf("%s in maintenance got a new connection", s)
+		}
+	}
+	if st := p.Stats().Backends[0]; !st.Maintenance {
+		t.Errorf("got %+v, want maintenance", st)
+	}
+
+	if err := p.SetMaintenance(servers[0].Address, false); err != nil {
+		t.Fatal(err)
+	}
+	if s := selectServer(t, p); s != servers[0].Address {
+		t.Errorf("got %s after maintenance, want the healthy %s", s, servers[0].Address)
+	}
+	if err := p.SetMaintenance("localhost:9999", true); !errors.Is(err, load_balancer.ErrUnknownBackend) {
+		t.Errorf("got %v, want ErrUnknownBackend", err)
+	}
+}
+
+func TestCanonicalAddress(t *testing.T) {
+	for addr, want := range map[string]string{
+		"localhost:5000":           "localhost:5000",
+		"10.0.0.1:80":              "10.0.0.1:80",
+		"[::1]:80":                 "[::1]:80",
+		"[2001:db8:0:0::0001]:443": "[2001:db8::1]:443",
+		"[::ffff:10.0.0.1]:80":     "10.0.0.1:80",
+		"[fe80::1%eth0]:80":        "[fe80::1%eth0]:80",
+		"unix:///var/run/app.sock": "unix:///var/run/app.sock",
+	} {
+		if got := load_balancer.CanonicalAddress(addr); got != want {
+			t.Errorf("%s: got %s, want %s", addr, got, want)
+		}
+	}
+	if b := load_balancer.NewBackends([]string{"[2001:DB8::1]:443"}); b[0].Address != "[2001:db8::1]:443" {
+		t.Errorf("NewBackends kept %s", b[0].Address)
+	}
+}
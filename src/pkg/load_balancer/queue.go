@@ -0,0 +1,232 @@
+package load_balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQueueFull is returned by SelectServer when every backend is at its
+// connection cap and the queue waiting for a slot is full too.
+var ErrQueueFull = errors.New("all backends at connection capacity and the queue is full")
+
+// errQueueClosed fails the selections waiting in the queue of a policy
+// switched away from; Switchable selects again through the new one.
+var errQueueClosed = errors.New("queue closed, policy switched")
+
+// Queue tunes the queue selections wait in while every backend is at its
+// connection cap, instead of failing with ErrNoCapacity at once. The zero value
+// queues nothing.
+type Queue struct {
+	Depth   int           // selections that may wait at once, 0 for no queue
+	Timeout time.Duration // longest wait before failing with ErrNoCapacity, 0 for as long as the context allows
+}
+
+// QueueStats describe a queue and the waits in it.
+type QueueStats struct {
+	Waiting  int     `json:"waiting"`  // selections waiting now
+	Depth    int     `json:"depth"`    // most that may wait
+	Queued   uint64  `json:"queued"`   // times a selection joined the queue
+	Rejected uint64  `json:"rejected"` // selections failed because the queue was full
+	TimedOut uint64  `json:"timed_out"`
+	AvgWait  float64 `json:"avg_wait"` // seconds, over the selections that waited
+	MaxWait  float64 `json:"max_wait"` // seconds
+}
+
+// waitQueue lines selections up for connection slots, first come first served:
+// each freed slot wakes the selection that has waited longest. A nil *waitQueue
+// never waits.
+type waitQueue struct {
+	Queue
+	mu      sync.Mutex
+	waiters []chan struct{}
+	woken   int  // waiters signaled that haven't selected yet
+	closed  bool // see close
+
+	queued, rejected, timedOut, ended atomic.Uint64
+	waited, maxWait                   atomic.Int64 // nanoseconds
+}
+
+func newWaitQueue(q Queue) *waitQueue {
+	if q.Depth <= 0 {
+		return nil
+	}
+	return &waitQueue{Queue: q}
+}
+
+// queued runs sel, and while it fails with ErrNoCapacity waits for a slot to
+// free up and runs it again. Selections arriving while others wait join the
+// queue before trying, so they don't take the slots freed for those.
+func queued[T any](ctx context.Context, q *waitQueue, sel func() (T, error)) (T, error) {
+	if q == nil {
+		return sel()
+	}
+	start := time.Now()
+	waited := false
+	defer func() {
+		if waited {
+			q.waitEnded(start)
+		}
+	}()
+	var v T
+	var err error
+	if !q.busy() {
+		if v, err = sel(); !errors.Is(err, ErrNoCapacity) {
+			return v, err
+		}
+	}
+	for {
+		err := q.wait(ctx, start)
+		waited = waited || !errors.Is(err, ErrQueueFull)
+		if err != nil {
+			return v, err
+		}
+		v, err = sel()
+		q.selected()
+		if !errors.Is(err, ErrNoCapacity) {
+			return v, err
+		}
+	}
+}
+
+// busy reports whether selections are waiting, or woken and about to take
+// the slot they were woken for.
+func (q *waitQueue) busy() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiters)+q.woken > 0
+}
+
+// selected records that a woken selection ran.
+func (q *waitQueue) selected() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.woken--
+}
+
+// wait blocks until a slot frees up, failing once the queue is full or closed,
+// Timeout has passed since start or ctx is done.
+func (q *waitQueue) wait(ctx context.Context, start time.Time) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return errQueueClosed
+	}
+	if len(q.waiters) >= q.Depth {
+		q.mu.Unlock()
+		q.rejected.Add(1)
+		return ErrQueueFull
+	}
+	ready := make(chan struct{}, 1)
+	q.waiters = append(q.waiters, ready)
+	q.mu.Unlock()
+	q.queued.Add(1)
+
+	var expired <-chan time.Time
+	if q.Timeout > 0 {
+		t := time.NewTimer(q.Timeout - time.Since(start))
+		defer t.Stop()
+		expired = t.C
+	}
+	var err error
+	select {
+	case <-ready:
+		if q.isClosed() {
+			return errQueueClosed
+		}
+		return nil
+	case <-expired:
+		q.timedOut.Add(1)
+		err = fmt.Errorf("%w: waited %v in the queue", ErrNoCapacity, q.Timeout)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	q.mu.Lock()
+	for i, w := range q.waiters {
+		if w == ready {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			break
+		}
+	}
+	q.mu.Unlock()
+	select {
+	case <-ready:
+		q.selected()
+		q.signal() // woken on the way out, pass the slot on
+	default:
+	}
+	return err
+}
+
+// signal wakes the selection that has waited longest, after a slot freed up.
+func (q *waitQueue) signal() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiters) == 0 {
+		return
+	}
+	q.waiters[0] <- struct{}{}
+	q.waiters = q.waiters[1:]
+	q.woken++
+}
+
+// close fails the selections waiting, and any that would wait, with
+// errQueueClosed: their policy was switched away from and frees no more slots.
+func (q *waitQueue) close() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	for _, w := range q.waiters {
+		w <- struct{}{}
+	}
+	q.waiters = nil
+}
+
+func (q *waitQueue) isClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+// waitEnded records how long a selection that started at start waited.
+func (q *waitQueue) waitEnded(start time.Time) {
+	d := int64(time.Since(start))
+	q.ended.Add(1)
+	q.waited.Add(d)
+	for {
+		m := q.maxWait.Load()
+		if d <= m || q.maxWait.CompareAndSwap(m, d) {
+			return
+		}
+	}
+}
+
+func (q *waitQueue) stats() *QueueStats {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	waiting := len(q.waiters)
+	q.mu.Unlock()
+	st := &QueueStats{
+		Waiting:  waiting,
+		Depth:    q.Depth,
+		Queued:   q.queued.Load(),
+		Rejected: q.rejected.Load(),
+		TimedOut: q.timedOut.Load(),
+		MaxWait:  time.Duration(q.maxWait.Load()).Seconds(),
+	}
+	if n := q.ended.Load(); n > 0 {
+		st.AvgWait = time.Duration(q.waited.Load() / int64(n)).Seconds()
+	}
+	return st
+}
@@ -0,0 +1,100 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func queuedPolicy(t *testing.T, q load_balancer.Queue) load_balancer.Policy {
+	t.Helper()
+	p, err := load_balancer.NewPolicy("LeastConnections", load_balancer.NewPool(servers[:1]), load_balancer.Options{MaxConnsPerBackend: 1, Queue: q})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestQueueWaitsForSlot(t *testing.T) {
+	p := queuedPolicy(t, load_balancer.Queue{Depth: 2, Timeout: time.Second})
+	s := selectServer(t, p)
+
+	got := make(chan string)
+	go func() { got <- selectServer(t, p) }()
+	time.Sleep(20 * time.Millisecond)
+	if st := p.Stats().Queue; st == nil || st.Waiting != 1 {
+		t.Fatalf("queue stats %+v, want 1 waiting", st)
+	}
+	select {
+	case s := <-got:
+		t.Fatalf("selected %s while the backend was full", s)
+	default:
+	}
+
+	p.Update(s, load_balancer.Result{})
+	select {
+	case s := <-got:
+		if s != "localhost:5000" {
+			t.Errorf("got %s, want localhost:5000", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("still waiting after a slot freed up")
+	}
+	if st := p.Stats().Queue; st.Waiting != 0 || st.Queued != 1 || st.MaxWait < 0.01 {
+		t.Errorf("queue stats %+v, want 1 queued for at least 10ms", st)
+	}
+}
+
+func TestQueueFirstComeFirstServed(t *testing.T) {
+	p := queuedPolicy(t, load_balancer.Queue{Depth: 3, Timeout: time.Second})
+	s := selectServer(t, p)
+
+	order := make(chan int, 3)
+	for i := range 3 {
+		go func() {
+			selectServer(t, p)
+			order <- i
+		}()
+		time.Sleep(10 * time.Millisecond) // line them up in order
+	}
+	for want := range 3 {
+		p.Update(s, load_balancer.Result{})
+		if got := <-order; got != want {
+			t.Errorf("selection %d got the slot, want %d", got, want)
+		}
+	}
+}
+
+func TestQueueFullAndTimeout(t *testing.T) {
+	p := queuedPolicy(t, load_balancer.Queue{Depth: 1, Timeout: 30 * time.Millisecond})
+	selectServer(t, p)
+
+	errs := make(chan error)
+	go func() {
+		_, err := p.SelectServer(context.Background(), load_balancer.ConnInfo{})
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if _, err := p.SelectServer(context.Background(), load_balancer.ConnInfo{}); !errors.Is(err, load_balancer.ErrQueueFull) {
+		t.Errorf("got %v, want ErrQueueFull", err)
+	}
+	if err := <-errs; !errors.Is(err, load_balancer.ErrNoCapacity) {
+		t.Errorf("got %v, want ErrNoCapacity after the timeout", err)
+	}
+	if st := p.Stats().Queue; st.Rejected != 1 || st.TimedOut != 1 || st.Waiting != 0 {
+		t.Errorf("queue stats %+v, want 1 rejected and 1 timed out", st)
+	}
+}
+
+func TestQueueContextCanceled(t *testing.T) {
+	p := queuedPolicy(t, load_balancer.Queue{Depth: 1})
+	selectServer(t, p)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.SelectServer(ctx, load_balancer.ConnInfo{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the context's error", err)
+	}
+}
@@ -0,0 +1,131 @@
+package load_balancer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ReportTTL is how long a load report stays valid. A backend whose last report is
+// older counts as unloaded, so a crashed agent doesn't pin stale numbers.
+const ReportTTL = 10 * time.Second
+
+// LoadReport is what a backend agent sends about itself.
+type LoadReport struct {
+	Server     string  `json:"server"`      // backend address as the balancer knows it
+	QueueDepth int     `json:"queue_depth"` // requests waiting or in progress
+	CPU        float64 `json:"cpu"`         // utilisation, 0 to 1
+}
+
+type timedReport struct {
+	LoadReport
+	at time.Time
+}
+
+// Report records a load report for one of the pool's backends.
+func (p *Pool) Report(r LoadReport) error {
+	if r.QueueDepth < 0 || r.CPU < 0 || r.CPU > 1 {
+		return fmt.Errorf("invalid load report for %s: queue_depth %d, cpu %v", r.Server, r.QueueDepth, r.CPU)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.stats[r.Server]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownBackend, r.Server)
+	}
+	if p.reports == nil {
+		p.reports = make(map[string]timedReport)
+	}
+	p.reports[r.Server] = timedReport{LoadReport: r, at: time.Now()}
+	return nil
+}
+
+// report returns the last valid load report for server.
+func (p *Pool) report(server string, now time.Time) (LoadReport, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	r, ok := p.reports[server]
+	if !ok || now.Sub(r.at) > ReportTTL {
+		return LoadReport{}, false
+	}
+	return r.LoadReport, true
+}
+
+// ReportedLoad: smooth weighted round robin where each backend's weight is scaled
+// down by the load its agent last reported, so busy backends get fewer connections
+// no matter how many the balancer itself sees.
+type ReportedLoad struct {
+	*Pool
+	current map[string]float64
+	mu      sync.Mutex
+}
+
+func NewReportedLoad(backends []Backend) *ReportedLoad { return newReportedLoad(NewPool(backends)) }
+
+func newReportedLoad(pool *Pool) *ReportedLoad {
+	return &ReportedLoad{Pool: pool, current: make(map[string]float64)}
+}
+
+// minCPUShare keeps a fully busy backend from dropping to zero weight.
+const minCPUShare = 0.05
+
+func (p *ReportedLoad) SelectServer(ctx context.Context, info ConnInfo) (string, error) {
+	return p.pinned(info, p.pick)(ctx, nil)
+}
+
+func (p *ReportedLoad) SelectServers(ctx context.Context, info ConnInfo, n int) ([]string, error) {
+	return selectN(ctx, n, p.pinned(info, p.pick), p.Release)
+}
+
+func (p *ReportedLoad) pick(ctx context.Context, exclude map[string]bool) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	candidates := p.candidates(exclude)
+	if err := checkSelect(ctx, len(candidates)); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	var notes []string // of each candidate, if explaining
+	if explainer(ctx) != nil {
+		notes = make([]string, len(candidates))
+	}
+	for i, c := range candidates {
+		if r, ok := p.report(c.Address, now); ok {
+			candidates[i].weight = c.weight / float64(1+r.QueueDepth) * max(1-r.CPU, minCPUShare)
+			if notes != nil {
+				notes[i] = fmt.Sprintf("reported queue depth %d, cpu %.0f%%", r.QueueDepth, r.CPU*100)
+			}
+		}
+	}
+	credits := smoothCredits(ctx, p.current, candidates)
+	selected := smoothPick(p.current, candidates)
+	p.selected(selected)
+	p.explain(ctx, Decision{Policy: "ReportedLoad", Chosen: selected, Reason: smoothReason, Scoring: smoothScoring + ", weights lowered by the load reports"},
+		exclude, candidates, func(i int) float64 { return credits[i] }, func(i int) string { return notes[i] })
+	return selected, nil
+}
+
+func (p *ReportedLoad) Update(server string, result Result) { p.finished(server, result) }
+
+func (p *ReportedLoad) Release(server string) { p.released(server) }
+
+func (p *ReportedLoad) RemoveServer(server string) error {
+	if err := p.Pool.RemoveServer(server); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.current, server)
+	return nil
+}
+
+func (p *ReportedLoad) Stats() PolicyStats {
+	st := p.snapshot("ReportedLoad")
+	now := time.Now()
+	for i := range st.Backends {
+		if r, ok := p.report(st.Backends[i].Address, now); ok {
+			st.Backends[i].QueueDepth, st.Backends[i].CPU = r.QueueDepth, r.CPU
+		}
+	}
+	return st
+}
@@ -0,0 +1,51 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"errors"
+	"testing"
+)
+
+func TestReportedLoad(t *testing.T) {
+	p := load_balancer.NewReportedLoad(servers[:2])
+
+	// no reports yet: plain round robin
+	var res []string
+	for range 4 {
+		res = append(res, selectServer(t, p))
+	}
+	expected := []string{"localhost:5000", "localhost:5001", "localhost:5000", "localhost:5001"}
+	if !equal(res, expected) {
+		t.Errorf("got %v, want %v", res, expected)
+	}
+
+	// localhost:5000 has 3 queued requests: a quarter of the weight of localhost:5001
+	if err := p.Report(load_balancer.LoadReport{Server: "localhost:5000", QueueDepth: 3}); err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for range 10 {
+		if selectServer(t, p) == "localhost:5000" {
+			count++
+		}
+	}
+	if count != 2 {
+		t.Errorf("loaded backend got %d of 10 selections, want 2", count)
+	}
+	if st := p.Stats().Backends[0]; st.QueueDepth != 3 {
+		t.Errorf("stats queue depth %d, want 3", st.QueueDepth)
+	}
+}
+
+func TestReportValidation(t *testing.T) {
+	p := load_balancer.NewReportedLoad(servers)
+	if err := p.Report(load_balancer.LoadReport{Server: "localhost:6000"}); !errors.Is(err, load_balancer.ErrUnknownBackend) {
+		t.Errorf("got %v, want ErrUnknownBackend", err)
+	}
+	if err := p.Report(load_balancer.LoadReport{Server: "localhost:5000", CPU: 1.5}); err == nil {
+		t.Error("cpu above 1 accepted")
+	}
+	if err := p.Report(load_balancer.LoadReport{Server: "localhost:5000", QueueDepth: -1}); err == nil {
+		t.Error("negative queue depth accepted")
+	}
+}
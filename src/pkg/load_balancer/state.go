@@ -0,0 +1,87 @@
+package load_balancer
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// State is the part of a policy worth keeping across restarts, so a restarted
+// balancer doesn't fall back to cold-start behaviour.
+type State struct {
+	Policy   string                  `json:"policy"`
+	SavedAt  time.Time               `json:"saved_at"`
+	Backends map[string]BackendState `json:"backends"`
+}
+
+// BackendState is the learned state of one backend.
+type BackendState struct {
+	Weight    int     `json:"weight"`
+	Latency   float64 `json:"latency,omitempty"` // seconds
+	ErrorRate float64 `json:"error_rate,omitempty"`
+}
+
+// restorer is implemented by policies that keep learned per-backend state.
+type restorer interface {
+	restore(server string, st BackendState)
+}
+
+// SaveState writes the state of policy to path as JSON. The file is replaced
+// atomically so a crash mid-write never leaves a truncated state behind.
+func SaveState(policy Policy, path string) error {
+	stats := policy.Stats()
+	st := State{Policy: stats.Policy, SavedAt: time.Now(), Backends: make(map[string]BackendState, len(stats.Backends))}
+	for _, b := range stats.Backends {
+		st.Backends[b.Address] = BackendState{Weight: b.Weight, Latency: b.AvgResponseTime, ErrorRate: b.ErrorRate}
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadState restores state saved by SaveState into policy. Backends that are no
+// longer in the pool are skipped. A missing file is not an error: there is
+// simply nothing to restore yet.
+func LoadState(policy Policy, path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st State
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	weighted, _ := policy.(interface{ SetWeight(string, int) error })
+	r, _ := policy.(restorer)
+	for server, b := range st.Backends {
+		if weighted != nil {
+			if err := weighted.SetWeight(server, b.Weight); errors.Is(err, ErrUnknownBackend) {
+				continue
+			}
+		}
+		if r != nil {
+			r.restore(server, b)
+		}
+	}
+	return nil
+}
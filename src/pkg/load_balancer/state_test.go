@@ -0,0 +1,80 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	p := load_balancer.NewAdaptive(servers[:2], load_balancer.AdaptiveCoefficients{})
+	if err := p.SetWeight("localhost:5001", 3); err != nil {
+		t.Fatal(err)
+	}
+	p.Update(selectServer(t, p), load_balancer.Result{Duration: 200 * time.Millisecond})
+	p.Update(selectServer(t, p), load_balancer.Result{Err: errors.New("reset")})
+	if err := load_balancer.SaveState(p, path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := load_balancer.NewAdaptive(servers[:2], load_balancer.AdaptiveCoefficients{})
+	if err := load_balancer.LoadState(restored, path); err != nil {
+		t.Fatal(err)
+	}
+	want, got := p.Stats().Backends, restored.Stats().Backends
+	for i := range want {
+		if got[i].Weight != want[i].Weight || got[i].AvgResponseTime != want[i].AvgResponseTime || got[i].ErrorRate != want[i].ErrorRate {
+			t.Errorf("got %+v, want %+v", got[i], want[i])
+		}
+	}
+}
+
+func TestStateAcrossPolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	p := load_balancer.NewLeastResponseTime(servers[:2])
+	latency := map[string]time.Duration{"localhost:5000": 500 * time.Millisecond, "localhost:5001": 100 * time.Millisecond}
+	for range 2 {
+		server := selectServer(t, p)
+		p.Update(server, load_balancer.Result{Duration: latency[server]})
+	}
+	if err := load_balancer.SaveState(p, path); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restarted balancer, here with one more backend, keeps what it learned
+	s, err := load_balancer.NewSwitchable("LeastResponseTime", load_balancer.NewPool(servers[:3]), load_balancer.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := load_balancer.LoadState(s, path); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Stats().Backends[1].AvgResponseTime; got != 0.1 {
+		t.Errorf("got average %v, want 0.1", got)
+	}
+}
+
+func TestLoadStateMissingFile(t *testing.T) {
+	p := load_balancer.NewRoundRobin(servers)
+	if err := load_balancer.LoadState(p, filepath.Join(t.TempDir(), "none.json")); err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestPrime(t *testing.T) {
+	latency := map[string]time.Duration{"localhost:5000": 300 * time.Millisecond, "localhost:5001": 100 * time.Millisecond, "localhost:5002": 200 * time.Millisecond}
+	for _, name := range []string{"LeastResponseTime", "Adaptive"} {
+		p, err := load_balancer.NewPolicy(name, load_balancer.NewPool(servers[:3]), load_balancer.Options{InitialLatency: latency})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := selectServer(t, p); got != "localhost:5001" {
+			t.Errorf("%s: got %s, want the fastest estimate localhost:5001", name, got)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package load_balancer
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// PolicyStats is a point-in-time view of a policy and its backends.
+type PolicyStats struct {
+	Policy   string         `json:"policy"`
+	Backends []BackendStats `json:"backends"`
+	Panic    bool           `json:"panic,omitempty"` // too few usable backends, health is ignored
+	Queue    *QueueStats    `json:"queue,omitempty"` // selections waiting for a connection slot, see Queue
+}
+
+// BackendStats are the counters kept for one backend.
+type BackendStats struct {
+	Address  string `json:"address"`
+	Weight   int    `json:"weight"`
+	Health   string `json:"health"`
+	Active   int64  `json:"active"`   // connections selected and not yet finished
+	Selected uint64 `json:"selected"` // total selections
+	Failures uint64 `json:"failures"` // finished connections that reported an error
+
+	BytesSent     uint64 `json:"bytes_sent"`     // to the backend
+	BytesReceived uint64 `json:"bytes_received"` // from the backend
+	SendErrors    uint64 `json:"send_errors"`    // connections whose copy to the backend broke off
+	RecvErrors    uint64 `json:"recv_errors"`    // connections whose copy from the backend broke off
+
+	AvgResponseTime float64 `json:"avg_response_time,omitempty"` // seconds, latency-based policies only
+	ErrorRate       float64 `json:"error_rate,omitempty"`        // recent share of failures, Adaptive only
+	QueueDepth      int     `json:"queue_depth,omitempty"`       // last load report, ReportedLoad only
+	CPU             float64 `json:"cpu,omitempty"`
+	Ejected         bool    `json:"ejected,omitempty"` // skipped after consecutive failures
+	Draining        bool    `json:"draining,omitempty"`
+	Maintenance     bool    `json:"maintenance,omitempty"`
+}
+
+// backendCounters back BackendStats. They live in the pool so they survive
+// switching policies.
+type backendCounters struct {
+	active    atomic.Int64
+	selected  atomic.Uint64
+	failures  atomic.Uint64
+	successes atomic.Uint64
+
+	bytesSent, bytesReceived atomic.Uint64
+	sendErrors, recvErrors   atomic.Uint64
+}
+
+// counters returns the counters of server, or nil if it isn't in the pool.
+func (p *Pool) counters(server string) *backendCounters {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.stats[server]
+}
+
+// selected records that a policy picked server.
+func (p *Pool) selected(server string) {
+	if c := p.counters(server); c != nil {
+		c.selected.Add(1)
+		c.active.Add(1)
+	}
+	p.trialStarted(server)
+	p.Events().OnSelect(server)
+}
+
+// finished records the end of a connection to server.
+func (p *Pool) finished(server string, result Result) {
+	c := p.counters(server)
+	if c == nil {
+		return
+	}
+	defer p.Events().OnClose(server, result)
+	if result.Failed() {
+		c.failures.Add(1)
+	} else {
+		c.successes.Add(1)
+	}
+	c.bytesSent.Add(uint64(max(result.Sent, 0)))
+	c.bytesReceived.Add(uint64(max(result.Received, 0)))
+	if result.SendFailed {
+		c.sendErrors.Add(1)
+	}
+	if result.RecvFailed {
+		c.recvErrors.Add(1)
+	}
+	p.observe(server, result)
+	for {
+		n := c.active.Load()
+		if n <= 0 || c.active.CompareAndSwap(n, n-1) {
+			return
+		}
+	}
+}
+
+// released undoes the selection of a server that was never used.
+func (p *Pool) released(server string) {
+	c := p.counters(server)
+	if c == nil {
+		return
+	}
+	c.selected.Add(^uint64(0))
+	p.trialCancelled(server)
+	for {
+		n := c.active.Load()
+		if n <= 0 || c.active.CompareAndSwap(n, n-1) {
+			return
+		}
+	}
+}
+
+// activeCounts returns the open connections per backend.
+func (p *Pool) activeCounts() map[string]int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make(map[string]int, len(p.stats))
+	for s, c := range p.stats {
+		out[s] = int(c.active.Load())
+	}
+	return out
+}
+
+// snapshot builds the pool part of a policy's stats, in pool order.
+func (p *Pool) snapshot(policy string) PolicyStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	now := time.Now()
+	st := PolicyStats{Policy: policy, Backends: make([]BackendStats, 0, len(p.backends)), Panic: p.panicked(now)}
+	for _, b := range p.backends {
+		c := p.stats[b.Address]
+		st.Backends = append(st.Backends, BackendStats{
+			Address:       b.Address,
+			Weight:        b.Weight,
+			Health:        b.Health.String(),
+			Active:        c.active.Load(),
+			Selected:      c.selected.Load(),
+			Failures:      c.failures.Load(),
+			BytesSent:     c.bytesSent.Load(),
+			BytesReceived: c.bytesReceived.Load(),
+			SendErrors:    c.sendErrors.Load(),
+			RecvErrors:    c.recvErrors.Load(),
+			Ejected:       p.ejected(b.Address, now),
+			Draining:      b.Draining,
+			Maintenance:   b.Maintenance,
+		})
+	}
+	return st
+}
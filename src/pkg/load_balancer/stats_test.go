@@ -0,0 +1,73 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStatsFairness(t *testing.T) {
+	p := load_balancer.NewRoundRobin(servers)
+	for range 400 {
+		p.Update(selectServer(t, p), load_balancer.Result{})
+	}
+
+	st := p.Stats()
+	if st.Policy != "RoundRobin" {
+		t.Errorf("got policy %s, want RoundRobin", st.Policy)
+	}
+	for _, b := range st.Backends {
+		if b.Selected != 100 || b.Active != 0 {
+			t.Errorf("%s: selected %d active %d, want 100 and 0", b.Address, b.Selected, b.Active)
+		}
+	}
+}
+
+func TestStatsCounters(t *testing.T) {
+	p := load_balancer.NewLeastConnections(servers[:2])
+	for range 3 {
+		selectServer(t, p)
+	}
+	p.Update("localhost:5000", load_balancer.Result{Err: errors.New("reset")})
+
+	expected := []load_balancer.BackendStats{
+		{Address: "localhost:5000", Weight: 1, Health: "unknown", Active: 1, Selected: 2, Failures: 1},
+		{Address: "localhost:5001", Weight: 1, Health: "unknown", Active: 1, Selected: 1},
+	}
+	st := p.Stats()
+	if len(st.Backends) != len(expected) {
+		t.Fatalf("got %d backends, want %d", len(st.Backends), len(expected))
+	}
+	for i, b := range st.Backends {
+		if b != expected[i] {
+			t.Errorf("got %+v, want %+v", b, expected[i])
+		}
+	}
+
+	// stats must serialize for the admin API
+	if _, err := json.Marshal(st); err != nil {
+		t.Errorf("marshal stats: %v", err)
+	}
+}
+
+func TestStatsBytes(t *testing.T) {
+	p := load_balancer.NewRoundRobin(servers[:1])
+	p.Update(selectServer(t, p), load_balancer.Result{Bytes: 300, Sent: 100, Received: 200})
+	p.Update(selectServer(t, p), load_balancer.Result{Err: errors.New("reset"), Bytes: 50, Sent: 50, RecvFailed: true})
+
+	b := p.Stats().Backends[0]
+	if b.BytesSent != 150 || b.BytesReceived != 200 || b.SendErrors != 0 || b.RecvErrors != 1 {
+		t.Errorf("got sent %d received %d, errors %d/%d, want 150 200, 0/1", b.BytesSent, b.BytesReceived, b.SendErrors, b.RecvErrors)
+	}
+}
+
+func TestStatsResponseTime(t *testing.T) {
+	p := load_balancer.NewLeastResponseTime(servers[:1])
+	p.Update(selectServer(t, p), load_balancer.Result{Duration: 250 * time.Millisecond})
+
+	if avg := p.Stats().Backends[0].AvgResponseTime; avg != 0.25 {
+		t.Errorf("got average %v, want 0.25", avg)
+	}
+}
@@ -0,0 +1,56 @@
+package load_balancer
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// SetSubset limits this balancer instance to size of the pool's backends, chosen
+// deterministically from instanceID so a fleet of balancers spreads evenly over a
+// large pool. Zero size turns subsetting off.
+//
+// Backends are ranked by rendezvous hashing of instanceID and address, so adding,
+// removing or losing a backend only changes the subset by that backend.
+func (p *Pool) SetSubset(instanceID string, size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.instanceID = instanceID
+	p.subsetSize = size
+}
+
+// subset keeps the size highest-ranked candidates for instanceID, in their original order.
+func subset(candidates []candidate, instanceID string, size int) []candidate {
+	if size <= 0 || len(candidates) <= size {
+		return candidates
+	}
+	ranked := make([]int, len(candidates))
+	scores := make([]uint64, len(candidates))
+	for i, c := range candidates {
+		ranked[i] = i
+		scores[i] = rendezvousScore(instanceID, c.Address)
+	}
+	sort.Slice(ranked, func(a, b int) bool { return scores[ranked[a]] > scores[ranked[b]] })
+	ranked = ranked[:size]
+	sort.Ints(ranked)
+
+	out := make([]candidate, 0, size)
+	for _, i := range ranked {
+		out = append(out, candidates[i])
+	}
+	return out
+}
+
+func rendezvousScore(instanceID, address string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(instanceID))
+	h.Write([]byte{0})
+	h.Write([]byte(address))
+	// fnv alone clusters on similar inputs, finish with a 64-bit mixer
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
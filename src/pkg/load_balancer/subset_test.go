@@ -0,0 +1,86 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func fleet(n int) []load_balancer.Backend {
+	var addrs []string
+	for i := range n {
+		addrs = append(addrs, fmt.Sprintf("10.0.0.%d:80", i))
+	}
+	return load_balancer.NewBackends(addrs)
+}
+
+// subsetOf returns the distinct backends a round robin policy cycles through.
+func subsetOf(t *testing.T, p *load_balancer.RoundRobin, n int) []string {
+	t.Helper()
+	seen := map[string]bool{}
+	for range n * 4 {
+		seen[selectServer(t, p)] = true
+	}
+	var out []string
+	for s := range seen {
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestSubsetDeterministic(t *testing.T) {
+	a := load_balancer.NewRoundRobin(fleet(20))
+	a.SetSubset("lb-1", 5)
+	b := load_balancer.NewRoundRobin(fleet(20))
+	b.SetSubset("lb-1", 5)
+
+	sa, sb := subsetOf(t, a, 20), subsetOf(t, b, 20)
+	if len(sa) != 5 {
+		t.Fatalf("subset has %d backends, want 5", len(sa))
+	}
+	if !equal(sa, sb) {
+		t.Errorf("same instance got different subsets: %v, %v", sa, sb)
+	}
+}
+
+func TestSubsetSpread(t *testing.T) {
+	counts := map[string]int{}
+	for i := range 100 {
+		p := load_balancer.NewRoundRobin(fleet(20))
+		p.SetSubset(fmt.Sprintf("lb-%d", i), 5)
+		for _, s := range subsetOf(t, p, 20) {
+			counts[s]++
+		}
+	}
+	// 100 instances x 5 backends over 20 backends is 25 each on average
+	for _, b := range fleet(20) {
+		if c := counts[b.Address]; c < 10 || c > 40 {
+			t.Errorf("%s used by %d instances, want 10-40", b.Address, c)
+		}
+	}
+}
+
+func TestSubsetReplacesUnhealthy(t *testing.T) {
+	p := load_balancer.NewRoundRobin(fleet(20))
+	p.SetSubset("lb-1", 5)
+	before := subsetOf(t, p, 20)
+
+	p.SetHealthy(before[0], false)
+	after := subsetOf(t, p, 20)
+	if len(after) != 5 {
+		t.Fatalf("subset has %d backends, want 5", len(after))
+	}
+	kept := 0
+	for _, s := range after {
+		for _, o := range before[1:] {
+			if s == o {
+				kept++
+			}
+		}
+	}
+	if kept != 4 {
+		t.Errorf("only %d of 4 healthy subset members kept: %v -> %v", kept, before, after)
+	}
+}
@@ -0,0 +1,125 @@
+package load_balancer
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Switchable is a Policy whose underlying policy can be replaced at runtime
+// without touching connections in flight. Every policy it builds shares the same
+// pool, and connection counters are carried over to policies that keep them.
+// Selections run outside its lock, as they may wait in a queue for a slot
+// that only an Update or Release frees; those waiting when the policy is
+// switched select again through the new one.
+type Switchable struct {
+	*Pool
+	opts Options
+
+	mu      sync.RWMutex // held for writing only while switching
+	name    string
+	current Policy
+}
+
+// queueCloser is implemented by policies whose selections may wait in a queue.
+type queueCloser interface {
+	closeQueue()
+}
+
+// seeder is implemented by policies that can take over live connection counts.
+type seeder interface {
+	seed(active map[string]int)
+}
+
+func NewSwitchable(name string, pool *Pool, opts Options) (*Switchable, error) {
+	policy, err := NewPolicy(name, pool, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Switchable{Pool: pool, opts: opts, name: name, current: policy}, nil
+}
+
+// Name returns the name of the active policy.
+func (p *Switchable) Name() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.name
+}
+
+// Switch replaces the active policy with the named one.
+func (p *Switchable) Switch(name string) error {
+	policy, err := NewPolicy(name, p.Pool, p.opts)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	if s, ok := policy.(seeder); ok {
+		s.seed(p.activeCounts())
+	}
+	old := p.current
+	p.name, p.current = name, policy
+	p.mu.Unlock()
+	if q, ok := old.(queueCloser); ok {
+		q.closeQueue()
+	}
+	return nil
+}
+
+// policy returns the active policy.
+func (p *Switchable) policy() Policy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.current
+}
+
+func (p *Switchable) SelectServer(ctx context.Context, info ConnInfo) (string, error) {
+	for {
+		server, err := p.policy().SelectServer(ctx, info)
+		if !errors.Is(err, errQueueClosed) {
+			return server, err
+		}
+	}
+}
+
+func (p *Switchable) SelectServers(ctx context.Context, info ConnInfo, n int) ([]string, error) {
+	for {
+		servers, err := p.policy().SelectServers(ctx, info, n)
+		if !errors.Is(err, errQueueClosed) {
+			return servers, err
+		}
+	}
+}
+
+func (p *Switchable) Update(server string, result Result) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	p.current.Update(server, result)
+}
+
+func (p *Switchable) Release(server string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	p.current.Release(server)
+}
+
+// RemoveServer removes server through the active policy, so it forgets the
+// server too. Policies switched away from are discarded and need no cleanup.
+func (p *Switchable) RemoveServer(server string) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.current.RemoveServer(server)
+}
+
+func (p *Switchable) Stats() PolicyStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.current.Stats()
+}
+
+func (p *Switchable) restore(server string, st BackendState) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if r, ok := p.current.(restorer); ok {
+		r.restore(server, st)
+	}
+}
@@ -0,0 +1,97 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"testing"
+	"time"
+)
+
+func TestSwitchable(t *testing.T) {
+	p, err := load_balancer.NewSwitchable("RoundRobin", load_balancer.NewPool(servers), load_balancer.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res []string
+	for range 3 {
+		res = append(res, selectServer(t, p))
+	}
+
+	// LeastConnections inherits the three open connections
+	if err := p.Switch("LeastConnections"); err != nil {
+		t.Fatal(err)
+	}
+	if p.Name() != "LeastConnections" {
+		t.Errorf("got policy %s, want LeastConnections", p.Name())
+	}
+	res = append(res, selectServer(t, p))
+	p.Update("localhost:5001", load_balancer.Result{})
+	res = append(res, selectServer(t, p))
+
+	expected := []string{
+		"localhost:5000", "localhost:5001", "localhost:5002",
+		"localhost:5003", "localhost:5001",
+	}
+	if !equal(res, expected) {
+		t.Errorf("got %v, want %v", res, expected)
+	}
+
+	if err := p.Switch("Random"); err == nil {
+		t.Error("switching to an unknown policy succeeded")
+	}
+	if p.Name() != "LeastConnections" {
+		t.Errorf("failed switch changed policy to %s", p.Name())
+	}
+}
+
+func TestSwitchWhileQueued(t *testing.T) {
+	opts := load_balancer.Options{MaxConnsPerBackend: 1, Queue: load_balancer.Queue{Depth: 1}}
+	p, err := load_balancer.NewSwitchable("LeastConnections", load_balancer.NewPool(servers[:1]), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := selectServer(t, p)
+
+	got := make(chan string)
+	go func() { got <- selectServer(t, p) }()
+	time.Sleep(20 * time.Millisecond)
+	if st := p.Stats().Queue; st == nil || st.Waiting != 1 {
+		t.Fatalf("queue stats %+v, want 1 waiting", st)
+	}
+
+	switched := make(chan error)
+	go func() { switched <- p.Switch("LeastConnections") }()
+	select {
+	case err := <-switched:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("switch blocked by the queued selection")
+	}
+
+	// the queued selection waits on the new policy, which kept the cap
+	select {
+	case s := <-got:
+		t.Fatalf("selected %s while the backend was full", s)
+	case <-time.After(20 * time.Millisecond):
+	}
+	released := make(chan struct{})
+	go func() {
+		p.Update(s, load_balancer.Result{})
+		close(released)
+	}()
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("update blocked")
+	}
+	select {
+	case s := <-got:
+		if s != "localhost:5000" {
+			t.Errorf("got %s, want localhost:5000", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("still waiting after a slot freed up")
+	}
+}
@@ -0,0 +1,67 @@
+package load_balancer
+
+import "time"
+
+// Transition is a backend changing state, see Events.OnTransition. States are
+// "unknown" (never checked), "healthy", "unhealthy", "ejected" (for failing,
+// see SetFailureEjection and EjectOutliers) and "maintenance".
+type Transition struct {
+	Server   string
+	From, To string
+	Reason   string // one of the Reason constants
+	Detail   string // e.g. the error of a failed probe
+	At       time.Time
+	After    time.Duration // time spent in From
+}
+
+// Reasons of a Transition.
+const (
+	ReasonProbe     = "probe"     // an active health check failed or passed
+	ReasonConnect   = "connect"   // connecting to the backend failed, or worked again
+	ReasonFailures  = "failures"  // consecutive failed connections ejected it
+	ReasonOutlier   = "outlier"   // outlier detection ejected it
+	ReasonRecovered = "recovered" // a connection to it succeeded while ejected, e.g. its trial
+	ReasonExpired   = "expired"   // its outlier ejection ended
+	ReasonAdmin     = "admin"     // an operator, through SetHealthy, SetMaintenance or SetFailureEjection
+)
+
+// status returns the state of b, as Transition names them. An outlier stays
+// ejected until the sweep that notices its ejection ended. Caller holds p.mu.
+func (p *Pool) status(b *Backend) string {
+	switch {
+	case b.Maintenance:
+		return "maintenance"
+	case b.Health == Unhealthy:
+		return "unhealthy"
+	}
+	if e := p.ejections[b.Address]; e != nil && !e.until.IsZero() {
+		return "ejected"
+	}
+	if o := p.outliers[b.Address]; o != nil && !o.until.IsZero() {
+		return "ejected"
+	}
+	return b.Health.String()
+}
+
+// moved appends to ts the transition of b to its current state, if it changed,
+// for reason at at. Caller holds p.mu, and announces ts once it released it.
+func (p *Pool) moved(ts []Transition, b *Backend, reason, detail string, at time.Time) []Transition {
+	to := p.status(b)
+	if to == b.status {
+		return ts
+	}
+	t := Transition{Server: b.Address, From: b.status, To: to, Reason: reason, Detail: detail, At: at, After: at.Sub(b.since)}
+	b.status, b.since = to, at
+	return append(ts, t)
+}
+
+// announce calls the OnTransition hook with each of ts.
+func (p *Pool) announce(ts []Transition) {
+	if len(ts) == 0 {
+		return
+	}
+	e := p.Events()
+	for _, t := range ts {
+		e.OnTransition(t)
+	}
+}
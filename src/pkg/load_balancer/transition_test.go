@@ -0,0 +1,98 @@
+package load_balancer_test
+
+import (
+	"Load-Balancer/pkg/load_balancer"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// transitions records the transitions the pool reports.
+type transitions struct {
+	load_balancer.NoEvents
+	mu  sync.Mutex
+	all []load_balancer.Transition
+}
+
+func (r *transitions) OnTransition(t load_balancer.Transition) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.all = append(r.all, t)
+}
+
+// summary returns the transitions as "server from->to reason".
+func (r *transitions) summary() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []string
+	for _, t := range r.all {
+		out = append(out, fmt.Sprintf("%s %s->%s %s", t.Server, t.From, t.To, t.Reason))
+	}
+	return out
+}
+
+func TestTransitions(t *testing.T) {
+	p := load_balancer.NewN2One(servers[:2])
+	r := &transitions{}
+	p.SetEvents(r)
+	p.SetFailureEjection(load_balancer.FailureEjection{Threshold: 2, CoolDown: time.Hour})
+	a, b := servers[0].Address, servers[1].Address
+
+	p.SetHealthyBecause(a, true, load_balancer.ReasonProbe, nil)
+	time.Sleep(10 * time.Millisecond)
+	p.SetHealthyBecause(a, false, load_balancer.ReasonProbe, errors.New("status 503"))
+	p.SetHealthyBecause(a, false, load_balancer.ReasonProbe, errors.New("status 503")) // no change
+	p.SetHealthy(a, true)
+
+	failed := load_balancer.Result{Err: errors.New("refused")}
+	for range 2 {
+		s := selectServer(t, p)
+		p.Update(s, failed)
+	}
+	p.Update(a, load_balancer.Result{}) // e.g. a connection opened before the ejection
+	p.SetMaintenance(b, true)
+	p.SetMaintenance(b, false)
+
+	want := []string{
+		a + " unknown->healthy probe",
+		a + " healthy->unhealthy probe",
+		a + " unhealthy->healthy admin",
+		a + " healthy->ejected failures",
+		a + " ejected->healthy recovered",
+		b + " unknown->maintenance admin",
+		b + " maintenance->unknown admin",
+	}
+	if got := r.summary(); !slices.Equal(got, want) {
+		t.Fatalf("transitions\n%q\nwant\n%q", got, want)
+	}
+	down := r.all[1]
+	if down.Detail != "status 503" || down.After < 10*time.Millisecond || !down.At.After(r.all[0].At) {
+		t.Errorf("transition to unhealthy %+v, want the probe's error and at least 10ms healthy", down)
+	}
+	if r.all[3].Detail != "2 consecutive failures, the last: refused" {
+		t.Errorf("ejection detail %q", r.all[3].Detail)
+	}
+}
+
+func TestOutlierTransitions(t *testing.T) {
+	p := outlierPool(t)
+	r := &transitions{}
+	p.SetEvents(r)
+	cfg := load_balancer.OutlierDetection{MinRequests: 10, BaseEjection: 20 * time.Millisecond}
+	if got := p.EjectOutliers(cfg); !slices.Equal(got, []string{"localhost:5008"}) {
+		t.Fatalf("ejected %v", got)
+	}
+	time.Sleep(30 * time.Millisecond)
+	p.EjectOutliers(cfg)
+
+	want := []string{"localhost:5008 unknown->ejected outlier", "localhost:5008 ejected->unknown expired"}
+	if got := r.summary(); !slices.Equal(got, want) {
+		t.Fatalf("transitions %q, want %q", got, want)
+	}
+	if e := r.all[1]; e.After != 20*time.Millisecond {
+		t.Errorf("ejected for %v, want the 20ms ejection", e.After)
+	}
+}
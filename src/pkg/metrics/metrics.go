@@ -0,0 +1,436 @@
+// Package metrics keeps counters, gauges and histograms, with labels, and
+// writes them in the Prometheus text exposition format, or OpenMetrics with
+// exemplars linking histogram observations to traces, so the balancer can be
+// scraped without a client library. Metrics are registered once, at startup,
+// and are safe for concurrent use.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Type is the kind of a metric.
+type Type string
+
+const (
+	CounterType   Type = "counter"
+	GaugeType     Type = "gauge"
+	HistogramType Type = "histogram"
+)
+
+// DefBuckets are the upper bounds, in seconds, of histograms of latencies
+// from a millisecond to a minute.
+var DefBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Registry holds metrics in the order they were registered.
+type Registry struct {
+	mu       sync.Mutex
+	metrics  []metric
+	names    map[string]bool
+	observer atomic.Pointer[observer] // called with every histogram observation, if set
+}
+
+// observer sees a histogram observation of the series of values.
+type observer func(d *desc, values []string, v float64)
+
+// metric is a registered family of series.
+type metric interface {
+	describe() *desc
+	// collect calls emit for each series, sorted by label values.
+	collect(emit func(values []string, s series))
+}
+
+// series is one set of label values of a metric: a float64 for counters and
+// gauges, a *Histogram for histograms.
+type series any
+
+type desc struct {
+	name, help string
+	typ        Type
+	labels     []string
+}
+
+func (d *desc) describe() *desc { return d }
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if name := m.describe().name; r.names[name] {
+		panic("metrics: " + name + " registered twice")
+	} else {
+		r.names[name] = true
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+// Counter registers a counter with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{vec[Counter]{desc: desc{name, help, CounterType, labels}}}
+	r.register(v)
+	return v
+}
+
+// Gauge registers a gauge with the given label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{vec[Gauge]{desc: desc{name, help, GaugeType, labels}}}
+	r.register(v)
+	return v
+}
+
+// Histogram registers a histogram with the given bucket upper bounds, in
+// increasing order, and label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !slices.IsSorted(buckets) {
+		panic("metrics: buckets of " + name + " aren't sorted")
+	}
+	buckets = slices.Clone(buckets)
+	v := &HistogramVec{vec[Histogram]{desc: desc{name, help, HistogramType, labels}}}
+	v.init = func(h *Histogram, values []string) {
+		h.buckets = buckets
+		h.counts = make([]uint64, len(buckets))
+		h.observed = func(x float64) {
+			if f := r.observer.Load(); f != nil {
+				(*f)(&v.desc, values, x)
+			}
+		}
+	}
+	r.register(v)
+	return v
+}
+
+// Func registers a counter or gauge whose values are read when the registry
+// is written: collect calls emit with the value of each series and its label
+// values. Use it for values kept elsewhere, such as policy stats.
+func (r *Registry) Func(name, help string, typ Type, labels []string, collect func(emit func(value float64, values ...string))) {
+	r.register(&funcMetric{desc{name, help, typ, labels}, collect})
+}
+
+// WriteText writes every metric in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	return r.write(w, false)
+}
+
+// WriteOpenMetrics writes every metric in the OpenMetrics text format, with
+// the exemplars of histogram buckets. Counter families are named without their
+// _total suffix, as the format asks.
+func (r *Registry) WriteOpenMetrics(w io.Writer) error {
+	return r.write(w, true)
+}
+
+func (r *Registry) write(w io.Writer, openMetrics bool) error {
+	r.mu.Lock()
+	ms := slices.Clone(r.metrics)
+	r.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, m := range ms {
+		d := m.describe()
+		family, help := d.name, escapeHelp(d.help)
+		if openMetrics {
+			help = escapeLabel(d.help)
+			if d.typ == CounterType {
+				family = strings.TrimSuffix(d.name, "_total")
+			}
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", family, help, family, d.typ)
+		m.collect(func(values []string, s series) {
+			switch s := s.(type) {
+			case float64:
+				name := d.name
+				if openMetrics && d.typ == CounterType {
+					name = family + "_total"
+				}
+				writeSample(bw, name, d.labels, values, "", "", s, nil)
+			case *Histogram:
+				counts, count, sum, exemplars := s.snapshot()
+				if !openMetrics {
+					exemplars = nil
+				}
+				for i, le := range s.buckets {
+					writeSample(bw, d.name+"_bucket", d.labels, values, "le", formatFloat(le), float64(counts[i]), exemplarOf(exemplars, i))
+				}
+				writeSample(bw, d.name+"_bucket", d.labels, values, "le", "+Inf", float64(count), exemplarOf(exemplars, len(s.buckets)))
+				writeSample(bw, d.name+"_sum", d.labels, values, "", "", sum, nil)
+				writeSample(bw, d.name+"_count", d.labels, values, "", "", float64(count), nil)
+			}
+		})
+	}
+	if openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry, e.g. on /metrics: in OpenMetrics to scrapers
+// that accept it, such as Prometheus, in the Prometheus text format otherwise.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text") {
+			w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+			r.WriteOpenMetrics(w)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// writeSample writes a sample, followed by its exemplar if not nil.
+func writeSample(w *bufio.Writer, name string, labels, values []string, extra, extraValue string, v float64, ex *exemplar) {
+	w.WriteString(name)
+	if len(labels) > 0 || extra != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(l + `="` + escapeLabel(values[i]) + `"`)
+		}
+		if extra != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extra + `="` + extraValue + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	if ex != nil {
+		fmt.Fprintf(w, ` # {trace_id="%s"} %s %.3f`, escapeLabel(ex.traceID), formatFloat(ex.value), float64(ex.at.UnixMilli())/1000)
+	}
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// vec holds the series of a metric by label values.
+type vec[T any] struct {
+	desc
+	mu       sync.RWMutex
+	children map[string]*T
+	values   map[string][]string
+	init     func(s *T, values []string) // readies a new series, if set
+}
+
+// with returns the series of values, creating it on first use.
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	s := v.children[key]
+	v.mu.RUnlock()
+	if s != nil {
+		return s
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s = v.children[key]; s == nil {
+		if v.children == nil {
+			v.children, v.values = make(map[string]*T), make(map[string][]string)
+		}
+		s = new(T)
+		values = slices.Clone(values)
+		if v.init != nil {
+			v.init(s, values)
+		}
+		v.children[key], v.values[key] = s, values
+	}
+	return s
+}
+
+// each calls f for each series, sorted by label values.
+func (v *vec[T]) each(f func(values []string, s *T)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	children, values := v.children, v.values
+	v.mu.RUnlock()
+	slices.Sort(keys)
+	for _, k := range keys {
+		v.mu.RLock()
+		s, vals := children[k], values[k]
+		v.mu.RUnlock()
+		f(vals, s)
+	}
+}
+
+// Counter is a value that only goes up.
+type Counter struct{ bits atomic.Uint64 }
+
+// Add adds v, which must not be negative.
+func (c *Counter) Add(v float64) { addFloat(&c.bits, v) }
+func (c *Counter) Inc()          { c.Add(1) }
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// CounterVec is a counter with labels.
+type CounterVec struct{ vec[Counter] }
+
+// With returns the counter of the label values, in the order the labels were
+// registered.
+func (v *CounterVec) With(values ...string) *Counter { return v.with(values) }
+
+func (v *CounterVec) collect(emit func([]string, series)) {
+	v.each(func(values []string, c *Counter) { emit(values, c.Value()) })
+}
+
+// Gauge is a value that goes up and down.
+type Gauge struct{ bits atomic.Uint64 }
+
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+func (g *Gauge) Add(v float64) { addFloat(&g.bits, v) }
+func (g *Gauge) Inc()          { g.Add(1) }
+func (g *Gauge) Dec()          { g.Add(-1) }
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+// GaugeVec is a gauge with labels.
+type GaugeVec struct{ vec[Gauge] }
+
+// With returns the gauge of the label values, in the order the labels were
+// registered.
+func (v *GaugeVec) With(values ...string) *Gauge { return v.with(values) }
+
+func (v *GaugeVec) collect(emit func([]string, series)) {
+	v.each(func(values []string, g *Gauge) { emit(values, g.Value()) })
+}
+
+// Histogram counts observations in buckets by upper bound.
+type Histogram struct {
+	buckets   []float64
+	observed  func(v float64) // passes observations on to the registry's observer
+	mu        sync.Mutex
+	counts    []uint64 // per bucket, summed up when written
+	count     uint64
+	sum       float64
+	exemplars []exemplar // per bucket and +Inf, nil until the first
+}
+
+// exemplar is the last observation in a bucket that was part of a trace.
+type exemplar struct {
+	traceID string
+	value   float64
+	at      time.Time
+}
+
+// Observe records v.
+func (h *Histogram) Observe(v float64) { h.ObserveWithExemplar(v, "") }
+
+// ObserveWithExemplar records v, observed in the trace traceID, which becomes
+// the exemplar of v's bucket in OpenMetrics until a later one replaces it.
+// With an empty traceID it is Observe.
+func (h *Histogram) ObserveWithExemplar(v float64, traceID string) {
+	i, _ := slices.BinarySearch(h.buckets, v)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	if traceID != "" {
+		if h.exemplars == nil {
+			h.exemplars = make([]exemplar, len(h.buckets)+1)
+		}
+		h.exemplars[i] = exemplar{traceID, v, time.Now()}
+	}
+	h.mu.Unlock()
+	h.observed(v)
+}
+
+// snapshot returns the cumulative bucket counts, the count, the sum and the
+// exemplars, nil if none.
+func (h *Histogram) snapshot() ([]uint64, uint64, float64, []exemplar) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	counts := make([]uint64, len(h.counts))
+	var n uint64
+	for i, c := range h.counts {
+		n += c
+		counts[i] = n
+	}
+	return counts, h.count, h.sum, slices.Clone(h.exemplars)
+}
+
+// exemplarOf returns the exemplar of bucket i, nil if it has none.
+func exemplarOf(exemplars []exemplar, i int) *exemplar {
+	if exemplars == nil || exemplars[i].traceID == "" {
+		return nil
+	}
+	return &exemplars[i]
+}
+
+// HistogramVec is a histogram with labels.
+type HistogramVec struct{ vec[Histogram] }
+
+// With returns the histogram of the label values, in the order the labels
+// were registered.
+func (v *HistogramVec) With(values ...string) *Histogram { return v.with(values) }
+
+func (v *HistogramVec) collect(emit func([]string, series)) {
+	v.each(func(values []string, h *Histogram) { emit(values, h) })
+}
+
+type funcMetric struct {
+	desc
+	fn func(emit func(value float64, values ...string))
+}
+
+func (m *funcMetric) collect(emit func([]string, series)) {
+	type sample struct {
+		values []string
+		v      float64
+	}
+	var samples []sample
+	m.fn(func(v float64, values ...string) {
+		samples = append(samples, sample{values, v})
+	})
+	slices.SortStableFunc(samples, func(a, b sample) int { return slices.Compare(a.values, b.values) })
+	for _, s := range samples {
+		emit(s.values, s.v)
+	}
+}
+
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
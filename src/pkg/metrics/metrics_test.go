@@ -0,0 +1,136 @@
+package metrics_test
+
+import (
+	"Load-Balancer/pkg/metrics"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := metrics.NewRegistry()
+	conns := r.Counter("lb_connections_total", "Client connections accepted.")
+	active := r.Gauge("lb_connections_active", "Client connections open.")
+	bytes := r.Counter("lb_bytes_total", "Bytes proxied.", "backend", "direction")
+	latency := r.Histogram("lb_duration_seconds", "Proxy latency.", []float64{0.1, 1}, "mode")
+	r.Func("lb_healthy", "Whether a backend\nis healthy.", metrics.GaugeType, []string{"backend"}, func(emit func(float64, ...string)) {
+		emit(0, `b"2`)
+		emit(1, "a")
+	})
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conns.With().Inc()
+			active.With().Inc()
+			bytes.With("10.0.0.2:80", "out").Add(2)
+		}()
+	}
+	wg.Wait()
+	active.With().Dec()
+	bytes.With("10.0.0.1:80", "in").Add(1.5)
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		latency.With("tcp").Observe(v)
+	}
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP lb_connections_total Client connections accepted.
+# TYPE lb_connections_total counter
+lb_connections_total 10
+# HELP lb_connections_active Client connections open.
+# TYPE lb_connections_active gauge
+lb_connections_active 9
+# HELP lb_bytes_total Bytes proxied.
+# TYPE lb_bytes_total counter
+lb_bytes_total{backend="10.0.0.1:80",direction="in"} 1.5
+lb_bytes_total{backend="10.0.0.2:80",direction="out"} 20
+# HELP lb_duration_seconds Proxy latency.
+# TYPE lb_duration_seconds histogram
+lb_duration_seconds_bucket{mode="tcp",le="0.1"} 2
+lb_duration_seconds_bucket{mode="tcp",le="1"} 3
+lb_duration_seconds_bucket{mode="tcp",le="+Inf"} 4
+lb_duration_seconds_sum{mode="tcp"} 3.65
+lb_duration_seconds_count{mode="tcp"} 4
+# HELP lb_healthy Whether a backend\nis healthy.
+# TYPE lb_healthy gauge
+lb_healthy{backend="a"} 1
+lb_healthy{backend="b\"2"} 0
+`
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.Counter("lb_x_total", "X.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice didn't panic")
+		}
+	}()
+	r.Gauge("lb_x_total", "X.")
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.Counter("lb_connections_total", `Client "connections".`).With().Add(3)
+	latency := r.Histogram("lb_duration_seconds", "Proxy latency.", []float64{0.1, 1}, "mode")
+	h := latency.With("tcp")
+	h.ObserveWithExemplar(0.05, "0af7651916cd43dd8448eb211c80319c")
+	h.Observe(0.07) // keeps the exemplar
+	h.ObserveWithExemplar(3, "4bf92f3577b34da6a3ce929d0e0e4736")
+
+	var b strings.Builder
+	if err := r.WriteOpenMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	at := regexp.MustCompile(`\} ([0-9.e+-]+) \d+\.\d{3}\n`)
+	got := at.ReplaceAllString(b.String(), "} $1 TS\n")
+	want := `# HELP lb_connections Client \"connections\".
+# TYPE lb_connections counter
+lb_connections_total 3
+# HELP lb_duration_seconds Proxy latency.
+# TYPE lb_duration_seconds histogram
+lb_duration_seconds_bucket{mode="tcp",le="0.1"} 2 # {trace_id="0af7651916cd43dd8448eb211c80319c"} 0.05 TS
+lb_duration_seconds_bucket{mode="tcp",le="1"} 2
+lb_duration_seconds_bucket{mode="tcp",le="+Inf"} 3 # {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"} 3 TS
+lb_duration_seconds_sum{mode="tcp"} 3.12
+lb_duration_seconds_count{mode="tcp"} 3
+# EOF
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// the Prometheus text format has no exemplars
+	b.Reset()
+	r.WriteText(&b)
+	if strings.Contains(b.String(), "trace_id") {
+		t.Errorf("exemplars in the text format:\n%s", b.String())
+	}
+}
+
+func TestHandlerNegotiates(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.Counter("lb_connections_total", "Client connections.").With().Inc()
+	for accept, want := range map[string]string{
+		"": "text/plain; version=0.0.4; charset=utf-8",
+		"application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5": "application/openmetrics-text; version=1.0.0; charset=utf-8",
+	} {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		r.Handler().ServeHTTP(rec, req)
+		if got := rec.Header().Get("Content-Type"); got != want {
+			t.Errorf("Accept %q: Content-Type %q, want %q", accept, got, want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatsDConfig says where and how a StatsD pushes a registry.
+type StatsDConfig struct {
+	Addr     string        // host:port of the StatsD server, UDP
+	Prefix   string        // prepended to metric names, e.g. "lb."
+	Interval time.Duration // between flushes, 10s if zero
+
+	// DogStatsD sends labels as tags, backend:10.0.0.1:80, with Tags added
+	// to every metric; plain StatsD has no tags, so there label values are
+	// appended to the name instead, lb_bytes_total.10_0_0_1_80.in.
+	DogStatsD bool
+	Tags      []string
+
+	OnError func(err error) // called when a send fails, if set
+}
+
+// StatsD pushes the metrics of a registry to a StatsD server, for setups that
+// don't scrape Prometheus: on every flush, counters as their increase since
+// the last one (|c) and gauges as their value (|g); histogram observations,
+// in seconds, as they happen, as timings in milliseconds (|ms).
+type StatsD struct {
+	r      *Registry
+	cfg    StatsDConfig
+	conn   net.Conn
+	mu     sync.Mutex
+	buf    []byte             // lines not sent yet
+	sent   map[string]float64 // counter values as of the last flush
+	done   chan struct{}
+	closed chan struct{}
+}
+
+// maxPacket keeps packets within an Ethernet frame, as StatsD servers expect.
+const maxPacket = 1432
+
+// NewStatsD starts pushing r as cfg says, until Close. A registry pushes to
+// one StatsD at most.
+func NewStatsD(r *Registry, cfg StatsDConfig) (*StatsD, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &StatsD{
+		r:      r,
+		cfg:    cfg,
+		conn:   conn,
+		sent:   make(map[string]float64),
+		done:   make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+	var timing observer = func(d *desc, values []string, v float64) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		ms := math.Round(v*1e6) / 1e3 // to the microsecond
+		s.add(d, values, strconv.FormatFloat(ms, 'f', -1, 64), "ms")
+	}
+	r.observer.Store(&timing)
+	go s.run()
+	return s, nil
+}
+
+func (s *StatsD) run() {
+	defer close(s.closed)
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Flush()
+		case <-s.done:
+			s.Flush()
+			return
+		}
+	}
+}
+
+// Close sends what is left and stops pushing.
+func (s *StatsD) Close() error {
+	s.r.observer.Store(nil)
+	close(s.done)
+	<-s.closed
+	return s.conn.Close()
+}
+
+// Flush sends the counters and gauges, and the timings observed since the
+// last flush.
+func (s *StatsD) Flush() {
+	s.r.mu.Lock()
+	ms := slices.Clone(s.r.metrics)
+	s.r.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range ms {
+		d := m.describe()
+		m.collect(func(values []string, v series) {
+			switch v := v.(type) {
+			case float64:
+				if d.typ == CounterType {
+					key := d.name + "\xff" + strings.Join(values, "\xff")
+					last, seen := s.sent[key]
+					s.sent[key] = v
+					if v < last { // reset, e.g. a backend removed and added again
+						last = 0
+					}
+					if v -= last; v == 0 && seen {
+						return
+					}
+					s.add(d, values, formatFloat(v), "c")
+				} else {
+					s.add(d, values, formatFloat(v), "g")
+				}
+			}
+		})
+	}
+	s.send()
+}
+
+// add queues a line, sending the queue first if the line doesn't fit in its
+// packet. s.mu must be held.
+func (s *StatsD) add(d *desc, values []string, value, typ string) {
+	var line []byte
+	line = append(line, s.cfg.Prefix...)
+	line = append(line, d.name...)
+	if !s.cfg.DogStatsD {
+		for _, v := range values {
+			line = append(append(line, '.'), statsdName(v)...)
+		}
+	}
+	line = append(append(line, ':'), value...)
+	line = append(append(line, '|'), typ...)
+	if s.cfg.DogStatsD && len(d.labels)+len(s.cfg.Tags) > 0 {
+		line = append(line, "|#"...)
+		for i, l := range d.labels {
+			if i > 0 {
+				line = append(line, ',')
+			}
+			line = append(append(append(line, l...), ':'), statsdTag(values[i])...)
+		}
+		for i, t := range s.cfg.Tags {
+			if i > 0 || len(d.labels) > 0 {
+				line = append(line, ',')
+			}
+			line = append(line, t...)
+		}
+	}
+	if len(s.buf) > 0 && len(s.buf)+1+len(line) > maxPacket {
+		s.send()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line...)
+}
+
+// send sends the queue. s.mu must be held.
+func (s *StatsD) send() {
+	if len(s.buf) == 0 {
+		return
+	}
+	_, err := s.conn.Write(s.buf)
+	s.buf = s.buf[:0]
+	if err != nil && s.cfg.OnError != nil {
+		s.cfg.OnError(err)
+	}
+}
+
+// statsdName makes v a part of a metric name: characters other than letters,
+// digits, - and _ become _.
+func statsdName(v string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' {
+			return r
+		}
+		return '_'
+	}, v)
+}
+
+// statsdTag makes v a tag value: the separators of the line, | , and #, and
+// newlines become _.
+func statsdTag(v string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '|' || r == ',' || r == '#' || r == '\n' {
+			return '_'
+		}
+		return r
+	}, v)
+}
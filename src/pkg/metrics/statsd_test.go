@@ -0,0 +1,92 @@
+package metrics_test
+
+import (
+	"Load-Balancer/pkg/metrics"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// statsdServer returns the address of a UDP listener and a function returning
+// the lines of the next packet.
+func statsdServer(t *testing.T) (string, func() []string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn.LocalAddr().String(), func() []string {
+		buf := make([]byte, 2048)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(string(buf[:n]), "\n")
+	}
+}
+
+func TestStatsD(t *testing.T) {
+	r := metrics.NewRegistry()
+	bytes := r.Counter("lb_bytes_total", "Bytes proxied.", "backend", "direction")
+	active := r.Gauge("lb_connections_active", "Client connections open.")
+	latency := r.Histogram("lb_dial_seconds", "Dial latency.", []float64{0.1}, "backend")
+
+	addr, read := statsdServer(t)
+	s, err := metrics.NewStatsD(r, metrics.StatsDConfig{Addr: addr, Prefix: "lb.", Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	bytes.With("10.0.0.1:80", "in").Add(100)
+	active.With().Set(3)
+	latency.With("10.0.0.1:80").Observe(0.0125)
+	s.Flush()
+	want := []string{
+		"lb.lb_dial_seconds.10_0_0_1_80:12.5|ms",
+		"lb.lb_bytes_total.10_0_0_1_80.in:100|c",
+		"lb.lb_connections_active:3|g",
+	}
+	if got := read(); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// counters send their increase, and nothing if they didn't change
+	bytes.With("10.0.0.1:80", "in").Add(50)
+	s.Flush()
+	want = []string{"lb.lb_bytes_total.10_0_0_1_80.in:50|c", "lb.lb_connections_active:3|g"}
+	if got := read(); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	s.Flush()
+	want = []string{"lb.lb_connections_active:3|g"}
+	if got := read(); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDogStatsD(t *testing.T) {
+	r := metrics.NewRegistry()
+	bytes := r.Counter("lb_bytes_total", "Bytes proxied.", "backend", "direction")
+	r.Func("lb_healthy", "Health.", metrics.GaugeType, []string{"backend"}, func(emit func(float64, ...string)) {
+		emit(1, "a|b,c")
+	})
+
+	addr, read := statsdServer(t)
+	s, err := metrics.NewStatsD(r, metrics.StatsDConfig{Addr: addr, Interval: time.Hour, DogStatsD: true, Tags: []string{"env:prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytes.With("10.0.0.1:80", "out").Add(7)
+	s.Close() // flushes
+	want := []string{
+		"lb_bytes_total:7|c|#backend:10.0.0.1:80,direction:out,env:prod",
+		"lb_healthy:1|g|#backend:a_b_c,env:prod",
+	}
+	if got := read(); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,147 @@
+// Package proxyproto writes HAProxy PROXY protocol headers, which tell a backend
+// the real client address of a proxied connection.
+package proxyproto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// signature starts every version 2 header.
+var signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// TLV is a type-length-value extension of a version 2 header.
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// TLV types, from the PROXY protocol specification.
+const (
+	TypeUniqueID      = 0x05
+	TypeSSL           = 0x20
+	SubtypeSSLVersion = 0x21
+	SubtypeSSLCN      = 0x22
+)
+
+// Client flags of a TypeSSL TLV.
+const (
+	clientSSL      = 0x01 // the client connected over TLS
+	clientCertConn = 0x02 // and presented a certificate on this connection
+)
+
+// SSL returns the TLV telling the backend the client connected over TLS with
+// the given version, e.g. "TLSv1.3". A non-empty cn is the common name of the
+// client certificate, which was verified.
+func SSL(version, cn string) TLV {
+	client := byte(clientSSL)
+	if cn != "" {
+		client |= clientCertConn
+	}
+	v := []byte{client, 0, 0, 0, 0} // verify: 0 means the certificate, if any, verified
+	v = appendTLV(v, TLV{SubtypeSSLVersion, []byte(version)})
+	if cn != "" {
+		v = appendTLV(v, TLV{SubtypeSSLCN, []byte(cn)})
+	}
+	return TLV{TypeSSL, v}
+}
+
+// maxUniqueID is the longest unique ID the specification allows.
+const maxUniqueID = 128
+
+// UniqueID returns the TLV giving the backend an ID of the connection, e.g. to
+// find it in the proxy's logs. IDs longer than 128 bytes are cut.
+func UniqueID(id string) TLV {
+	if len(id) > maxUniqueID {
+		id = id[:maxUniqueID]
+	}
+	return TLV{TypeUniqueID, []byte(id)}
+}
+
+func appendTLV(b []byte, t TLV) []byte {
+	b = append(b, t.Type)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(t.Value)))
+	return append(b, t.Value...)
+}
+
+// Header returns the version 1 (text) or 2 (binary) header for a connection
+// from src to dst. Connections that aren't TCP are announced without addresses.
+// Version 1 has no room for tlvs and leaves them out.
+func Header(version int, src, dst net.Addr, tlvs ...TLV) ([]byte, error) {
+	srcIP, srcPort, ok1 := tcpAddr(src)
+	dstIP, dstPort, ok2 := tcpAddr(dst)
+	known := ok1 && ok2
+	v4 := known && srcIP.To4() != nil && dstIP.To4() != nil
+	if v4 {
+		srcIP, dstIP = srcIP.To4(), dstIP.To4()
+	} else if known {
+		srcIP, dstIP = srcIP.To16(), dstIP.To16()
+	}
+
+	switch version {
+	case 1:
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		if v4 {
+			return fmt.Appendf(nil, "PROXY TCP4 %s %s %d %d\r\n", srcIP, dstIP, srcPort, dstPort), nil
+		}
+		// netip keeps IPv4 addresses mapped into IPv6 in IPv6 notation
+		src6, dst6 := netip.AddrFrom16([16]byte(srcIP)), netip.AddrFrom16([16]byte(dstIP))
+		return fmt.Appendf(nil, "PROXY TCP6 %s %s %d %d\r\n", src6, dst6, srcPort, dstPort), nil
+	case 2:
+		h := append([]byte(nil), signature...)
+		if !known {
+			// LOCAL: the backend uses the connection's own addresses
+			return append(h, 0x20, 0x00, 0, 0), nil
+		}
+		family := byte(0x21) // TCP over IPv6
+		if v4 {
+			family = 0x11 // TCP over IPv4
+		}
+		var ext []byte
+		for _, t := range tlvs {
+			ext = appendTLV(ext, t)
+		}
+		h = append(h, 0x21, family) // version 2, PROXY
+		h = binary.BigEndian.AppendUint16(h, uint16(2*len(srcIP)+4+len(ext)))
+		h = append(h, srcIP...)
+		h = append(h, dstIP...)
+		h = binary.BigEndian.AppendUint16(h, uint16(srcPort))
+		h = binary.BigEndian.AppendUint16(h, uint16(dstPort))
+		return append(h, ext...), nil
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+}
+
+// Write writes the header for a connection from src to dst to w.
+func Write(w io.Writer, version int, src, dst net.Addr, tlvs ...TLV) error {
+	h, err := Header(version, src, dst, tlvs...)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(h)
+	return err
+}
+
+// tcpAddr returns the IP and port of a TCP address.
+func tcpAddr(a net.Addr) (net.IP, int, bool) {
+	if a, ok := a.(*net.TCPAddr); ok && a.IP != nil {
+		return a.IP, a.Port, true
+	}
+	if a == nil || a.Network() != "tcp" {
+		return nil, 0, false
+	}
+	host, port, err := net.SplitHostPort(a.String())
+	if err != nil {
+		return nil, 0, false
+	}
+	ip := net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	return ip, p, ip != nil && err == nil
+}
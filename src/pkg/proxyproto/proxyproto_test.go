@@ -0,0 +1,98 @@
+package proxyproto_test
+
+import (
+	"Load-Balancer/pkg/proxyproto"
+	"bytes"
+	"net"
+	"testing"
+)
+
+var (
+	client4 = &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56324}
+	server4 = &net.TCPAddr{IP: net.ParseIP("192.168.0.11"), Port: 443}
+	client6 = &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324}
+	unix    = &net.UnixAddr{Name: "/run/lb.sock", Net: "unix"}
+)
+
+func TestHeaderV1(t *testing.T) {
+	for _, tc := range []struct {
+		src, dst net.Addr
+		want     string
+	}{
+		{client4, server4, "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"},
+		// mixed families are both announced as IPv6
+		{client6, server4, "PROXY TCP6 2001:db8::1 ::ffff:192.168.0.11 56324 443\r\n"},
+		{unix, server4, "PROXY UNKNOWN\r\n"},
+	} {
+		h, err := proxyproto.Header(1, tc.src, tc.dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(h) != tc.want {
+			t.Errorf("got %q, want %q", h, tc.want)
+		}
+	}
+}
+
+func TestHeaderV2(t *testing.T) {
+	h, err := proxyproto.Header(2, client4, server4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte("\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c" +
+		"\xc0\xa8\x00\x01\xc0\xa8\x00\x0b\xdc\x04\x01\xbb")
+	if !bytes.Equal(h, want) {
+		t.Errorf("got % x, want % x", h, want)
+	}
+
+	h, _ = proxyproto.Header(2, client6, server4)
+	if h[13] != 0x21 || len(h) != 16+36 {
+		t.Errorf("got family %#x and %d bytes, want TCP over IPv6 in 52 bytes", h[13], len(h))
+	}
+	h, _ = proxyproto.Header(2, unix, server4)
+	if !bytes.Equal(h[12:], []byte{0x20, 0x00, 0, 0}) {
+		t.Errorf("got % x, want a LOCAL header", h[12:])
+	}
+}
+
+func TestHeaderTLV(t *testing.T) {
+	h, err := proxyproto.Header(2, client4, server4, proxyproto.SSL("TLSv1.3", "alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte("\x20\x00\x17" + // SSL, 23 bytes
+		"\x03\x00\x00\x00\x00" + // TLS with a verified client certificate
+		"\x21\x00\x07TLSv1.3" +
+		"\x22\x00\x05alice")
+	if !bytes.Equal(h[28:], want) {
+		t.Errorf("got TLVs % x, want % x", h[28:], want)
+	}
+	if n := int(h[14])<<8 | int(h[15]); n != 12+len(want) {
+		t.Errorf("got length %d, want %d", n, 12+len(want))
+	}
+
+	// version 1 can't carry TLVs
+	h, _ = proxyproto.Header(1, client4, server4, proxyproto.SSL("TLSv1.3", "alice"))
+	if string(h) != "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n" {
+		t.Errorf("got %q", h)
+	}
+}
+
+func TestHeaderVersion(t *testing.T) {
+	if _, err := proxyproto.Header(3, client4, server4); err == nil {
+		t.Error("got no error for version 3")
+	}
+}
+
+func TestUniqueID(t *testing.T) {
+	h, err := proxyproto.Header(2, client4, server4, proxyproto.UniqueID("3f2a9c1e07b4d568"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x05\x00\x103f2a9c1e07b4d568"; string(h[28:]) != want {
+		t.Errorf("got TLV % x, want % x", h[28:], want)
+	}
+	if tlv := proxyproto.UniqueID(string(make([]byte, 200))); len(tlv.Value) != 128 {
+		t.Errorf("got a %d byte ID, want it cut to 128", len(tlv.Value))
+	}
+}
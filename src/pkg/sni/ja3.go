@@ -0,0 +1,137 @@
+package sni
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrNotHello is returned by JA3 for bytes that don't start with a ClientHello.
+var ErrNotHello = errors.New("not a TLS ClientHello")
+
+// JA3 returns the JA3 fingerprint of the ClientHello that starts records, the
+// bytes a TLS client sends first: the hex MD5 hash of the JA3 string, which is
+// returned too. The string lists the hello's version, cipher suites,
+// extensions, elliptic curves and point formats; GREASE values (RFC 8701) are
+// left out, so a client hashes the same on every connection.
+func JA3(records []byte) (hash, text string, err error) {
+	hello, err := handshakeMessage(records)
+	if err != nil {
+		return "", "", err
+	}
+	s := reader(hello)
+	version := s.uint16()
+	s.skip(32) // random
+	s.skip(int(s.uint8()))
+	ciphers := s.list(int(s.uint16()) / 2)
+	s.skip(int(s.uint8())) // compression methods
+	if s == nil {
+		return "", "", ErrNotHello
+	}
+	var extensions, curves, points []uint16
+	var exts reader
+	if len(s) > 0 { // extensions are optional
+		exts = s.bytes(int(s.uint16()))
+	}
+	for len(exts) > 0 {
+		typ := exts.uint16()
+		data := reader(exts.bytes(int(exts.uint16())))
+		if !grease(typ) {
+			extensions = append(extensions, typ)
+		}
+		switch typ {
+		case 10: // supported_groups
+			curves = data.list(int(data.uint16()) / 2)
+		case 11: // ec_point_formats
+			for _, b := range data.bytes(int(data.uint8())) {
+				points = append(points, uint16(b))
+			}
+		}
+	}
+	if s == nil {
+		return "", "", ErrNotHello
+	}
+	text = strconv.Itoa(int(version)) + "," + join(ciphers) + "," + join(extensions) + "," + join(curves) + "," + join(points)
+	sum := md5.Sum([]byte(text))
+	return hex.EncodeToString(sum[:]), text, nil
+}
+
+// handshakeMessage returns the body of the ClientHello that starts records,
+// which may be split over several TLS records.
+func handshakeMessage(records []byte) ([]byte, error) {
+	var msg []byte
+	for len(records) >= 5 && records[0] == 22 { // handshake record
+		n := int(binary.BigEndian.Uint16(records[3:5]))
+		if len(records) < 5+n {
+			break
+		}
+		msg, records = append(msg, records[5:5+n]...), records[5+n:]
+		if len(msg) >= 4 {
+			if msg[0] != 1 { // client_hello
+				return nil, ErrNotHello
+			}
+			if size := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3]); len(msg) >= 4+size {
+				return msg[4 : 4+size], nil
+			}
+		}
+	}
+	return nil, ErrNotHello
+}
+
+// reader consumes a hello. Reading past its end makes it nil, and a nil reader
+// reads zeros.
+type reader []byte
+
+func (r *reader) bytes(n int) []byte {
+	if len(*r) < n {
+		*r = nil
+		return nil
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b
+}
+
+func (r *reader) skip(n int) { r.bytes(n) }
+
+func (r *reader) uint8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+// list reads n 16-bit values, leaving out GREASE.
+func (r *reader) list(n int) []uint16 {
+	var v []uint16
+	for range n {
+		if x := r.uint16(); !grease(x) {
+			v = append(v, x)
+		}
+	}
+	return v
+}
+
+// grease reports whether v is one of the reserved values clients sprinkle in
+// to keep servers tolerant, 0x0a0a, 0x1a1a ... 0xfafa.
+func grease(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func join(v []uint16) string {
+	s := make([]string, len(v))
+	for i, x := range v {
+		s[i] = strconv.Itoa(int(x))
+	}
+	return strings.Join(s, "-")
+}
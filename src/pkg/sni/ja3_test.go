@@ -0,0 +1,81 @@
+package sni_test
+
+import (
+	"Load-Balancer/pkg/sni"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestJA3(t *testing.T) {
+	// ciphers and extensions led by GREASE, as Chrome sends them
+	ext := func(typ uint16, data ...byte) []byte {
+		return append([]byte{byte(typ >> 8), byte(typ), 0, byte(len(data))}, data...)
+	}
+	var exts []byte
+	exts = append(exts, ext(0x3a3a)...)
+	exts = append(exts, ext(0)...)                                  // server_name, empty
+	exts = append(exts, ext(10, 0, 6, 0x2a, 0x2a, 0, 29, 0, 23)...) // supported_groups: GREASE, x25519, secp256r1
+	exts = append(exts, ext(11, 1, 0)...)                           // ec_point_formats: uncompressed
+	exts = append(exts, ext(43, 4, 0x03, 0x04, 0x03, 0x03)...)      // supported_versions
+	body := []byte{3, 3}                                            // legacy version
+	body = append(body, make([]byte, 32)...)                        // random
+	body = append(body, 0)                                          // session id
+	body = append(body, 0, 6, 0x0a, 0x0a, 0x13, 0x01, 0xc0, 0x2b)   // ciphers
+	body = append(body, 1, 0)                                       // compression
+	body = append(body, byte(len(exts)>>8), byte(len(exts)))
+	body = append(body, exts...)
+	msg := append([]byte{1, 0, byte(len(body) >> 8), byte(len(body))}, body...)
+	record := func(b []byte) []byte {
+		return append([]byte{22, 3, 1, byte(len(b) >> 8), byte(len(b))}, b...)
+	}
+	// split over two records, which the hello may be
+	records := append(record(msg[:20]), record(msg[20:])...)
+
+	hash, text, err := sni.JA3(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "771,4865-49195,0-10-11-43,29-23,0"; text != want {
+		t.Errorf("JA3 string %q, want %q", text, want)
+	}
+	sum := md5.Sum([]byte(text))
+	if hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("hash %s isn't the MD5 of the JA3 string", hash)
+	}
+
+	for name, b := range map[string][]byte{
+		"not TLS":   []byte("GET / HTTP/1.1\r\n\r\n"),
+		"truncated": records[:len(records)-10],
+		"short":     record(msg[:len(msg)-10]),
+	} {
+		if _, _, err := sni.JA3(b); !errors.Is(err, sni.ErrNotHello) {
+			t.Errorf("%s: got %v, want ErrNotHello", name, err)
+		}
+	}
+}
+
+func TestPeekHelloJA3(t *testing.T) {
+	hashes := make(map[string]bool)
+	for range 2 {
+		client, server := net.Pipe()
+		go tls.Client(client, &tls.Config{ServerName: "api.example.com", InsecureSkipVerify: true}).Handshake()
+		hello, _, err := sni.PeekHello(server, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hello.ServerName != "api.example.com" || len(hello.JA3) != 32 {
+			t.Errorf("got %+v, want the server name and a JA3 hash", hello)
+		}
+		hashes[hello.JA3] = true
+		client.Close()
+		server.Close()
+	}
+	if len(hashes) != 1 {
+		t.Errorf("the same client hashed differently: %v", hashes)
+	}
+}
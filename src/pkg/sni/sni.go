@@ -0,0 +1,75 @@
+// Package sni reads the server name a TLS client asks for without terminating
+// TLS, so the connection can be routed by name and passed through untouched.
+package sni
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// errPeeked stops the handshake once the ClientHello has been read.
+var errPeeked = errors.New("peeked")
+
+// Hello is what a ClientHello tells about a client.
+type Hello struct {
+	ServerName string // "" if none
+	JA3        string // JA3 fingerprint, see JA3
+}
+
+// Peek reads the ClientHello from c and returns the server name it asks for,
+// "" if none, and a connection that replays the bytes read before continuing
+// with c. Reading the hello gives up after timeout.
+func Peek(c net.Conn, timeout time.Duration) (string, net.Conn, error) {
+	hello, conn, err := PeekHello(c, timeout)
+	return hello.ServerName, conn, err
+}
+
+// PeekHello is like Peek, but returns the client's JA3 fingerprint too.
+func PeekHello(c net.Conn, timeout time.Duration) (Hello, net.Conn, error) {
+	var buf bytes.Buffer
+	var hello Hello
+	c.SetReadDeadline(time.Now().Add(timeout))
+	err := tls.Server(readOnly{io.TeeReader(c, &buf)}, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello.ServerName = info.ServerName
+			return nil, errPeeked
+		},
+	}).Handshake()
+	c.SetReadDeadline(time.Time{})
+	if !errors.Is(err, errPeeked) {
+		return Hello{}, nil, err
+	}
+	hello.JA3, _, _ = JA3(buf.Bytes())
+	return hello, &Conn{Conn: c, r: io.MultiReader(&buf, c)}, nil
+}
+
+// Conn is a connection whose first bytes were peeked at and are read again.
+type Conn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *Conn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// CloseWrite half-closes the underlying connection, if it supports that.
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// readOnly lets the TLS server read the hello but not answer it.
+type readOnly struct{ io.Reader }
+
+func (readOnly) Write(p []byte) (int, error)      { return 0, io.ErrClosedPipe }
+func (readOnly) Close() error                     { return nil }
+func (readOnly) LocalAddr() net.Addr              { return nil }
+func (readOnly) RemoteAddr() net.Addr             { return nil }
+func (readOnly) SetDeadline(time.Time) error      { return nil }
+func (readOnly) SetReadDeadline(time.Time) error  { return nil }
+func (readOnly) SetWriteDeadline(time.Time) error { return nil }
@@ -0,0 +1,66 @@
+package sni_test
+
+import (
+	"Load-Balancer/pkg/sni"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPeek(t *testing.T) {
+	for _, name := range []string{"api.example.com", ""} {
+		client, server := net.Pipe()
+		// record what the client sends, to compare with what Peek replays
+		var sent bytes.Buffer
+		go func() {
+			tls.Client(teeConn{client, &sent}, &tls.Config{ServerName: name, InsecureSkipVerify: true}).Handshake()
+		}()
+
+		got, conn, err := sni.Peek(server, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != name {
+			t.Errorf("got server name %q, want %q", got, name)
+		}
+		replayed := make([]byte, sent.Len())
+		if _, err := io.ReadFull(conn, replayed); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(replayed, sent.Bytes()) {
+			t.Error("the ClientHello isn't replayed as sent")
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestPeekNotTLS(t *testing.T) {
+	client, server := net.Pipe()
+	go client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	if _, _, err := sni.Peek(server, time.Second); err == nil {
+		t.Error("got no error for plain HTTP")
+	}
+	client.Close()
+}
+
+func TestPeekTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	if _, _, err := sni.Peek(server, 50*time.Millisecond); err == nil {
+		t.Error("got no error from a silent client")
+	}
+}
+
+type teeConn struct {
+	net.Conn
+	w io.Writer
+}
+
+func (c teeConn) Write(p []byte) (int, error) {
+	c.w.Write(p)
+	return c.Conn.Write(p)
+}
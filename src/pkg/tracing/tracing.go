@@ -0,0 +1,269 @@
+// Package tracing records OpenTelemetry spans and exports them to a collector
+// over OTLP/HTTP, in its JSON encoding, without the OpenTelemetry SDK. Trace
+// context travels in W3C traceparent headers.
+//
+// A nil *Tracer records nothing and a nil *Span ignores every call, so callers
+// don't need to check whether tracing is on.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace, SpanID a span in it.
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+// String returns id in hex, as traceparent headers and OTLP carry it.
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanContext is what a span passes on to its children, in process or in a
+// traceparent header.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// Valid reports whether c has a trace and span ID.
+func (c SpanContext) Valid() bool { return c.TraceID != TraceID{} && c.SpanID != SpanID{} }
+
+// Traceparent returns c as a W3C traceparent header value.
+func (c SpanContext) Traceparent() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(c.TraceID[:]) + "-" + hex.EncodeToString(c.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header value. Versions after 00
+// are read as 00, as the spec asks.
+func ParseTraceparent(v string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+	var c SpanContext
+	var flags [1]byte
+	if !decodeHex(c.TraceID[:], parts[1]) || !decodeHex(c.SpanID[:], parts[2]) || !decodeHex(flags[:], parts[3]) || !c.Valid() {
+		return SpanContext{}, false
+	}
+	c.Sampled = flags[0]&1 == 1
+	return c, true
+}
+
+// decodeHex decodes lower case hex s into dst, which it must fill exactly.
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// SpanKind says how a span relates to its remote parent or children.
+type SpanKind int
+
+// Values as in the OTLP protocol.
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// Attribute is a key and a string, int64, float64 or bool value.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func String(key, v string) Attribute    { return Attribute{key, v} }
+func Int(key string, v int64) Attribute { return Attribute{key, v} }
+func Bool(key string, v bool) Attribute { return Attribute{key, v} }
+
+// Span is a timed operation. Its methods are safe to call on nil, and Finish
+// must be called once, after which the span must not be changed.
+type Span struct {
+	Name       string
+	Kind       SpanKind
+	Context    SpanContext
+	Parent     SpanID // zero for a root span
+	Start, End time.Time
+	Attributes []Attribute
+	Err        string // status message, the span failed if set
+
+	tracer *Tracer
+}
+
+// SpanContext returns the context children of s inherit, or the zero
+// SpanContext for a nil span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+// SetAttributes adds attributes to s.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s != nil {
+		s.Attributes = append(s.Attributes, attrs...)
+	}
+}
+
+// SetError marks s failed with err, if err isn't nil.
+func (s *Span) SetError(err error) {
+	if s != nil && err != nil {
+		s.Err = err.Error()
+	}
+}
+
+// Finish ends s now and hands it to its tracer for export, if sampled.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	if s.Context.Sampled {
+		s.tracer.queue(s)
+	}
+}
+
+// Exporter sends finished spans somewhere, e.g. OTLPExporter.
+type Exporter interface {
+	Export(ctx context.Context, spans []*Span) error
+}
+
+// Tracer starts spans and exports the sampled ones in batches.
+type Tracer struct {
+	exporter Exporter
+	sample   float64         // share of root spans sampled
+	onError  func(err error) // called when an export fails
+	spans    chan *Span
+	flush    chan chan struct{}
+	done     chan struct{}
+	close    sync.Once
+}
+
+const (
+	batchSize     = 512
+	queueSize     = 4096 // spans finished beyond this while exports lag are dropped
+	batchInterval = 5 * time.Second
+)
+
+// NewTracer returns a tracer sampling the given share of new traces, 0 to 1;
+// traces started elsewhere follow their parent's decision. Export errors go to
+// onError, if set.
+func NewTracer(exporter Exporter, sample float64, onError func(err error)) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		sample:   sample,
+		onError:  onError,
+		spans:    make(chan *Span, queueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start starts a span, a child of parent if parent is valid.
+func (t *Tracer) Start(name string, kind SpanKind, parent SpanContext) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{Name: name, Kind: kind, Start: time.Now(), tracer: t}
+	if parent.Valid() {
+		s.Context.TraceID, s.Parent, s.Context.Sampled = parent.TraceID, parent.SpanID, parent.Sampled
+	} else {
+		rand.Read(s.Context.TraceID[:])
+		s.Context.Sampled = t.sampled(s.Context.TraceID)
+	}
+	rand.Read(s.Context.SpanID[:])
+	return s
+}
+
+// sampled decides by trace ID, so every balancer sampling a trace at the same
+// rate agrees.
+func (t *Tracer) sampled(id TraceID) bool {
+	if t.sample >= 1 {
+		return true
+	}
+	var n uint64
+	for _, b := range id[8:] {
+		n = n<<8 | uint64(b)
+	}
+	return float64(n>>11) < t.sample*(1<<53)
+}
+
+func (t *Tracer) queue(s *Span) {
+	select {
+	case t.spans <- s:
+	default: // exports can't keep up, drop rather than block the proxy
+	}
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := t.exporter.Export(ctx, batch)
+		cancel()
+		if err != nil && t.onError != nil {
+			t.onError(err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case s := <-t.spans:
+			if batch = append(batch, s); len(batch) >= batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case ack := <-t.flush:
+			for n := len(t.spans); n > 0; n-- {
+				batch = append(batch, <-t.spans)
+			}
+			export()
+			close(ack)
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// Shutdown exports the spans finished so far and stops the tracer, or gives
+// up when ctx ends.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	ack := make(chan struct{})
+	select {
+	case t.flush <- ack:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ack:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	t.close.Do(func() { close(t.done) })
+	return nil
+}
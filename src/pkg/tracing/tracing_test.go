@@ -0,0 +1,121 @@
+package tracing_test
+
+import (
+	"Load-Balancer/pkg/tracing"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTraceparent(t *testing.T) {
+	const v = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	c, ok := tracing.ParseTraceparent(v)
+	if !ok || !c.Sampled {
+		t.Fatalf("ParseTraceparent(%q) = %+v, %v", v, c, ok)
+	}
+	if got := c.Traceparent(); got != v {
+		t.Errorf("got %q back, want %q", got, v)
+	}
+	if c, ok := tracing.ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-later"); !ok || c.Sampled {
+		t.Errorf("a later version with more fields should parse, unsampled: %+v, %v", c, ok)
+	}
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, ok := tracing.ParseTraceparent(bad); ok {
+			t.Errorf("ParseTraceparent(%q) accepted", bad)
+		}
+	}
+}
+
+type recorder struct{ spans chan []*tracing.Span }
+
+func (r recorder) Export(_ context.Context, spans []*tracing.Span) error {
+	r.spans <- spans
+	return nil
+}
+
+func TestTracerSampling(t *testing.T) {
+	rec := recorder{make(chan []*tracing.Span, 1)}
+	tr := tracing.NewTracer(rec, 0, nil)
+
+	root := tr.Start("dropped", tracing.KindServer, tracing.SpanContext{})
+	if root.SpanContext().Sampled || !root.SpanContext().Valid() {
+		t.Errorf("root span at rate 0: %+v, want valid and unsampled", root.SpanContext())
+	}
+	root.Finish()
+
+	parent, _ := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	child := tr.Start("kept", tracing.KindServer, parent)
+	child.SetAttributes(tracing.String("lb.backend", "10.0.0.1:80"))
+	child.SetError(errors.New("reset"))
+	child.Finish()
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := <-rec.spans
+	if len(spans) != 1 || spans[0].Name != "kept" {
+		t.Fatalf("exported %v, want only the span of the sampled parent", spans)
+	}
+	s := spans[0]
+	if s.Context.TraceID != parent.TraceID || s.Parent != parent.SpanID || s.Err != "reset" {
+		t.Errorf("got %+v, want a failed child of %+v", s, parent)
+	}
+
+	var nilTracer *tracing.Tracer
+	nilTracer.Start("x", tracing.KindServer, parent).Finish() // no-op
+}
+
+func TestOTLPExport(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer x" {
+			t.Errorf("got headers %v", r.Header)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	exp := &tracing.OTLPExporter{Endpoint: srv.URL + "/v1/traces", Service: "lb", Headers: map[string]string{"Authorization": "Bearer x"}}
+	parent, _ := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	s := &tracing.Span{Name: "proxy", Kind: tracing.KindServer, Context: parent, Parent: tracing.SpanID{1}, Start: time.Unix(1, 0), End: time.Unix(2, 0)}
+	s.SetAttributes(tracing.Int("lb.bytes_sent", 42))
+	if err := exp.Export(context.Background(), []*tracing.Span{s}); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := got["resourceSpans"].([]any)[0].(map[string]any)
+	service := rs["resource"].(map[string]any)["attributes"].([]any)[0].(map[string]any)
+	if service["key"] != "service.name" || service["value"].(map[string]any)["stringValue"] != "lb" {
+		t.Errorf("resource attribute %v, want service.name lb", service)
+	}
+	span := rs["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)[0].(map[string]any)
+	want := map[string]any{
+		"traceId":           "4bf92f3577b34da6a3ce929d0e0e4736",
+		"spanId":            "00f067aa0ba902b7",
+		"parentSpanId":      "0100000000000000",
+		"kind":              float64(2),
+		"startTimeUnixNano": "1000000000",
+		"endTimeUnixNano":   "2000000000",
+	}
+	for k, v := range want {
+		if span[k] != v {
+			t.Errorf("%s = %v, want %v", k, span[k], v)
+		}
+	}
+	attr := span["attributes"].([]any)[0].(map[string]any)
+	if attr["value"].(map[string]any)["intValue"] != "42" {
+		t.Errorf("int attribute %v, want the value as a string", attr)
+	}
+}